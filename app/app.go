@@ -2,12 +2,16 @@ package app
 
 import (
 	"fmt"
+	"forum-server/app/config"
 	"log"
 	"net/http"
-	"os"
 	"time"
 
+	"forum-server/app/auth"
 	"forum-server/app/handler"
+	"forum-server/app/mail"
+	"forum-server/app/ratelimit"
+	"forum-server/app/storage"
 	"forum-server/audit"
 	db "forum-server/db"
 
@@ -28,18 +32,28 @@ type App struct {
 	Middleware  *jwtmiddleware.JWTMiddleware
 	DB          *gorm.DB
 	Auditor     *audit.Auditor
+	Hub         *Hub
+	Mailer      mail.Mailer
+	Store       storage.BlobStore
+	Limiter     *ratelimit.Limiter
 }
 
 func (a *App) Init(auditor *audit.Auditor) {
 	a.Auditor = auditor
 	a.DB = db.Init(a.Auditor)
+	a.Limiter = ratelimit.New(a.Auditor)
+	a.Hub = NewHub(a.DB, a.Auditor, a.Limiter)
+	a.Mailer = mail.New()
+	a.Store = storage.New()
+	go a.cleanupAttachments()
+	go a.purgeTrash()
 	a.Router = mux.NewRouter()
 	a.AuthRouter = mux.NewRouter()
 
 	a.setMiddleware()
 	a.setRoutes()
 
-	a.AuthNegroni = negroni.New(negroni.HandlerFunc(a.Middleware.HandlerWithNext), negroni.Wrap(a.AuthRouter))
+	a.AuthNegroni = negroni.New(negroni.HandlerFunc(a.Middleware.HandlerWithNext), negroni.HandlerFunc(a.validateSession), negroni.Wrap(a.AuthRouter))
 	a.Router.PathPrefix("/api").Handler(a.AuthNegroni)
 
 }
@@ -47,7 +61,7 @@ func (a *App) Init(auditor *audit.Auditor) {
 func (a *App) setMiddleware() {
 	a.Middleware = jwtmiddleware.New(jwtmiddleware.Options{
 		ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_SECRET")), nil
+			return []byte(config.String("JWT_SECRET", "")), nil
 		},
 		SigningMethod: jwt.SigningMethodHS256,
 	})
@@ -61,36 +75,162 @@ func (a *App) setRoutes() {
 	// TODO: find better names for routes
 
 	a.postNoAuth("/api/login", a.login)
+	a.postNoAuth("/api/login/2fa", a.verifyTwoFactorLogin)
 	a.postNoAuth("/api/register", a.register)
+	a.postNoAuth("/api/token/refresh", a.refreshToken)
+	a.postNoAuth("/api/password/forgot", a.forgotPassword)
+	a.postNoAuth("/api/password/reset", a.resetPassword)
+	a.postNoAuth("/api/email/verify", a.verifyEmail)
+	a.postNoAuth("/api/email/confirm", a.confirmEmailChange)
+	a.post("/api/email/verify/resend", a.resendVerification)
+	a.post("/api/logout", a.logout)
+	a.get("/api/2fa", a.getTwoFactor)
+	a.post("/api/2fa/setup", a.setupTwoFactor)
+	a.post("/api/2fa/enable", a.enableTwoFactor)
+	a.post("/api/2fa/disable", a.disableTwoFactor)
+	a.post("/api/2fa/recovery-codes", a.regenerateRecoveryCodes)
 	a.get("/api/users", a.getUsers)
 	a.get("/api/user/{userId}", a.getUserById)
 	a.getNoAuth("/api/user/public/{userId}", a.getPublicUser)
 	a.getNoAuth("/api/user/publicByUsername/{username}", a.getPublicUserByUsername)
 	a.put("/api/user/{userId}", a.updateUser)
 	a.delete("/api/user/{userId}", a.deleteUser)
+	a.put("/api/user/{userId}/email", a.changeEmail)
+	a.put("/api/user/{userId}/role", a.require(auth.PermRoleManage, a.assignRole))
+	a.post("/api/user/{userId}/bans", a.require(auth.PermUserBan, a.banUser))
+	a.get("/api/user/{userId}/bans", a.require(auth.PermUserBan, a.getUserBans))
+	a.get("/api/user/{userId}/logins", a.getLoginHistory)
+	a.delete("/api/user/{userId}/2fa", a.require(auth.PermTwoFactorReset, a.resetTwoFactor))
+	a.get("/api/bans", a.require(auth.PermUserBan, a.getActiveBans))
+	a.delete("/api/bans/{banId}", a.require(auth.PermUserBan, a.liftBan))
+
+	a.get("/api/roles", a.require(auth.PermRoleManage, a.getRoles))
+	a.post("/api/roles", a.require(auth.PermRoleManage, a.createRole))
+	a.put("/api/roles/{roleId}", a.require(auth.PermRoleManage, a.updateRole))
+	a.get("/api/permissions", a.require(auth.PermRoleManage, a.getPermissions))
 
 	a.getNoAuth("/api/boards", a.getBoards)
 	a.getNoAuth("/api/board/{boardId}", a.getBoard)
 	a.getNoAuth("/api/board/{boardId}/posts", a.getPostsFromBoard)
 	a.getNoAuth("/api/posts/{postId}/comments", a.getCommentsFromPost)
+	a.getNoAuth("/api/posts/{postId}/comments/tree", a.getCommentTree)
 	a.getNoAuth("/api/posts/{postId}", a.getPost)
 	a.getNoAuth("/api/board/{boardId}/lastPost", a.getLastPost)
-	a.post("/api/boards/addBoard", a.addBoard)
-	a.put("/api/boards/{boardId}", a.updateBoard)
-	a.delete("/api/boards/{boardId}", a.deleteBoard)
-	a.post("/api/boards/{boardId}/newPost", a.addPost)
-	a.post("/api/post/addComment", a.addComment)
+	a.post("/api/boards/addBoard", a.require(auth.PermBoardCreate, a.addBoard))
+	a.put("/api/boards/{boardId}", a.require(auth.PermBoardUpdate, a.updateBoard))
+	a.delete("/api/boards/{boardId}", a.require(auth.PermBoardDelete, a.deleteBoard))
+	a.post("/api/boards/{boardId}/newPost", a.verified(a.addPost))
+	a.post("/api/post/addComment", a.verified(a.addComment))
 	a.put("/api/posts/{postId}", a.updatePost)
 	a.put("/api/posts/comments/{commentId}", a.updateComment)
 	a.delete("/api/posts/{postId}", a.deletePost)
-	a.delete("/api/posts/comments{commentId}", a.deleteComment)
+	a.delete("/api/posts/comments/{commentId}", a.deleteComment)
+	a.getNoAuth("/api/announcements", a.getAnnouncements)
+	a.post("/api/posts/{postId}/pin", a.require(auth.PermPostModerate, a.setPostState("pin", true)))
+	a.delete("/api/posts/{postId}/pin", a.require(auth.PermPostModerate, a.setPostState("pin", false)))
+	a.post("/api/posts/{postId}/lock", a.require(auth.PermPostModerate, a.setPostState("lock", true)))
+	a.delete("/api/posts/{postId}/lock", a.require(auth.PermPostModerate, a.setPostState("lock", false)))
+	a.post("/api/posts/{postId}/archive", a.require(auth.PermPostModerate, a.setPostState("archive", true)))
+	a.delete("/api/posts/{postId}/archive", a.require(auth.PermPostModerate, a.setPostState("archive", false)))
+	a.post("/api/posts/{postId}/announce", a.require(auth.PermPostAnnounce, a.setPostState("announce", true)))
+	a.delete("/api/posts/{postId}/announce", a.require(auth.PermPostAnnounce, a.setPostState("announce", false)))
+	a.post("/api/posts/{postId}/move", a.require(auth.PermPostModerate, a.movePost))
+	a.post("/api/posts/{postId}/merge", a.require(auth.PermPostModerate, a.mergePost))
+	a.post("/api/posts/{postId}/split", a.require(auth.PermPostModerate, a.splitPost))
+	a.getNoAuth("/api/tags", a.getTags)
+	a.getNoAuth("/api/tags/autocomplete", a.autocompleteTags)
+	a.getNoAuth("/api/tags/posts", a.getTaggedPosts)
+	a.getNoAuth("/api/tags/{name}", a.getTag)
+	a.put("/api/tags/{name}", a.require(auth.PermTagManage, a.updateTag))
+	a.post("/api/tags/{name}/synonyms", a.require(auth.PermTagManage, a.addTagSynonym))
+	a.delete("/api/tags/{name}/synonyms/{synonym}", a.require(auth.PermTagManage, a.removeTagSynonym))
+	a.getNoAuth("/api/reputation", a.getReputationRules)
+	a.getNoAuth("/api/reactions", a.getAllowedReactions)
+	a.put("/api/posts/{postId}/vote", a.verified(a.votePost))
+	a.put("/api/posts/comments/{commentId}/vote", a.verified(a.voteComment))
+	a.getNoAuth("/api/posts/{postId}/reactions", a.getPostReactions)
+	a.getNoAuth("/api/posts/comments/{commentId}/reactions", a.getCommentReactions)
+	a.post("/api/posts/{postId}/reactions", a.verified(a.addPostReaction))
+	a.post("/api/posts/comments/{commentId}/reactions", a.verified(a.addCommentReaction))
+	a.delete("/api/posts/{postId}/reactions/{emoji}", a.removePostReaction)
+	a.delete("/api/posts/comments/{commentId}/reactions/{emoji}", a.removeCommentReaction)
+	a.get("/api/posts/{postId}/revisions", a.getPostRevisions)
+	a.get("/api/posts/{postId}/revisions/diff", a.getPostRevisionDiff)
+	a.get("/api/posts/comments/{commentId}/revisions", a.getCommentRevisions)
+	a.get("/api/posts/comments/{commentId}/revisions/diff", a.getCommentRevisionDiff)
+	a.post("/api/posts/{postId}/revisions/{number:[0-9]+}/restore", a.require(auth.PermRevisionRestore, a.restorePostRevision))
+	a.post("/api/posts/comments/{commentId}/revisions/{number:[0-9]+}/restore", a.require(auth.PermRevisionRestore, a.restoreCommentRevision))
 
 	a.getNoAuth("/api/user/fromPost/{postId}", a.getPostAuthor)
 	a.getNoAuth("/api/post/{postId}/getLastComment", a.getLastCommentFromPost)
 	a.post("/api/user/{userId}/avatar", a.uploadAvatar)
+	a.getNoAuth("/api/media/{key:.*}", a.serveMedia)
+	a.post("/api/attachments", a.verified(a.uploadAttachment))
+	a.get("/api/attachments/usage", a.getAttachmentUsage)
+	a.getNoAuth("/api/attachments/{attachmentId:[0-9a-f-]{36}}", a.getAttachment)
+	a.getNoAuth("/api/attachments/{attachmentId:[0-9a-f-]{36}}/thumbnail", a.getAttachmentThumbnail)
+	a.delete("/api/attachments/{attachmentId}", a.deleteAttachment)
+	a.getNoAuth("/api/posts/{postId}/attachments", a.getPostAttachments)
 	a.getNoAuth("/api/user/{username}/posts", a.getPostsFromUser)
 	a.getNoAuth("/api/user/{username}/comments", a.getCommentsFromUser)
 	a.getNoAuth("/api/board/fromPost/{postId}", a.getBoardFromPost)
+
+	a.getNoAuth("/api/search", a.search)
+
+	a.getNoAuth("/api/ws", a.Hub.ServeWS)
+	a.get("/api/chat/history", a.getChatHistory)
+	a.delete("/api/chat/messages/{messageId}", a.require(auth.PermChatModerate, a.deleteChatMessage))
+	a.post("/api/chat/mutes", a.require(auth.PermChatModerate, a.muteChatUser))
+	a.delete("/api/chat/mutes/{userId}", a.require(auth.PermChatModerate, a.unmuteChatUser))
+
+	a.get("/api/conversations", a.getConversations)
+	a.post("/api/conversations", a.verified(a.createConversation))
+	a.get("/api/conversations/unread", a.getUnreadCount)
+	a.get("/api/conversations/{conversationId}", a.getConversation)
+	a.get("/api/conversations/{conversationId}/messages", a.getMessages)
+	a.post("/api/conversations/{conversationId}/messages", a.verified(a.sendMessage))
+	a.put("/api/conversations/{conversationId}/read", a.markConversationRead)
+	a.post("/api/conversations/{conversationId}/leave", a.leaveConversation)
+	a.post("/api/conversations/{conversationId}/report", a.reportConversation)
+	a.get("/api/blocks", a.getBlocks)
+	a.post("/api/user/{userId}/block", a.blockUser)
+	a.delete("/api/user/{userId}/block", a.unblockUser)
+
+	a.post("/api/posts/{postId}/report", a.reportPost)
+	a.post("/api/posts/comments/{commentId}/report", a.reportComment)
+	a.post("/api/user/{userId}/report", a.reportUser)
+	a.get("/api/reports", a.require(auth.PermReportResolve, a.getReportQueue))
+	a.get("/api/reports/mine", a.getMyReports)
+	a.get("/api/reports/{targetType}/{targetId}", a.getTargetReports)
+	a.post("/api/reports/{targetType}/{targetId}/resolve", a.resolveReports)
+
+	a.get("/api/trash/{type}", a.require(auth.PermTrashManage, a.getTrash))
+	a.post("/api/trash/{type}/{id}/restore", a.require(auth.PermTrashManage, a.restoreTrash))
+
+	a.get("/api/notifications", a.getNotifications)
+	a.get("/api/notifications/unread", a.getUnreadNotificationCount)
+	a.put("/api/notifications/read", a.markAllNotificationsRead)
+	a.get("/api/notifications/preferences", a.getNotificationPreferences)
+	a.put("/api/notifications/preferences", a.updateNotificationPreferences)
+	a.put("/api/notifications/{notificationId}/read", a.markNotificationRead)
+	a.delete("/api/notifications/{notificationId}", a.deleteNotification)
+	a.get("/api/subscriptions", a.getBoardSubscriptions)
+	a.post("/api/boards/{boardId}/subscription", a.subscribeBoard)
+	a.delete("/api/boards/{boardId}/subscription", a.unsubscribeBoard)
+}
+
+// require wraps f so it only runs when the caller's role grants permission.
+func (a *App) require(permission string, f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.RequirePermission(a.DB, permission, w, r, f)
+	}
+}
+
+// verified wraps f so it only runs for users who verified their email.
+func (a *App) verified(f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.RequireVerifiedEmail(a.DB, w, r, f)
+	}
 }
 
 func (a *App) getNoAuth(path string, f func(w http.ResponseWriter, r *http.Request)) {
@@ -134,17 +274,85 @@ func (a *App) checkRole(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *App) login(w http.ResponseWriter, r *http.Request) {
-	handler.UserLogin(a.DB, a.Auditor, w, r)
+	handler.UserLogin(a.DB, a.Auditor, a.Mailer, w, r)
 }
 
 func (a *App) register(w http.ResponseWriter, r *http.Request) {
-	handler.UserRegister(a.DB, a.Auditor, w, r)
+	handler.UserRegister(a.DB, a.Auditor, a.Mailer, w, r)
+}
+
+func (a *App) refreshToken(w http.ResponseWriter, r *http.Request) {
+	handler.RefreshToken(a.DB, a.Auditor, w, r)
+}
+
+func (a *App) logout(w http.ResponseWriter, r *http.Request) {
+	handler.Logout(a.DB, a.Auditor, w, r)
+}
+
+func (a *App) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	handler.ForgotPassword(a.DB, a.Mailer, w, r)
+}
+
+func (a *App) resetPassword(w http.ResponseWriter, r *http.Request) {
+	handler.ResetPassword(a.DB, a.Auditor, w, r)
+}
+
+func (a *App) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	handler.VerifyEmail(a.DB, w, r)
+}
+
+func (a *App) resendVerification(w http.ResponseWriter, r *http.Request) {
+	handler.ResendVerification(a.DB, a.Mailer, w, r)
+}
+
+func (a *App) changeEmail(w http.ResponseWriter, r *http.Request) {
+	handler.ChangeEmail(a.DB, a.Auditor, a.Mailer, w, r)
+}
+
+func (a *App) confirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	handler.ConfirmEmailChange(a.DB, a.Auditor, w, r)
+}
+
+func (a *App) validateSession(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	handler.ValidateSession(a.DB, w, r, next)
 }
 
 func (a *App) getUserById(w http.ResponseWriter, r *http.Request) {
 	handler.GetUserById(a.DB, a.Auditor, w, r)
 }
 
+func (a *App) verifyTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	handler.VerifyTwoFactorLogin(a.DB, a.Auditor, a.Mailer, w, r)
+}
+
+func (a *App) getTwoFactor(w http.ResponseWriter, r *http.Request) {
+	handler.GetTwoFactor(a.DB, w, r)
+}
+
+func (a *App) setupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	handler.SetupTwoFactor(a.DB, w, r)
+}
+
+func (a *App) enableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	handler.EnableTwoFactor(a.DB, a.Auditor, w, r)
+}
+
+func (a *App) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	handler.DisableTwoFactor(a.DB, a.Auditor, a.Mailer, w, r)
+}
+
+func (a *App) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	handler.RegenerateRecoveryCodes(a.DB, a.Auditor, w, r)
+}
+
+func (a *App) resetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	handler.ResetTwoFactor(a.DB, a.Auditor, a.Mailer, w, r)
+}
+
+func (a *App) getLoginHistory(w http.ResponseWriter, r *http.Request) {
+	handler.GetLoginHistory(a.DB, w, r)
+}
+
 func (a *App) getPublicUser(w http.ResponseWriter, r *http.Request) {
 	handler.GetPublicUser(a.DB, w, r)
 }
@@ -169,6 +377,42 @@ func (a *App) deleteUser(w http.ResponseWriter, r *http.Request) {
 	handler.DeleteUser(a.DB, a.Auditor, w, r)
 }
 
+func (a *App) getRoles(w http.ResponseWriter, r *http.Request) {
+	handler.GetRoles(a.DB, w, r)
+}
+
+func (a *App) getPermissions(w http.ResponseWriter, r *http.Request) {
+	handler.GetPermissions(a.DB, w, r)
+}
+
+func (a *App) createRole(w http.ResponseWriter, r *http.Request) {
+	handler.CreateRole(a.DB, a.Auditor, w, r)
+}
+
+func (a *App) updateRole(w http.ResponseWriter, r *http.Request) {
+	handler.UpdateRole(a.DB, a.Auditor, w, r)
+}
+
+func (a *App) assignRole(w http.ResponseWriter, r *http.Request) {
+	handler.AssignRole(a.DB, a.Auditor, a.Hub, w, r)
+}
+
+func (a *App) banUser(w http.ResponseWriter, r *http.Request) {
+	handler.BanUser(a.DB, a.Auditor, w, r)
+}
+
+func (a *App) getUserBans(w http.ResponseWriter, r *http.Request) {
+	handler.GetUserBans(a.DB, w, r)
+}
+
+func (a *App) getActiveBans(w http.ResponseWriter, r *http.Request) {
+	handler.GetActiveBans(a.DB, w, r)
+}
+
+func (a *App) liftBan(w http.ResponseWriter, r *http.Request) {
+	handler.LiftBan(a.DB, a.Auditor, w, r)
+}
+
 func (a *App) getBoards(w http.ResponseWriter, r *http.Request) {
 	handler.GetBoards(a.DB, w, r)
 }
@@ -201,6 +445,10 @@ func (a *App) getCommentsFromPost(w http.ResponseWriter, r *http.Request) {
 	handler.GetCommentsFromPost(a.DB, w, r)
 }
 
+func (a *App) getCommentTree(w http.ResponseWriter, r *http.Request) {
+	handler.GetCommentTree(a.DB, w, r)
+}
+
 func (a *App) getCommentsFromUser(w http.ResponseWriter, r *http.Request) {
 	handler.GetCommentsFromUser(a.DB, w, r)
 }
@@ -214,15 +462,85 @@ func (a *App) addBoard(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *App) addPost(w http.ResponseWriter, r *http.Request) {
-	handler.AddPost(a.DB, w, r)
+	handler.AddPost(a.DB, a.Hub, w, r)
 }
 
 func (a *App) addComment(w http.ResponseWriter, r *http.Request) {
-	handler.AddComment(a.DB, w, r)
+	handler.AddComment(a.DB, a.Hub, w, r)
 }
 
 func (a *App) uploadAvatar(w http.ResponseWriter, r *http.Request) {
-	handler.UploadAvatar(a.DB, w, r)
+	handler.UploadAvatar(a.DB, a.Store, w, r)
+}
+
+func (a *App) serveMedia(w http.ResponseWriter, r *http.Request) {
+	handler.ServeMedia(a.Store, w, r)
+}
+
+func (a *App) uploadAttachment(w http.ResponseWriter, r *http.Request) {
+	handler.UploadAttachment(a.DB, a.Store, w, r)
+}
+
+func (a *App) getAttachmentUsage(w http.ResponseWriter, r *http.Request) {
+	handler.GetAttachmentUsage(a.DB, w, r)
+}
+
+func (a *App) getAttachment(w http.ResponseWriter, r *http.Request) {
+	handler.GetAttachment(a.DB, a.Store, w, r)
+}
+
+func (a *App) getAttachmentThumbnail(w http.ResponseWriter, r *http.Request) {
+	handler.GetAttachmentThumbnail(a.DB, a.Store, w, r)
+}
+
+func (a *App) deleteAttachment(w http.ResponseWriter, r *http.Request) {
+	handler.DeleteAttachment(a.DB, a.Store, w, r)
+}
+
+func (a *App) getPostAttachments(w http.ResponseWriter, r *http.Request) {
+	handler.GetPostAttachments(a.DB, w, r)
+}
+
+// cleanupAttachments periodically deletes uploads that were never attached
+// to a post or comment.
+func (a *App) cleanupAttachments() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		removed, err := handler.CleanupOrphanAttachments(a.DB, a.Store, handler.OrphanAttachmentAge)
+		if err != nil {
+			a.Auditor.Log("", "Cleanup Attachments", "Error", err.Error())
+			continue
+		}
+		if removed > 0 {
+			a.Auditor.Log("", "Cleanup Attachments", "Success", fmt.Sprintf("removed %d orphaned attachments", removed))
+		}
+	}
+}
+
+// purgeTrash periodically removes rows that have been in the trash for
+// longer than the retention period.
+func (a *App) purgeTrash() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		purged, err := handler.PurgeTrash(a.DB, a.Store, handler.TrashRetention())
+		if err != nil {
+			a.Auditor.Log("", "Purge Trash", "Error", err.Error())
+			continue
+		}
+		if purged > 0 {
+			a.Auditor.Log("", "Purge Trash", "Success", fmt.Sprintf("purged %d deleted rows", purged))
+		}
+	}
+}
+
+func (a *App) getTrash(w http.ResponseWriter, r *http.Request) {
+	handler.GetTrash(a.DB, w, r)
+}
+
+func (a *App) restoreTrash(w http.ResponseWriter, r *http.Request) {
+	handler.RestoreTrash(a.DB, w, r)
 }
 
 func (a *App) getPostsFromUser(w http.ResponseWriter, r *http.Request) {
@@ -230,27 +548,278 @@ func (a *App) getPostsFromUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *App) updatePost(w http.ResponseWriter, r *http.Request) {
-	handler.UpdatePost(a.DB, w, r)
+	handler.UpdatePost(a.DB, a.Hub, w, r)
 }
 
 func (a *App) updateComment(w http.ResponseWriter, r *http.Request) {
-	handler.UpdateComment(a.DB, w, r)
+	handler.UpdateComment(a.DB, a.Hub, w, r)
 }
 
 func (a *App) deletePost(w http.ResponseWriter, r *http.Request) {
-	handler.DeletePost(a.DB, w, r)
+	handler.DeletePost(a.DB, a.Hub, w, r)
 }
 
 func (a *App) deleteComment(w http.ResponseWriter, r *http.Request) {
-	handler.DeleteComment(a.DB, w, r)
+	handler.DeleteComment(a.DB, a.Hub, w, r)
+}
+
+func (a *App) setPostState(name string, value bool) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.SetPostState(a.DB, a.Auditor, a.Hub, name, value, w, r)
+	}
+}
+
+func (a *App) movePost(w http.ResponseWriter, r *http.Request) {
+	handler.MovePost(a.DB, a.Auditor, a.Hub, w, r)
+}
+
+func (a *App) mergePost(w http.ResponseWriter, r *http.Request) {
+	handler.MergePost(a.DB, a.Auditor, a.Hub, w, r)
+}
+
+func (a *App) splitPost(w http.ResponseWriter, r *http.Request) {
+	handler.SplitPost(a.DB, a.Auditor, a.Hub, w, r)
+}
+
+func (a *App) getAnnouncements(w http.ResponseWriter, r *http.Request) {
+	handler.GetAnnouncements(a.DB, w, r)
+}
+
+func (a *App) getTags(w http.ResponseWriter, r *http.Request) {
+	handler.GetTags(a.DB, w, r)
+}
+
+func (a *App) autocompleteTags(w http.ResponseWriter, r *http.Request) {
+	handler.AutocompleteTags(a.DB, w, r)
+}
+
+func (a *App) getTaggedPosts(w http.ResponseWriter, r *http.Request) {
+	handler.GetTaggedPosts(a.DB, w, r)
+}
+
+func (a *App) getTag(w http.ResponseWriter, r *http.Request) {
+	handler.GetTag(a.DB, w, r)
+}
+
+func (a *App) updateTag(w http.ResponseWriter, r *http.Request) {
+	handler.UpdateTag(a.DB, a.Auditor, w, r)
+}
+
+func (a *App) addTagSynonym(w http.ResponseWriter, r *http.Request) {
+	handler.AddTagSynonym(a.DB, a.Auditor, w, r)
+}
+
+func (a *App) removeTagSynonym(w http.ResponseWriter, r *http.Request) {
+	handler.RemoveTagSynonym(a.DB, a.Auditor, w, r)
+}
+
+func (a *App) getReputationRules(w http.ResponseWriter, r *http.Request) {
+	handler.GetReputationRules(w, r)
+}
+
+func (a *App) getAllowedReactions(w http.ResponseWriter, r *http.Request) {
+	handler.GetAllowedReactions(w, r)
+}
+
+func (a *App) votePost(w http.ResponseWriter, r *http.Request) {
+	handler.VotePost(a.DB, a.Hub, w, r)
+}
+
+func (a *App) voteComment(w http.ResponseWriter, r *http.Request) {
+	handler.VoteComment(a.DB, a.Hub, w, r)
+}
+
+func (a *App) getPostReactions(w http.ResponseWriter, r *http.Request) {
+	handler.GetPostReactions(a.DB, w, r)
+}
+
+func (a *App) getCommentReactions(w http.ResponseWriter, r *http.Request) {
+	handler.GetCommentReactions(a.DB, w, r)
+}
+
+func (a *App) addPostReaction(w http.ResponseWriter, r *http.Request) {
+	handler.AddPostReaction(a.DB, a.Hub, w, r)
+}
+
+func (a *App) addCommentReaction(w http.ResponseWriter, r *http.Request) {
+	handler.AddCommentReaction(a.DB, a.Hub, w, r)
+}
+
+func (a *App) removePostReaction(w http.ResponseWriter, r *http.Request) {
+	handler.RemovePostReaction(a.DB, a.Hub, w, r)
+}
+
+func (a *App) removeCommentReaction(w http.ResponseWriter, r *http.Request) {
+	handler.RemoveCommentReaction(a.DB, a.Hub, w, r)
+}
+
+func (a *App) getPostRevisions(w http.ResponseWriter, r *http.Request) {
+	handler.GetPostRevisions(a.DB, w, r)
+}
+
+func (a *App) getPostRevisionDiff(w http.ResponseWriter, r *http.Request) {
+	handler.GetPostRevisionDiff(a.DB, w, r)
+}
+
+func (a *App) getCommentRevisions(w http.ResponseWriter, r *http.Request) {
+	handler.GetCommentRevisions(a.DB, w, r)
+}
+
+func (a *App) getCommentRevisionDiff(w http.ResponseWriter, r *http.Request) {
+	handler.GetCommentRevisionDiff(a.DB, w, r)
+}
+
+func (a *App) restorePostRevision(w http.ResponseWriter, r *http.Request) {
+	handler.RestorePostRevision(a.DB, a.Hub, w, r)
+}
+
+func (a *App) restoreCommentRevision(w http.ResponseWriter, r *http.Request) {
+	handler.RestoreCommentRevision(a.DB, a.Hub, w, r)
 }
 
 func (a *App) getBoardFromPost(w http.ResponseWriter, r *http.Request) {
 	handler.GetBoardFromPost(a.DB, w, r)
 }
 
+func (a *App) search(w http.ResponseWriter, r *http.Request) {
+	handler.Search(a.DB, w, r)
+}
+
+func (a *App) getChatHistory(w http.ResponseWriter, r *http.Request) {
+	handler.GetChatHistory(a.DB, w, r)
+}
+
+func (a *App) deleteChatMessage(w http.ResponseWriter, r *http.Request) {
+	handler.DeleteChatMessage(a.DB, a.Auditor, a.Hub, w, r)
+}
+
+func (a *App) muteChatUser(w http.ResponseWriter, r *http.Request) {
+	handler.MuteChatUser(a.DB, a.Auditor, w, r)
+}
+
+func (a *App) unmuteChatUser(w http.ResponseWriter, r *http.Request) {
+	handler.UnmuteChatUser(a.DB, a.Auditor, w, r)
+}
+
+func (a *App) getConversations(w http.ResponseWriter, r *http.Request) {
+	handler.GetConversations(a.DB, w, r)
+}
+
+func (a *App) createConversation(w http.ResponseWriter, r *http.Request) {
+	handler.CreateConversation(a.DB, a.Hub, w, r)
+}
+
+func (a *App) getUnreadCount(w http.ResponseWriter, r *http.Request) {
+	handler.GetUnreadCount(a.DB, w, r)
+}
+
+func (a *App) getConversation(w http.ResponseWriter, r *http.Request) {
+	handler.GetConversation(a.DB, a.Auditor, w, r)
+}
+
+func (a *App) getMessages(w http.ResponseWriter, r *http.Request) {
+	handler.GetMessages(a.DB, a.Auditor, w, r)
+}
+
+func (a *App) sendMessage(w http.ResponseWriter, r *http.Request) {
+	handler.SendMessage(a.DB, a.Hub, w, r)
+}
+
+func (a *App) markConversationRead(w http.ResponseWriter, r *http.Request) {
+	handler.MarkConversationRead(a.DB, a.Hub, w, r)
+}
+
+func (a *App) leaveConversation(w http.ResponseWriter, r *http.Request) {
+	handler.LeaveConversation(a.DB, w, r)
+}
+
+func (a *App) reportPost(w http.ResponseWriter, r *http.Request) {
+	handler.ReportPost(a.DB, a.Auditor, w, r)
+}
+
+func (a *App) reportComment(w http.ResponseWriter, r *http.Request) {
+	handler.ReportComment(a.DB, a.Auditor, w, r)
+}
+
+func (a *App) reportUser(w http.ResponseWriter, r *http.Request) {
+	handler.ReportUser(a.DB, a.Auditor, w, r)
+}
+
+func (a *App) getReportQueue(w http.ResponseWriter, r *http.Request) {
+	handler.GetReportQueue(a.DB, w, r)
+}
+
+func (a *App) getMyReports(w http.ResponseWriter, r *http.Request) {
+	handler.GetMyReports(a.DB, w, r)
+}
+
+func (a *App) getTargetReports(w http.ResponseWriter, r *http.Request) {
+	handler.GetTargetReports(a.DB, w, r)
+}
+
+func (a *App) resolveReports(w http.ResponseWriter, r *http.Request) {
+	handler.ResolveReports(a.DB, a.Auditor, a.Hub, w, r)
+}
+
+func (a *App) reportConversation(w http.ResponseWriter, r *http.Request) {
+	handler.ReportConversation(a.DB, a.Auditor, w, r)
+}
+
+func (a *App) getBlocks(w http.ResponseWriter, r *http.Request) {
+	handler.GetBlocks(a.DB, w, r)
+}
+
+func (a *App) blockUser(w http.ResponseWriter, r *http.Request) {
+	handler.BlockUser(a.DB, w, r)
+}
+
+func (a *App) unblockUser(w http.ResponseWriter, r *http.Request) {
+	handler.UnblockUser(a.DB, w, r)
+}
+
+func (a *App) getNotifications(w http.ResponseWriter, r *http.Request) {
+	handler.GetNotifications(a.DB, w, r)
+}
+
+func (a *App) getUnreadNotificationCount(w http.ResponseWriter, r *http.Request) {
+	handler.GetUnreadNotificationCount(a.DB, w, r)
+}
+
+func (a *App) markAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	handler.MarkAllNotificationsRead(a.DB, w, r)
+}
+
+func (a *App) getNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	handler.GetNotificationPreferences(a.DB, w, r)
+}
+
+func (a *App) updateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	handler.UpdateNotificationPreferences(a.DB, w, r)
+}
+
+func (a *App) markNotificationRead(w http.ResponseWriter, r *http.Request) {
+	handler.MarkNotificationRead(a.DB, w, r)
+}
+
+func (a *App) deleteNotification(w http.ResponseWriter, r *http.Request) {
+	handler.DeleteNotification(a.DB, w, r)
+}
+
+func (a *App) getBoardSubscriptions(w http.ResponseWriter, r *http.Request) {
+	handler.GetBoardSubscriptions(a.DB, w, r)
+}
+
+func (a *App) subscribeBoard(w http.ResponseWriter, r *http.Request) {
+	handler.SubscribeBoard(a.DB, w, r)
+}
+
+func (a *App) unsubscribeBoard(w http.ResponseWriter, r *http.Request) {
+	handler.UnsubscribeBoard(a.DB, w, r)
+}
+
 func (a *App) Run(host string) {
 	a.Negroni = negroni.Classic()
+	a.Negroni.Use(a.Limiter)
 	a.Negroni.UseHandler(a.Router)
 	//a.Negroni.Use(a.CORS)
 	//a.AuthNegroni.Use(a.CORS)
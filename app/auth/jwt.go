@@ -1,38 +1,66 @@
 package auth
 
 import (
+	"fmt"
+	"forum-server/app/config"
 	"forum-server/app/model"
 	"log"
-	"os"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	_ "github.com/joho/godotenv/autoload"
 )
 
+const AccessTokenLifetime = time.Hour
+
 type Claims struct {
-	ID       string `json:"id"`
-	Username string `json:"username"`
-	Role     string `json:"role"`
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid,omitempty"`
 	jwt.StandardClaims
 }
 
-func GenerateToken(user *model.User) (string, error) {
+// GenerateToken signs an access token for user. sessionId is the refresh
+// token family the access token belongs to, so revoking the family also
+// invalidates the access tokens issued from it.
+func GenerateToken(user *model.User, sessionId string) (string, error) {
 	claims := Claims{
-		ID:       user.ID,
-		Username: user.Username,
+		ID:        user.ID,
+		Username:  user.Username,
+		Role:      user.Role,
+		SessionID: sessionId,
 		StandardClaims: jwt.StandardClaims{
 			Issuer:    "kerrmetric.space",
 			IssuedAt:  time.Now().Unix(),
-			ExpiresAt: time.Now().Unix() + 3600,
+			ExpiresAt: time.Now().Add(AccessTokenLifetime).Unix(),
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	signedToken, err := token.SignedString([]byte(config.String("JWT_SECRET", "")))
 	if err != nil {
 		log.Println("ERROR SIGN:", err)
-		return "", nil
+		return "", err
 	}
 	return signedToken, nil
 }
+
+// ParseToken validates a signed access token and returns its claims. It is
+// used where the jwt middleware doesn't run, such as websocket upgrades.
+func ParseToken(tokenString string) (*Claims, error) {
+	claims := Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return []byte(config.String("JWT_SECRET", "")), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return &claims, nil
+}
@@ -1,12 +1,109 @@
 package auth
 
+const (
+	PermBoardCreate      = "board.create"
+	PermBoardUpdate      = "board.update"
+	PermBoardDelete      = "board.delete"
+	PermPostUpdateAny    = "post.update.any"
+	PermPostDeleteAny    = "post.delete.any"
+	PermCommentDeleteAny = "comment.delete.any"
+	PermUserViewAny      = "user.view.any"
+	PermUserBan          = "user.ban"
+	PermRoleManage       = "role.manage"
+	PermChatModerate     = "chat.moderate"
+	PermMessageModerate  = "message.moderate"
+	PermRevisionRestore  = "revision.restore"
+	PermTrashManage      = "trash.manage"
+	PermPostModerate     = "post.moderate"
+	PermPostAnnounce     = "post.announce"
+	PermTagManage        = "tag.manage"
+	PermReportResolve    = "report.resolve"
+	PermTwoFactorReset   = "user.two_factor.reset"
+)
+
+// Permissions lists every permission the server checks for.
+var Permissions = []string{
+	PermBoardCreate,
+	PermBoardUpdate,
+	PermBoardDelete,
+	PermPostUpdateAny,
+	PermPostDeleteAny,
+	PermCommentDeleteAny,
+	PermUserViewAny,
+	PermUserBan,
+	PermRoleManage,
+	PermChatModerate,
+	PermMessageModerate,
+	PermRevisionRestore,
+	PermTrashManage,
+	PermPostModerate,
+	PermPostAnnounce,
+	PermTagManage,
+	PermReportResolve,
+	PermTwoFactorReset,
+}
+
+// DefaultRoles are created on startup if missing. The admin role is always
+// granted every permission in Permissions.
+var DefaultRoles = map[string][]string{
+	"admin": Permissions,
+	"moderator": {
+		PermPostDeleteAny,
+		PermCommentDeleteAny,
+		PermUserBan,
+		PermChatModerate,
+		PermMessageModerate,
+		PermRevisionRestore,
+		PermTrashManage,
+		PermPostModerate,
+		PermReportResolve,
+	},
+	"user": {},
+}
+
+// AttachmentLimit caps the size of a single upload and the total size of
+// everything one user has uploaded.
+type AttachmentLimit struct {
+	MaxFileSize int64
+	Quota       int64
+}
+
+// AttachmentLimits are keyed by role name. Roles that aren't listed get the
+// limits of the user role.
+var AttachmentLimits = map[string]AttachmentLimit{
+	"admin":     {MaxFileSize: 100 << 20, Quota: 5 << 30},
+	"moderator": {MaxFileSize: 50 << 20, Quota: 1 << 30},
+	"user":      {MaxFileSize: 10 << 20, Quota: 250 << 20},
+}
+
+func AttachmentLimitFor(role string) AttachmentLimit {
+	if limit, ok := AttachmentLimits[role]; ok {
+		return limit
+	}
+	return AttachmentLimits["user"]
+}
+
 type Role struct {
-	ID          string       `json:"id"`
-	Name        string       `json:"name"`
-	Permissions []Permission `json:"permissions"`
+	ID          string       `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	Name        string       `gorm:"UNIQUE" json:"name"`
+	Permissions []Permission `gorm:"many2many:role_permissions" json:"permissions"`
 }
 
 type Permission struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID   string `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	Name string `gorm:"UNIQUE" json:"name"`
+}
+
+type NewRole struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+func (r *Role) HasPermission(name string) bool {
+	for _, p := range r.Permissions {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
 }
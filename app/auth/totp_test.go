@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key from the RFC 6238 test vectors.
+var rfcSecret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+// The RFC lists 8 digit codes; these are their last six.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+}
+
+func TestTOTPCode(t *testing.T) {
+	for _, tt := range rfcVectors {
+		code, err := TOTPCode(rfcSecret, tt.unix/int64(TOTPPeriod.Seconds()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("TOTPCode at %d = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	for _, tt := range rfcVectors {
+		now := time.Unix(tt.unix, 0)
+		counter, ok := VerifyTOTP(rfcSecret, tt.code, now, 0)
+		if !ok || counter != tt.unix/30 {
+			t.Errorf("VerifyTOTP at %d = %d, %v, want %d, true", tt.unix, counter, ok, tt.unix/30)
+		}
+	}
+
+	if _, ok := VerifyTOTP(rfcSecret, "287 082", time.Unix(59, 0), 0); !ok {
+		t.Error("VerifyTOTP refused a code with a space in it")
+	}
+	for _, code := range []string{"", "28708", "2870820", "287083"} {
+		if _, ok := VerifyTOTP(rfcSecret, code, time.Unix(59, 0), 0); ok {
+			t.Errorf("VerifyTOTP accepted %q", code)
+		}
+	}
+	if _, ok := VerifyTOTP("not base32!", "287082", time.Unix(59, 0), 0); ok {
+		t.Error("VerifyTOTP accepted a code for an invalid secret")
+	}
+}
+
+func TestVerifyTOTPSkew(t *testing.T) {
+	const counter = 1234567890 / 30
+	code, err := TOTPCode(rfcSecret, counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(periods int64) time.Time {
+		return time.Unix((counter+periods)*30, 0)
+	}
+
+	for periods := int64(-totpSkew); periods <= totpSkew; periods++ {
+		if got, ok := VerifyTOTP(rfcSecret, code, at(periods), 0); !ok || got != counter {
+			t.Errorf("VerifyTOTP %d periods off = %d, %v, want %d, true", periods, got, ok, counter)
+		}
+	}
+	for _, periods := range []int64{-totpSkew - 1, totpSkew + 1} {
+		if _, ok := VerifyTOTP(rfcSecret, code, at(periods), 0); ok {
+			t.Errorf("VerifyTOTP accepted a code %d periods off", periods)
+		}
+	}
+}
+
+func TestVerifyTOTPReplay(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	last, ok := VerifyTOTP(rfcSecret, "005924", now, 0)
+	if !ok {
+		t.Fatal("VerifyTOTP refused a valid code")
+	}
+	if _, ok := VerifyTOTP(rfcSecret, "005924", now, last); ok {
+		t.Error("VerifyTOTP accepted the same code twice")
+	}
+
+	// a code from before the last one used is refused even inside the window
+	earlier, err := TOTPCode(rfcSecret, last-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := VerifyTOTP(rfcSecret, earlier, now, last); ok {
+		t.Error("VerifyTOTP accepted a code older than the last one used")
+	}
+
+	next, err := TOTPCode(rfcSecret, last+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := VerifyTOTP(rfcSecret, next, now, last); !ok || got != last+1 {
+		t.Errorf("VerifyTOTP for the next period = %d, %v, want %d, true", got, ok, last+1)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if len(code) != recoveryCodeLength+1 || code[recoveryCodeLength/2] != '-' {
+			t.Errorf("recovery code %q isn't two halves joined by a dash", code)
+		}
+		if seen[code] {
+			t.Errorf("recovery code %q generated twice", code)
+		}
+		seen[code] = true
+		if hashes[i] != HashRecoveryCode(code) {
+			t.Errorf("hash of %q doesn't match HashRecoveryCode", code)
+		}
+	}
+
+	if HashRecoveryCode("abcde-fghij") != HashRecoveryCode(" ABCDE FGHIJ") {
+		t.Error("HashRecoveryCode depends on case or separators")
+	}
+}
+
+func TestSealTOTPSecret(t *testing.T) {
+	t.Setenv("TWO_FACTOR_KEY", "test key")
+
+	sealed, err := SealTOTPSecret(rfcSecret, "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed == rfcSecret {
+		t.Fatal("sealed secret is the plain secret")
+	}
+	again, err := SealTOTPSecret(rfcSecret, "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == sealed {
+		t.Error("sealing twice gave the same output")
+	}
+
+	secret, err := OpenTOTPSecret(sealed, "user-1")
+	if err != nil || secret != rfcSecret {
+		t.Fatalf("OpenTOTPSecret = %q, %v, want %q", secret, err, rfcSecret)
+	}
+	if _, err := OpenTOTPSecret(sealed, "user-2"); err == nil {
+		t.Error("opened a secret sealed for another user")
+	}
+	if _, err := OpenTOTPSecret(rfcSecret, "user-1"); err == nil {
+		t.Error("opened a plain secret")
+	}
+
+	t.Setenv("TWO_FACTOR_KEY", "other key")
+	if _, err := OpenTOTPSecret(sealed, "user-1"); err == nil {
+		t.Error("opened a secret with the wrong key")
+	}
+
+	t.Setenv("TWO_FACTOR_KEY", "")
+	if _, err := SealTOTPSecret(rfcSecret, "user-1"); err != ErrNoTOTPKey {
+		t.Errorf("SealTOTPSecret without a key = %v, want ErrNoTOTPKey", err)
+	}
+}
@@ -0,0 +1,52 @@
+// Package config reads settings from the environment. Every setting goes
+// through it so fallbacks and parsing work the same way everywhere.
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// String is the value of key, or fallback if it is unset or empty.
+func String(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// Int is the value of key as an integer, or fallback if it is unset or
+// isn't one.
+func Int(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return n
+	}
+	return fallback
+}
+
+// PositiveInt is like Int but also falls back for zero and negative values,
+// for counts and durations where those make no sense.
+func PositiveInt(key string, fallback int) int {
+	if n := Int(key, fallback); n > 0 {
+		return n
+	}
+	return fallback
+}
+
+// Bool reports whether key is set to "true".
+func Bool(key string) bool {
+	return os.Getenv(key) == "true"
+}
+
+// List splits the value of key, or fallback if it is unset, on commas. Items
+// are trimmed and empty ones dropped.
+func List(key, fallback string) []string {
+	items := []string{}
+	for _, item := range strings.Split(String(key, fallback), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	t.Setenv("CONFIG_TEST", "")
+	if got := String("CONFIG_TEST", "fallback"); got != "fallback" {
+		t.Errorf("String unset = %q, want fallback", got)
+	}
+	t.Setenv("CONFIG_TEST", "value")
+	if got := String("CONFIG_TEST", "fallback"); got != "value" {
+		t.Errorf("String = %q, want value", got)
+	}
+}
+
+func TestInt(t *testing.T) {
+	tests := []struct {
+		value         string
+		int, positive int
+	}{
+		{"", 7, 7},
+		{"12", 12, 12},
+		{"0", 0, 7},
+		{"-3", -3, 7},
+		{"twelve", 7, 7},
+	}
+	for _, tt := range tests {
+		t.Setenv("CONFIG_TEST", tt.value)
+		if got := Int("CONFIG_TEST", 7); got != tt.int {
+			t.Errorf("Int(%q) = %d, want %d", tt.value, got, tt.int)
+		}
+		if got := PositiveInt("CONFIG_TEST", 7); got != tt.positive {
+			t.Errorf("PositiveInt(%q) = %d, want %d", tt.value, got, tt.positive)
+		}
+	}
+}
+
+func TestBool(t *testing.T) {
+	for value, want := range map[string]bool{"true": true, "": false, "1": false, "false": false} {
+		t.Setenv("CONFIG_TEST", value)
+		if got := Bool("CONFIG_TEST"); got != want {
+			t.Errorf("Bool(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	t.Setenv("CONFIG_TEST", "")
+	if got := List("CONFIG_TEST", "a,b"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("List unset = %q, want the fallback", got)
+	}
+	t.Setenv("CONFIG_TEST", " x , ,y,")
+	if got := List("CONFIG_TEST", "a,b"); !reflect.DeepEqual(got, []string{"x", "y"}) {
+		t.Errorf("List = %q, want [x y]", got)
+	}
+}
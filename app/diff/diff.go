@@ -0,0 +1,186 @@
+package diff
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Op types, in the order they appear for a replaced run.
+const (
+	Equal  = "equal"
+	Delete = "delete"
+	Insert = "insert"
+)
+
+// maxEdits bounds the work done on two very different texts. Past it the
+// changed middle is shown as one deletion followed by one insertion.
+const maxEdits = 1000
+
+// Op is a run of text that is the same in both versions, only in the old
+// one (delete) or only in the new one (insert).
+type Op struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Lines compares a and b line by line. Each line keeps its trailing newline,
+// so joining the Text of every equal and insert op gives b back.
+func Lines(a, b string) []Op {
+	return diff(splitLines(a), splitLines(b))
+}
+
+// Words compares a and b word by word, treating each run of whitespace as a
+// token of its own.
+func Words(a, b string) []Op {
+	return diff(splitWords(a), splitWords(b))
+}
+
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func splitWords(s string) []string {
+	words := []string{}
+	start, space := 0, false
+	for i, r := range s {
+		if i > start && unicode.IsSpace(r) != space {
+			words = append(words, s[start:i])
+			start = i
+		}
+		space = unicode.IsSpace(r)
+	}
+	if start < len(s) {
+		words = append(words, s[start:])
+	}
+	return words
+}
+
+// diff trims what a and b share at either end, compares what is left and
+// joins neighbouring tokens of the same type into one op.
+func diff(a, b []string) []Op {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := []Op{}
+	for _, token := range a[:prefix] {
+		ops = appendOp(ops, Equal, token)
+	}
+	for _, op := range myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]) {
+		ops = appendOp(ops, op.Type, op.Text)
+	}
+	for _, token := range a[len(a)-suffix:] {
+		ops = appendOp(ops, Equal, token)
+	}
+	return ops
+}
+
+func appendOp(ops []Op, typ, text string) []Op {
+	if n := len(ops); n > 0 && ops[n-1].Type == typ {
+		ops[n-1].Text += text
+		return ops
+	}
+	return append(ops, Op{Type: typ, Text: text})
+}
+
+// myers finds a shortest edit script from a to b, one op per token. It keeps
+// the frontier of every step so the path can be walked back once b is
+// reached.
+func myers(a, b []string) []Op {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	trace := [][]int{}
+
+	for d := 0; d <= max; d++ {
+		if d > maxEdits {
+			return replace(a, b)
+		}
+		// keep diagonals -d-1 through d+1, all that the walk back reads
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[offset+k-1] < v[offset+k+1] {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b)
+			}
+		}
+	}
+	return replace(a, b)
+}
+
+func backtrack(trace [][]int, a, b []string) []Op {
+	x, y := len(a), len(b)
+	reversed := []Op{}
+
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int {
+			return v[k+d+1]
+		}
+
+		k := x - y
+		prevK := k - 1
+		if k == -d || k != d && at(k-1) < at(k+1) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversed = append(reversed, Op{Type: Equal, Text: a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				reversed = append(reversed, Op{Type: Insert, Text: b[y-1]})
+			} else {
+				reversed = append(reversed, Op{Type: Delete, Text: a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	ops := make([]Op, len(reversed))
+	for i, op := range reversed {
+		ops[len(ops)-1-i] = op
+	}
+	return ops
+}
+
+func replace(a, b []string) []Op {
+	ops := []Op{}
+	if len(a) > 0 {
+		ops = append(ops, Op{Type: Delete, Text: strings.Join(a, "")})
+	}
+	if len(b) > 0 {
+		ops = append(ops, Op{Type: Insert, Text: strings.Join(b, "")})
+	}
+	return ops
+}
@@ -0,0 +1,102 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// rebuild joins the ops each side of a diff is made of, which must give the
+// old and the new text back.
+func rebuild(ops []Op) (string, string) {
+	var a, b strings.Builder
+	for _, op := range ops {
+		switch op.Type {
+		case Equal:
+			a.WriteString(op.Text)
+			b.WriteString(op.Text)
+		case Delete:
+			a.WriteString(op.Text)
+		case Insert:
+			b.WriteString(op.Text)
+		}
+	}
+	return a.String(), b.String()
+}
+
+var roundTrips = []struct {
+	name string
+	a, b string
+}{
+	{"empty", "", ""},
+	{"same", "one\ntwo\n", "one\ntwo\n"},
+	{"from empty", "", "one\ntwo\n"},
+	{"to empty", "one\ntwo\n", ""},
+	{"insert", "one\nthree\n", "one\ntwo\nthree\n"},
+	{"delete", "one\ntwo\nthree\n", "one\nthree\n"},
+	{"replace", "one\ntwo\nthree\n", "one\n2\nthree\n"},
+	{"no trailing newline", "one\ntwo", "one\ntwo\n"},
+	{"reordered", "a b c d e", "e d c b a"},
+	{"whitespace", "a  b\tc", "a b\t\tc "},
+	{"unicode", "héllo wörld", "héllo wörld ☃"},
+	{"interleaved", "a x b y c z", "a b c"},
+}
+
+func TestLinesRoundTrip(t *testing.T) {
+	for _, tt := range roundTrips {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := rebuild(Lines(tt.a, tt.b))
+			if a != tt.a || b != tt.b {
+				t.Errorf("Lines(%q, %q) rebuilds %q, %q", tt.a, tt.b, a, b)
+			}
+		})
+	}
+}
+
+func TestWordsRoundTrip(t *testing.T) {
+	for _, tt := range roundTrips {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := rebuild(Words(tt.a, tt.b))
+			if a != tt.a || b != tt.b {
+				t.Errorf("Words(%q, %q) rebuilds %q, %q", tt.a, tt.b, a, b)
+			}
+		})
+	}
+}
+
+func TestOpsAreMerged(t *testing.T) {
+	ops := Lines("one\ntwo\nthree\nfour\n", "one\n2\n3\nfour\n")
+	want := []Op{
+		{Equal, "one\n"},
+		{Delete, "two\nthree\n"},
+		{Insert, "2\n3\n"},
+		{Equal, "four\n"},
+	}
+	if fmt.Sprint(ops) != fmt.Sprint(want) {
+		t.Errorf("Lines = %v, want %v", ops, want)
+	}
+}
+
+func TestMaxEditsFallback(t *testing.T) {
+	var a, b strings.Builder
+	for i := 0; i < maxEdits; i++ {
+		fmt.Fprintf(&a, "a%d\n", i)
+		fmt.Fprintf(&b, "b%d\n", i)
+	}
+	old := "same\n" + a.String() + "end\n"
+	new := "same\n" + b.String() + "end\n"
+
+	ops := Lines(old, new)
+	want := []Op{
+		{Equal, "same\n"},
+		{Delete, a.String()},
+		{Insert, b.String()},
+		{Equal, "end\n"},
+	}
+	if fmt.Sprint(ops) != fmt.Sprint(want) {
+		t.Fatalf("Lines gave %d ops, want one deletion and one insertion between the shared ends", len(ops))
+	}
+	if ra, rb := rebuild(ops); ra != old || rb != new {
+		t.Error("fallback ops don't rebuild the inputs")
+	}
+}
@@ -0,0 +1,444 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"forum-server/app/auth"
+	"forum-server/app/model"
+	"forum-server/app/storage"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/form3tech-oss/jwt-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxAttachmentsPerItem = 10
+	thumbnailSize         = 320
+	maxFilenameLength     = 255
+
+	// OrphanAttachmentAge is how long an upload may stay unlinked before it
+	// is cleaned up.
+	OrphanAttachmentAge = 24 * time.Hour
+)
+
+var attachmentTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"image/webp",
+	"application/pdf",
+	"application/zip",
+	"application/x-gzip",
+	"text/plain",
+}
+
+var (
+	errTooManyAttachments = fmt.Errorf("at most %d attachments are allowed", maxAttachmentsPerItem)
+	errQuotaExceeded      = errors.New("attachment quota exceeded")
+)
+
+func UploadAttachment(db *gorm.DB, store storage.BlobStore, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := ctx.Value("user").(*jwt.Token).Claims.(jwt.MapClaims)
+
+	reqId := fmt.Sprintf("%v", claims["id"])
+	role, _ := claims["role"].(string)
+	limit := auth.AttachmentLimitFor(role)
+
+	if ban := postingBan(db, reqId, ""); ban != nil {
+		respondBanned(w, ban)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, limit.MaxFileSize+(1<<20))
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, "a file is required")
+		return
+	}
+	defer file.Close()
+
+	data, err := storage.ReadLimited(file, limit.MaxFileSize)
+	if err == storage.ErrTooLarge {
+		RespondError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("attachments must be at most %dMB", limit.MaxFileSize>>20))
+		return
+	}
+	if err != nil || len(data) == 0 {
+		RespondError(w, http.StatusBadRequest, "invalid file")
+		return
+	}
+
+	used, err := attachmentUsage(db, reqId)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	if used+int64(len(data)) > limit.Quota {
+		RespondError(w, http.StatusRequestEntityTooLarge, "attachment quota exceeded")
+		return
+	}
+
+	contentType := storage.DetectContentType(data)
+	if !storage.Allowed(contentType, attachmentTypes) {
+		RespondError(w, http.StatusUnsupportedMediaType, "file type is not allowed")
+		return
+	}
+
+	id := uuid.New().String()
+	attachment := model.Attachment{
+		ID:          id,
+		UploaderID:  reqId,
+		Filename:    attachmentFilename(header.Filename, contentType),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Key:         "attachments/" + id + "/file" + storage.Extension(contentType),
+		CreateDate:  time.Now().UTC(),
+	}
+
+	// images too large to decode are still accepted, just without a thumbnail
+	var thumbnail []byte
+	thumbnailType := ""
+	if storage.Allowed(contentType, storage.ImageTypes) {
+		img, err := storage.DecodeImage(data)
+		if err != nil && err != storage.ErrImageTooLarge {
+			RespondError(w, http.StatusBadRequest, "invalid image")
+			return
+		}
+		if err == nil {
+			attachment.Width = img.Bounds().Dx()
+			attachment.Height = img.Bounds().Dy()
+			thumbnail, thumbnailType, err = storage.EncodeImage(storage.Fit(img, thumbnailSize))
+			if err != nil {
+				log.Println("ERROR ENCODE THUMBNAIL:", err)
+				RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+				return
+			}
+			attachment.ThumbnailKey = "attachments/" + id + "/thumbnail" + storage.Extension(thumbnailType)
+		}
+	}
+
+	if err := storage.PutBytes(ctx, store, attachment.Key, data, contentType); err != nil {
+		log.Println("ERROR STORE ATTACHMENT:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	keys := []string{attachment.Key}
+	if thumbnail != nil {
+		if err := storage.PutBytes(ctx, store, attachment.ThumbnailKey, thumbnail, thumbnailType); err != nil {
+			log.Println("ERROR STORE THUMBNAIL:", err)
+			deleteObjects(store, keys)
+			RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+			return
+		}
+		keys = append(keys, attachment.ThumbnailKey)
+	}
+
+	// the check above is repeated with the uploader locked so concurrent
+	// uploads can't both squeeze under the quota
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", reqId).First(&model.User{}).Error; err != nil {
+			return err
+		}
+		used, err := attachmentUsage(tx, reqId)
+		if err != nil {
+			return err
+		}
+		if used+attachment.Size > limit.Quota {
+			return errQuotaExceeded
+		}
+		return tx.Create(&attachment).Error
+	})
+	if err != nil {
+		deleteObjects(store, keys)
+		if errors.Is(err, errQuotaExceeded) {
+			RespondError(w, http.StatusRequestEntityTooLarge, "attachment quota exceeded")
+			return
+		}
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, withAttachmentURLs(&attachment))
+}
+
+func GetAttachment(db *gorm.DB, store storage.BlobStore, w http.ResponseWriter, r *http.Request) {
+	serveAttachment(db, store, false, w, r)
+}
+
+func GetAttachmentThumbnail(db *gorm.DB, store storage.BlobStore, w http.ResponseWriter, r *http.Request) {
+	serveAttachment(db, store, true, w, r)
+}
+
+func GetPostAttachments(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	postId := vars["postId"]
+
+	post, err := getPostById(db, postId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "post not found")
+		return
+	}
+	if _, err := getBoardByID(db, post.BoardID); err != nil {
+		RespondError(w, http.StatusNotFound, "post not found")
+		return
+	}
+
+	attachments := []model.Attachment{}
+	if err := db.Where("post_id = ?", post.ID).Order("create_date").Find(&attachments).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	for i := range attachments {
+		withAttachmentURLs(&attachments[i])
+	}
+	RespondJSON(w, http.StatusOK, attachments)
+}
+
+func GetAttachmentUsage(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := ctx.Value("user").(*jwt.Token).Claims.(jwt.MapClaims)
+
+	role, _ := claims["role"].(string)
+	limit := auth.AttachmentLimitFor(role)
+
+	used, err := attachmentUsage(db, fmt.Sprintf("%v", claims["id"]))
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, model.AttachmentUsage{
+		Used:        used,
+		Quota:       limit.Quota,
+		MaxFileSize: limit.MaxFileSize,
+	})
+}
+
+func DeleteAttachment(db *gorm.DB, store storage.BlobStore, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	attachment, err := getAttachmentById(db, vars["attachmentId"])
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "attachment not found")
+		return
+	}
+
+	if reqId != attachment.UploaderID && !hasPermission(db, r, auth.PermPostDeleteAny) {
+		RespondError(w, http.StatusUnauthorized, "no access")
+		return
+	}
+
+	if _, err := deleteAttachments(db, store, "id = ?", attachment.ID); err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusNoContent, nil)
+}
+
+// CleanupOrphanAttachments deletes uploads that were never linked to a post
+// or comment within maxAge, returning how many were removed.
+func CleanupOrphanAttachments(db *gorm.DB, store storage.BlobStore, maxAge time.Duration) (int, error) {
+	return deleteAttachments(db, store, "post_id = ? AND create_date < ?", "", time.Now().UTC().Add(-maxAge))
+}
+
+func serveAttachment(db *gorm.DB, store storage.BlobStore, thumbnail bool, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	attachment, err := getAttachmentById(db, vars["attachmentId"])
+	if err != nil || !canViewAttachment(db, optionalUserID(db, r), attachment) {
+		RespondError(w, http.StatusNotFound, "attachment not found")
+		return
+	}
+
+	key := attachment.Key
+	if thumbnail {
+		if attachment.ThumbnailKey == "" {
+			RespondError(w, http.StatusNotFound, "attachment has no thumbnail")
+			return
+		}
+		key = attachment.ThumbnailKey
+	}
+
+	body, contentType, err := store.Get(r.Context(), key)
+	if err == storage.ErrNotFound {
+		RespondError(w, http.StatusNotFound, "attachment not found")
+		return
+	}
+	if err != nil {
+		log.Println("ERROR GET OBJECT:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	defer body.Close()
+
+	// only images are shown inline; everything else is downloaded so it is
+	// never rendered in the site's origin
+	disposition := "attachment"
+	if thumbnail || storage.Allowed(attachment.ContentType, storage.ImageTypes) {
+		disposition = "inline"
+	}
+	if !thumbnail || contentType == "" {
+		contentType = attachment.ContentType
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", contentDisposition(disposition, attachment.Filename))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	io.Copy(w, body)
+}
+
+// canViewAttachment reports whether userId, empty for anonymous callers, may
+// see attachment. Boards are readable by everyone, so a linked attachment is
+// visible for as long as its post, its comment if any and the post's board
+// exist. Unlinked uploads are only visible to their uploader.
+func canViewAttachment(db *gorm.DB, userId string, attachment *model.Attachment) bool {
+	if attachment.PostID == "" {
+		return userId != "" && userId == attachment.UploaderID
+	}
+
+	post, err := getPostById(db, attachment.PostID)
+	if err != nil {
+		return false
+	}
+	if _, err := getBoardByID(db, post.BoardID); err != nil {
+		return false
+	}
+	if attachment.CommentID != "" {
+		comment, err := getCommentById(db, attachment.CommentID)
+		if err != nil || comment.Deleted {
+			return false
+		}
+	}
+	return true
+}
+
+// checkAttachments makes sure ids are unlinked uploads by uploaderId and that
+// adding them to a post or comment that already has existing attachments
+// stays within maxAttachmentsPerItem. It returns ids without duplicates.
+func checkAttachments(db *gorm.DB, uploaderId string, ids []string, existing int64) ([]string, error) {
+	unique := []string{}
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	if len(unique) == 0 {
+		return unique, nil
+	}
+	if existing+int64(len(unique)) > maxAttachmentsPerItem {
+		return nil, errTooManyAttachments
+	}
+
+	var count int64
+	if err := db.Model(&model.Attachment{}).Where("id IN ? AND uploader_id = ? AND post_id = ?", unique, uploaderId, "").Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count != int64(len(unique)) {
+		return nil, errors.New("attachment not found")
+	}
+	return unique, nil
+}
+
+// linkAttachments attaches uploads checked by checkAttachments to a post, or
+// to one of its comments when commentId is set.
+func linkAttachments(db *gorm.DB, ids []string, postId, commentId string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return db.Model(&model.Attachment{}).Where("id IN ? AND post_id = ?", ids, "").Updates(map[string]interface{}{
+		"post_id":    postId,
+		"comment_id": commentId,
+	}).Error
+}
+
+func countAttachments(db *gorm.DB, postId, commentId string) int64 {
+	var count int64
+	db.Model(&model.Attachment{}).Where("post_id = ? AND comment_id = ?", postId, commentId).Count(&count)
+	return count
+}
+
+// deleteAttachments removes the attachments matching the query along with
+// their stored files.
+func deleteAttachments(db *gorm.DB, store storage.BlobStore, query string, args ...interface{}) (int, error) {
+	attachments := []model.Attachment{}
+	if err := db.Where(query, args...).Find(&attachments).Error; err != nil {
+		return 0, err
+	}
+	if len(attachments) == 0 {
+		return 0, nil
+	}
+
+	ids := []string{}
+	keys := []string{}
+	for _, a := range attachments {
+		ids = append(ids, a.ID)
+		keys = append(keys, a.Key)
+		if a.ThumbnailKey != "" {
+			keys = append(keys, a.ThumbnailKey)
+		}
+	}
+	if err := db.Where("id IN ?", ids).Delete(&model.Attachment{}).Error; err != nil {
+		return 0, err
+	}
+	deleteObjects(store, keys)
+	return len(attachments), nil
+}
+
+func attachmentUsage(db *gorm.DB, userId string) (int64, error) {
+	var used int64
+	err := db.Model(&model.Attachment{}).Where("uploader_id = ?", userId).Select("COALESCE(SUM(size), 0)").Scan(&used).Error
+	return used, err
+}
+
+func getAttachmentById(db *gorm.DB, attachmentId string) (*model.Attachment, error) {
+	if attachmentId == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	attachment := model.Attachment{}
+	if err := db.Where(&model.Attachment{ID: attachmentId}).First(&attachment).Error; err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+func withAttachmentURLs(attachment *model.Attachment) *model.Attachment {
+	attachment.URL = "/api/attachments/" + attachment.ID
+	if attachment.ThumbnailKey != "" {
+		attachment.ThumbnailURL = attachment.URL + "/thumbnail"
+	}
+	return attachment
+}
+
+// attachmentFilename keeps the name the client gave a file for display and
+// downloads, minus any directories.
+func attachmentFilename(name, contentType string) string {
+	name = strings.TrimSpace(path.Base(strings.ReplaceAll(name, "\\", "/")))
+	if name == "" || name == "." || name == "/" {
+		return "attachment" + storage.Extension(contentType)
+	}
+	if runes := []rune(name); len(runes) > maxFilenameLength {
+		name = string(runes[len(runes)-maxFilenameLength:])
+	}
+	return name
+}
+
+func contentDisposition(disposition, filename string) string {
+	if header := mime.FormatMediaType(disposition, map[string]string{"filename": filename}); header != "" {
+		return header
+	}
+	return disposition
+}
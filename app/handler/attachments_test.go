@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"forum-server/app/auth"
+	"forum-server/app/model"
+	"forum-server/app/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func uploadAttachment(t *testing.T, db *gorm.DB, store storage.BlobStore, user *model.User, filename string, data []byte, status int) *model.Attachment {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	UploadAttachment(db, store, rec, testUpload(t, "/api/attachments", filename, data, user))
+	attachment := model.Attachment{}
+	decodeResponse(t, rec, status, &attachment)
+	return &attachment
+}
+
+// getAttachment fetches attachmentId as user, or anonymously when user is
+// nil, returning the status.
+func getAttachment(t *testing.T, db *gorm.DB, store storage.BlobStore, user *model.User, attachmentId string) int {
+	t.Helper()
+	r := testRequest(t, "GET", "/api/attachments/"+attachmentId, nil, nil, "attachmentId", attachmentId)
+	if user != nil {
+		token, _, err := issueTokens(db, user, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	GetAttachment(db, store, rec, r)
+	return rec.Code
+}
+
+func TestUploadAttachment(t *testing.T) {
+	db := testDB(t)
+	store := storage.NewMemoryStore("/api/media")
+	user := testUser(t, db, "user")
+
+	image := uploadAttachment(t, db, store, user, `C:\photos\cat.png`, testPNG(t), http.StatusOK)
+	if image.Filename != "cat.png" || image.Width != 300 || image.Height != 200 || image.ThumbnailURL == "" {
+		t.Errorf("image attachment = %+v", image)
+	}
+	if keys := store.Keys(); len(keys) != 2 {
+		t.Errorf("stored %v, want the file and its thumbnail", keys)
+	}
+
+	text := uploadAttachment(t, db, store, user, "notes.txt", []byte("plain notes"), http.StatusOK)
+	if text.ContentType != "text/plain" || text.ThumbnailURL != "" {
+		t.Errorf("text attachment = %+v", text)
+	}
+
+	uploadAttachment(t, db, store, user, "page.txt", []byte("<html><script></script></html>"), http.StatusUnsupportedMediaType)
+
+	// fill the rest of the quota so the next upload goes over it
+	used, _ := attachmentUsage(db, user.ID)
+	filler := model.Attachment{ID: "filler", UploaderID: user.ID, Size: auth.AttachmentLimitFor(user.Role).Quota - used, Key: "attachments/filler/file", CreateDate: time.Now().UTC()}
+	if err := db.Create(&filler).Error; err != nil {
+		t.Fatal(err)
+	}
+	uploadAttachment(t, db, store, user, "notes.txt", []byte("one byte too many"), http.StatusRequestEntityTooLarge)
+	if keys := store.Keys(); len(keys) != 3 {
+		t.Errorf("refused uploads left files behind: %v", keys)
+	}
+}
+
+func TestAttachmentVisibility(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	db := testDB(t)
+	store := storage.NewMemoryStore("/api/media")
+	uploader := testUser(t, db, "user")
+	other := testUser(t, db, "user")
+	board := testBoard(t, db, "board")
+	attachment := uploadAttachment(t, db, store, uploader, "notes.txt", []byte("plain notes"), http.StatusOK)
+
+	for name, user := range map[string]*model.User{"anonymous": nil, "someone else": other} {
+		if status := getAttachment(t, db, store, user, attachment.ID); status != http.StatusNotFound {
+			t.Errorf("%s: unlinked upload status = %d, want 404", name, status)
+		}
+	}
+	if status := getAttachment(t, db, store, uploader, attachment.ID); status != http.StatusOK {
+		t.Errorf("uploader: unlinked upload status = %d, want 200", status)
+	}
+
+	post := func(user *model.User, status int) {
+		t.Helper()
+		body := model.NewPost{Title: "title", Content: "content", AttachmentIDs: []string{attachment.ID}}
+		rec := httptest.NewRecorder()
+		AddPost(db, &testPublisher{}, rec, testRequest(t, "POST", "/api/boards/"+board.ID+"/newPost", body, user, "boardId", board.ID))
+		decodeResponse(t, rec, status, nil)
+	}
+	post(other, http.StatusBadRequest)
+	post(uploader, http.StatusOK)
+
+	if status := getAttachment(t, db, store, nil, attachment.ID); status != http.StatusOK {
+		t.Errorf("anonymous: linked attachment status = %d, want 200", status)
+	}
+	if err := db.Delete(board).Error; err != nil {
+		t.Fatal(err)
+	}
+	if status := getAttachment(t, db, store, uploader, attachment.ID); status != http.StatusNotFound {
+		t.Errorf("attachment on a trashed board status = %d, want 404", status)
+	}
+}
+
+func TestDeleteAttachment(t *testing.T) {
+	db := testDB(t)
+	store := storage.NewMemoryStore("/api/media")
+	uploader := testUser(t, db, "user")
+	other := testUser(t, db, "user")
+	moderator := testUser(t, db, "moderator")
+
+	remove := func(user *model.User, attachmentId string, status int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		DeleteAttachment(db, store, rec, testRequest(t, "DELETE", "/api/attachments/"+attachmentId, nil, user, "attachmentId", attachmentId))
+		decodeResponse(t, rec, status, nil)
+	}
+
+	own := uploadAttachment(t, db, store, uploader, "cat.png", testPNG(t), http.StatusOK)
+	moderated := uploadAttachment(t, db, store, uploader, "notes.txt", []byte("plain notes"), http.StatusOK)
+	remove(other, own.ID, http.StatusUnauthorized)
+	remove(uploader, own.ID, http.StatusNoContent)
+	remove(moderator, moderated.ID, http.StatusNoContent)
+	remove(uploader, own.ID, http.StatusNotFound)
+
+	if keys := store.Keys(); len(keys) != 0 {
+		t.Errorf("deleted attachments left %v behind", keys)
+	}
+}
@@ -0,0 +1,297 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forum-server/app/auth"
+	"forum-server/app/model"
+	"forum-server/audit"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/form3tech-oss/jwt-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	// BanSite locks the user out entirely: they can't sign in and their
+	// sessions stop working.
+	BanSite = "site"
+	// BanReadOnly lets the user sign in and read but not post anywhere.
+	BanReadOnly = "read_only"
+	// BanBoard stops the user posting in one board.
+	BanBoard = "board"
+)
+
+var banScopes = []string{BanSite, BanReadOnly, BanBoard}
+
+var errBanRank = errors.New("you can't ban staff of your own rank or above")
+
+var banSorts = map[string]sortKey{
+	"newest": {column: "start_date", desc: true, kind: sortTime},
+	"oldest": {column: "start_date", desc: false, kind: sortTime},
+}
+
+// BanUser bans a user for DurationHours, or for good when it is 0. Site
+// bans sign the user out everywhere straight away.
+func BanUser(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	userId := vars["userId"]
+
+	user, err := getUserById(db, userId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if user.ID == reqId {
+		RespondError(w, http.StatusBadRequest, "you can't ban yourself")
+		return
+	}
+	if !outranks(db, r, user) {
+		RespondError(w, http.StatusForbidden, errBanRank.Error())
+		return
+	}
+
+	newBan := model.NewBan{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&newBan); err != nil {
+		RespondError(w, http.StatusBadRequest, "")
+		return
+	}
+	defer r.Body.Close()
+
+	if !contains(banScopes, newBan.Scope) {
+		RespondError(w, http.StatusBadRequest, fmt.Sprintf("invalid scope, expected one of %s", strings.Join(banScopes, ", ")))
+		return
+	}
+	if newBan.Scope == BanBoard {
+		if _, err := getBoardByID(db, newBan.BoardID); err != nil {
+			RespondError(w, http.StatusBadRequest, "board not found")
+			return
+		}
+	} else {
+		newBan.BoardID = ""
+	}
+	reason := strings.TrimSpace(newBan.Reason)
+	if reason == "" {
+		RespondError(w, http.StatusBadRequest, "reason is required")
+		return
+	}
+	if err := validReason(reason); err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if newBan.DurationHours < 0 {
+		RespondError(w, http.StatusBadRequest, "duration_hours must not be negative")
+		return
+	}
+
+	ban := model.Ban{
+		UserID:   user.ID,
+		IssuedBy: reqId,
+		Reason:   reason,
+		Scope:    newBan.Scope,
+		BoardID:  newBan.BoardID,
+	}
+	if newBan.DurationHours > 0 {
+		expiresAt := time.Now().UTC().Add(time.Duration(newBan.DurationHours) * time.Hour)
+		ban.ExpiresAt = &expiresAt
+	}
+	if err := issueBan(db, &ban); err != nil {
+		log.Println("ERROR BAN:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	auditor.Log(reqId, "Ban User", "Success", user.ID+" "+ban.Scope)
+	RespondJSON(w, http.StatusCreated, ban)
+}
+
+// LiftBan ends a ban early. The optional reason query parameter is kept
+// with it.
+func LiftBan(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	banId := vars["banId"]
+
+	ban := model.Ban{}
+	if err := activeBans(db, time.Now().UTC()).Where("id = ?", banId).First(&ban).Error; err != nil {
+		RespondError(w, http.StatusNotFound, "ban not found")
+		return
+	}
+
+	reason := r.URL.Query().Get("reason")
+	if err := validReason(reason); err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := time.Now().UTC()
+	ban.LiftedBy = reqId
+	ban.LiftReason = reason
+	ban.LiftDate = &now
+	if err := db.Model(&model.Ban{}).Where("id = ?", ban.ID).Updates(map[string]interface{}{
+		"lifted_by":   ban.LiftedBy,
+		"lift_reason": ban.LiftReason,
+		"lift_date":   ban.LiftDate,
+	}).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	auditor.Log(reqId, "Lift Ban", "Success", ban.UserID+" "+ban.ID)
+	RespondJSON(w, http.StatusOK, ban)
+}
+
+// GetUserBans lists every ban a user has had, including expired and lifted
+// ones.
+func GetUserBans(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userId := vars["userId"]
+
+	p, err := parsePagination(r, banSorts, "newest")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	bans := []model.Ban{}
+	page, err := p.find(db.Model(&model.Ban{}).Where(&model.Ban{UserID: userId}), &bans)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, page)
+}
+
+// GetActiveBans lists the bans in force right now.
+func GetActiveBans(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	p, err := parsePagination(r, banSorts, "newest")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	bans := []model.Ban{}
+	page, err := p.find(activeBans(db, time.Now().UTC()), &bans)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, page)
+}
+
+// outranks reports whether the caller's role ranks above target's, so that
+// moderators can't ban each other or the admins who appointed them.
+func outranks(db *gorm.DB, r *http.Request, target *model.User) bool {
+	roleName, _ := r.Context().Value("user").(*jwt.Token).Claims.(jwt.MapClaims)["role"].(string)
+	return roleRank(db, roleName) > roleRank(db, target.Role)
+}
+
+// roleRank is 2 for roles that can manage roles, 1 for roles that can ban
+// and 0 for everyone else.
+func roleRank(db *gorm.DB, name string) int {
+	role, err := getRoleByName(db, name)
+	if err != nil {
+		return 0
+	}
+	switch {
+	case role.HasPermission(auth.PermRoleManage):
+		return 2
+	case role.HasPermission(auth.PermUserBan):
+		return 1
+	}
+	return 0
+}
+
+// issueBan stores ban, starting now. Site bans also revoke the user's
+// sessions so they are signed out everywhere.
+func issueBan(db *gorm.DB, ban *model.Ban) error {
+	banId, err := uuid.NewUUID()
+	if err != nil {
+		return err
+	}
+	ban.ID = banId.String()
+	ban.StartDate = time.Now().UTC()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(ban).Error; err != nil {
+			return err
+		}
+		if ban.Scope != BanSite {
+			return nil
+		}
+		return revokeUserTokens(tx, ban.UserID)
+	})
+}
+
+// activeBans matches bans that have started and have neither expired nor
+// been lifted at now. Expired bans stop applying on their own.
+func activeBans(db *gorm.DB, now time.Time) *gorm.DB {
+	return db.Model(&model.Ban{}).Where("lift_date IS NULL AND start_date <= ? AND (expires_at IS NULL OR expires_at > ?)", now, now)
+}
+
+// siteBan returns the site ban keeping userId out, or nil if there is none.
+func siteBan(db *gorm.DB, userId string) *model.Ban {
+	return findBan(activeBans(db, time.Now().UTC()).Where("user_id = ? AND scope = ?", userId, BanSite))
+}
+
+// postingBan returns the ban that stops userId posting in boardId, or nil
+// if there is none. boardId is "" for content that isn't in a board, such
+// as private messages, where only site and read only bans apply.
+func postingBan(db *gorm.DB, userId, boardId string) *model.Ban {
+	q := activeBans(db, time.Now().UTC()).Where("user_id = ?", userId)
+	if boardId == "" {
+		q = q.Where("scope IN ?", []string{BanSite, BanReadOnly})
+	} else {
+		q = q.Where("scope IN ? OR (scope = ? AND board_id = ?)", []string{BanSite, BanReadOnly}, BanBoard, boardId)
+	}
+	return findBan(q)
+}
+
+// findBan returns the matching ban that lasts longest. Lookup errors are
+// logged and treated as no ban.
+func findBan(q *gorm.DB) *model.Ban {
+	ban := model.Ban{}
+	err := q.Order("expires_at DESC NULLS FIRST").First(&ban).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Println("ERROR BAN LOOKUP:", err)
+		}
+		return nil
+	}
+	return &ban
+}
+
+// banMessage tells a banned user why and for how long.
+func banMessage(ban *model.Ban) string {
+	message := "you are banned"
+	switch ban.Scope {
+	case BanReadOnly:
+		message = "you are banned from posting"
+	case BanBoard:
+		message = "you are banned from posting in this board"
+	}
+	if ban.ExpiresAt != nil {
+		message += " until " + ban.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	return message + ": " + ban.Reason
+}
+
+func respondBanned(w http.ResponseWriter, ban *model.Ban) {
+	RespondError(w, http.StatusForbidden, banMessage(ban))
+}
@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"forum-server/app/auth"
+	"forum-server/app/mail"
+	"forum-server/app/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func banUser(t *testing.T, db *gorm.DB, moderator, user *model.User, newBan model.NewBan, status int) *model.Ban {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	BanUser(db, testAuditor(db), rec, testRequest(t, "POST", "/api/user/"+user.ID+"/bans", newBan, moderator, "userId", user.ID))
+	if status != http.StatusCreated {
+		decodeResponse(t, rec, status, nil)
+		return nil
+	}
+	ban := model.Ban{}
+	decodeResponse(t, rec, status, &ban)
+	return &ban
+}
+
+func liftBan(t *testing.T, db *gorm.DB, moderator *model.User, ban *model.Ban, status int) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	LiftBan(db, testAuditor(db), rec, testRequest(t, "DELETE", "/api/bans/"+ban.ID+"?reason=appealed", nil, moderator, "banId", ban.ID))
+	decodeResponse(t, rec, status, nil)
+}
+
+func TestBanValidation(t *testing.T) {
+	db := testDB(t)
+	moderator := testUser(t, db, "moderator")
+	user := testUser(t, db, "user")
+	board := testBoard(t, db, "board")
+
+	tests := []struct {
+		name   string
+		target *model.User
+		ban    model.NewBan
+		status int
+	}{
+		{"missing user", &model.User{ID: "missing"}, model.NewBan{Scope: BanSite, Reason: "spam"}, http.StatusNotFound},
+		{"yourself", moderator, model.NewBan{Scope: BanSite, Reason: "spam"}, http.StatusBadRequest},
+		{"unknown scope", user, model.NewBan{Scope: "forever", Reason: "spam"}, http.StatusBadRequest},
+		{"missing board", user, model.NewBan{Scope: BanBoard, BoardID: "missing", Reason: "spam"}, http.StatusBadRequest},
+		{"blank reason", user, model.NewBan{Scope: BanSite, Reason: "  "}, http.StatusBadRequest},
+		{"negative duration", user, model.NewBan{Scope: BanSite, Reason: "spam", DurationHours: -1}, http.StatusBadRequest},
+		{"board", user, model.NewBan{Scope: BanBoard, BoardID: board.ID, Reason: "spam", DurationHours: 24}, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			banUser(t, db, moderator, tt.target, tt.ban, tt.status)
+		})
+	}
+
+	// only board bans keep their board
+	ban := banUser(t, db, moderator, user, model.NewBan{Scope: BanReadOnly, BoardID: board.ID, Reason: "spam"}, http.StatusCreated)
+	if ban.BoardID != "" || ban.ExpiresAt != nil || ban.IssuedBy != moderator.ID {
+		t.Errorf("read only ban = %+v, want a permanent ban without a board", ban)
+	}
+}
+
+func TestBanRank(t *testing.T) {
+	db := testDB(t)
+	admin := testUser(t, db, "admin")
+	moderator := testUser(t, db, "moderator")
+	user := testUser(t, db, "user")
+
+	tests := []struct {
+		caller, target *model.User
+		status         int
+	}{
+		{moderator, testUser(t, db, "moderator"), http.StatusForbidden},
+		{moderator, testUser(t, db, "admin"), http.StatusForbidden},
+		{admin, testUser(t, db, "admin"), http.StatusForbidden},
+		{admin, moderator, http.StatusCreated},
+		{moderator, user, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		banUser(t, db, tt.caller, tt.target, model.NewBan{Scope: BanReadOnly, Reason: "rude"}, tt.status)
+	}
+
+	for _, tt := range []struct {
+		caller *model.User
+		status int
+	}{{user, http.StatusUnauthorized}, {moderator, http.StatusOK}} {
+		rec := httptest.NewRecorder()
+		RequirePermission(db, auth.PermUserBan, rec, testRequest(t, "GET", "/api/bans", nil, tt.caller), func(w http.ResponseWriter, r *http.Request) {
+			GetActiveBans(db, w, r)
+		})
+		if rec.Code != tt.status {
+			t.Errorf("%s listing bans: status = %d, want %d", tt.caller.Role, rec.Code, tt.status)
+		}
+	}
+}
+
+func TestBanEnforcement(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test secret")
+	db := testDB(t)
+	moderator := testUser(t, db, "moderator")
+	user := testUser(t, db, "user")
+	other := testUser(t, db, "user")
+	banned := testBoard(t, db, "banned")
+	open := testBoard(t, db, "open")
+	bannedPost := testPost(t, db, banned, other, "banned")
+	openPost := testPost(t, db, open, other, "open")
+
+	comment := func(post *model.Post, status int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		AddComment(db, &testPublisher{}, rec, testRequest(t, "POST", "/api/post/addComment", model.NewComment{PostID: post.ID, Content: "hi"}, user))
+		decodeResponse(t, rec, status, nil)
+	}
+
+	boardBan := banUser(t, db, moderator, user, model.NewBan{Scope: BanBoard, BoardID: banned.ID, Reason: "spam"}, http.StatusCreated)
+	comment(bannedPost, http.StatusForbidden)
+	comment(openPost, http.StatusOK)
+	conversation := startConversation(t, db, user, other, http.StatusCreated)
+	liftBan(t, db, moderator, boardBan, http.StatusOK)
+	liftBan(t, db, moderator, boardBan, http.StatusNotFound)
+	comment(bannedPost, http.StatusOK)
+
+	readOnly := banUser(t, db, moderator, user, model.NewBan{Scope: BanReadOnly, Reason: "spam", DurationHours: 1}, http.StatusCreated)
+	comment(openPost, http.StatusForbidden)
+	sendMessage(t, db, conversation, user, http.StatusForbidden)
+
+	// expired bans stop applying without being lifted
+	db.Model(&model.Ban{}).Where("id = ?", readOnly.ID).Update("expires_at", time.Now().UTC().Add(-time.Minute))
+	if ban := postingBan(db, user.ID, open.ID); ban != nil {
+		t.Errorf("expired ban %+v still applies", ban)
+	}
+	sendMessage(t, db, conversation, user, http.StatusCreated)
+
+	// site bans sign the user out everywhere and keep them from signing in
+	_, refreshToken, err := issueTokens(db, user, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	siteBan := banUser(t, db, moderator, user, model.NewBan{Scope: BanSite, Reason: "spam"}, http.StatusCreated)
+	refresh(t, db, refreshToken, http.StatusUnauthorized)
+	if _, err := CheckSession(db, user.ID, user.Role, ""); err == nil || err.Status != http.StatusForbidden {
+		t.Errorf("session check while site banned = %v, want forbidden", err)
+	}
+	login := func(status int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		UserLogin(db, testAuditor(db), &mail.MemoryMailer{}, rec, testRequest(t, "POST", "/api/login", model.LoginCredentials{Email: user.Email, Password: testPassword}, nil))
+		decodeResponse(t, rec, status, nil)
+	}
+	login(http.StatusForbidden)
+	liftBan(t, db, moderator, siteBan, http.StatusOK)
+	login(http.StatusOK)
+}
+
+func TestBanHistory(t *testing.T) {
+	db := testDB(t)
+	moderator := testUser(t, db, "moderator")
+	user := testUser(t, db, "user")
+	lifted := banUser(t, db, moderator, user, model.NewBan{Scope: BanReadOnly, Reason: "spam"}, http.StatusCreated)
+	liftBan(t, db, moderator, lifted, http.StatusOK)
+	active := banUser(t, db, moderator, user, model.NewBan{Scope: BanSite, Reason: "spam again"}, http.StatusCreated)
+	banUser(t, db, moderator, testUser(t, db, "user"), model.NewBan{Scope: BanSite, Reason: "spam"}, http.StatusCreated)
+
+	rec := httptest.NewRecorder()
+	GetUserBans(db, rec, testRequest(t, "GET", "/api/user/"+user.ID+"/bans", nil, moderator, "userId", user.ID))
+	history := []model.Ban{}
+	decodePage(t, rec, &history)
+	if len(history) != 2 || history[0].ID != active.ID || history[1].ID != lifted.ID {
+		t.Fatalf("history = %+v, want the active ban then the lifted one", history)
+	}
+	if history[1].LiftedBy != moderator.ID || history[1].LiftReason != "appealed" || history[1].LiftDate == nil {
+		t.Errorf("lifted ban = %+v", history[1])
+	}
+
+	rec = httptest.NewRecorder()
+	GetActiveBans(db, rec, testRequest(t, "GET", "/api/bans", nil, moderator))
+	bans := []model.Ban{}
+	decodePage(t, rec, &bans)
+	if len(bans) != 2 {
+		t.Errorf("active bans = %+v, want 2", bans)
+	}
+	for _, ban := range bans {
+		if ban.ID == lifted.ID {
+			t.Error("lifted ban is still listed as active")
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"forum-server/app/model"
+	"forum-server/app/search"
+	"log"
 	"net/http"
 	"time"
 
@@ -14,9 +16,19 @@ import (
 )
 
 func GetBoards(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	p, err := parsePagination(r, boardSorts, "oldest")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	boards := []model.Board{}
-	db.Find(&boards)
-	RespondJSON(w, http.StatusOK, boards)
+	page, err := p.find(db.Model(&model.Board{}), &boards)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, page)
 }
 
 func GetBoard(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
@@ -31,21 +43,6 @@ func GetBoard(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 }
 
 func CreateBoard(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	userCtx := ctx.Value("user")
-
-	reqId := userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"]
-	userRole, err := getUserRoleById(db, fmt.Sprintf("%v", reqId))
-	if err != nil {
-		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
-		return
-	}
-
-	if userRole != "admin" {
-		RespondError(w, http.StatusUnauthorized, "no access")
-		return
-	}
-
 	newBoard := model.NewBoard{}
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&newBoard); err != nil {
@@ -71,25 +68,13 @@ func CreateBoard(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
 		return
 	}
+	if err := search.Index(db, search.TypeBoard, board.ID); err != nil {
+		log.Println("ERROR INDEX:", err)
+	}
 	RespondJSON(w, http.StatusCreated, board)
 }
 
 func UpdateBoard(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	userCtx := ctx.Value("user")
-
-	reqId := userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"]
-	userRole, err := getUserRoleById(db, fmt.Sprintf("%v", reqId))
-	if err != nil {
-		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
-		return
-	}
-
-	if userRole != "admin" {
-		RespondError(w, http.StatusUnauthorized, "no access")
-		return
-	}
-
 	vars := mux.Vars(r)
 	boardId := vars["boardId"]
 
@@ -110,6 +95,9 @@ func UpdateBoard(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		RespondError(w, http.StatusInternalServerError, "")
 		return
 	}
+	if err := search.Index(db, search.TypeBoard, board.ID); err != nil {
+		log.Println("ERROR INDEX:", err)
+	}
 	RespondJSON(w, http.StatusOK, board)
 }
 
@@ -118,16 +106,6 @@ func DeleteBoard(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	userCtx := ctx.Value("user")
 
 	reqId := userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"]
-	userRole, err := getUserRoleById(db, fmt.Sprintf("%v", reqId))
-	if err != nil {
-		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
-		return
-	}
-
-	if userRole != "admin" {
-		RespondError(w, http.StatusUnauthorized, "no access")
-		return
-	}
 
 	vars := mux.Vars(r)
 	boardId := vars["boardId"]
@@ -138,7 +116,13 @@ func DeleteBoard(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := db.Delete(&board).Error; err != nil {
+	reason, err := trashReason(r)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := deleteBoard(db, board, fmt.Sprintf("%v", reqId), reason); err != nil {
 		RespondError(w, http.StatusInternalServerError, "")
 		return
 	}
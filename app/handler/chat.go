@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forum-server/app/model"
+	"forum-server/audit"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/form3tech-oss/jwt-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	chatBackfillSize     = 50
+	maxChatMessageLength = 2000
+)
+
+var (
+	ErrChatMuted          = errors.New("you are muted in chat")
+	ErrChatEmpty          = errors.New("message is empty")
+	ErrChatMessageTooLong = errors.New("message is too long")
+	ErrChatUnverified     = errors.New("verify your email address first")
+	ErrChatBanned         = errors.New("you are banned from posting")
+)
+
+// SaveChatMessage stores a message sent to the global chat room.
+func SaveChatMessage(db *gorm.DB, userId, content string) (*model.ChatMessage, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, ErrChatEmpty
+	}
+	if len(content) > maxChatMessageLength {
+		return nil, ErrChatMessageTooLong
+	}
+
+	user, err := getUserById(db, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !user.EmailVerified {
+		return nil, ErrChatUnverified
+	}
+
+	muted, err := isChatMuted(db, userId)
+	if err != nil {
+		return nil, err
+	}
+	if muted {
+		return nil, ErrChatMuted
+	}
+	if postingBan(db, userId, "") != nil {
+		return nil, ErrChatBanned
+	}
+
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	message := model.ChatMessage{
+		ID:         id.String(),
+		AuthorID:   userId,
+		Content:    content,
+		CreateDate: time.Now().UTC().Format(sortableTimeFormat),
+	}
+	if err := db.Save(&message).Error; err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// ChatBackfill returns the most recent chat messages, oldest first, for
+// clients joining the room.
+func ChatBackfill(db *gorm.DB) ([]model.ChatMessage, error) {
+	messages := []model.ChatMessage{}
+	if err := db.Where("deleted = ?", false).Order("create_date desc").Limit(chatBackfillSize).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+func GetChatHistory(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	p, err := parsePagination(r, chatSorts, "newest")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	messages := []model.ChatMessage{}
+	page, err := p.find(db.Model(&model.ChatMessage{}).Where("deleted = ?", false), &messages)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, page)
+}
+
+func DeleteChatMessage(db *gorm.DB, auditor *audit.Auditor, pub Publisher, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	messageId := vars["messageId"]
+
+	message := model.ChatMessage{}
+	if err := db.Where(&model.ChatMessage{ID: messageId}).First(&message).Error; err != nil {
+		RespondError(w, http.StatusNotFound, "message not found")
+		return
+	}
+
+	message.Deleted = true
+	message.DeletedBy = reqId
+	if err := db.Save(&message).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	auditor.Log(reqId, "Delete Chat Message", "Success", message.ID)
+	pub.Publish(ChatChannel, EventChatDeleted, map[string]string{"id": message.ID})
+	RespondJSON(w, http.StatusNoContent, nil)
+}
+
+func MuteChatUser(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	newMute := model.NewChatMute{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&newMute); err != nil {
+		RespondError(w, http.StatusBadRequest, "")
+		return
+	}
+	defer r.Body.Close()
+
+	if newMute.Minutes < 1 {
+		RespondError(w, http.StatusBadRequest, "minutes must be positive")
+		return
+	}
+
+	if _, err := getUserById(db, newMute.UserID); err != nil {
+		RespondError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	now := time.Now().UTC()
+	mute := model.ChatMute{
+		UserID:     newMute.UserID,
+		MutedBy:    reqId,
+		Reason:     newMute.Reason,
+		Until:      now.Add(time.Duration(newMute.Minutes) * time.Minute),
+		CreateDate: now,
+	}
+	if err := db.Save(&mute).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	auditor.Log(reqId, "Mute Chat User", "Success", mute.UserID+" until "+mute.Until.Format(time.RFC3339))
+	RespondJSON(w, http.StatusOK, mute)
+}
+
+func UnmuteChatUser(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	userId := vars["userId"]
+
+	if err := db.Where(&model.ChatMute{UserID: userId}).Delete(&model.ChatMute{}).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	auditor.Log(reqId, "Unmute Chat User", "Success", userId)
+	RespondJSON(w, http.StatusNoContent, nil)
+}
+
+func isChatMuted(db *gorm.DB, userId string) (bool, error) {
+	var count int64
+	if err := db.Model(&model.ChatMute{}).Where("user_id = ? AND until > ?", userId, time.Now().UTC()).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
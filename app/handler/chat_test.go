@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"forum-server/app/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSaveChatMessageRefusals(t *testing.T) {
+	db := testDB(t)
+	user := testUser(t, db, "user")
+	unverified := testUser(t, db, "user")
+	if err := db.Model(unverified).Update("email_verified", false).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		userId  string
+		content string
+		want    error
+	}{
+		{"empty", user.ID, "  ", ErrChatEmpty},
+		{"too long", user.ID, strings.Repeat("x", maxChatMessageLength+1), ErrChatMessageTooLong},
+		{"unverified", unverified.ID, "hello", ErrChatUnverified},
+	}
+	for _, tt := range tests {
+		if _, err := SaveChatMessage(db, tt.userId, tt.content); err != tt.want {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	message, err := SaveChatMessage(db, user.ID, "  hello  ")
+	if err != nil || message.Content != "hello" || message.AuthorID != user.ID {
+		t.Fatalf("SaveChatMessage = %+v, %v", message, err)
+	}
+}
+
+func TestChatModeration(t *testing.T) {
+	db := testDB(t)
+	auditor := testAuditor(db)
+	moderator := testUser(t, db, "moderator")
+	user := testUser(t, db, "user")
+
+	message, err := SaveChatMessage(db, user.ID, "spam")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// muting needs a positive duration and a real user
+	for _, body := range []model.NewChatMute{{UserID: user.ID}, {UserID: "missing", Minutes: 5}} {
+		rec := httptest.NewRecorder()
+		MuteChatUser(db, auditor, rec, testRequest(t, "POST", "/api/chat/mutes", body, moderator))
+		if rec.Code == http.StatusOK {
+			t.Errorf("muted with %+v", body)
+		}
+	}
+	rec := httptest.NewRecorder()
+	MuteChatUser(db, auditor, rec, testRequest(t, "POST", "/api/chat/mutes", model.NewChatMute{UserID: user.ID, Minutes: 5}, moderator))
+	decodeResponse(t, rec, http.StatusOK, nil)
+	if _, err := SaveChatMessage(db, user.ID, "more spam"); err != ErrChatMuted {
+		t.Errorf("muted user's message: err = %v, want ErrChatMuted", err)
+	}
+
+	rec = httptest.NewRecorder()
+	UnmuteChatUser(db, auditor, rec, testRequest(t, "DELETE", "/api/chat/mutes/"+user.ID, nil, moderator, "userId", user.ID))
+	decodeResponse(t, rec, http.StatusNoContent, nil)
+	if _, err := SaveChatMessage(db, user.ID, "sorry"); err != nil {
+		t.Errorf("unmuted user's message: %v", err)
+	}
+
+	pub := &testPublisher{}
+	rec = httptest.NewRecorder()
+	DeleteChatMessage(db, auditor, pub, rec, testRequest(t, "DELETE", "/api/chat/messages/"+message.ID, nil, moderator, "messageId", message.ID))
+	decodeResponse(t, rec, http.StatusNoContent, nil)
+	if len(pub.events) != 1 || pub.events[0].Event != EventChatDeleted {
+		t.Errorf("published %+v, want one %s", pub.events, EventChatDeleted)
+	}
+
+	backfill, err := ChatBackfill(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	GetChatHistory(db, rec, testRequest(t, "GET", "/api/chat/history", nil, user))
+	history := []model.ChatMessage{}
+	decodePage(t, rec, &history)
+	for _, messages := range [][]model.ChatMessage{backfill, history} {
+		if len(messages) != 1 || messages[0].Content != "sorry" {
+			t.Errorf("messages = %+v, want only the one left", messages)
+		}
+	}
+}
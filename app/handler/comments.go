@@ -3,7 +3,11 @@ package handler
 import (
 	"encoding/json"
 	"fmt"
+	"forum-server/app/auth"
+	"forum-server/app/markup"
 	"forum-server/app/model"
+	"forum-server/app/search"
+	"log"
 	"net/http"
 	"time"
 
@@ -34,7 +38,13 @@ func GetCommentsFromUser(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	comments, err := getCommentsFromUser(db, user.ID)
+	p, err := parsePagination(r, commentSorts, "newest")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	comments, err := getCommentsFromUser(db, user.ID, p)
 	if err != nil {
 		RespondError(w, http.StatusNotFound, "comments not found")
 		return
@@ -45,7 +55,14 @@ func GetCommentsFromUser(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 func GetCommentsFromPost(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	postId := vars["postId"]
-	comments, err := getCommentsFromPost(db, postId)
+
+	p, err := parsePagination(r, commentSorts, "oldest")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	comments, err := getCommentsFromPost(db, postId, p)
 	if err != nil {
 		RespondError(w, http.StatusNotFound, "comments not found")
 		return
@@ -53,7 +70,7 @@ func GetCommentsFromPost(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	RespondJSON(w, http.StatusOK, comments)
 }
 
-func AddComment(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+func AddComment(db *gorm.DB, pub Publisher, w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	userCtx := ctx.Value("user")
 
@@ -67,6 +84,45 @@ func AddComment(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
+	post, err := getPostById(db, newComment.PostID)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "post not found")
+		return
+	}
+	if closed := postClosed(post); closed != "" {
+		RespondError(w, http.StatusForbidden, closed)
+		return
+	}
+	if ban := postingBan(db, fmt.Sprintf("%v", reqId), post.BoardID); ban != nil {
+		respondBanned(w, ban)
+		return
+	}
+
+	attachmentIds, err := checkAttachments(db, fmt.Sprintf("%v", reqId), newComment.AttachmentIDs, 0)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	depth := 0
+	var parent *model.Comment
+	if newComment.ParentID != "" {
+		parent, err = getCommentById(db, newComment.ParentID)
+		if err != nil || parent.PostID != newComment.PostID {
+			RespondError(w, http.StatusBadRequest, "parent comment not found")
+			return
+		}
+		if parent.Deleted {
+			RespondError(w, http.StatusBadRequest, "cannot reply to a deleted comment")
+			return
+		}
+		depth = parent.Depth + 1
+		if depth > maxCommentDepth() {
+			RespondError(w, http.StatusBadRequest, "maximum reply depth reached")
+			return
+		}
+	}
+
 	commentId, err := uuid.NewUUID()
 	if err != nil {
 		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
@@ -74,23 +130,69 @@ func AddComment(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	}
 
 	comment := model.Comment{
-		ID:         commentId.String(),
-		AuthorID:   fmt.Sprintf("%v", reqId),
-		PostID:     newComment.PostID,
-		ParentID:   "",
-		Content:    newComment.Content,
-		CreateDate: time.Now().UTC().Format(time.RFC3339),
+		ID:          commentId.String(),
+		AuthorID:    fmt.Sprintf("%v", reqId),
+		PostID:      newComment.PostID,
+		ParentID:    newComment.ParentID,
+		Depth:       depth,
+		Content:     newComment.Content,
+		ContentHTML: markup.Render(newComment.Content),
+		CreateDate:  time.Now().UTC().Format(time.RFC3339),
 	}
 
 	if err = db.Save(&comment).Error; err != nil {
 		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
 		return
 	}
+	if err := linkAttachments(db, attachmentIds, comment.PostID, comment.ID); err != nil {
+		log.Println("ERROR LINK ATTACHMENTS:", err)
+	}
+	if err := search.Index(db, search.TypeComment, comment.ID); err != nil {
+		log.Println("ERROR INDEX:", err)
+	}
+
+	if err := db.Model(&model.Post{}).Where(&model.Post{ID: comment.PostID}).Updates(map[string]interface{}{
+		"comment_count": gorm.Expr("comment_count + 1"),
+		"last_activity": comment.CreateDate,
+	}).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	pub.Publish(PostChannel(post.ID), EventCommentCreated, comment)
+	pub.Publish(BoardChannel(post.BoardID), EventCommentCreated, comment)
+	if post.AuthorID != comment.AuthorID {
+		pub.Publish(UserChannel(post.AuthorID), EventCommentCreated, comment)
+	}
+
+	notified := map[string]bool{post.AuthorID: true}
+	notify(db, pub, notice{
+		UserID:     post.AuthorID,
+		Type:       NotifyReply,
+		ActorID:    comment.AuthorID,
+		TargetType: "post",
+		TargetID:   post.ID,
+		GroupKey:   NotifyReply + ":post:" + post.ID,
+		Subject:    post.Title,
+	})
+	if parent != nil && !notified[parent.AuthorID] {
+		notified[parent.AuthorID] = true
+		notify(db, pub, notice{
+			UserID:     parent.AuthorID,
+			Type:       NotifyReply,
+			ActorID:    comment.AuthorID,
+			TargetType: "comment",
+			TargetID:   parent.ID,
+			GroupKey:   NotifyReply + ":comment:" + parent.ID,
+			Subject:    "your comment on " + post.Title,
+		})
+	}
+	notifyMentions(db, pub, comment.AuthorID, comment.Content, "comment", comment.ID, post.Title, notified)
 
 	RespondJSON(w, http.StatusOK, comment)
 }
 
-func UpdateComment(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+func UpdateComment(db *gorm.DB, pub Publisher, w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	userCtx := ctx.Value("user")
 
@@ -105,35 +207,63 @@ func UpdateComment(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if reqId != comment.AuthorID {
+	if reqId != comment.AuthorID || comment.Deleted {
 		RespondError(w, http.StatusUnauthorized, "no access")
 		return
 	}
 
+	post, err := getPostById(db, comment.PostID)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "post not found")
+		return
+	}
+	if post.Archived {
+		RespondError(w, http.StatusForbidden, "post is archived")
+		return
+	}
+	if ban := postingBan(db, fmt.Sprintf("%v", reqId), post.BoardID); ban != nil {
+		respondBanned(w, ban)
+		return
+	}
+
+	edit := model.CommentEdit{}
 	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&comment); err != nil {
+	if err := decoder.Decode(&edit); err != nil {
 		RespondError(w, http.StatusBadRequest, "")
 		return
 	}
 	defer r.Body.Close()
 
-	if err := db.Save(&comment).Error; err != nil {
+	if err := validReason(edit.Reason); err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	attachmentIds, err := checkAttachments(db, fmt.Sprintf("%v", reqId), edit.AttachmentIDs, countAttachments(db, comment.PostID, comment.ID))
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := editComment(db, comment, fmt.Sprintf("%v", reqId), edit.Reason, edit.Content); err != nil {
 		RespondError(w, http.StatusInternalServerError, "")
 		return
 	}
+	if err := linkAttachments(db, attachmentIds, comment.PostID, comment.ID); err != nil {
+		log.Println("ERROR LINK ATTACHMENTS:", err)
+	}
+	if err := search.Index(db, search.TypeComment, comment.ID); err != nil {
+		log.Println("ERROR INDEX:", err)
+	}
+	pub.Publish(PostChannel(comment.PostID), EventCommentUpdated, comment)
 	RespondJSON(w, http.StatusOK, comment)
 }
 
-func DeleteComment(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+func DeleteComment(db *gorm.DB, pub Publisher, w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	userCtx := ctx.Value("user")
 
 	reqId := userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"]
-	userRole, err := getUserRoleById(db, fmt.Sprintf("%v", reqId))
-	if err != nil {
-		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
-		return
-	}
 
 	vars := mux.Vars(r)
 	commentId := vars["commentId"]
@@ -144,15 +274,29 @@ func DeleteComment(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if reqId != comment.AuthorID && userRole != "admin" && userRole != "moderator" {
+	if reqId != comment.AuthorID && !hasPermission(db, r, auth.PermCommentDeleteAny) {
 		RespondError(w, http.StatusUnauthorized, "no access")
 		return
 	}
 
-	if err := db.Delete(&comment).Error; err != nil {
+	if comment.Deleted {
+		RespondError(w, http.StatusNotFound, "comment not found")
+		return
+	}
+
+	reason, err := trashReason(r)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := removeComment(db, comment, fmt.Sprintf("%v", reqId), reason); err != nil {
 		RespondError(w, http.StatusInternalServerError, "")
 		return
 	}
+	db.Model(&model.Post{}).Where(&model.Post{ID: comment.PostID}).Update("comment_count", gorm.Expr("comment_count - 1"))
+	pub.Publish(PostChannel(comment.PostID), EventCommentDeleted, map[string]string{"id": comment.ID, "post_id": comment.PostID})
+	notifyModeration(db, pub, fmt.Sprintf("%v", reqId), comment.AuthorID, "post", comment.PostID, "A moderator deleted your comment")
 	RespondJSON(w, http.StatusNoContent, nil)
 }
 
@@ -201,18 +345,12 @@ func getCommentById(db *gorm.DB, commentId string) (*model.Comment, error) {
 	return &comment, nil
 }
 
-func getCommentsFromUser(db *gorm.DB, userId string) (*[]model.Comment, error) {
-	var comments []model.Comment
-	if err := db.Where(&model.Comment{AuthorID: userId}).Find(&comments).Error; err != nil {
-		return nil, err
-	}
-	return &comments, nil
+func getCommentsFromUser(db *gorm.DB, userId string, p *pagination) (*Page, error) {
+	comments := []model.Comment{}
+	return p.find(db.Model(&model.Comment{}).Where(&model.Comment{AuthorID: userId}), &comments)
 }
 
-func getCommentsFromPost(db *gorm.DB, postId string) (*[]model.Comment, error) {
-	var comments []model.Comment
-	if err := db.Where(&model.Comment{PostID: postId}).Find(&comments).Error; err != nil {
-		return nil, err
-	}
-	return &comments, nil
+func getCommentsFromPost(db *gorm.DB, postId string, p *pagination) (*Page, error) {
+	comments := []model.Comment{}
+	return p.find(db.Model(&model.Comment{}).Where(&model.Comment{PostID: postId}), &comments)
 }
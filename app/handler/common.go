@@ -1,10 +1,52 @@
 package handler
 
 import (
+	"database/sql/driver"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"time"
 )
 
+// aggregateTimeFormats are the layouts the embedded store writes dates in.
+var aggregateTimeFormats = []string{"2006-01-02 15:04:05.999999999-07:00", time.RFC3339Nano}
+
+// aggregateTime scans a date computed by a query, such as MAX(create_date).
+// The embedded store returns those as text rather than as timestamps, so
+// they can't be scanned straight into a time.Time. NULL scans as the zero
+// time.
+type aggregateTime struct {
+	time.Time
+}
+
+func (t *aggregateTime) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	case time.Time:
+		t.Time = v
+		return nil
+	case []byte:
+		value = string(v)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("invalid time %v", value)
+	}
+	for _, layout := range aggregateTimeFormats {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid time %q", s)
+}
+
+func (t aggregateTime) Value() (driver.Value, error) {
+	return t.Time, nil
+}
+
 // RespondJSON responds with json
 func RespondJSON(w http.ResponseWriter, status int, payload interface{}) {
 	response, err := json.Marshal(payload)
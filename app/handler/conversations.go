@@ -0,0 +1,586 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forum-server/app/auth"
+	"forum-server/app/model"
+	"forum-server/audit"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/form3tech-oss/jwt-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	maxConversationParticipants = 20
+	maxMessageLength            = 5000
+)
+
+// GetConversations lists the conversations the caller takes part in, most
+// recently active first.
+func GetConversations(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	p, err := parsePagination(r, conversationSorts, "last_message")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	joined := db.Model(&model.ConversationParticipant{}).Select("conversation_id").Where("user_id = ? AND left_date IS NULL", reqId)
+
+	conversations := []model.Conversation{}
+	page, err := p.find(db.Model(&model.Conversation{}).Where("id IN (?)", joined), &conversations)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	if err := fillConversations(db, reqId, conversations); err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	page.Items = conversations
+	RespondJSON(w, http.StatusOK, page)
+}
+
+func GetUnreadCount(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	var count int64
+	err := db.Model(&model.Message{}).
+		Joins("JOIN conversation_participants ON conversation_participants.conversation_id = messages.conversation_id").
+		Where("conversation_participants.user_id = ? AND conversation_participants.left_date IS NULL", reqId).
+		Where("messages.author_id <> ? AND messages.create_date > conversation_participants.last_read_at", reqId).
+		Count(&count).Error
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, model.UnreadCount{Unread: count})
+}
+
+// CreateConversation starts a conversation between the caller and the given
+// users with a first message.
+func CreateConversation(db *gorm.DB, pub Publisher, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	newConversation := model.NewConversation{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&newConversation); err != nil {
+		RespondError(w, http.StatusBadRequest, "an unknown error has occurred")
+		return
+	}
+	defer r.Body.Close()
+
+	if ban := postingBan(db, reqId, ""); ban != nil {
+		respondBanned(w, ban)
+		return
+	}
+
+	others := []string{}
+	seen := map[string]bool{reqId: true}
+	for _, id := range newConversation.Participants {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		others = append(others, id)
+	}
+	if len(others) == 0 {
+		RespondError(w, http.StatusBadRequest, "a conversation needs at least one other participant")
+		return
+	}
+	if len(others)+1 > maxConversationParticipants {
+		RespondError(w, http.StatusBadRequest, fmt.Sprintf("a conversation can have at most %d participants", maxConversationParticipants))
+		return
+	}
+
+	content, err := checkMessageContent(newConversation.Content)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var found int64
+	if err := db.Model(&model.User{}).Where("id IN ? AND active = ?", others, true).Count(&found).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	if found != int64(len(others)) {
+		RespondError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	blocked, err := isBlockedBetween(db, reqId, others)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	if blocked {
+		RespondError(w, http.StatusForbidden, "you cannot message this user")
+		return
+	}
+
+	conversationId, err := uuid.NewUUID()
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	now := time.Now().UTC()
+	conversation := model.Conversation{
+		ID:         conversationId.String(),
+		CreatorID:  reqId,
+		Subject:    strings.TrimSpace(newConversation.Subject),
+		CreateDate: now,
+	}
+	for _, id := range append([]string{reqId}, others...) {
+		conversation.Participants = append(conversation.Participants, model.ConversationParticipant{
+			ConversationID: conversation.ID,
+			UserID:         id,
+			JoinDate:       now,
+		})
+	}
+
+	var message *model.Message
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&conversation).Error; err != nil {
+			return err
+		}
+		message, err = saveMessage(tx, &conversation, reqId, content)
+		return err
+	})
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	publishMessage(pub, &conversation, message)
+	RespondJSON(w, http.StatusCreated, conversation)
+}
+
+func GetConversation(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	conversationId := vars["conversationId"]
+
+	conversation, err := getConversationById(db, conversationId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "conversation not found")
+		return
+	}
+	if !canViewConversation(db, auditor, r, reqId, conversation) {
+		RespondError(w, http.StatusNotFound, "conversation not found")
+		return
+	}
+
+	conversations := []model.Conversation{*conversation}
+	if err := fillConversations(db, reqId, conversations); err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, conversations[0])
+}
+
+func GetMessages(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	conversationId := vars["conversationId"]
+
+	p, err := parsePagination(r, messageSorts, "newest")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	conversation, err := getConversationById(db, conversationId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "conversation not found")
+		return
+	}
+	if !canViewConversation(db, auditor, r, reqId, conversation) {
+		RespondError(w, http.StatusNotFound, "conversation not found")
+		return
+	}
+
+	messages := []model.Message{}
+	page, err := p.find(db.Model(&model.Message{}).Where(&model.Message{ConversationID: conversation.ID}), &messages)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, page)
+}
+
+func SendMessage(db *gorm.DB, pub Publisher, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	conversationId := vars["conversationId"]
+
+	newMessage := model.NewMessage{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&newMessage); err != nil {
+		RespondError(w, http.StatusBadRequest, "an unknown error has occurred")
+		return
+	}
+	defer r.Body.Close()
+
+	if ban := postingBan(db, reqId, ""); ban != nil {
+		respondBanned(w, ban)
+		return
+	}
+
+	content, err := checkMessageContent(newMessage.Content)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	conversation, err := getConversationById(db, conversationId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "conversation not found")
+		return
+	}
+	if _, err := getActiveParticipant(db, conversation.ID, reqId); err != nil {
+		RespondError(w, http.StatusNotFound, "conversation not found")
+		return
+	}
+
+	if err := db.Where("left_date IS NULL").Where(&model.ConversationParticipant{ConversationID: conversation.ID}).Find(&conversation.Participants).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	others := []string{}
+	for _, participant := range conversation.Participants {
+		if participant.UserID != reqId {
+			others = append(others, participant.UserID)
+		}
+	}
+	if len(others) == 0 {
+		RespondError(w, http.StatusBadRequest, "everyone else has left this conversation")
+		return
+	}
+
+	blocked, err := isBlockedBetween(db, reqId, others)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	if blocked {
+		RespondError(w, http.StatusForbidden, "you cannot message this user")
+		return
+	}
+
+	var message *model.Message
+	err = db.Transaction(func(tx *gorm.DB) error {
+		message, err = saveMessage(tx, conversation, reqId, content)
+		return err
+	})
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	publishMessage(pub, conversation, message)
+	RespondJSON(w, http.StatusCreated, message)
+}
+
+// MarkConversationRead moves the caller's read receipt up to the newest
+// message in the conversation.
+func MarkConversationRead(db *gorm.DB, pub Publisher, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	conversationId := vars["conversationId"]
+
+	conversation, err := getConversationById(db, conversationId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "conversation not found")
+		return
+	}
+	participant, err := getActiveParticipant(db, conversation.ID, reqId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "conversation not found")
+		return
+	}
+
+	if participant.LastReadAt < conversation.LastMessageAt {
+		participant.LastReadAt = conversation.LastMessageAt
+		if err := db.Model(participant).Update("last_read_at", participant.LastReadAt).Error; err != nil {
+			RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+			return
+		}
+
+		others := []model.ConversationParticipant{}
+		db.Where("left_date IS NULL AND user_id <> ?", reqId).Where(&model.ConversationParticipant{ConversationID: conversation.ID}).Find(&others)
+		for _, other := range others {
+			pub.Publish(UserChannel(other.UserID), EventMessageRead, participant)
+		}
+	}
+
+	RespondJSON(w, http.StatusOK, participant)
+}
+
+func LeaveConversation(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	conversationId := vars["conversationId"]
+
+	participant, err := getActiveParticipant(db, conversationId, reqId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "conversation not found")
+		return
+	}
+
+	if err := db.Model(participant).Update("left_date", time.Now().UTC()).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusNoContent, nil)
+}
+
+func GetBlocks(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	blocks := []model.Block{}
+	if err := db.Where("user_id = ?", reqId).Order("create_date desc").Find(&blocks).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, blocks)
+}
+
+func BlockUser(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	userId := vars["userId"]
+
+	if userId == reqId {
+		RespondError(w, http.StatusBadRequest, "you cannot block yourself")
+		return
+	}
+	if _, err := getUserById(db, userId); err != nil {
+		RespondError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	block := model.Block{
+		UserID:     reqId,
+		BlockedID:  userId,
+		CreateDate: time.Now().UTC(),
+	}
+	if err := db.Where(&model.Block{UserID: reqId, BlockedID: userId}).FirstOrCreate(&block).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, block)
+}
+
+func UnblockUser(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	userId := vars["userId"]
+
+	if err := db.Where("user_id = ? AND blocked_id = ?", reqId, userId).Delete(&model.Block{}).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusNoContent, nil)
+}
+
+func getConversationById(db *gorm.DB, conversationId string) (*model.Conversation, error) {
+	if conversationId == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	conversation := model.Conversation{}
+	if err := db.Where(&model.Conversation{ID: conversationId}).First(&conversation).Error; err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+func getActiveParticipant(db *gorm.DB, conversationId, userId string) (*model.ConversationParticipant, error) {
+	participant := model.ConversationParticipant{}
+	err := db.Where("conversation_id = ? AND user_id = ? AND left_date IS NULL", conversationId, userId).First(&participant).Error
+	if err != nil {
+		return nil, err
+	}
+	return &participant, nil
+}
+
+// canViewConversation allows current participants, and moderators once the
+// conversation has been reported. Moderator access is audited.
+func canViewConversation(db *gorm.DB, auditor *audit.Auditor, r *http.Request, userId string, conversation *model.Conversation) bool {
+	if _, err := getActiveParticipant(db, conversation.ID, userId); err == nil {
+		return true
+	}
+	if !hasPermission(db, r, auth.PermMessageModerate) {
+		return false
+	}
+
+	var reports int64
+	if err := db.Model(&model.Report{}).Where(&model.Report{TargetType: "conversation", TargetID: conversation.ID}).Count(&reports).Error; err != nil || reports == 0 {
+		return false
+	}
+	auditor.Log(userId, "View Reported Conversation", "Success", conversation.ID)
+	return true
+}
+
+// isBlockedBetween reports whether userId has blocked, or been blocked by,
+// any of others.
+func isBlockedBetween(db *gorm.DB, userId string, others []string) (bool, error) {
+	var count int64
+	err := db.Model(&model.Block{}).
+		Where("(user_id = ? AND blocked_id IN ?) OR (user_id IN ? AND blocked_id = ?)", userId, others, others, userId).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func checkMessageContent(content string) (string, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return "", errors.New("message is empty")
+	}
+	if len(content) > maxMessageLength {
+		return "", errors.New("message is too long")
+	}
+	return content, nil
+}
+
+// saveMessage stores a message, bumps the conversation's activity and marks
+// the message read for its author. It should run inside a transaction.
+func saveMessage(tx *gorm.DB, conversation *model.Conversation, authorId, content string) (*model.Message, error) {
+	messageId, err := uuid.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	message := model.Message{
+		ID:             messageId.String(),
+		ConversationID: conversation.ID,
+		AuthorID:       authorId,
+		Content:        content,
+		CreateDate:     time.Now().UTC().Format(sortableTimeFormat),
+	}
+	if err := tx.Create(&message).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&model.Conversation{}).Where(&model.Conversation{ID: conversation.ID}).Update("last_message_at", message.CreateDate).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&model.ConversationParticipant{}).Where("conversation_id = ? AND user_id = ?", conversation.ID, authorId).Update("last_read_at", message.CreateDate).Error; err != nil {
+		return nil, err
+	}
+
+	conversation.LastMessageAt = message.CreateDate
+	for i := range conversation.Participants {
+		if conversation.Participants[i].UserID == authorId {
+			conversation.Participants[i].LastReadAt = message.CreateDate
+		}
+	}
+	return &message, nil
+}
+
+// publishMessage notifies every participant still in the conversation,
+// including the author's other sessions.
+func publishMessage(pub Publisher, conversation *model.Conversation, message *model.Message) {
+	for _, participant := range conversation.Participants {
+		if participant.LeftDate == nil {
+			pub.Publish(UserChannel(participant.UserID), EventMessageCreated, message)
+		}
+	}
+}
+
+// fillConversations loads the participants of each conversation and the
+// number of messages userId has not read yet.
+func fillConversations(db *gorm.DB, userId string, conversations []model.Conversation) error {
+	if len(conversations) == 0 {
+		return nil
+	}
+
+	ids := []string{}
+	for _, conversation := range conversations {
+		ids = append(ids, conversation.ID)
+	}
+
+	participants := []model.ConversationParticipant{}
+	if err := db.Where("conversation_id IN ?", ids).Order("join_date").Find(&participants).Error; err != nil {
+		return err
+	}
+
+	counts := []struct {
+		ConversationID string
+		Unread         int64
+	}{}
+	err := db.Model(&model.Message{}).
+		Select("messages.conversation_id, count(*) AS unread").
+		Joins("JOIN conversation_participants ON conversation_participants.conversation_id = messages.conversation_id").
+		Where("messages.conversation_id IN ? AND conversation_participants.user_id = ?", ids, userId).
+		Where("messages.author_id <> ? AND messages.create_date > conversation_participants.last_read_at", userId).
+		Group("messages.conversation_id").
+		Scan(&counts).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range conversations {
+		conversations[i].Participants = []model.ConversationParticipant{}
+		for _, participant := range participants {
+			if participant.ConversationID == conversations[i].ID {
+				conversations[i].Participants = append(conversations[i].Participants, participant)
+			}
+		}
+		for _, count := range counts {
+			if count.ConversationID == conversations[i].ID {
+				conversations[i].Unread = count.Unread
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"forum-server/app/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// startConversation has from message to, failing the test unless it gets
+// status, and returns the conversation.
+func startConversation(t *testing.T, db *gorm.DB, from, to *model.User, status int) *model.Conversation {
+	t.Helper()
+	body := model.NewConversation{Participants: []string{to.ID}, Content: "hi"}
+	rec := httptest.NewRecorder()
+	CreateConversation(db, &testPublisher{}, rec, testRequest(t, "POST", "/api/conversations", body, from))
+	if status != http.StatusCreated {
+		decodeResponse(t, rec, status, nil)
+		return nil
+	}
+	conversation := model.Conversation{}
+	decodeResponse(t, rec, status, &conversation)
+	return &conversation
+}
+
+func sendMessage(t *testing.T, db *gorm.DB, conversation *model.Conversation, from *model.User, status int) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	SendMessage(db, &testPublisher{}, rec, testRequest(t, "POST", "/api/conversations/"+conversation.ID+"/messages", model.NewMessage{Content: "hello"}, from, "conversationId", conversation.ID))
+	decodeResponse(t, rec, status, nil)
+}
+
+func unreadCount(t *testing.T, db *gorm.DB, user *model.User) int64 {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	GetUnreadCount(db, rec, testRequest(t, "GET", "/api/conversations/unread", nil, user))
+	unread := model.UnreadCount{}
+	decodeResponse(t, rec, http.StatusOK, &unread)
+	return unread.Unread
+}
+
+func TestConversationAccess(t *testing.T) {
+	db := testDB(t)
+	auditor := testAuditor(db)
+	alice := testUser(t, db, "user")
+	bob := testUser(t, db, "user")
+	carol := testUser(t, db, "user")
+	moderator := testUser(t, db, "moderator")
+
+	conversation := startConversation(t, db, alice, bob, http.StatusCreated)
+	view := func(caller *model.User, status int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		GetConversation(db, auditor, rec, testRequest(t, "GET", "/api/conversations/"+conversation.ID, nil, caller, "conversationId", conversation.ID))
+		decodeResponse(t, rec, status, nil)
+		rec = httptest.NewRecorder()
+		GetMessages(db, auditor, rec, testRequest(t, "GET", "/api/conversations/"+conversation.ID+"/messages", nil, caller, "conversationId", conversation.ID))
+		decodeResponse(t, rec, status, nil)
+	}
+
+	view(alice, http.StatusOK)
+	view(bob, http.StatusOK)
+	view(carol, http.StatusNotFound)
+	sendMessage(t, db, conversation, carol, http.StatusNotFound)
+
+	// moderators may only read conversations someone reported
+	view(moderator, http.StatusNotFound)
+	rec := httptest.NewRecorder()
+	ReportConversation(db, auditor, rec, testRequest(t, "POST", "/api/conversations/"+conversation.ID+"/report", model.NewReport{Category: "harassment"}, bob, "conversationId", conversation.ID))
+	decodeResponse(t, rec, http.StatusCreated, nil)
+	view(moderator, http.StatusOK)
+	view(carol, http.StatusNotFound)
+
+	rec = httptest.NewRecorder()
+	LeaveConversation(db, rec, testRequest(t, "POST", "/api/conversations/"+conversation.ID+"/leave", nil, bob, "conversationId", conversation.ID))
+	decodeResponse(t, rec, http.StatusNoContent, nil)
+	view(bob, http.StatusNotFound)
+	sendMessage(t, db, conversation, alice, http.StatusBadRequest)
+}
+
+func TestConversationUnreadAndReceipts(t *testing.T) {
+	db := testDB(t)
+	alice := testUser(t, db, "user")
+	bob := testUser(t, db, "user")
+
+	conversation := startConversation(t, db, alice, bob, http.StatusCreated)
+	sendMessage(t, db, conversation, alice, http.StatusCreated)
+	if got := unreadCount(t, db, bob); got != 2 {
+		t.Errorf("bob has %d unread, want 2", got)
+	}
+	if got := unreadCount(t, db, alice); got != 0 {
+		t.Errorf("alice has %d unread of her own messages", got)
+	}
+
+	pub := &testPublisher{}
+	rec := httptest.NewRecorder()
+	MarkConversationRead(db, pub, rec, testRequest(t, "PUT", "/api/conversations/"+conversation.ID+"/read", nil, bob, "conversationId", conversation.ID))
+	decodeResponse(t, rec, http.StatusOK, nil)
+	if got := unreadCount(t, db, bob); got != 0 {
+		t.Errorf("bob has %d unread after reading, want 0", got)
+	}
+	if len(pub.events) != 1 || pub.events[0].Channel != UserChannel(alice.ID) || pub.events[0].Event != EventMessageRead {
+		t.Errorf("published %+v, want a read receipt to alice", pub.events)
+	}
+}
+
+func TestConversationBlocksAndRefusals(t *testing.T) {
+	db := testDB(t)
+	alice := testUser(t, db, "user")
+	bob := testUser(t, db, "user")
+
+	rec := httptest.NewRecorder()
+	BlockUser(db, rec, testRequest(t, "POST", "/api/user/"+bob.ID+"/block", nil, bob, "userId", bob.ID))
+	decodeResponse(t, rec, http.StatusBadRequest, nil)
+
+	conversation := startConversation(t, db, alice, bob, http.StatusCreated)
+	rec = httptest.NewRecorder()
+	BlockUser(db, rec, testRequest(t, "POST", "/api/user/"+alice.ID+"/block", nil, bob, "userId", alice.ID))
+	decodeResponse(t, rec, http.StatusOK, nil)
+
+	// a block works both ways
+	startConversation(t, db, alice, bob, http.StatusForbidden)
+	startConversation(t, db, bob, alice, http.StatusForbidden)
+	sendMessage(t, db, conversation, alice, http.StatusForbidden)
+
+	rec = httptest.NewRecorder()
+	UnblockUser(db, rec, testRequest(t, "DELETE", "/api/user/"+alice.ID+"/block", nil, bob, "userId", alice.ID))
+	decodeResponse(t, rec, http.StatusNoContent, nil)
+	sendMessage(t, db, conversation, alice, http.StatusCreated)
+
+	startConversation(t, db, alice, alice, http.StatusBadRequest)
+	startConversation(t, db, alice, &model.User{ID: "missing"}, http.StatusNotFound)
+}
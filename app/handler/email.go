@@ -0,0 +1,401 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forum-server/app/auth"
+	"forum-server/app/config"
+	"forum-server/app/mail"
+	"forum-server/app/model"
+	"forum-server/audit"
+	"log"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/form3tech-oss/jwt-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	EmailPurposeVerify = "verify"
+	EmailPurposeReset  = "reset"
+	EmailPurposeChange = "email_change"
+)
+
+var emailTokenLifetimes = map[string]time.Duration{
+	EmailPurposeVerify: 48 * time.Hour,
+	EmailPurposeReset:  time.Hour,
+	EmailPurposeChange: 24 * time.Hour,
+}
+
+// emailLinkPaths are the frontend pages, relative to APP_URL, that emailed
+// tokens link to.
+var emailLinkPaths = map[string]string{
+	EmailPurposeVerify: "/verify-email",
+	EmailPurposeReset:  "/reset-password",
+	EmailPurposeChange: "/confirm-email",
+}
+
+var errInvalidEmailToken = errors.New("invalid or expired token")
+
+// ForgotPassword emails a password reset link. It responds the same way
+// whether or not the address belongs to an account.
+func ForgotPassword(db *gorm.DB, mailer mail.Mailer, w http.ResponseWriter, r *http.Request) {
+	req := model.ForgotPasswordRequest{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		RespondError(w, http.StatusBadRequest, "an unknown error has occurred")
+		return
+	}
+	defer r.Body.Close()
+
+	email := strings.TrimSpace(req.Email)
+	user, err := getUserByEmail(db, email)
+	if email != "" && err == nil && user.Active {
+		token, err := issueEmailToken(db, user, EmailPurposeReset, user.Email)
+		if err != nil {
+			RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+			return
+		}
+		sendMail(mailer, mail.TemplateResetPassword, user.Email, mail.Data{
+			Username: user.Username,
+			Email:    user.Email,
+			Link:     emailLink(EmailPurposeReset, token),
+		})
+	}
+
+	RespondJSON(w, http.StatusAccepted, nil)
+}
+
+// ResetPassword sets a new password using an emailed reset token and signs
+// the user out everywhere.
+func ResetPassword(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	req := model.ResetPasswordRequest{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		RespondError(w, http.StatusBadRequest, "an unknown error has occurred")
+		return
+	}
+	defer r.Body.Close()
+
+	if req.Password == "" {
+		RespondError(w, http.StatusBadRequest, "password required")
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), 8)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	stored, err := consumeEmailToken(db, req.Token, EmailPurposeReset)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := db.Model(&model.User{}).Where(&model.User{ID: stored.UserID}).Update("password", string(hashedPassword)).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	if err := revokeUserTokens(db, stored.UserID); err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	auditor.Log(stored.UserID, "Reset Password", "Success", "")
+	RespondJSON(w, http.StatusNoContent, nil)
+}
+
+func VerifyEmail(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	req := model.EmailTokenRequest{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		RespondError(w, http.StatusBadRequest, "an unknown error has occurred")
+		return
+	}
+	defer r.Body.Close()
+
+	stored, err := consumeEmailToken(db, req.Token, EmailPurposeVerify)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// the address may have changed since the link was sent
+	result := db.Model(&model.User{}).Where("id = ? AND email = ?", stored.UserID, stored.Email).Update("email_verified", true)
+	if result.Error != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	if result.RowsAffected == 0 {
+		RespondError(w, http.StatusBadRequest, errInvalidEmailToken.Error())
+		return
+	}
+	RespondJSON(w, http.StatusNoContent, nil)
+}
+
+func ResendVerification(db *gorm.DB, mailer mail.Mailer, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	user, err := getUserById(db, reqId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if user.EmailVerified {
+		RespondError(w, http.StatusBadRequest, "email already verified")
+		return
+	}
+
+	if err := sendVerification(db, mailer, user); err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusAccepted, nil)
+}
+
+// ChangeEmail starts an email change. The address only changes once the
+// link sent to the new address is followed; the old address is told about
+// the request.
+func ChangeEmail(db *gorm.DB, auditor *audit.Auditor, mailer mail.Mailer, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	id := vars["userId"]
+
+	if reqId != id {
+		RespondError(w, http.StatusUnauthorized, "no access")
+		return
+	}
+
+	req := model.EmailChangeRequest{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		RespondError(w, http.StatusBadRequest, "an unknown error has occurred")
+		return
+	}
+	defer r.Body.Close()
+
+	user, err := getUserById(db, id)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		RespondError(w, http.StatusUnauthorized, "invalid password")
+		return
+	}
+
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if email == user.Email {
+		RespondError(w, http.StatusBadRequest, "that is already your email address")
+		return
+	}
+	if other, err := claimingUser(db, "LOWER(email) = ?", email); err == nil {
+		RespondError(w, http.StatusConflict, claimedMessage(other, "email"))
+		return
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	token, err := issueEmailToken(db, user, EmailPurposeChange, email)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	data := mail.Data{Username: user.Username, Email: email, Link: emailLink(EmailPurposeChange, token)}
+	sendMail(mailer, mail.TemplateConfirmEmail, email, data)
+	data.Link = ""
+	sendMail(mailer, mail.TemplateEmailChanged, user.Email, data)
+
+	auditor.Log(reqId, "Request Email Change", "Success", "")
+	RespondJSON(w, http.StatusAccepted, nil)
+}
+
+func ConfirmEmailChange(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	req := model.EmailTokenRequest{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		RespondError(w, http.StatusBadRequest, "an unknown error has occurred")
+		return
+	}
+	defer r.Body.Close()
+
+	stored, err := consumeEmailToken(db, req.Token, EmailPurposeChange)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if other, err := claimingUser(db, "LOWER(email) = ?", stored.Email); err == nil {
+		RespondError(w, http.StatusConflict, claimedMessage(other, "email"))
+		return
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	if err := db.Model(&model.User{}).Where(&model.User{ID: stored.UserID}).Updates(map[string]interface{}{
+		"email":          stored.Email,
+		"email_verified": true,
+	}).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	auditor.Log(stored.UserID, "Change Email", "Success", "")
+	RespondJSON(w, http.StatusNoContent, nil)
+}
+
+// RequireVerifiedEmail only calls next when the caller has verified their
+// email address.
+func RequireVerifiedEmail(db *gorm.DB, w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if r.Method == http.MethodOptions {
+		next(w, r)
+		return
+	}
+
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	user, err := getUserById(db, reqId)
+	if err != nil {
+		RespondError(w, http.StatusUnauthorized, "user not found")
+		return
+	}
+	if !user.EmailVerified {
+		RespondError(w, http.StatusForbidden, "verify your email address first")
+		return
+	}
+	next(w, r)
+}
+
+func sendVerification(db *gorm.DB, mailer mail.Mailer, user *model.User) error {
+	token, err := issueEmailToken(db, user, EmailPurposeVerify, user.Email)
+	if err != nil {
+		return err
+	}
+	sendMail(mailer, mail.TemplateVerifyEmail, user.Email, mail.Data{
+		Username: user.Username,
+		Email:    user.Email,
+		Link:     emailLink(EmailPurposeVerify, token),
+	})
+	return nil
+}
+
+// issueEmailToken stores a new token for purpose, retiring any earlier one
+// that hasn't been used, and returns the token to send.
+func issueEmailToken(db *gorm.DB, user *model.User, purpose, email string) (string, error) {
+	token, hash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	stored := model.EmailToken{
+		ID:         id.String(),
+		UserID:     user.ID,
+		Purpose:    purpose,
+		TokenHash:  hash,
+		Email:      email,
+		ExpiresAt:  now.Add(emailTokenLifetimes[purpose]),
+		CreateDate: now,
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.EmailToken{}).Where("user_id = ? AND purpose = ? AND used_at IS NULL", user.ID, purpose).Update("used_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&stored).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeEmailToken marks a token used and returns it, provided it exists
+// for purpose, hasn't expired and hasn't been used before.
+func consumeEmailToken(db *gorm.DB, token, purpose string) (*model.EmailToken, error) {
+	if token == "" {
+		return nil, errInvalidEmailToken
+	}
+
+	stored := model.EmailToken{}
+	if err := db.Where("token_hash = ? AND purpose = ?", auth.HashToken(token), purpose).First(&stored).Error; err != nil {
+		return nil, errInvalidEmailToken
+	}
+
+	now := time.Now().UTC()
+	if stored.UsedAt != nil || now.After(stored.ExpiresAt) {
+		return nil, errInvalidEmailToken
+	}
+
+	result := db.Model(&model.EmailToken{}).Where("id = ? AND used_at IS NULL", stored.ID).Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errInvalidEmailToken
+	}
+	return &stored, nil
+}
+
+func emailLink(purpose, token string) string {
+	return strings.TrimRight(config.String("APP_URL", ""), "/") + emailLinkPaths[purpose] + "?token=" + url.QueryEscape(token)
+}
+
+// sendMail renders and sends a message in the background so slow mail
+// servers don't hold up requests.
+func sendMail(mailer mail.Mailer, template, to string, data mail.Data) {
+	msg, err := mail.Render(template, to, data)
+	if err != nil {
+		log.Println("ERROR MAIL:", err)
+		return
+	}
+	go func() {
+		if err := mailer.Send(msg); err != nil {
+			log.Println("ERROR MAIL:", err)
+		}
+	}()
+}
+
+// normalizeEmail checks that address is a bare email address and lowercases
+// it, so the same mailbox can't sign up twice with different casing.
+func normalizeEmail(address string) (string, error) {
+	address = strings.TrimSpace(address)
+	parsed, err := netmail.ParseAddress(address)
+	if err != nil || parsed.Address != address {
+		return "", errors.New("invalid email address")
+	}
+	return strings.ToLower(address), nil
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"forum-server/app/mail"
+	"forum-server/app/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestResetPassword(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test secret")
+	db := testDB(t)
+	auditor := testAuditor(db)
+	user := testUser(t, db, "user")
+	_, refreshToken, err := issueTokens(db, user, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reset := func(token string, status int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		ResetPassword(db, auditor, rec, testRequest(t, "POST", "/api/password/reset", model.ResetPasswordRequest{Token: token, Password: "new password"}, nil))
+		decodeResponse(t, rec, status, nil)
+	}
+
+	verifyToken, err := issueEmailToken(db, user, EmailPurposeVerify, user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reset(verifyToken, http.StatusBadRequest)
+
+	retired, err := issueEmailToken(db, user, EmailPurposeReset, user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := issueEmailToken(db, user, EmailPurposeReset, user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reset(retired, http.StatusBadRequest)
+	reset(token, http.StatusNoContent)
+	reset(token, http.StatusBadRequest)
+
+	stored, err := getUserById(db, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("new password")) != nil {
+		t.Error("password unchanged")
+	}
+	refresh(t, db, refreshToken, http.StatusUnauthorized)
+}
+
+func TestForgotPasswordDoesNotRevealAccounts(t *testing.T) {
+	db := testDB(t)
+	user := testUser(t, db, "user")
+
+	for _, email := range []string{"nobody@example.com", strings.ToUpper(user.Email)} {
+		rec := httptest.NewRecorder()
+		ForgotPassword(db, &mail.MemoryMailer{}, rec, testRequest(t, "POST", "/api/password/forgot", model.ForgotPasswordRequest{Email: email}, nil))
+		decodeResponse(t, rec, http.StatusAccepted, nil)
+	}
+
+	var tokens int64
+	db.Model(&model.EmailToken{}).Where("purpose = ?", EmailPurposeReset).Count(&tokens)
+	if tokens != 1 {
+		t.Errorf("%d reset tokens issued, want 1", tokens)
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	db := testDB(t)
+	user := testUser(t, db, "user")
+	if err := db.Model(user).Update("email_verified", false).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	verified := func(status int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		RequireVerifiedEmail(db, rec, testRequest(t, "POST", "/api/attachments", nil, user), func(w http.ResponseWriter, r *http.Request) {
+			RespondJSON(w, http.StatusOK, nil)
+		})
+		decodeResponse(t, rec, status, nil)
+	}
+	verified(http.StatusForbidden)
+
+	// a link sent to an address the user has since changed doesn't count
+	stale, err := issueEmailToken(db, user, EmailPurposeVerify, "old@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	VerifyEmail(db, rec, testRequest(t, "POST", "/api/email/verify", model.EmailTokenRequest{Token: stale}, nil))
+	decodeResponse(t, rec, http.StatusBadRequest, nil)
+
+	token, err := issueEmailToken(db, user, EmailPurposeVerify, user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	VerifyEmail(db, rec, testRequest(t, "POST", "/api/email/verify", model.EmailTokenRequest{Token: token}, nil))
+	decodeResponse(t, rec, http.StatusNoContent, nil)
+	verified(http.StatusOK)
+
+	rec = httptest.NewRecorder()
+	ResendVerification(db, &mail.MemoryMailer{}, rec, testRequest(t, "POST", "/api/email/verify/resend", nil, user))
+	decodeResponse(t, rec, http.StatusBadRequest, nil)
+}
+
+func TestChangeEmail(t *testing.T) {
+	db := testDB(t)
+	auditor := testAuditor(db)
+	user := testUser(t, db, "user")
+	other := testUser(t, db, "user")
+	trashed := testUser(t, db, "user")
+	if err := db.Delete(trashed).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		caller *model.User
+		body   model.EmailChangeRequest
+		status int
+	}{
+		{"someone else", other, model.EmailChangeRequest{Email: "new@example.com", Password: testPassword}, http.StatusUnauthorized},
+		{"wrong password", user, model.EmailChangeRequest{Email: "new@example.com", Password: "guess"}, http.StatusUnauthorized},
+		{"not an address", user, model.EmailChangeRequest{Email: "Someone <new@example.com>", Password: testPassword}, http.StatusBadRequest},
+		{"taken", user, model.EmailChangeRequest{Email: other.Email, Password: testPassword}, http.StatusConflict},
+		{"held by a trashed user", user, model.EmailChangeRequest{Email: trashed.Email, Password: testPassword}, http.StatusConflict},
+		{"free", user, model.EmailChangeRequest{Email: "New@example.com", Password: testPassword}, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			ChangeEmail(db, auditor, &mail.MemoryMailer{}, rec, testRequest(t, "PUT", "/api/user/"+user.ID+"/email", tt.body, tt.caller, "userId", user.ID))
+			decodeResponse(t, rec, tt.status, nil)
+		})
+	}
+
+	stored := model.EmailToken{}
+	if err := db.Where("user_id = ? AND purpose = ?", user.ID, EmailPurposeChange).First(&stored).Error; err != nil || stored.Email != "new@example.com" {
+		t.Fatalf("change token = %+v, %v, want one for new@example.com", stored, err)
+	}
+
+	token, err := issueEmailToken(db, user, EmailPurposeChange, "new@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	ConfirmEmailChange(db, auditor, rec, testRequest(t, "POST", "/api/email/confirm", model.EmailTokenRequest{Token: token}, nil))
+	decodeResponse(t, rec, http.StatusNoContent, nil)
+	changed, err := getUserById(db, user.ID)
+	if err != nil || changed.Email != "new@example.com" || !changed.EmailVerified {
+		t.Errorf("user after confirming = %+v, %v", changed, err)
+	}
+}
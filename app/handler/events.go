@@ -0,0 +1,38 @@
+package handler
+
+const (
+	EventPostCreated     = "post.created"
+	EventPostUpdated     = "post.updated"
+	EventPostDeleted     = "post.deleted"
+	EventCommentCreated  = "comment.created"
+	EventCommentUpdated  = "comment.updated"
+	EventCommentDeleted  = "comment.deleted"
+	EventVoteUpdated     = "vote.updated"
+	EventReactionUpdated = "reaction.updated"
+	EventChatMessage     = "chat.message"
+	EventChatDeleted     = "chat.deleted"
+	EventMessageCreated  = "message.created"
+	EventMessageRead     = "message.read"
+	EventNotification    = "notification.created"
+)
+
+const ChatChannel = "chat:global"
+
+// Publisher delivers realtime events to everyone subscribed to a channel.
+type Publisher interface {
+	Publish(channel, event string, data interface{})
+}
+
+func BoardChannel(boardId string) string {
+	return "board:" + boardId
+}
+
+func PostChannel(postId string) string {
+	return "post:" + postId
+}
+
+// UserChannel carries events about a user's own content and is only open
+// to that user.
+func UserChannel(userId string) string {
+	return "user:" + userId
+}
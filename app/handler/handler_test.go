@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"forum-server/app/model"
+	"forum-server/audit"
+	forumdb "forum-server/db"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/form3tech-oss/jwt-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const testPassword = "correct horse battery staple"
+
+// testDB opens an empty embedded store, with the default roles seeded, for
+// one test.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := forumdb.OpenEmbedded("file:" + name + "?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func testAuditor(db *gorm.DB) *audit.Auditor {
+	return &audit.Auditor{DB: db}
+}
+
+// testUser creates an active, verified user with role whose password is
+// testPassword.
+func testUser(t *testing.T, db *gorm.DB, role string) *model.User {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := uuid.NewString()
+	user := model.User{
+		ID:            id,
+		Username:      "user-" + id[:8],
+		Email:         id[:8] + "@example.com",
+		Password:      string(hash),
+		Role:          role,
+		Active:        true,
+		EmailVerified: true,
+		CreateDate:    time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &user
+}
+
+// testBoard creates a board named name.
+func testBoard(t *testing.T, db *gorm.DB, name string) *model.Board {
+	t.Helper()
+	board := model.Board{ID: uuid.NewString(), Name: name, CreateDate: time.Now().UTC()}
+	if err := db.Create(&board).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &board
+}
+
+// testPost creates a post by author on board.
+func testPost(t *testing.T, db *gorm.DB, board *model.Board, author *model.User, title string) *model.Post {
+	t.Helper()
+	now := time.Now().UTC().Format(sortableTimeFormat)
+	post := model.Post{
+		ID:           uuid.NewString(),
+		AuthorID:     author.ID,
+		BoardID:      board.ID,
+		Title:        title,
+		Content:      title,
+		CreateDate:   now,
+		LastActivity: now,
+	}
+	if err := db.Create(&post).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &post
+}
+
+// testComment creates a comment by author on post, replying to parent
+// unless it is nil.
+func testComment(t *testing.T, db *gorm.DB, post *model.Post, author *model.User, parent *model.Comment, content string) *model.Comment {
+	t.Helper()
+	comment := model.Comment{
+		ID:         uuid.NewString(),
+		AuthorID:   author.ID,
+		PostID:     post.ID,
+		Content:    content,
+		CreateDate: time.Now().UTC().Format(sortableTimeFormat),
+	}
+	if parent != nil {
+		comment.ParentID = parent.ID
+		comment.Depth = parent.Depth + 1
+	}
+	if err := db.Create(&comment).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &comment
+}
+
+// testEvent is an event sent through a testPublisher.
+type testEvent struct {
+	Channel string
+	Event   string
+	Data    interface{}
+}
+
+// testPublisher records what handlers publish.
+type testPublisher struct {
+	mu     sync.Mutex
+	events []testEvent
+}
+
+func (p *testPublisher) Publish(channel, event string, data interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, testEvent{channel, event, data})
+}
+
+// testRequest builds a request to a handler. body is sent as JSON unless it
+// is nil, user is the signed in caller or nil for none, and vars are the
+// route variables as name, value pairs.
+func testRequest(t *testing.T, method, target string, body interface{}, user *model.User, vars ...string) *http.Request {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	if body != nil {
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := httptest.NewRequest(method, target, buf)
+	if user != nil {
+		token := &jwt.Token{Claims: jwt.MapClaims{"id": user.ID, "role": user.Role}}
+		r = r.WithContext(context.WithValue(r.Context(), "user", token))
+	}
+	if len(vars) > 0 {
+		m := map[string]string{}
+		for i := 0; i+1 < len(vars); i += 2 {
+			m[vars[i]] = vars[i+1]
+		}
+		r = mux.SetURLVars(r, m)
+	}
+	return r
+}
+
+// testPage is a Page whose items are left to decode later.
+type testPage struct {
+	Items      json.RawMessage `json:"items"`
+	NextCursor string          `json:"next_cursor"`
+	PrevCursor string          `json:"prev_cursor"`
+	Total      int64           `json:"total"`
+}
+
+// decodePage checks rec is a page and decodes its items into items.
+func decodePage(t *testing.T, rec *httptest.ResponseRecorder, items interface{}) testPage {
+	t.Helper()
+	page := testPage{}
+	decodeResponse(t, rec, http.StatusOK, &page)
+	if err := json.Unmarshal(page.Items, items); err != nil {
+		t.Fatalf("decoding items %s: %v", page.Items, err)
+	}
+	return page
+}
+
+// decodeResponse checks the status of rec and decodes its body into v,
+// unless v is nil.
+func decodeResponse(t *testing.T, rec *httptest.ResponseRecorder, status int, v interface{}) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, status, rec.Body.String())
+	}
+	if v == nil {
+		return
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body.String(), err)
+	}
+}
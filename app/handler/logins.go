@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"fmt"
+	"forum-server/app/auth"
+	"forum-server/app/config"
+	"forum-server/app/mail"
+	"forum-server/app/model"
+	"forum-server/app/ratelimit"
+	"forum-server/audit"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/form3tech-oss/jwt-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultLoginDelayAfter      = 3
+	defaultLoginLockoutAttempts = 10
+	defaultLoginLockoutMinutes  = 15
+	defaultLoginIPAttempts      = 50
+	maxLoginDelay               = time.Minute
+)
+
+var loginSorts = map[string]sortKey{
+	"newest": {column: "create_date", desc: true, kind: sortTime},
+	"oldest": {column: "create_date", desc: false, kind: sortTime},
+}
+
+// loginLimits control how failed logins are throttled. Failures count for
+// Lockout, both as the window they are counted over and as how long a lock
+// lasts after the last of them.
+type loginLimits struct {
+	// DelayAfter failures on an account, each further attempt has to wait
+	// twice as long as the last, up to maxLoginDelay.
+	DelayAfter int
+	// LockoutAttempts failures lock the account.
+	LockoutAttempts int
+	// IPAttempts failures from one IP, across any accounts, block it.
+	IPAttempts int
+	Lockout    time.Duration
+}
+
+// readLoginLimits takes the limits from LOGIN_DELAY_AFTER,
+// LOGIN_LOCKOUT_ATTEMPTS, LOGIN_IP_ATTEMPTS and LOGIN_LOCKOUT_MINUTES. Zero
+// would disable a limit outright, so only positive values are honoured.
+func readLoginLimits() loginLimits {
+	return loginLimits{
+		DelayAfter:      config.PositiveInt("LOGIN_DELAY_AFTER", defaultLoginDelayAfter),
+		LockoutAttempts: config.PositiveInt("LOGIN_LOCKOUT_ATTEMPTS", defaultLoginLockoutAttempts),
+		IPAttempts:      config.PositiveInt("LOGIN_IP_ATTEMPTS", defaultLoginIPAttempts),
+		Lockout:         time.Duration(config.PositiveInt("LOGIN_LOCKOUT_MINUTES", defaultLoginLockoutMinutes)) * time.Minute,
+	}
+}
+
+// GetLoginHistory lists a user's sign in attempts. Users can see their own;
+// anyone else needs PermUserViewAny.
+func GetLoginHistory(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	userId := vars["userId"]
+
+	if reqId != userId && !hasPermission(db, r, auth.PermUserViewAny) {
+		RespondError(w, http.StatusForbidden, "you can't view this user's logins")
+		return
+	}
+
+	p, err := parsePagination(r, loginSorts, "newest")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	q := db.Model(&model.LoginAttempt{}).Where("user_id = ?", userId)
+	if success := r.URL.Query().Get("success"); success != "" {
+		q = q.Where("success = ?", success == "true")
+	}
+
+	attempts := []model.LoginAttempt{}
+	page, err := p.find(q, &attempts)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, page)
+}
+
+// loginWait is how long a login for userId from ip has to wait, or 0 if it
+// may go ahead. userId is "" when the email doesn't match an account.
+func loginWait(db *gorm.DB, limits loginLimits, userId, ip string, now time.Time) time.Duration {
+	if count, last := ipFailures(db, limits, ip, now); count >= int64(limits.IPAttempts) {
+		return last.Add(limits.Lockout).Sub(now)
+	}
+	if userId == "" {
+		return 0
+	}
+
+	count, last := accountFailures(db, limits, userId, now)
+	switch {
+	case count >= int64(limits.LockoutAttempts):
+		return last.Add(limits.Lockout).Sub(now)
+	case count >= int64(limits.DelayAfter):
+		delay := time.Duration(math.Pow(2, float64(count-int64(limits.DelayAfter)))) * time.Second
+		if delay > maxLoginDelay {
+			delay = maxLoginDelay
+		}
+		return last.Add(delay).Sub(now)
+	}
+	return 0
+}
+
+func ipFailures(db *gorm.DB, limits loginLimits, ip string, now time.Time) (int64, time.Time) {
+	return loginFailures(db.Where("ip = ?", ip), now.Add(-limits.Lockout))
+}
+
+// accountFailures only counts failures since the user last signed in, so a
+// successful login starts them over.
+func accountFailures(db *gorm.DB, limits loginLimits, userId string, now time.Time) (int64, time.Time) {
+	since := now.Add(-limits.Lockout)
+	success := model.LoginAttempt{}
+	if err := db.Where("user_id = ? AND success = ?", userId, true).Order("create_date DESC").First(&success).Error; err == nil && success.CreateDate.After(since) {
+		since = success.CreateDate
+	}
+	return loginFailures(db.Where("user_id = ?", userId), since)
+}
+
+// loginFailures counts the failed attempts matched by q since since, and
+// returns when the latest was.
+func loginFailures(q *gorm.DB, since time.Time) (int64, time.Time) {
+	var result struct {
+		Count int64
+		Last  aggregateTime
+	}
+	err := q.Model(&model.LoginAttempt{}).
+		Select("COUNT(*) AS count, MAX(create_date) AS last").
+		Where("success = ? AND create_date > ?", false, since).
+		Scan(&result).Error
+	if err != nil {
+		log.Println("ERROR LOGIN FAILURES:", err)
+		return 0, time.Time{}
+	}
+	if result.Last.IsZero() {
+		return 0, time.Time{}
+	}
+	return result.Count, result.Last.Time
+}
+
+// recordLogin stores an attempt. A successful one from a client the user
+// hasn't signed in from before, by both IP and user agent, is flagged in
+// the audit log, unless it's their first login.
+func recordLogin(db *gorm.DB, auditor *audit.Auditor, userId string, r *http.Request, success bool) {
+	id, err := uuid.NewUUID()
+	if err != nil {
+		log.Println("ERROR LOGIN ATTEMPT:", err)
+		return
+	}
+	attempt := model.LoginAttempt{
+		ID:         id.String(),
+		UserID:     userId,
+		IP:         ratelimit.ClientIP(r),
+		UserAgent:  r.UserAgent(),
+		Success:    success,
+		CreateDate: time.Now().UTC(),
+	}
+
+	if success {
+		var seen, known int64
+		db.Model(&model.LoginAttempt{}).Where("user_id = ? AND success = ?", userId, true).Count(&seen)
+		db.Model(&model.LoginAttempt{}).Where("user_id = ? AND success = ? AND ip = ? AND user_agent = ?", userId, true, attempt.IP, attempt.UserAgent).Count(&known)
+		attempt.NewClient = seen > 0 && known == 0
+	}
+
+	if err := db.Create(&attempt).Error; err != nil {
+		log.Println("ERROR LOGIN ATTEMPT:", err)
+		return
+	}
+	if attempt.NewClient {
+		auditor.Log(userId, "Login", "New Client", attempt.IP+" "+attempt.UserAgent)
+	}
+}
+
+// loginFailed records a failed attempt and, if it is the one that locks the
+// account, tells the owner and leaves them a link to reset their password.
+func loginFailed(db *gorm.DB, auditor *audit.Auditor, mailer mail.Mailer, limits loginLimits, user *model.User, r *http.Request) {
+	userId := ""
+	if user != nil {
+		userId = user.ID
+	}
+	recordLogin(db, auditor, userId, r, false)
+
+	now := time.Now().UTC()
+	ip := ratelimit.ClientIP(r)
+	if count, _ := ipFailures(db, limits, ip, now); count == int64(limits.IPAttempts) {
+		auditor.Log("", "Login", "Locked", "too many failed logins from "+ip)
+	}
+	if user == nil {
+		return
+	}
+	if count, _ := accountFailures(db, limits, user.ID, now); count != int64(limits.LockoutAttempts) {
+		return
+	}
+
+	auditor.Log(user.ID, "Login", "Locked", "too many failed logins, last from "+ip)
+	token, err := issueEmailToken(db, user, EmailPurposeReset, user.Email)
+	if err != nil {
+		log.Println("ERROR LOCKOUT MAIL:", err)
+		return
+	}
+	sendMail(mailer, mail.TemplateAccountLocked, user.Email, mail.Data{
+		Username: user.Username,
+		Email:    user.Email,
+		Link:     emailLink(EmailPurposeReset, token),
+	})
+}
+
+func respondLoginWait(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	RespondError(w, http.StatusTooManyRequests, fmt.Sprintf("too many failed logins, try again in %d seconds", seconds))
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"forum-server/app/mail"
+	"forum-server/app/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// login signs in as user with password from ip.
+func login(t *testing.T, db *gorm.DB, user *model.User, password, ip, userAgent string, status int) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	r := testRequest(t, "POST", "/api/login", model.LoginCredentials{Email: user.Email, Password: password}, nil)
+	r.RemoteAddr = ip + ":1234"
+	r.Header.Set("User-Agent", userAgent)
+	UserLogin(db, testAuditor(db), &mail.MemoryMailer{}, rec, r)
+	decodeResponse(t, rec, status, nil)
+	return rec
+}
+
+func TestLoginLockout(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test secret")
+	t.Setenv("LOGIN_DELAY_AFTER", "100")
+	t.Setenv("LOGIN_LOCKOUT_ATTEMPTS", "3")
+	db := testDB(t)
+	user := testUser(t, db, "user")
+
+	// a successful login starts the count over
+	login(t, db, user, "wrong", "10.0.0.1", "test", http.StatusUnauthorized)
+	login(t, db, user, "wrong", "10.0.0.1", "test", http.StatusUnauthorized)
+	login(t, db, user, testPassword, "10.0.0.1", "test", http.StatusOK)
+	login(t, db, user, "wrong", "10.0.0.1", "test", http.StatusUnauthorized)
+	login(t, db, user, "wrong", "10.0.0.1", "test", http.StatusUnauthorized)
+	login(t, db, user, "wrong", "10.0.0.2", "test", http.StatusUnauthorized)
+
+	rec := login(t, db, user, testPassword, "10.0.0.3", "test", http.StatusTooManyRequests)
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("locked login has no Retry-After")
+	}
+	var resets int64
+	db.Model(&model.EmailToken{}).Where("user_id = ? AND purpose = ?", user.ID, EmailPurposeReset).Count(&resets)
+	if resets != 1 {
+		t.Errorf("locking the account issued %d reset tokens, want 1", resets)
+	}
+
+	// locks lapse once the last failure is older than the lockout
+	db.Model(&model.LoginAttempt{}).Where("user_id = ?", user.ID).Update("create_date", time.Now().UTC().Add(-time.Hour))
+	login(t, db, user, testPassword, "10.0.0.3", "test", http.StatusOK)
+}
+
+func TestLoginDelayAndIPBlock(t *testing.T) {
+	t.Setenv("LOGIN_DELAY_AFTER", "1")
+	t.Setenv("LOGIN_IP_ATTEMPTS", "3")
+	db := testDB(t)
+	user := testUser(t, db, "user")
+
+	login(t, db, user, "wrong", "10.0.0.1", "test", http.StatusUnauthorized)
+	login(t, db, user, testPassword, "10.0.0.2", "test", http.StatusTooManyRequests)
+
+	// unknown emails still count against the IP
+	stranger := &model.User{Email: "nobody@example.com"}
+	login(t, db, stranger, "wrong", "10.0.0.9", "test", http.StatusUnauthorized)
+	login(t, db, stranger, "wrong", "10.0.0.9", "test", http.StatusUnauthorized)
+	login(t, db, stranger, "wrong", "10.0.0.9", "test", http.StatusUnauthorized)
+	login(t, db, stranger, "wrong", "10.0.0.9", "test", http.StatusTooManyRequests)
+	login(t, db, stranger, "wrong", "10.0.0.8", "test", http.StatusUnauthorized)
+}
+
+func TestLoginHistory(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test secret")
+	db := testDB(t)
+	user := testUser(t, db, "user")
+	login(t, db, user, testPassword, "10.0.0.1", "laptop", http.StatusOK)
+	login(t, db, user, "wrong", "10.0.0.1", "laptop", http.StatusUnauthorized)
+	login(t, db, user, testPassword, "10.0.0.1", "laptop", http.StatusOK)
+	login(t, db, user, testPassword, "10.0.0.2", "phone", http.StatusOK)
+
+	history := func(caller *model.User, query string, status int) []model.LoginAttempt {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		GetLoginHistory(db, rec, testRequest(t, "GET", "/api/user/"+user.ID+"/logins"+query, nil, caller, "userId", user.ID))
+		if status != http.StatusOK {
+			decodeResponse(t, rec, status, nil)
+			return nil
+		}
+		attempts := []model.LoginAttempt{}
+		decodePage(t, rec, &attempts)
+		return attempts
+	}
+	history(testUser(t, db, "user"), "", http.StatusForbidden)
+	history(testUser(t, db, "moderator"), "", http.StatusForbidden)
+	if attempts := history(testUser(t, db, "admin"), "", http.StatusOK); len(attempts) != 4 {
+		t.Errorf("admin sees %d attempts, want 4", len(attempts))
+	}
+
+	attempts := history(user, "?success=true", http.StatusOK)
+	if len(attempts) != 3 {
+		t.Fatalf("successful attempts = %+v, want 3", attempts)
+	}
+	// only the latest login came from a client the user hadn't used
+	for i, attempt := range attempts {
+		if attempt.NewClient != (i == 0) {
+			t.Errorf("attempt %d from %s new_client = %v", i, attempt.UserAgent, attempt.NewClient)
+		}
+	}
+	if failed := history(user, "?success=false", http.StatusOK); len(failed) != 1 || failed[0].Success {
+		t.Errorf("failed attempts = %+v, want 1", failed)
+	}
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"context"
+	"forum-server/app/storage"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// ServeMedia streams objects from stores that have no public URL of their
+// own, such as the local and in-memory stores. Private objects are never
+// served from here.
+func ServeMedia(store storage.BlobStore, w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if !storage.Public(key) {
+		RespondError(w, http.StatusNotFound, "file not found")
+		return
+	}
+
+	body, contentType, err := store.Get(r.Context(), key)
+	if err == storage.ErrNotFound {
+		RespondError(w, http.StatusNotFound, "file not found")
+		return
+	}
+	if err != nil {
+		log.Println("ERROR GET OBJECT:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	defer body.Close()
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	io.Copy(w, body)
+}
+
+// deleteObjects removes keys from store, logging rather than failing since
+// the records pointing at them are already gone.
+func deleteObjects(store storage.BlobStore, keys []string) {
+	for _, key := range keys {
+		if err := store.Delete(context.Background(), key); err != nil {
+			log.Println("ERROR DELETE OBJECT:", err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"forum-server/app/model"
+	"forum-server/app/storage"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testUpload is testRequest with data sent as the multipart file field.
+func testUpload(t *testing.T, target, filename string, data []byte, user *model.User, vars ...string) *http.Request {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	form := multipart.NewWriter(buf)
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+
+	r := testRequest(t, "POST", target, nil, user, vars...)
+	r.Body = io.NopCloser(buf)
+	r.ContentLength = int64(buf.Len())
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return r
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 300, 200))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUploadAvatar(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	db := testDB(t)
+	store := storage.NewMemoryStore("/api/media")
+	user := testUser(t, db, "user")
+	other := testUser(t, db, "user")
+	target := "/api/user/" + user.ID + "/avatar"
+
+	rec := httptest.NewRecorder()
+	UploadAvatar(db, store, rec, testUpload(t, target, "a.png", testPNG(t), other, "userId", user.ID))
+	decodeResponse(t, rec, http.StatusUnauthorized, nil)
+
+	rec = httptest.NewRecorder()
+	UploadAvatar(db, store, rec, testUpload(t, target, "a.png", []byte("<html><script></script></html>"), user, "userId", user.ID))
+	decodeResponse(t, rec, http.StatusUnsupportedMediaType, nil)
+	if keys := store.Keys(); len(keys) != 0 {
+		t.Fatalf("refused uploads stored %v", keys)
+	}
+
+	avatarURL := func() string {
+		got := model.User{}
+		db.Where("id = ?", user.ID).First(&got)
+		return got.AvatarURL
+	}
+	upload := func() []string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		UploadAvatar(db, store, rec, testUpload(t, target, "a.png", testPNG(t), user, "userId", user.ID))
+		resp := model.LoginResponse{}
+		decodeResponse(t, rec, http.StatusOK, &resp)
+		if resp.Token == "" {
+			t.Error("no new token after the upload")
+		}
+		keys := store.Keys()
+		if len(keys) != len(storage.AvatarSizes) {
+			t.Fatalf("stored %v, want one key per avatar size", keys)
+		}
+		if url := avatarURL(); !strings.HasPrefix(url, "/api/media/avatars/"+user.ID+"/") || !strings.HasSuffix(url, "/256.jpg") {
+			t.Errorf("avatar_url = %q", url)
+		}
+		return keys
+	}
+
+	first := upload()
+	second := upload()
+	for _, key := range first {
+		for _, kept := range second {
+			if key == kept {
+				t.Errorf("old avatar %s kept after a new upload", key)
+			}
+		}
+	}
+}
+
+func TestServeMedia(t *testing.T) {
+	store := storage.NewMemoryStore("/api/media")
+	storage.PutBytes(context.Background(), store, "avatars/u1/a/64.png", []byte("avatar"), "image/png")
+	storage.PutBytes(context.Background(), store, "attachments/u1/a/file.pdf", []byte("private"), "application/pdf")
+
+	rec := httptest.NewRecorder()
+	ServeMedia(store, rec, testRequest(t, "GET", "/api/media/avatars/u1/a/64.png", nil, nil, "key", "avatars/u1/a/64.png"))
+	if rec.Code != http.StatusOK || rec.Body.String() != "avatar" || rec.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("public key: %d %q %q", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+
+	for _, key := range []string{"attachments/u1/a/file.pdf", "avatars/u1/missing.png"} {
+		rec := httptest.NewRecorder()
+		ServeMedia(store, rec, testRequest(t, "GET", "/api/media/"+key, nil, nil, "key", key))
+		decodeResponse(t, rec, http.StatusNotFound, nil)
+	}
+}
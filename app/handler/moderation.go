@@ -0,0 +1,535 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"forum-server/app/markup"
+	"forum-server/app/model"
+	"forum-server/app/search"
+	"forum-server/audit"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/form3tech-oss/jwt-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// postState is a flag moderators set on a post, with the audit actions for
+// setting and clearing it and the notification its author gets when it is
+// set.
+type postState struct {
+	column string
+	set    string
+	clear  string
+	notice string
+}
+
+// postStates are keyed by the name used in their routes.
+var postStates = map[string]postState{
+	"pin":      {column: "pinned", set: "Pin Post", clear: "Unpin Post", notice: "A moderator pinned your post %q"},
+	"announce": {column: "announced", set: "Announce Post", clear: "Withdraw Announcement", notice: "Your post %q was made an announcement"},
+	"lock":     {column: "locked", set: "Lock Post", clear: "Unlock Post", notice: "A moderator locked your post %q"},
+	"archive":  {column: "archived", set: "Archive Post", clear: "Unarchive Post", notice: "A moderator archived your post %q"},
+}
+
+// SetPostState sets or clears one of the postStates on a post.
+func SetPostState(db *gorm.DB, auditor *audit.Auditor, pub Publisher, name string, value bool, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	state, ok := postStates[name]
+	if !ok {
+		RespondError(w, http.StatusNotFound, "unknown post state")
+		return
+	}
+
+	vars := mux.Vars(r)
+	postId := vars["postId"]
+
+	post, err := getPostById(db, postId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "post not found")
+		return
+	}
+
+	if err := db.Model(&model.Post{}).Where("id = ?", post.ID).Update(state.column, value).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	post, err = getPostById(db, post.ID)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	action := state.clear
+	if value {
+		action = state.set
+	}
+	auditor.Log(reqId, action, "Success", post.ID)
+
+	pub.Publish(PostChannel(post.ID), EventPostUpdated, post)
+	pub.Publish(BoardChannel(post.BoardID), EventPostUpdated, post)
+	if value {
+		notifyModeration(db, pub, reqId, post.AuthorID, "post", post.ID, fmt.Sprintf(state.notice, post.Title))
+	}
+	RespondJSON(w, http.StatusOK, post)
+}
+
+// GetAnnouncements lists the posts announced across every board.
+func GetAnnouncements(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	p, err := parsePagination(r, postSorts, "newest")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	posts := []model.Post{}
+	page, err := p.find(db.Model(&model.Post{}).Where("announced = ?", true), &posts)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	if err := loadTags(db, posts); err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, page)
+}
+
+// postClosed says why a post no longer takes comments, or returns "" when
+// it still does.
+func postClosed(post *model.Post) string {
+	switch {
+	case post.Archived:
+		return "post is archived"
+	case post.Locked:
+		return "post is locked"
+	}
+	return ""
+}
+
+// MovePost moves a post to another board and leaves a locked stub in the
+// old one that redirects to it.
+func MovePost(db *gorm.DB, auditor *audit.Auditor, pub Publisher, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	postId := vars["postId"]
+
+	move := model.PostMove{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&move); err != nil {
+		RespondError(w, http.StatusBadRequest, "an unknown error has occurred")
+		return
+	}
+	defer r.Body.Close()
+
+	post, err := getPostById(db, postId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "post not found")
+		return
+	}
+	if post.RedirectID != "" {
+		RespondError(w, http.StatusBadRequest, "post is a redirect")
+		return
+	}
+	board, err := getBoardByID(db, move.BoardID)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, "board not found")
+		return
+	}
+	if board.ID == post.BoardID {
+		RespondError(w, http.StatusBadRequest, "post is already in this board")
+		return
+	}
+
+	oldBoardId := post.BoardID
+	var stub *model.Post
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.Post{}).Where("id = ?", post.ID).Update("board_id", board.ID).Error; err != nil {
+			return err
+		}
+		post.BoardID = board.ID
+
+		stub, err = redirectStub(post, oldBoardId, fmt.Sprintf("This thread was moved to %s.", board.Name))
+		if err != nil {
+			return err
+		}
+		return tx.Create(stub).Error
+	})
+	if err != nil {
+		log.Println("ERROR MOVE POST:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	if err := search.Index(db, search.TypePost, stub.ID); err != nil {
+		log.Println("ERROR INDEX:", err)
+	}
+
+	auditor.Log(reqId, "Move Post", "Success", post.ID+" from "+oldBoardId+" to "+board.ID)
+	pub.Publish(PostChannel(post.ID), EventPostUpdated, post)
+	pub.Publish(BoardChannel(oldBoardId), EventPostCreated, stub)
+	pub.Publish(BoardChannel(board.ID), EventPostCreated, post)
+	notifyModeration(db, pub, reqId, post.AuthorID, "post", post.ID, fmt.Sprintf("A moderator moved your post %q to %s", post.Title, board.Name))
+	RespondJSON(w, http.StatusOK, post)
+}
+
+// MergePost moves every comment of a post into another, keeping their
+// authors and timestamps. The merged post's own text becomes a comment on
+// the target, and the post itself is turned into a redirect to it.
+func MergePost(db *gorm.DB, auditor *audit.Auditor, pub Publisher, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	postId := vars["postId"]
+
+	merge := model.PostMerge{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&merge); err != nil {
+		RespondError(w, http.StatusBadRequest, "an unknown error has occurred")
+		return
+	}
+	defer r.Body.Close()
+
+	source, err := getPostById(db, postId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "post not found")
+		return
+	}
+	target, err := getPostById(db, merge.TargetID)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, "target post not found")
+		return
+	}
+	if source.ID == target.ID {
+		RespondError(w, http.StatusBadRequest, "cannot merge a post into itself")
+		return
+	}
+	if source.RedirectID != "" || target.RedirectID != "" {
+		RespondError(w, http.StatusBadRequest, "post is a redirect")
+		return
+	}
+
+	commentId, err := uuid.NewUUID()
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	body := model.Comment{
+		ID:          commentId.String(),
+		AuthorID:    source.AuthorID,
+		PostID:      target.ID,
+		Content:     source.Content,
+		ContentHTML: source.ContentHTML,
+		CreateDate:  source.CreateDate,
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&body).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model.Attachment{}).Where("post_id = ? AND comment_id = ?", source.ID, "").
+			Updates(map[string]interface{}{"post_id": target.ID, "comment_id": body.ID}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model.Attachment{}).Where("post_id = ?", source.ID).Update("post_id", target.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&model.Comment{}).Where("post_id = ?", source.ID).Update("post_id", target.ID).Error; err != nil {
+			return err
+		}
+
+		if err := editPost(tx, source, reqId, "Merged into "+target.ID, source.Title, fmt.Sprintf("This thread was merged into %q.", target.Title)); err != nil {
+			return err
+		}
+		if err := tx.Model(&model.Post{}).Where("id = ?", source.ID).Updates(map[string]interface{}{"redirect_id": target.ID, "locked": true}).Error; err != nil {
+			return err
+		}
+		source.RedirectID = target.ID
+		source.Locked = true
+
+		lastActivity := target.LastActivity
+		if source.LastActivity > lastActivity {
+			lastActivity = source.LastActivity
+		}
+		if err := tx.Model(&model.Post{}).Where("id = ?", target.ID).Update("last_activity", lastActivity).Error; err != nil {
+			return err
+		}
+		if err := recountComments(tx, source.ID); err != nil {
+			return err
+		}
+		return recountComments(tx, target.ID)
+	})
+	if err != nil {
+		log.Println("ERROR MERGE POST:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	if err := search.Index(db, search.TypeComment, body.ID); err != nil {
+		log.Println("ERROR INDEX:", err)
+	}
+	if err := search.Index(db, search.TypePost, source.ID); err != nil {
+		log.Println("ERROR INDEX:", err)
+	}
+
+	auditor.Log(reqId, "Merge Post", "Success", source.ID+" into "+target.ID)
+	if merged, err := getPostById(db, target.ID); err == nil {
+		target = merged
+	}
+	pub.Publish(PostChannel(source.ID), EventPostUpdated, source)
+	pub.Publish(BoardChannel(source.BoardID), EventPostUpdated, source)
+	pub.Publish(PostChannel(target.ID), EventPostUpdated, target)
+	pub.Publish(BoardChannel(target.BoardID), EventPostUpdated, target)
+	notifyModeration(db, pub, reqId, source.AuthorID, "post", target.ID, fmt.Sprintf("A moderator merged your post %q into %q", source.Title, target.Title))
+	RespondJSON(w, http.StatusOK, target)
+}
+
+// SplitPost moves the chosen comments, along with every reply to them, into
+// a new post. A stub comment pointing to the new post takes the place of
+// the first comment moved.
+func SplitPost(db *gorm.DB, auditor *audit.Auditor, pub Publisher, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	postId := vars["postId"]
+
+	split := model.PostSplit{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&split); err != nil {
+		RespondError(w, http.StatusBadRequest, "an unknown error has occurred")
+		return
+	}
+	defer r.Body.Close()
+
+	if strings.TrimSpace(split.Title) == "" {
+		RespondError(w, http.StatusBadRequest, "title is required")
+		return
+	}
+	if len(split.CommentIDs) == 0 {
+		RespondError(w, http.StatusBadRequest, "no comments selected")
+		return
+	}
+
+	post, err := getPostById(db, postId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "post not found")
+		return
+	}
+	if post.RedirectID != "" {
+		RespondError(w, http.StatusBadRequest, "post is a redirect")
+		return
+	}
+	if split.BoardID == "" {
+		split.BoardID = post.BoardID
+	}
+	if _, err := getBoardByID(db, split.BoardID); err != nil {
+		RespondError(w, http.StatusBadRequest, "board not found")
+		return
+	}
+
+	comments := []model.Comment{}
+	if err := db.Unscoped().Where("post_id = ?", post.ID).Order("create_date asc").Order("id asc").Find(&comments).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	moved, err := splitComments(comments, split.CommentIDs)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	newPostId, err := uuid.NewUUID()
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	content := fmt.Sprintf("Split from %q.", post.Title)
+	newPost := model.Post{
+		ID:           newPostId.String(),
+		AuthorID:     reqId,
+		BoardID:      split.BoardID,
+		Title:        split.Title,
+		Content:      content,
+		ContentHTML:  markup.Render(content),
+		CreateDate:   now,
+		LastActivity: now,
+	}
+
+	// the first comment moved has no parent in the split, so its original
+	// place is where the stub goes
+	first := moved[0]
+	for _, c := range comments {
+		if c.ID == first.ID {
+			first = c
+			break
+		}
+	}
+	stubId, err := uuid.NewUUID()
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	stubContent := fmt.Sprintf("%d comments were split into %q.", len(moved), newPost.Title)
+	if len(moved) == 1 {
+		stubContent = fmt.Sprintf("A comment was split into %q.", newPost.Title)
+	}
+	stub := model.Comment{
+		ID:          stubId.String(),
+		AuthorID:    reqId,
+		PostID:      post.ID,
+		ParentID:    first.ParentID,
+		Depth:       first.Depth,
+		Content:     stubContent,
+		ContentHTML: markup.Render(stubContent),
+		CreateDate:  first.CreateDate,
+		RedirectID:  newPost.ID,
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&newPost).Error; err != nil {
+			return err
+		}
+		ids := []string{}
+		for _, c := range moved {
+			ids = append(ids, c.ID)
+			if err := tx.Unscoped().Model(&model.Comment{}).Where("id = ?", c.ID).Updates(map[string]interface{}{
+				"post_id":   newPost.ID,
+				"parent_id": c.ParentID,
+				"depth":     c.Depth,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&model.Attachment{}).Where("comment_id IN ?", ids).Update("post_id", newPost.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&stub).Error; err != nil {
+			return err
+		}
+		if err := recountComments(tx, post.ID); err != nil {
+			return err
+		}
+		return recountComments(tx, newPost.ID)
+	})
+	if err != nil {
+		log.Println("ERROR SPLIT POST:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	if err := search.Index(db, search.TypePost, newPost.ID); err != nil {
+		log.Println("ERROR INDEX:", err)
+	}
+	if err := search.Index(db, search.TypeComment, stub.ID); err != nil {
+		log.Println("ERROR INDEX:", err)
+	}
+
+	auditor.Log(reqId, "Split Post", "Success", fmt.Sprintf("%d comments from %s into %s", len(moved), post.ID, newPost.ID))
+	if created, err := getPostById(db, newPost.ID); err == nil {
+		newPost = *created
+	}
+	pub.Publish(PostChannel(post.ID), EventCommentCreated, stub)
+	pub.Publish(BoardChannel(newPost.BoardID), EventPostCreated, newPost)
+	RespondJSON(w, http.StatusOK, newPost)
+}
+
+// redirectStub builds a locked post for board that points to post, shown
+// where post used to be.
+func redirectStub(post *model.Post, boardId, content string) (*model.Post, error) {
+	stubId, err := uuid.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+	return &model.Post{
+		ID:           stubId.String(),
+		AuthorID:     post.AuthorID,
+		BoardID:      boardId,
+		Title:        post.Title,
+		Content:      content,
+		ContentHTML:  markup.Render(content),
+		CreateDate:   post.CreateDate,
+		LastActivity: post.LastActivity,
+		Locked:       true,
+		RedirectID:   post.ID,
+	}, nil
+}
+
+// splitComments picks the comments to move out of a post, given all of its
+// comments in order, trash included. Every reply to a chosen comment goes
+// with it. The result is in the same order, re-rooted: comments whose parent
+// stays behind become top level and depths are counted from there.
+func splitComments(comments []model.Comment, selected []string) ([]model.Comment, error) {
+	byId := map[string]model.Comment{}
+	for _, c := range comments {
+		byId[c.ID] = c
+	}
+
+	chosen := map[string]bool{}
+	for _, id := range selected {
+		c, ok := byId[id]
+		if !ok || c.DeletedAt.Valid || c.Deleted || c.RedirectID != "" {
+			return nil, fmt.Errorf("comment %s can't be split from this post", id)
+		}
+		chosen[id] = true
+	}
+
+	// a comment moves when it or any of its ancestors was chosen
+	var moves func(c model.Comment, seen int) bool
+	moves = func(c model.Comment, seen int) bool {
+		if chosen[c.ID] {
+			return true
+		}
+		parent, ok := byId[c.ParentID]
+		if !ok || seen > len(comments) {
+			return false
+		}
+		return moves(parent, seen+1)
+	}
+
+	moved := []model.Comment{}
+	inSplit := map[string]bool{}
+	for _, c := range comments {
+		if moves(c, 0) {
+			inSplit[c.ID] = true
+			moved = append(moved, c)
+		}
+	}
+
+	// parents come before replies, so depths can be filled in going forward
+	depth := map[string]int{}
+	for i, c := range moved {
+		if !inSplit[c.ParentID] {
+			moved[i].ParentID = ""
+			moved[i].Depth = 0
+		} else {
+			moved[i].Depth = depth[c.ParentID] + 1
+		}
+		depth[c.ID] = moved[i].Depth
+	}
+	return moved, nil
+}
+
+// recountComments sets a post's comment count from the comments it has.
+func recountComments(db *gorm.DB, postId string) error {
+	var count int64
+	if err := db.Model(&model.Comment{}).Where("post_id = ? AND deleted = ?", postId, false).Count(&count).Error; err != nil {
+		return err
+	}
+	return db.Model(&model.Post{}).Where("id = ?", postId).Update("comment_count", count).Error
+}
@@ -0,0 +1,274 @@
+package handler
+
+import (
+	"forum-server/app/auth"
+	"forum-server/app/model"
+	"forum-server/audit"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func setPostState(t *testing.T, db *gorm.DB, moderator *model.User, post *model.Post, name string, value bool, status int) *model.Post {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	SetPostState(db, testAuditor(db), &testPublisher{}, name, value, rec, testRequest(t, "POST", "/api/posts/"+post.ID+"/"+name, nil, moderator, "postId", post.ID))
+	updated := model.Post{}
+	decodeResponse(t, rec, status, &updated)
+	return &updated
+}
+
+func TestSetPostState(t *testing.T) {
+	db := testDB(t)
+	moderator := testUser(t, db, "moderator")
+	author := testUser(t, db, "user")
+	post := testPost(t, db, testBoard(t, db, "board"), author, "post")
+
+	if got := setPostState(t, db, moderator, post, "pin", true, http.StatusOK); !got.Pinned {
+		t.Error("pin didn't pin the post")
+	}
+	if got := setPostState(t, db, moderator, post, "lock", true, http.StatusOK); !got.Locked || !got.Pinned {
+		t.Errorf("lock = %+v, want it locked and still pinned", got)
+	}
+	if got := setPostState(t, db, moderator, post, "pin", false, http.StatusOK); got.Pinned || !got.Locked {
+		t.Errorf("unpin = %+v, want it unpinned and still locked", got)
+	}
+	setPostState(t, db, moderator, post, "feature", true, http.StatusNotFound)
+	setPostState(t, db, moderator, &model.Post{ID: "missing"}, "pin", true, http.StatusNotFound)
+
+	actions := []string{}
+	db.Model(&audit.Audit{}).Where("user_id = ?", moderator.ID).Order("date_time").Pluck("action", &actions)
+	if want := []string{"Pin Post", "Lock Post", "Unpin Post"}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("audited %v, want %v", actions, want)
+	}
+	// clearing a state doesn't notify the author
+	if notifications := notificationsOf(t, db, author); len(notifications) != 2 {
+		t.Errorf("author got %d notifications, want one each for the pin and lock", len(notifications))
+	}
+}
+
+func TestPostStatePermissions(t *testing.T) {
+	db := testDB(t)
+	moderator := testUser(t, db, "moderator")
+	admin := testUser(t, db, "admin")
+	user := testUser(t, db, "user")
+
+	tests := []struct {
+		caller     *model.User
+		permission string
+		status     int
+	}{
+		{moderator, auth.PermPostModerate, http.StatusOK},
+		{user, auth.PermPostModerate, http.StatusUnauthorized},
+		{moderator, auth.PermPostAnnounce, http.StatusUnauthorized},
+		{admin, auth.PermPostAnnounce, http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		RequirePermission(db, tt.permission, rec, testRequest(t, "POST", "/api/posts/p/pin", nil, tt.caller), func(w http.ResponseWriter, r *http.Request) {
+			RespondJSON(w, http.StatusOK, nil)
+		})
+		if rec.Code != tt.status {
+			t.Errorf("%s needing %s: status = %d, want %d", tt.caller.Role, tt.permission, rec.Code, tt.status)
+		}
+	}
+}
+
+func TestClosedPostsRefuseChanges(t *testing.T) {
+	db := testDB(t)
+	moderator := testUser(t, db, "moderator")
+	author := testUser(t, db, "user")
+	post := testPost(t, db, testBoard(t, db, "board"), author, "post")
+	comment := testComment(t, db, post, author, nil, "comment")
+
+	addComment := func(status int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		AddComment(db, &testPublisher{}, rec, testRequest(t, "POST", "/api/post/addComment", model.NewComment{PostID: post.ID, Content: "reply"}, author))
+		decodeResponse(t, rec, status, nil)
+	}
+	editComment := func(status int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		UpdateComment(db, &testPublisher{}, rec, testRequest(t, "PUT", "/api/posts/comments/"+comment.ID, model.CommentEdit{Content: "edited"}, author, "commentId", comment.ID))
+		decodeResponse(t, rec, status, nil)
+	}
+
+	setPostState(t, db, moderator, post, "lock", true, http.StatusOK)
+	addComment(http.StatusForbidden)
+	editPostAs(t, db, post, author, model.PostEdit{Title: "post", Content: "locked posts can still be edited"}, http.StatusOK)
+
+	setPostState(t, db, moderator, post, "lock", false, http.StatusOK)
+	setPostState(t, db, moderator, post, "archive", true, http.StatusOK)
+	addComment(http.StatusForbidden)
+	editComment(http.StatusForbidden)
+	editPostAs(t, db, post, author, model.PostEdit{Title: "post", Content: "archived"}, http.StatusForbidden)
+
+	setPostState(t, db, moderator, post, "archive", false, http.StatusOK)
+	addComment(http.StatusOK)
+	editComment(http.StatusOK)
+}
+
+func TestPinnedAndAnnouncedPosts(t *testing.T) {
+	db := testDB(t)
+	admin := testUser(t, db, "admin")
+	board := testBoard(t, db, "board")
+	older := testPost(t, db, board, admin, "older")
+	newer := testPost(t, db, board, admin, "newer")
+	db.Model(older).Update("create_date", "2020-01-01 00:00:00")
+
+	setPostState(t, db, admin, older, "pin", true, http.StatusOK)
+	posts, _ := boardPage(t, db, board, url.Values{})
+	if got, want := postIDs(posts), []string{older.ID, newer.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("board posts = %v, want the pinned post first: %v", got, want)
+	}
+
+	setPostState(t, db, admin, newer, "announce", true, http.StatusOK)
+	rec := httptest.NewRecorder()
+	GetAnnouncements(db, rec, testRequest(t, "GET", "/api/announcements", nil, nil))
+	announced := []model.Post{}
+	decodePage(t, rec, &announced)
+	if got := postIDs(announced); !reflect.DeepEqual(got, []string{newer.ID}) {
+		t.Errorf("announcements = %v, want only %s", got, newer.ID)
+	}
+}
+
+func TestMovePost(t *testing.T) {
+	db := testDB(t)
+	moderator := testUser(t, db, "moderator")
+	from := testBoard(t, db, "from")
+	to := testBoard(t, db, "to")
+	post := testPost(t, db, from, moderator, "post")
+
+	move := func(postId, boardId string, status int) *model.Post {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		MovePost(db, testAuditor(db), &testPublisher{}, rec, testRequest(t, "POST", "/api/posts/"+postId+"/move", model.PostMove{BoardID: boardId}, moderator, "postId", postId))
+		moved := model.Post{}
+		decodeResponse(t, rec, status, &moved)
+		return &moved
+	}
+
+	move(post.ID, from.ID, http.StatusBadRequest)
+	move(post.ID, "missing", http.StatusBadRequest)
+	if moved := move(post.ID, to.ID, http.StatusOK); moved.BoardID != to.ID {
+		t.Errorf("moved post board = %s, want %s", moved.BoardID, to.ID)
+	}
+
+	stubs, _ := boardPage(t, db, from, url.Values{})
+	if len(stubs) != 1 || stubs[0].RedirectID != post.ID || !stubs[0].Locked || stubs[0].Title != post.Title {
+		t.Fatalf("old board posts = %+v, want a locked stub redirecting to %s", stubs, post.ID)
+	}
+	move(stubs[0].ID, to.ID, http.StatusBadRequest)
+}
+
+func TestMergePost(t *testing.T) {
+	db := testDB(t)
+	moderator := testUser(t, db, "moderator")
+	author := testUser(t, db, "user")
+	board := testBoard(t, db, "board")
+	source := testPost(t, db, board, author, "source")
+	target := testPost(t, db, board, author, "target")
+	testComment(t, db, source, author, nil, "on the source")
+	testComment(t, db, target, author, nil, "on the target")
+	if err := db.Create(&model.Attachment{ID: "a", UploaderID: author.ID, PostID: source.ID, Key: "attachments/a/file"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	merge := func(sourceId, targetId string, status int) *model.Post {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		MergePost(db, testAuditor(db), &testPublisher{}, rec, testRequest(t, "POST", "/api/posts/"+sourceId+"/merge", model.PostMerge{TargetID: targetId}, moderator, "postId", sourceId))
+		merged := model.Post{}
+		decodeResponse(t, rec, status, &merged)
+		return &merged
+	}
+
+	merge(source.ID, source.ID, http.StatusBadRequest)
+	merge(source.ID, "missing", http.StatusBadRequest)
+	if merged := merge(source.ID, target.ID, http.StatusOK); merged.CommentCount != 3 {
+		t.Errorf("target comment_count = %d, want its comment, the source's and the source's text", merged.CommentCount)
+	}
+
+	comments := []model.Comment{}
+	db.Where("post_id = ?", target.ID).Find(&comments)
+	body := false
+	for _, c := range comments {
+		if c.Content == source.Content && c.AuthorID == author.ID && c.CreateDate == source.CreateDate {
+			body = true
+		}
+	}
+	if len(comments) != 3 || !body {
+		t.Errorf("target comments = %+v, want the source's text kept as a comment", comments)
+	}
+
+	stub := model.Post{}
+	db.Where("id = ?", source.ID).First(&stub)
+	if stub.RedirectID != target.ID || !stub.Locked || stub.CommentCount != 0 || stub.EditCount != 1 {
+		t.Errorf("merged source = %+v, want a locked redirect with its text in a revision", stub)
+	}
+	attachment := model.Attachment{}
+	db.Where("id = ?", "a").First(&attachment)
+	if attachment.PostID != target.ID || attachment.CommentID == "" {
+		t.Errorf("attachment = %+v, want it on the comment holding the source's text", attachment)
+	}
+	merge(target.ID, source.ID, http.StatusBadRequest)
+}
+
+func TestSplitPost(t *testing.T) {
+	db := testDB(t)
+	moderator := testUser(t, db, "moderator")
+	author := testUser(t, db, "user")
+	board := testBoard(t, db, "board")
+	post := testPost(t, db, board, author, "post")
+	other := testPost(t, db, board, author, "other")
+	stays := testComment(t, db, post, author, nil, "stays")
+	chosen := testComment(t, db, post, author, stays, "chosen")
+	reply := testComment(t, db, post, author, chosen, "reply")
+	removed := testComment(t, db, post, author, nil, "removed")
+	elsewhere := testComment(t, db, other, author, nil, "elsewhere")
+	removeComment(db, removed, author.ID, "")
+
+	split := func(body model.PostSplit, status int) *model.Post {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		SplitPost(db, testAuditor(db), &testPublisher{}, rec, testRequest(t, "POST", "/api/posts/"+post.ID+"/split", body, moderator, "postId", post.ID))
+		created := model.Post{}
+		decodeResponse(t, rec, status, &created)
+		return &created
+	}
+
+	split(model.PostSplit{Title: " ", CommentIDs: []string{chosen.ID}}, http.StatusBadRequest)
+	split(model.PostSplit{Title: "split"}, http.StatusBadRequest)
+	split(model.PostSplit{Title: "split", CommentIDs: []string{removed.ID}}, http.StatusBadRequest)
+	split(model.PostSplit{Title: "split", CommentIDs: []string{elsewhere.ID}}, http.StatusBadRequest)
+	split(model.PostSplit{Title: "split", BoardID: "missing", CommentIDs: []string{chosen.ID}}, http.StatusBadRequest)
+
+	created := split(model.PostSplit{Title: "split", CommentIDs: []string{chosen.ID}}, http.StatusOK)
+	if created.BoardID != board.ID || created.AuthorID != moderator.ID || created.CommentCount != 2 {
+		t.Errorf("split post = %+v", created)
+	}
+
+	moved := map[string]model.Comment{}
+	comments := []model.Comment{}
+	db.Where("post_id = ?", created.ID).Find(&comments)
+	for _, c := range comments {
+		moved[c.ID] = c
+	}
+	if c := moved[chosen.ID]; c.ParentID != "" || c.Depth != 0 {
+		t.Errorf("chosen comment = %+v, want it top level in the split", c)
+	}
+	if c := moved[reply.ID]; c.ParentID != chosen.ID || c.Depth != 1 {
+		t.Errorf("reply = %+v, want it still under the chosen comment", c)
+	}
+
+	stubs := []model.Comment{}
+	db.Where("post_id = ? AND redirect_id = ?", post.ID, created.ID).Find(&stubs)
+	if len(stubs) != 1 || stubs[0].ParentID != stays.ID || stubs[0].Depth != chosen.Depth {
+		t.Errorf("stubs = %+v, want one in the chosen comment's place", stubs)
+	}
+}
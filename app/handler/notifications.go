@@ -0,0 +1,447 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"forum-server/app/model"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/form3tech-oss/jwt-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	NotifyReply      = "reply"
+	NotifyMention    = "mention"
+	NotifyBoardPost  = "board_post"
+	NotifyModeration = "moderation"
+	NotifyRole       = "role"
+	NotifyReport     = "report"
+)
+
+var NotificationTypes = []string{NotifyReply, NotifyMention, NotifyBoardPost, NotifyModeration, NotifyRole, NotifyReport}
+
+const maxMentions = 10
+
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([\w.-]+)`)
+
+var notificationSorts = map[string]sortKey{
+	"newest": {column: "update_date", desc: true, kind: sortString},
+}
+
+// notificationMessages holds the message for a single notification and the
+// one used once several have been folded together.
+var notificationMessages = map[string][2]string{
+	NotifyReply:      {"New reply on %[2]s", "%[1]d new replies on %[2]s"},
+	NotifyMention:    {"You were mentioned in %[2]s", "You were mentioned %[1]d times in %[2]s"},
+	NotifyBoardPost:  {"New post in %[2]s", "%[1]d new posts in %[2]s"},
+	NotifyModeration: {"%[2]s", "%[2]s"},
+	NotifyRole:       {"Your role was changed to %[2]s", "Your role was changed to %[2]s"},
+	NotifyReport:     {"%[2]s", "%[2]s"},
+}
+
+// notice describes a notification to deliver. Notices with a GroupKey are
+// folded into the recipient's unread notification with the same key.
+type notice struct {
+	UserID     string
+	Type       string
+	ActorID    string
+	TargetType string
+	TargetID   string
+	GroupKey   string
+	Subject    string
+}
+
+func GetNotifications(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	p, err := parsePagination(r, notificationSorts, "newest")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	q := db.Model(&model.Notification{}).Where("user_id = ?", reqId)
+	if r.URL.Query().Get("unread") == "true" {
+		q = q.Where("read = ?", false)
+	}
+
+	notifications := []model.Notification{}
+	page, err := p.find(q, &notifications)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, page)
+}
+
+func GetUnreadNotificationCount(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	var count int64
+	if err := db.Model(&model.Notification{}).Where("user_id = ? AND read = ?", reqId, false).Count(&count).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, model.UnreadCount{Unread: count})
+}
+
+func MarkNotificationRead(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	notificationId := vars["notificationId"]
+
+	notification, err := getNotificationById(db, notificationId)
+	if err != nil || notification.UserID != reqId {
+		RespondError(w, http.StatusNotFound, "notification not found")
+		return
+	}
+
+	notification.Read = true
+	if err := db.Model(notification).Update("read", true).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, notification)
+}
+
+func MarkAllNotificationsRead(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	if err := db.Model(&model.Notification{}).Where("user_id = ? AND read = ?", reqId, false).Update("read", true).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusNoContent, nil)
+}
+
+func DeleteNotification(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	notificationId := vars["notificationId"]
+
+	notification, err := getNotificationById(db, notificationId)
+	if err != nil || notification.UserID != reqId {
+		RespondError(w, http.StatusNotFound, "notification not found")
+		return
+	}
+
+	if err := db.Delete(notification).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusNoContent, nil)
+}
+
+// GetNotificationPreferences lists every notification type and whether the
+// caller receives it. Types are enabled unless turned off.
+func GetNotificationPreferences(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	prefs, err := getNotificationPreferences(db, reqId)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, prefs)
+}
+
+// UpdateNotificationPreferences takes a map of notification type to whether
+// it should be delivered.
+func UpdateNotificationPreferences(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	update := map[string]bool{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&update); err != nil {
+		RespondError(w, http.StatusBadRequest, "an unknown error has occurred")
+		return
+	}
+	defer r.Body.Close()
+
+	for kind := range update {
+		if _, ok := notificationMessages[kind]; !ok {
+			RespondError(w, http.StatusBadRequest, fmt.Sprintf("unknown notification type %q", kind))
+			return
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for kind, enabled := range update {
+			pref := model.NotificationPreference{UserID: reqId, Type: kind, Enabled: enabled}
+			if err := tx.Save(&pref).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	prefs, err := getNotificationPreferences(db, reqId)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, prefs)
+}
+
+func SubscribeBoard(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	boardId := vars["boardId"]
+
+	if _, err := getBoardByID(db, boardId); err != nil {
+		RespondError(w, http.StatusNotFound, "board not found")
+		return
+	}
+
+	subscription := model.BoardSubscription{
+		UserID:     reqId,
+		BoardID:    boardId,
+		CreateDate: time.Now().UTC(),
+	}
+	if err := db.Where(&model.BoardSubscription{UserID: reqId, BoardID: boardId}).FirstOrCreate(&subscription).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, subscription)
+}
+
+func UnsubscribeBoard(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	boardId := vars["boardId"]
+
+	if err := db.Where("user_id = ? AND board_id = ?", reqId, boardId).Delete(&model.BoardSubscription{}).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusNoContent, nil)
+}
+
+func GetBoardSubscriptions(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	subscriptions := []model.BoardSubscription{}
+	if err := db.Where("user_id = ?", reqId).Order("create_date").Find(&subscriptions).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, subscriptions)
+}
+
+func getNotificationById(db *gorm.DB, notificationId string) (*model.Notification, error) {
+	if notificationId == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	notification := model.Notification{}
+	if err := db.Where(&model.Notification{ID: notificationId}).First(&notification).Error; err != nil {
+		return nil, err
+	}
+	return &notification, nil
+}
+
+func getNotificationPreferences(db *gorm.DB, userId string) ([]model.NotificationPreference, error) {
+	stored := []model.NotificationPreference{}
+	if err := db.Where("user_id = ?", userId).Find(&stored).Error; err != nil {
+		return nil, err
+	}
+
+	prefs := []model.NotificationPreference{}
+	for _, kind := range NotificationTypes {
+		pref := model.NotificationPreference{UserID: userId, Type: kind, Enabled: true}
+		for _, s := range stored {
+			if s.Type == kind {
+				pref.Enabled = s.Enabled
+			}
+		}
+		prefs = append(prefs, pref)
+	}
+	return prefs, nil
+}
+
+// notify stores n for its recipient and pushes it to them. Failures are only
+// logged since notifications never block the action that caused them.
+func notify(db *gorm.DB, pub Publisher, n notice) {
+	if n.UserID == "" || n.UserID == n.ActorID {
+		return
+	}
+
+	pref := model.NotificationPreference{}
+	err := db.Where("user_id = ? AND type = ?", n.UserID, n.Type).First(&pref).Error
+	if err == nil && !pref.Enabled {
+		return
+	}
+
+	notification := model.Notification{}
+	found := false
+	if n.GroupKey != "" {
+		err := db.Where("user_id = ? AND group_key = ? AND read = ?", n.UserID, n.GroupKey, false).First(&notification).Error
+		found = err == nil
+	}
+
+	if found {
+		notification.Count++
+	} else {
+		id, err := uuid.NewUUID()
+		if err != nil {
+			log.Println("ERROR NOTIFY:", err)
+			return
+		}
+		notification = model.Notification{
+			ID:         id.String(),
+			UserID:     n.UserID,
+			Type:       n.Type,
+			TargetType: n.TargetType,
+			TargetID:   n.TargetID,
+			GroupKey:   n.GroupKey,
+			Count:      1,
+		}
+	}
+	notification.ActorID = n.ActorID
+	notification.Message = notificationMessage(n.Type, notification.Count, n.Subject)
+	notification.UpdateDate = time.Now().UTC().Format(sortableTimeFormat)
+
+	if err := db.Save(&notification).Error; err != nil {
+		log.Println("ERROR NOTIFY:", err)
+		return
+	}
+	pub.Publish(UserChannel(n.UserID), EventNotification, notification)
+}
+
+func notificationMessage(kind string, count int, subject string) string {
+	messages := notificationMessages[kind]
+	if count > 1 {
+		return fmt.Sprintf(messages[1], count, subject)
+	}
+	return fmt.Sprintf(messages[0], count, subject)
+}
+
+// parseMentions returns the usernames @mentioned in content.
+func parseMentions(content string) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := match[1]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+		if len(names) == maxMentions {
+			break
+		}
+	}
+	return names
+}
+
+// notifyMentions notifies users @mentioned in content, skipping any listed
+// in skip because they were already told about it another way.
+func notifyMentions(db *gorm.DB, pub Publisher, actorId, content, targetType, targetId, subject string, skip map[string]bool) {
+	names := parseMentions(content)
+	if len(names) == 0 {
+		return
+	}
+
+	users := []model.User{}
+	if err := db.Where("username IN ?", names).Find(&users).Error; err != nil {
+		log.Println("ERROR NOTIFY:", err)
+		return
+	}
+	for _, user := range users {
+		if skip[user.ID] {
+			continue
+		}
+		notify(db, pub, notice{
+			UserID:     user.ID,
+			Type:       NotifyMention,
+			ActorID:    actorId,
+			TargetType: targetType,
+			TargetID:   targetId,
+			GroupKey:   NotifyMention + ":" + targetType + ":" + targetId,
+			Subject:    subject,
+		})
+	}
+}
+
+// notifyBoardSubscribers tells everyone subscribed to the post's board about
+// it. Busy boards can have many subscribers, so AddPost runs it in the
+// background rather than making the author wait.
+func notifyBoardSubscribers(db *gorm.DB, pub Publisher, post model.Post) {
+	board, err := getBoardByID(db, post.BoardID)
+	if err != nil {
+		return
+	}
+
+	subscriptions := []model.BoardSubscription{}
+	if err := db.Where("board_id = ?", post.BoardID).Find(&subscriptions).Error; err != nil {
+		log.Println("ERROR NOTIFY:", err)
+		return
+	}
+	for _, subscription := range subscriptions {
+		notify(db, pub, notice{
+			UserID:     subscription.UserID,
+			Type:       NotifyBoardPost,
+			ActorID:    post.AuthorID,
+			TargetType: "board",
+			TargetID:   board.ID,
+			GroupKey:   NotifyBoardPost + ":" + board.ID,
+			Subject:    board.Name,
+		})
+	}
+}
+
+// notifyModeration tells the author of some content that someone else acted
+// on it.
+func notifyModeration(db *gorm.DB, pub Publisher, actorId, authorId, targetType, targetId, message string) {
+	notify(db, pub, notice{
+		UserID:     authorId,
+		Type:       NotifyModeration,
+		ActorID:    actorId,
+		TargetType: targetType,
+		TargetID:   targetId,
+		Subject:    message,
+	})
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"forum-server/app/model"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func notificationsOf(t *testing.T, db *gorm.DB, user *model.User) []model.Notification {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	GetNotifications(db, rec, testRequest(t, "GET", "/api/notifications", nil, user))
+	notifications := []model.Notification{}
+	decodePage(t, rec, &notifications)
+	return notifications
+}
+
+func replyTo(t *testing.T, db *gorm.DB, post *model.Post, author *model.User, content string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	AddComment(db, &testPublisher{}, rec, testRequest(t, "POST", "/api/post/addComment", model.NewComment{PostID: post.ID, Content: content}, author))
+	decodeResponse(t, rec, http.StatusOK, nil)
+}
+
+func TestParseMentions(t *testing.T) {
+	got := parseMentions("hi @alice and @bob.smith, @alice again, mail@example.com")
+	if want := []string{"alice", "bob.smith"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parseMentions = %v, want %v", got, want)
+	}
+}
+
+func TestRepliesAreGroupedUntilRead(t *testing.T) {
+	db := testDB(t)
+	alice := testUser(t, db, "user")
+	bob := testUser(t, db, "user")
+	carol := testUser(t, db, "user")
+	post := testPost(t, db, testBoard(t, db, "notify"), alice, "Hello")
+
+	replyTo(t, db, post, bob, "first, cc @"+carol.Username)
+	replyTo(t, db, post, bob, "second")
+	replyTo(t, db, post, alice, "replying to myself")
+
+	notifications := notificationsOf(t, db, alice)
+	if len(notifications) != 1 || notifications[0].Count != 2 || notifications[0].Message != "2 new replies on Hello" {
+		t.Fatalf("alice's notifications = %+v, want one for two replies", notifications)
+	}
+	if mentions := notificationsOf(t, db, carol); len(mentions) != 1 || mentions[0].Type != NotifyMention {
+		t.Errorf("carol's notifications = %+v, want one mention", mentions)
+	}
+
+	rec := httptest.NewRecorder()
+	MarkNotificationRead(db, rec, testRequest(t, "PUT", "/api/notifications/x/read", nil, alice, "notificationId", notifications[0].ID))
+	decodeResponse(t, rec, http.StatusOK, nil)
+	replyTo(t, db, post, bob, "third")
+	if got := notificationsOf(t, db, alice); len(got) != 2 {
+		t.Errorf("got %d notifications, want a new one after reading the first", len(got))
+	}
+
+	rec = httptest.NewRecorder()
+	GetUnreadNotificationCount(db, rec, testRequest(t, "GET", "/api/notifications/unread", nil, alice))
+	unread := model.UnreadCount{}
+	decodeResponse(t, rec, http.StatusOK, &unread)
+	if unread.Unread != 1 {
+		t.Errorf("unread = %d, want 1", unread.Unread)
+	}
+}
+
+func TestNotificationsBelongToTheirRecipient(t *testing.T) {
+	db := testDB(t)
+	alice := testUser(t, db, "user")
+	bob := testUser(t, db, "user")
+	post := testPost(t, db, testBoard(t, db, "owners"), alice, "Hello")
+	replyTo(t, db, post, bob, "reply")
+	notification := notificationsOf(t, db, alice)[0]
+
+	rec := httptest.NewRecorder()
+	MarkNotificationRead(db, rec, testRequest(t, "PUT", "/api/notifications/x/read", nil, bob, "notificationId", notification.ID))
+	decodeResponse(t, rec, http.StatusNotFound, nil)
+	rec = httptest.NewRecorder()
+	DeleteNotification(db, rec, testRequest(t, "DELETE", "/api/notifications/x", nil, bob, "notificationId", notification.ID))
+	decodeResponse(t, rec, http.StatusNotFound, nil)
+
+	rec = httptest.NewRecorder()
+	DeleteNotification(db, rec, testRequest(t, "DELETE", "/api/notifications/x", nil, alice, "notificationId", notification.ID))
+	decodeResponse(t, rec, http.StatusNoContent, nil)
+	if got := notificationsOf(t, db, alice); len(got) != 0 {
+		t.Errorf("notifications after delete = %+v", got)
+	}
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	db := testDB(t)
+	alice := testUser(t, db, "user")
+	bob := testUser(t, db, "user")
+	post := testPost(t, db, testBoard(t, db, "prefs"), alice, "Hello")
+
+	rec := httptest.NewRecorder()
+	UpdateNotificationPreferences(db, rec, testRequest(t, "PUT", "/api/notifications/preferences", map[string]bool{"carrier_pigeon": true}, alice))
+	decodeResponse(t, rec, http.StatusBadRequest, nil)
+
+	rec = httptest.NewRecorder()
+	UpdateNotificationPreferences(db, rec, testRequest(t, "PUT", "/api/notifications/preferences", map[string]bool{NotifyReply: false}, alice))
+	prefs := []model.NotificationPreference{}
+	decodeResponse(t, rec, http.StatusOK, &prefs)
+	if len(prefs) != len(NotificationTypes) {
+		t.Errorf("got %d preferences, want one per type", len(prefs))
+	}
+	for _, pref := range prefs {
+		if pref.Enabled != (pref.Type != NotifyReply) {
+			t.Errorf("%s enabled = %v", pref.Type, pref.Enabled)
+		}
+	}
+
+	replyTo(t, db, post, bob, "reply")
+	if got := notificationsOf(t, db, alice); len(got) != 0 {
+		t.Errorf("notified of a turned off type: %+v", got)
+	}
+}
+
+func TestBoardSubscriptions(t *testing.T) {
+	db := testDB(t)
+	alice := testUser(t, db, "user")
+	bob := testUser(t, db, "user")
+	board := testBoard(t, db, "subscribed")
+
+	rec := httptest.NewRecorder()
+	SubscribeBoard(db, rec, testRequest(t, "POST", "/api/boards/missing/subscription", nil, alice, "boardId", "missing"))
+	decodeResponse(t, rec, http.StatusNotFound, nil)
+	rec = httptest.NewRecorder()
+	SubscribeBoard(db, rec, testRequest(t, "POST", "/api/boards/x/subscription", nil, alice, "boardId", board.ID))
+	decodeResponse(t, rec, http.StatusOK, nil)
+
+	for i := 0; i < 2; i++ {
+		notifyBoardSubscribers(db, &testPublisher{}, *testPost(t, db, board, bob, "news"))
+	}
+	notifications := notificationsOf(t, db, alice)
+	if len(notifications) != 1 || notifications[0].Count != 2 || notifications[0].Type != NotifyBoardPost {
+		t.Errorf("notifications = %+v, want one for two new posts", notifications)
+	}
+
+	rec = httptest.NewRecorder()
+	UnsubscribeBoard(db, rec, testRequest(t, "DELETE", "/api/boards/x/subscription", nil, alice, "boardId", board.ID))
+	decodeResponse(t, rec, http.StatusNoContent, nil)
+	rec = httptest.NewRecorder()
+	GetBoardSubscriptions(db, rec, testRequest(t, "GET", "/api/subscriptions", nil, alice))
+	subscriptions := []model.BoardSubscription{}
+	decodeResponse(t, rec, http.StatusOK, &subscriptions)
+	if len(subscriptions) != 0 {
+		t.Errorf("subscriptions after unsubscribing = %+v", subscriptions)
+	}
+}
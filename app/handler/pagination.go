@@ -0,0 +1,387 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageLimit = 25
+	maxPageLimit     = 100
+
+	// sortableTimeFormat is fixed width so timestamps stored as strings still
+	// sort correctly when several fall within the same second.
+	sortableTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+type sortKind int
+
+const (
+	sortString sortKind = iota
+	sortInt
+	sortTime
+)
+
+// sortKey orders a collection by column, breaking ties by id. column must
+// also be the json name of the matching field on the listed model so
+// cursors can be built from the returned rows.
+type sortKey struct {
+	column string
+	desc   bool
+	kind   sortKind
+}
+
+var postSorts = map[string]sortKey{
+	"newest":         {column: "create_date", desc: true, kind: sortString},
+	"oldest":         {column: "create_date", desc: false, kind: sortString},
+	"most_commented": {column: "comment_count", desc: true, kind: sortInt},
+	"last_activity":  {column: "last_activity", desc: true, kind: sortString},
+	"top":            {column: "score", desc: true, kind: sortInt},
+}
+
+var commentSorts = map[string]sortKey{
+	"newest": {column: "create_date", desc: true, kind: sortString},
+	"oldest": {column: "create_date", desc: false, kind: sortString},
+	"top":    {column: "score", desc: true, kind: sortInt},
+}
+
+var userSorts = map[string]sortKey{
+	"newest":     {column: "create_date", desc: true, kind: sortString},
+	"oldest":     {column: "create_date", desc: false, kind: sortString},
+	"reputation": {column: "reputation", desc: true, kind: sortInt},
+}
+
+var chatSorts = map[string]sortKey{
+	"newest": {column: "create_date", desc: true, kind: sortString},
+}
+
+var conversationSorts = map[string]sortKey{
+	"last_message": {column: "last_message_at", desc: true, kind: sortString},
+}
+
+var messageSorts = map[string]sortKey{
+	"newest": {column: "create_date", desc: true, kind: sortString},
+	"oldest": {column: "create_date", desc: false, kind: sortString},
+}
+
+var boardSorts = map[string]sortKey{
+	"newest": {column: "create_date", desc: true, kind: sortTime},
+	"oldest": {column: "create_date", desc: false, kind: sortTime},
+}
+
+// Page is the envelope every collection route responds with.
+type Page struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	PrevCursor string      `json:"prev_cursor,omitempty"`
+	Total      int64       `json:"total"`
+}
+
+type cursor struct {
+	Sort     string      `json:"s"`
+	Value    interface{} `json:"v"`
+	ID       string      `json:"id"`
+	Lead     *bool       `json:"l,omitempty"`
+	Backward bool        `json:"b,omitempty"`
+}
+
+type pagination struct {
+	limit    int
+	sortName string
+	sort     sortKey
+	lead     string
+	cursor   *cursor
+}
+
+// parsePagination reads the limit, sort and cursor query parameters.
+func parsePagination(r *http.Request, sorts map[string]sortKey, defaultSort string) (*pagination, error) {
+	query := r.URL.Query()
+
+	p := pagination{limit: defaultPageLimit, sortName: defaultSort}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return nil, errors.New("invalid limit")
+		}
+		if n > maxPageLimit {
+			n = maxPageLimit
+		}
+		p.limit = n
+	}
+
+	if name := query.Get("sort"); name != "" {
+		p.sortName = name
+	}
+	key, ok := sorts[p.sortName]
+	if !ok {
+		return nil, fmt.Errorf("invalid sort, expected one of %s", strings.Join(sortNames(sorts), ", "))
+	}
+	p.sort = key
+
+	if encoded := query.Get("cursor"); encoded != "" {
+		c, err := decodeCursor(encoded, key.kind)
+		if err != nil || c.Sort != p.sortName {
+			return nil, errors.New("invalid cursor")
+		}
+		p.cursor = c
+	}
+
+	return &p, nil
+}
+
+// leading puts rows with the boolean column set ahead of all others,
+// whatever the sort. column must also be the json name of its field.
+func (p *pagination) leading(column string) error {
+	if p.cursor != nil && p.cursor.Lead == nil {
+		return errors.New("invalid cursor")
+	}
+	p.lead = column
+	return nil
+}
+
+// find counts the rows matched by q and loads one page of them into dest,
+// which must be a pointer to a slice of structs.
+func (p *pagination) find(q *gorm.DB, dest interface{}) (*Page, error) {
+	q = q.Session(&gorm.Session{})
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	if err := p.apply(q).Find(dest).Error; err != nil {
+		return nil, err
+	}
+
+	return p.page(dest, total), nil
+}
+
+func (p *pagination) apply(q *gorm.DB) *gorm.DB {
+	desc := p.sort.desc
+	if p.cursor != nil && p.cursor.Backward {
+		desc = !desc
+	}
+
+	// the lead column always sorts true first, flipped with the rest when
+	// paging backward
+	leadDesc := p.cursor == nil || !p.cursor.Backward
+
+	if p.cursor != nil {
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		where := fmt.Sprintf("(%s, id) %s (?, ?)", p.sort.column, op)
+		args := []interface{}{p.cursor.Value, p.cursor.ID}
+		if p.lead != "" {
+			leadOp := ">"
+			if leadDesc {
+				leadOp = "<"
+			}
+			where = fmt.Sprintf("(%s %s ? OR (%s = ? AND %s))", p.lead, leadOp, p.lead, where)
+			args = append([]interface{}{*p.cursor.Lead, *p.cursor.Lead}, args...)
+		}
+		q = q.Where(where, args...)
+	}
+
+	if p.lead != "" {
+		if leadDesc {
+			q = q.Order(p.lead + " desc")
+		} else {
+			q = q.Order(p.lead + " asc")
+		}
+	}
+
+	dir := "asc"
+	if desc {
+		dir = "desc"
+	}
+	return q.Order(p.sort.column + " " + dir).Order("id " + dir).Limit(p.limit + 1)
+}
+
+// page trims the extra row fetched by apply, restores the requested order
+// and builds the cursors on either side of the result.
+func (p *pagination) page(dest interface{}, total int64) *Page {
+	items := reflect.ValueOf(dest).Elem()
+
+	hasMore := items.Len() > p.limit
+	if hasMore {
+		items.Set(items.Slice(0, p.limit))
+	}
+
+	backward := p.cursor != nil && p.cursor.Backward
+	if backward {
+		for i, j := 0, items.Len()-1; i < j; i, j = i+1, j-1 {
+			a, b := items.Index(i).Interface(), items.Index(j).Interface()
+			items.Index(i).Set(reflect.ValueOf(b))
+			items.Index(j).Set(reflect.ValueOf(a))
+		}
+	}
+
+	page := Page{Items: items.Interface(), Total: total}
+	if items.Len() == 0 {
+		return &page
+	}
+
+	if hasMore || backward {
+		page.NextCursor = p.cursorFor(items.Index(items.Len()-1), false)
+	}
+	if (hasMore && backward) || (!backward && p.cursor != nil) {
+		page.PrevCursor = p.cursorFor(items.Index(0), true)
+	}
+	return &page
+}
+
+func (p *pagination) cursorFor(item reflect.Value, backward bool) string {
+	c := cursor{
+		Sort:     p.sortName,
+		Value:    jsonField(item, p.sort.column),
+		ID:       fmt.Sprintf("%v", jsonField(item, "id")),
+		Backward: backward,
+	}
+	if t, ok := c.Value.(time.Time); ok {
+		c.Value = t.Format(time.RFC3339Nano)
+	}
+	if p.lead != "" {
+		lead, _ := jsonField(item, p.lead).(bool)
+		c.Lead = &lead
+	}
+
+	encoded, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func decodeCursor(encoded string, kind sortKind) (*cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(string(raw)))
+	decoder.UseNumber()
+
+	c := cursor{}
+	if err := decoder.Decode(&c); err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case sortInt:
+		n, ok := c.Value.(json.Number)
+		if !ok {
+			return nil, errors.New("invalid cursor value")
+		}
+		if c.Value, err = n.Int64(); err != nil {
+			return nil, err
+		}
+	case sortTime:
+		s, ok := c.Value.(string)
+		if !ok {
+			return nil, errors.New("invalid cursor value")
+		}
+		if c.Value, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			return nil, err
+		}
+	default:
+		if _, ok := c.Value.(string); !ok {
+			return nil, errors.New("invalid cursor value")
+		}
+	}
+	return &c, nil
+}
+
+// jsonField returns the value of the struct field tagged with name.
+func jsonField(item reflect.Value, name string) interface{} {
+	item = reflect.Indirect(item)
+	t := item.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if v := jsonField(item.Field(i), name); v != nil {
+				return v
+			}
+			continue
+		}
+		if strings.Split(field.Tag.Get("json"), ",")[0] == name {
+			return item.Field(i).Interface()
+		}
+	}
+	return nil
+}
+
+func sortNames(sorts map[string]sortKey) []string {
+	names := []string{}
+	for name := range sorts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type offsetCursor struct {
+	Offset int `json:"o"`
+}
+
+// parseOffsetPagination reads limit and cursor for collections that can't
+// be paged by key, such as relevance ranked search results.
+func parseOffsetPagination(r *http.Request) (int, int, error) {
+	query := r.URL.Query()
+
+	limit := defaultPageLimit
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			return 0, 0, errors.New("invalid limit")
+		}
+		if n > maxPageLimit {
+			n = maxPageLimit
+		}
+		limit = n
+	}
+
+	offset := 0
+	if encoded := query.Get("cursor"); encoded != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil {
+			return 0, 0, errors.New("invalid cursor")
+		}
+		c := offsetCursor{}
+		if err := json.Unmarshal(raw, &c); err != nil || c.Offset < 0 {
+			return 0, 0, errors.New("invalid cursor")
+		}
+		offset = c.Offset
+	}
+
+	return limit, offset, nil
+}
+
+func offsetPage(items interface{}, count int, total int64, limit, offset int) *Page {
+	page := Page{Items: items, Total: total}
+	if int64(offset+count) < total {
+		page.NextCursor = encodeOffsetCursor(offset + limit)
+	}
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		page.PrevCursor = encodeOffsetCursor(prev)
+	}
+	return &page
+}
+
+func encodeOffsetCursor(offset int) string {
+	encoded, _ := json.Marshal(offsetCursor{Offset: offset})
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
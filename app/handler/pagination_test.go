@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"forum-server/app/model"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func boardPage(t *testing.T, db *gorm.DB, board *model.Board, query url.Values) ([]model.Post, testPage) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	GetPostsFromBoard(db, rec, testRequest(t, "GET", "/api/board/"+board.ID+"/posts?"+query.Encode(), nil, nil, "boardId", board.ID))
+	posts := []model.Post{}
+	page := decodePage(t, rec, &posts)
+	return posts, page
+}
+
+func postIDs(posts []model.Post) []string {
+	ids := make([]string, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+	return ids
+}
+
+func TestBoardPostsPageBothWays(t *testing.T) {
+	db := testDB(t)
+	author := testUser(t, db, "user")
+	board := testBoard(t, db, "paging")
+	var posts []*model.Post
+	for _, title := range []string{"a", "b", "c", "d", "e"} {
+		posts = append(posts, testPost(t, db, board, author, title))
+	}
+	// the oldest post is pinned, so it leads whatever the sort
+	if err := db.Model(posts[0]).Update("pinned", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := []string{posts[0].ID, posts[4].ID, posts[3].ID, posts[2].ID, posts[1].ID}
+
+	var got []string
+	var pages []testPage
+	query := url.Values{"sort": {"newest"}, "limit": {"2"}}
+	for {
+		items, page := boardPage(t, db, board, query)
+		if page.Total != 5 {
+			t.Fatalf("total = %d, want 5", page.Total)
+		}
+		got = append(got, postIDs(items)...)
+		pages = append(pages, page)
+		if page.NextCursor == "" || len(pages) > 5 {
+			break
+		}
+		query.Set("cursor", page.NextCursor)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("paged through %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("paged through %v, want %v", got, want)
+		}
+	}
+	if pages[0].PrevCursor != "" {
+		t.Error("first page has a prev_cursor")
+	}
+
+	// stepping back from the last page returns the one before it
+	query.Set("cursor", pages[len(pages)-1].PrevCursor)
+	items, _ := boardPage(t, db, board, query)
+	if ids := postIDs(items); len(ids) != 2 || ids[0] != want[2] || ids[1] != want[3] {
+		t.Errorf("previous page = %v, want %v", ids, want[2:4])
+	}
+}
+
+func TestPaginationRefusals(t *testing.T) {
+	db := testDB(t)
+	author := testUser(t, db, "user")
+	board := testBoard(t, db, "refusals")
+	testPost(t, db, board, author, "a")
+	testPost(t, db, board, author, "b")
+
+	_, page := boardPage(t, db, board, url.Values{"sort": {"top"}, "limit": {"1"}})
+	if page.NextCursor == "" {
+		t.Fatal("no next_cursor")
+	}
+
+	for _, query := range []string{"limit=0", "limit=x", "sort=sideways", "cursor=%21", "sort=newest&cursor=" + page.NextCursor} {
+		rec := httptest.NewRecorder()
+		GetPostsFromBoard(db, rec, testRequest(t, "GET", "/api/board/"+board.ID+"/posts?"+query, nil, nil, "boardId", board.ID))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, rec.Code)
+		}
+	}
+}
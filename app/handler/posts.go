@@ -3,7 +3,11 @@ package handler
 import (
 	"encoding/json"
 	"fmt"
+	"forum-server/app/auth"
+	"forum-server/app/markup"
 	"forum-server/app/model"
+	"forum-server/app/reputation"
+	"forum-server/app/search"
 	"log"
 	"net/http"
 	"time"
@@ -22,6 +26,10 @@ func GetPost(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		RespondError(w, http.StatusNotFound, "post not found")
 		return
 	}
+	if err := loadPostTags(db, post); err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
 	RespondJSON(w, http.StatusOK, post)
 }
 
@@ -35,7 +43,13 @@ func GetPostsFromUser(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	posts, err := getPostsFromUser(db, user.ID)
+	p, err := parsePagination(r, postSorts, "newest")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	posts, err := getPostsFromUser(db, user.ID, p)
 	if err != nil {
 		RespondError(w, http.StatusNotFound, "posts not found")
 		return
@@ -46,7 +60,18 @@ func GetPostsFromUser(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 func GetPostsFromBoard(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	boardId := vars["boardId"]
-	posts, err := getPostsFromBoard(db, boardId)
+
+	p, err := parsePagination(r, postSorts, "last_activity")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := p.leading("pinned"); err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	posts, err := getPostsFromBoard(db, boardId, p)
 	if err != nil {
 		RespondError(w, http.StatusNotFound, "posts not found")
 		return
@@ -54,7 +79,7 @@ func GetPostsFromBoard(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	RespondJSON(w, http.StatusOK, posts)
 }
 
-func UpdatePost(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+func UpdatePost(db *gorm.DB, pub Publisher, w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	userCtx := ctx.Value("user")
 
@@ -69,26 +94,80 @@ func UpdatePost(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if reqId != post.AuthorID {
+	if reqId != post.AuthorID && !hasPermission(db, r, auth.PermPostUpdateAny) && !hasReputation(db, fmt.Sprintf("%v", reqId), reputation.AbilityEditOthers) {
 		RespondError(w, http.StatusUnauthorized, "no access")
 		return
 	}
+	if post.Archived {
+		RespondError(w, http.StatusForbidden, "post is archived")
+		return
+	}
+	if ban := postingBan(db, fmt.Sprintf("%v", reqId), post.BoardID); ban != nil {
+		respondBanned(w, ban)
+		return
+	}
 
+	edit := model.PostEdit{}
 	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&post); err != nil {
+	if err := decoder.Decode(&edit); err != nil {
 		RespondError(w, http.StatusBadRequest, "")
 		return
 	}
 	defer r.Body.Close()
 
-	if err := db.Save(&post).Error; err != nil {
-		RespondError(w, http.StatusInternalServerError, "")
+	if err := validReason(edit.Reason); err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	attachmentIds, err := checkAttachments(db, fmt.Sprintf("%v", reqId), edit.AttachmentIDs, countAttachments(db, post.ID, ""))
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+
+	// tags are created in the same transaction so a refused edit leaves none
+	// behind
+	allowRestricted := hasPermission(db, r, auth.PermTagManage)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var tags []model.Tag
+		if edit.Tags != nil {
+			current, err := postTagIds(tx, post.ID)
+			if err != nil {
+				return err
+			}
+			if tags, err = resolveTags(tx, edit.Tags, allowRestricted, current); err != nil {
+				return err
+			}
+		}
+		if err := editPost(tx, post, fmt.Sprintf("%v", reqId), edit.Reason, edit.Title, edit.Content); err != nil {
+			return err
+		}
+		if edit.Tags != nil {
+			return setPostTags(tx, post, tags)
+		}
+		return nil
+	})
+	if err != nil {
+		respondTagError(w, err)
+		return
+	}
+	if err := linkAttachments(db, attachmentIds, post.ID, ""); err != nil {
+		log.Println("ERROR LINK ATTACHMENTS:", err)
+	}
+	if err := loadPostTags(db, post); err != nil {
+		log.Println("ERROR LOAD TAGS:", err)
+	}
+	if err := search.Index(db, search.TypePost, post.ID); err != nil {
+		log.Println("ERROR INDEX:", err)
+	}
+	pub.Publish(PostChannel(post.ID), EventPostUpdated, post)
+	pub.Publish(BoardChannel(post.BoardID), EventPostUpdated, post)
+	notifyModeration(db, pub, fmt.Sprintf("%v", reqId), post.AuthorID, "post", post.ID, fmt.Sprintf("A moderator edited your post %q", post.Title))
 	RespondJSON(w, http.StatusOK, post)
 }
 
-func AddPost(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+func AddPost(db *gorm.DB, pub Publisher, w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	userCtx := ctx.Value("user")
 
@@ -103,7 +182,23 @@ func AddPost(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	vars := mux.Vars(r)
-	boardId := vars["boardId"]
+	board, err := getBoardByID(db, vars["boardId"])
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "board not found")
+		return
+	}
+	boardId := board.ID
+
+	if ban := postingBan(db, fmt.Sprintf("%v", reqId), boardId); ban != nil {
+		respondBanned(w, ban)
+		return
+	}
+
+	attachmentIds, err := checkAttachments(db, fmt.Sprintf("%v", reqId), newPost.AttachmentIDs, 0)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	postId, err := uuid.NewUUID()
 	if err != nil {
@@ -111,33 +206,57 @@ func AddPost(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	now := time.Now().UTC().Format(time.RFC3339)
 	post := model.Post{
-		ID:         postId.String(),
-		AuthorID:   fmt.Sprintf("%v", reqId),
-		BoardID:    boardId,
-		Title:      newPost.Title,
-		Content:    newPost.Content,
-		CreateDate: time.Now().UTC().Format(time.RFC3339),
+		ID:           postId.String(),
+		AuthorID:     fmt.Sprintf("%v", reqId),
+		BoardID:      boardId,
+		Title:        newPost.Title,
+		Content:      newPost.Content,
+		ContentHTML:  markup.Render(newPost.Content),
+		CreateDate:   now,
+		CommentCount: 0,
+		LastActivity: now,
 	}
 
-	if err = db.Save(&post).Error; err != nil {
-		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+	// tags are created in the same transaction so a refused post leaves none
+	// behind
+	allowRestricted := hasPermission(db, r, auth.PermTagManage)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		tags, err := resolveTags(tx, newPost.Tags, allowRestricted, nil)
+		if err != nil {
+			return err
+		}
+		if err := tx.Save(&post).Error; err != nil {
+			return err
+		}
+		if len(tags) > 0 {
+			return setPostTags(tx, &post, tags)
+		}
+		return nil
+	})
+	if err != nil {
+		respondTagError(w, err)
 		return
 	}
+	if err := linkAttachments(db, attachmentIds, post.ID, ""); err != nil {
+		log.Println("ERROR LINK ATTACHMENTS:", err)
+	}
+	if err := search.Index(db, search.TypePost, post.ID); err != nil {
+		log.Println("ERROR INDEX:", err)
+	}
+	pub.Publish(BoardChannel(post.BoardID), EventPostCreated, post)
+	go notifyBoardSubscribers(db, pub, post)
+	notifyMentions(db, pub, post.AuthorID, post.Content, "post", post.ID, post.Title, nil)
 
 	RespondJSON(w, http.StatusOK, map[string]string{"id": post.ID})
 }
 
-func DeletePost(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+func DeletePost(db *gorm.DB, pub Publisher, w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	userCtx := ctx.Value("user")
 
 	reqId := userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"]
-	userRole, err := getUserRoleById(db, fmt.Sprintf("%v", reqId))
-	if err != nil {
-		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
-		return
-	}
 
 	vars := mux.Vars(r)
 	postId := vars["postId"]
@@ -148,15 +267,25 @@ func DeletePost(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if reqId != post.AuthorID && userRole != "admin" && userRole != "moderator" {
+	if reqId != post.AuthorID && !hasPermission(db, r, auth.PermPostDeleteAny) {
 		RespondError(w, http.StatusUnauthorized, "no access")
 		return
 	}
 
-	if err := db.Delete(&post).Error; err != nil {
+	reason, err := trashReason(r)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := deletePost(db, post, fmt.Sprintf("%v", reqId), reason); err != nil {
 		RespondError(w, http.StatusInternalServerError, "")
 		return
 	}
+	deleted := map[string]string{"id": post.ID, "board_id": post.BoardID}
+	pub.Publish(PostChannel(post.ID), EventPostDeleted, deleted)
+	pub.Publish(BoardChannel(post.BoardID), EventPostDeleted, deleted)
+	notifyModeration(db, pub, fmt.Sprintf("%v", reqId), post.AuthorID, "post", post.ID, fmt.Sprintf("A moderator deleted your post %q", post.Title))
 	RespondJSON(w, http.StatusNoContent, nil)
 }
 
@@ -166,14 +295,13 @@ func GetPostAuthor(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	post, err := getPostById(db, postId)
 	if err != nil {
 		RespondError(w, http.StatusNotFound, "post not found")
-		log.Fatal(err)
 		return
 	}
 
 	author, err := publicUser(db, post.AuthorID)
 	if err != nil {
-		log.Fatal(err)
 		RespondError(w, http.StatusNotFound, "user not found")
+		return
 	}
 
 	RespondJSON(w, http.StatusOK, author)
@@ -187,18 +315,20 @@ func getPostById(db *gorm.DB, postId string) (*model.Post, error) {
 	return &post, nil
 }
 
-func getPostsFromUser(db *gorm.DB, userId string) (*[]model.Post, error) {
-	var posts []model.Post
-	if err := db.Where(&model.Post{AuthorID: userId}).Find(&posts).Error; err != nil {
+func getPostsFromUser(db *gorm.DB, userId string, p *pagination) (*Page, error) {
+	posts := []model.Post{}
+	page, err := p.find(db.Model(&model.Post{}).Where(&model.Post{AuthorID: userId}), &posts)
+	if err != nil {
 		return nil, err
 	}
-	return &posts, nil
+	return page, loadTags(db, posts)
 }
 
-func getPostsFromBoard(db *gorm.DB, boardId string) (*[]model.Post, error) {
-	var posts []model.Post
-	if err := db.Where(&model.Post{BoardID: boardId}).Find(&posts).Error; err != nil {
+func getPostsFromBoard(db *gorm.DB, boardId string, p *pagination) (*Page, error) {
+	posts := []model.Post{}
+	page, err := p.find(db.Model(&model.Post{}).Where(&model.Post{BoardID: boardId}), &posts)
+	if err != nil {
 		return nil, err
 	}
-	return &posts, nil
+	return page, loadTags(db, posts)
 }
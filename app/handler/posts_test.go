@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"forum-server/app/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAddPostNeedsLiveBoard(t *testing.T) {
+	db := testDB(t)
+	user := testUser(t, db, "user")
+	live := testBoard(t, db, "live")
+	trashed := testBoard(t, db, "trashed")
+	if err := db.Delete(trashed).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		boardId string
+		status  int
+	}{
+		{"missing board", "no-such-board", http.StatusNotFound},
+		{"trashed board", trashed.ID, http.StatusNotFound},
+		{"live board", live.ID, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &testPublisher{}
+			body := model.NewPost{Title: "title", Content: "content"}
+			rec := httptest.NewRecorder()
+			AddPost(db, pub, rec, testRequest(t, "POST", "/api/boards/"+tt.boardId+"/newPost", body, user, "boardId", tt.boardId))
+			decodeResponse(t, rec, tt.status, nil)
+
+			var posts int64
+			db.Model(&model.Post{}).Where("board_id = ?", tt.boardId).Count(&posts)
+			if created := tt.status == http.StatusOK; (posts == 1) != created || (len(pub.events) > 0) != created {
+				t.Errorf("%d posts stored and %d events published", posts, len(pub.events))
+			}
+		})
+	}
+}
+
+func TestContentIsRendered(t *testing.T) {
+	db := testDB(t)
+	user := testUser(t, db, "user")
+	board := testBoard(t, db, "board")
+
+	rec := httptest.NewRecorder()
+	AddPost(db, &testPublisher{}, rec, testRequest(t, "POST", "/api/boards/"+board.ID+"/newPost", model.NewPost{Title: "title", Content: "*new* <script>x</script>"}, user, "boardId", board.ID))
+	post := model.Post{}
+	decodeResponse(t, rec, http.StatusOK, &post)
+	db.Where("id = ?", post.ID).First(&post)
+	if post.ContentHTML != "<p><em>new</em> x</p>\n" {
+		t.Errorf("new post content_html = %q", post.ContentHTML)
+	}
+
+	rec = httptest.NewRecorder()
+	UpdatePost(db, &testPublisher{}, rec, testRequest(t, "PUT", "/api/posts/"+post.ID, model.PostEdit{Title: "title", Content: "**edited**"}, user, "postId", post.ID))
+	decodeResponse(t, rec, http.StatusOK, nil)
+	stored := model.Post{}
+	db.Where("id = ?", post.ID).First(&stored)
+	if stored.ContentHTML != "<p><strong>edited</strong></p>\n" {
+		t.Errorf("edited post content_html = %q", stored.ContentHTML)
+	}
+
+	comment := testComment(t, db, &stored, user, nil, "old")
+	rec = httptest.NewRecorder()
+	UpdateComment(db, &testPublisher{}, rec, testRequest(t, "PUT", "/api/comments/"+comment.ID, model.CommentEdit{Content: "`code`"}, user, "commentId", comment.ID))
+	decodeResponse(t, rec, http.StatusOK, nil)
+	db.Where("id = ?", comment.ID).First(comment)
+	if comment.ContentHTML != "<p><code>code</code></p>\n" {
+		t.Errorf("edited comment content_html = %q", comment.ContentHTML)
+	}
+}
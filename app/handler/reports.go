@@ -0,0 +1,514 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forum-server/app/auth"
+	"forum-server/app/model"
+	"forum-server/audit"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/form3tech-oss/jwt-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	ReportOpen      = "open"
+	ReportDismissed = "dismissed"
+	ReportRemoved   = "removed"
+	ReportWarned    = "warned"
+	ReportBanned    = "banned"
+)
+
+const maxReportDetailsLength = 1000
+
+var reportCategories = []string{"spam", "harassment", "hate", "violence", "sexual", "misinformation", "off_topic", "other"}
+
+var reportTargets = []string{"post", "comment", "user", "conversation"}
+
+// reportActions maps each resolution action to the status the reports it
+// closes are left with.
+var reportActions = map[string]string{
+	"dismiss": ReportDismissed,
+	"delete":  ReportRemoved,
+	"warn":    ReportWarned,
+	"ban":     ReportBanned,
+}
+
+// reportOutcomes tell reporters what came of their report.
+var reportOutcomes = map[string]string{
+	ReportDismissed: "A moderator reviewed the %s you reported and took no action",
+	ReportRemoved:   "The %s you reported was removed",
+	ReportWarned:    "A moderator warned the user behind the %s you reported",
+	ReportBanned:    "A moderator banned the user behind the %s you reported",
+}
+
+var (
+	errNoOpenReports = errors.New("no open reports")
+	errNotRemovable  = errors.New("only posts and comments can be deleted")
+)
+
+var reportSorts = map[string]sortKey{
+	"newest": {column: "create_date", desc: true, kind: sortTime},
+	"oldest": {column: "create_date", desc: false, kind: sortTime},
+}
+
+var reportQueueSorts = map[string]sortKey{
+	"most_reported": {column: "report_count", desc: true, kind: sortInt},
+	"oldest":        {column: "first_report_date", desc: false, kind: sortTime},
+	"newest":        {column: "last_report_date", desc: true, kind: sortTime},
+}
+
+// reportQueueRow is a model.ReportQueueItem as it is scanned from the
+// database, before its aggregated dates are parsed.
+type reportQueueRow struct {
+	ID              string        `json:"id"`
+	TargetType      string        `json:"target_type"`
+	TargetAuthorID  string        `json:"target_author_id"`
+	ReportCount     int           `json:"report_count"`
+	FirstReportDate aggregateTime `json:"first_report_date"`
+	LastReportDate  aggregateTime `json:"last_report_date"`
+}
+
+func ReportPost(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	postId := vars["postId"]
+
+	post, err := getPostById(db, postId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "post not found")
+		return
+	}
+	fileReport(db, auditor, w, r, "post", post.ID, post.AuthorID)
+}
+
+func ReportComment(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	commentId := vars["commentId"]
+
+	comment, err := getCommentById(db, commentId)
+	if err != nil || comment.Deleted {
+		RespondError(w, http.StatusNotFound, "comment not found")
+		return
+	}
+	fileReport(db, auditor, w, r, "comment", comment.ID, comment.AuthorID)
+}
+
+func ReportUser(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userId := vars["userId"]
+
+	user, err := getUserById(db, userId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	fileReport(db, auditor, w, r, "user", user.ID, user.ID)
+}
+
+// ReportConversation flags a conversation for moderators, who may then read
+// it.
+func ReportConversation(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	conversationId := vars["conversationId"]
+
+	// users who left may still report what was said before they did
+	participant := model.ConversationParticipant{}
+	if err := db.Where("conversation_id = ? AND user_id = ?", conversationId, reqId).First(&participant).Error; err != nil {
+		RespondError(w, http.StatusNotFound, "conversation not found")
+		return
+	}
+	fileReport(db, auditor, w, r, "conversation", participant.ConversationID, "")
+}
+
+// GetReportQueue lists reported targets with the number of open reports on
+// each, most reported first. Conversations are only listed for moderators
+// who may read reported messages.
+func GetReportQueue(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	p, err := parsePagination(r, reportQueueSorts, "most_reported")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	reports := db.Model(&model.Report{}).
+		Select("target_id AS id, target_type, MAX(target_author_id) AS target_author_id, COUNT(*) AS report_count, MIN(create_date) AS first_report_date, MAX(create_date) AS last_report_date").
+		Where("status = ?", ReportOpen).
+		Group("target_type, target_id")
+
+	targetType := r.URL.Query().Get("type")
+	switch {
+	case targetType == "":
+		if !hasPermission(db, r, auth.PermMessageModerate) {
+			reports = reports.Where("target_type <> ?", "conversation")
+		}
+	case !contains(reportTargets, targetType):
+		RespondError(w, http.StatusBadRequest, fmt.Sprintf("invalid type, expected one of %s", strings.Join(reportTargets, ", ")))
+		return
+	case targetType == "conversation" && !hasPermission(db, r, auth.PermMessageModerate):
+		RespondError(w, http.StatusUnauthorized, "no access")
+		return
+	default:
+		reports = reports.Where("target_type = ?", targetType)
+	}
+
+	rows := []reportQueueRow{}
+	page, err := p.find(db.Table("(?) AS queue", reports), &rows)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	items := []model.ReportQueueItem{}
+	for _, row := range page.Items.([]reportQueueRow) {
+		items = append(items, model.ReportQueueItem{
+			ID:              row.ID,
+			TargetType:      row.TargetType,
+			TargetAuthorID:  row.TargetAuthorID,
+			ReportCount:     row.ReportCount,
+			FirstReportDate: row.FirstReportDate.Time,
+			LastReportDate:  row.LastReportDate.Time,
+		})
+	}
+	page.Items = items
+	RespondJSON(w, http.StatusOK, page)
+}
+
+// GetTargetReports lists the reports filed on one target. Only open reports
+// are listed unless status says otherwise.
+func GetTargetReports(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	targetType := vars["targetType"]
+	targetId := vars["targetId"]
+
+	if !canModerateReports(db, r, targetType) {
+		RespondError(w, http.StatusUnauthorized, "no access")
+		return
+	}
+
+	p, err := parsePagination(r, reportSorts, "newest")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	q := db.Model(&model.Report{}).Where("target_type = ? AND target_id = ?", targetType, targetId)
+	switch status := r.URL.Query().Get("status"); status {
+	case "":
+		q = q.Where("status = ?", ReportOpen)
+	case "all":
+	default:
+		q = q.Where("status = ?", status)
+	}
+
+	reports := []model.Report{}
+	page, err := p.find(q, &reports)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, page)
+}
+
+// GetMyReports lists the reports the caller filed and what became of them.
+func GetMyReports(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	p, err := parsePagination(r, reportSorts, "newest")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	reports := []model.Report{}
+	page, err := p.find(db.Model(&model.Report{}).Where(&model.Report{ReporterID: reqId}), &reports)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, page)
+}
+
+// ResolveReports acts on a reported target and closes every open report on
+// it. The action is one of dismiss, delete (posts and comments only), warn
+// or ban, and the reporters are told the outcome.
+func ResolveReports(db *gorm.DB, auditor *audit.Auditor, pub Publisher, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	targetType := vars["targetType"]
+	targetId := vars["targetId"]
+
+	if !canModerateReports(db, r, targetType) {
+		RespondError(w, http.StatusUnauthorized, "no access")
+		return
+	}
+
+	resolution := model.ReportResolution{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&resolution); err != nil {
+		RespondError(w, http.StatusBadRequest, "")
+		return
+	}
+	defer r.Body.Close()
+
+	status, ok := reportActions[resolution.Action]
+	if !ok {
+		RespondError(w, http.StatusBadRequest, "invalid action, expected one of dismiss, delete, warn, ban")
+		return
+	}
+	note := strings.TrimSpace(resolution.Note)
+	if err := validReason(note); err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if status == ReportBanned && !hasPermission(db, r, auth.PermUserBan) {
+		RespondError(w, http.StatusUnauthorized, "no access")
+		return
+	}
+
+	reports, err := openReports(db, targetType, targetId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, errNoOpenReports.Error())
+		return
+	}
+
+	authorId := reports[0].TargetAuthorID
+	if targetType == "conversation" {
+		authorId = ""
+		if resolution.UserID != "" {
+			participant := model.ConversationParticipant{}
+			if err := db.Where("conversation_id = ? AND user_id = ?", targetId, resolution.UserID).First(&participant).Error; err != nil {
+				RespondError(w, http.StatusBadRequest, "user_id must be a participant of the conversation")
+				return
+			}
+			authorId = participant.UserID
+		}
+	}
+	if (status == ReportWarned || status == ReportBanned) && authorId == "" {
+		RespondError(w, http.StatusBadRequest, "user_id is required to warn or ban in a conversation")
+		return
+	}
+
+	switch status {
+	case ReportRemoved:
+		err = removeReported(db, pub, targetType, targetId, reqId, note)
+	case ReportWarned:
+		message := fmt.Sprintf("A moderator warned you about your %s", reportTargetName(targetType))
+		if note != "" {
+			message += ": " + note
+		}
+		notifyModeration(db, pub, reqId, authorId, targetType, targetId, message)
+	case ReportBanned:
+		reason := note
+		if reason == "" {
+			reason = fmt.Sprintf("Banned after your %s was reported", reportTargetName(targetType))
+		}
+		var author *model.User
+		if author, err = getUserById(db, authorId); err == nil {
+			if !outranks(db, r, author) {
+				RespondError(w, http.StatusForbidden, errBanRank.Error())
+				return
+			}
+			err = issueBan(db, &model.Ban{UserID: authorId, IssuedBy: reqId, Reason: reason, Scope: BanSite})
+		}
+	}
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		RespondError(w, http.StatusNotFound, "the reported content no longer exists, dismiss the reports instead")
+		return
+	case errors.Is(err, errNotRemovable):
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		log.Println("ERROR RESOLVE REPORTS:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	closed, err := closeReports(db, targetType, targetId, status, reqId, note)
+	if err != nil {
+		log.Println("ERROR RESOLVE REPORTS:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	notified := map[string]bool{}
+	for _, report := range reports {
+		if notified[report.ReporterID] {
+			continue
+		}
+		notified[report.ReporterID] = true
+		notify(db, pub, notice{
+			UserID:     report.ReporterID,
+			Type:       NotifyReport,
+			ActorID:    reqId,
+			TargetType: targetType,
+			TargetID:   targetId,
+			Subject:    fmt.Sprintf(reportOutcomes[status], reportTargetName(targetType)),
+		})
+	}
+
+	auditor.Log(reqId, "Resolve Reports", "Success", fmt.Sprintf("%s %s %s", targetType, targetId, status))
+	RespondJSON(w, http.StatusOK, map[string]interface{}{"status": status, "closed": closed})
+}
+
+// fileReport records the caller's report on a target, or returns the one
+// they already have open on it.
+func fileReport(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request, targetType, targetId, authorId string) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	newReport := model.NewReport{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&newReport); err != nil {
+		RespondError(w, http.StatusBadRequest, "an unknown error has occurred")
+		return
+	}
+	defer r.Body.Close()
+
+	if !contains(reportCategories, newReport.Category) {
+		RespondError(w, http.StatusBadRequest, fmt.Sprintf("invalid category, expected one of %s", strings.Join(reportCategories, ", ")))
+		return
+	}
+	details := strings.TrimSpace(newReport.Details)
+	if len([]rune(details)) > maxReportDetailsLength {
+		RespondError(w, http.StatusBadRequest, fmt.Sprintf("details must be at most %d characters", maxReportDetailsLength))
+		return
+	}
+	if newReport.Category == "other" && details == "" {
+		RespondError(w, http.StatusBadRequest, "details are required for the other category")
+		return
+	}
+	if authorId == reqId {
+		RespondError(w, http.StatusBadRequest, "you can't report yourself")
+		return
+	}
+
+	existing := model.Report{}
+	err := db.Where(&model.Report{ReporterID: reqId, TargetType: targetType, TargetID: targetId, Status: ReportOpen}).First(&existing).Error
+	if err == nil {
+		RespondJSON(w, http.StatusOK, existing)
+		return
+	}
+
+	reportId, err := uuid.NewUUID()
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	report := model.Report{
+		ID:             reportId.String(),
+		ReporterID:     reqId,
+		TargetType:     targetType,
+		TargetID:       targetId,
+		TargetAuthorID: authorId,
+		Category:       newReport.Category,
+		Details:        details,
+		Status:         ReportOpen,
+		CreateDate:     time.Now().UTC(),
+	}
+	if err := db.Create(&report).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	auditor.Log(reqId, "File Report", "Success", targetType+" "+targetId)
+	RespondJSON(w, http.StatusCreated, report)
+}
+
+// canModerateReports allows moderators to handle reports, with reported
+// conversations further limited to those who may read private messages.
+func canModerateReports(db *gorm.DB, r *http.Request, targetType string) bool {
+	if !contains(reportTargets, targetType) {
+		return false
+	}
+	if targetType == "conversation" && !hasPermission(db, r, auth.PermMessageModerate) {
+		return false
+	}
+	return hasPermission(db, r, auth.PermReportResolve)
+}
+
+func openReports(db *gorm.DB, targetType, targetId string) ([]model.Report, error) {
+	reports := []model.Report{}
+	if err := db.Where(&model.Report{TargetType: targetType, TargetID: targetId, Status: ReportOpen}).Order("create_date").Find(&reports).Error; err != nil {
+		return nil, err
+	}
+	if len(reports) == 0 {
+		return nil, errNoOpenReports
+	}
+	return reports, nil
+}
+
+func closeReports(db *gorm.DB, targetType, targetId, status, resolvedBy, note string) (int64, error) {
+	result := db.Model(&model.Report{}).Where(&model.Report{TargetType: targetType, TargetID: targetId, Status: ReportOpen}).Updates(map[string]interface{}{
+		"status":          status,
+		"resolved_by":     resolvedBy,
+		"resolution_note": note,
+		"resolve_date":    time.Now().UTC(),
+	})
+	return result.RowsAffected, result.Error
+}
+
+// removeReported moves a reported post or comment to the trash and tells
+// its author.
+func removeReported(db *gorm.DB, pub Publisher, targetType, targetId, deletedBy, reason string) error {
+	switch targetType {
+	case "post":
+		post, err := getPostById(db, targetId)
+		if err != nil {
+			return err
+		}
+		if err := deletePost(db, post, deletedBy, reason); err != nil {
+			return err
+		}
+		deleted := map[string]string{"id": post.ID, "board_id": post.BoardID}
+		pub.Publish(PostChannel(post.ID), EventPostDeleted, deleted)
+		pub.Publish(BoardChannel(post.BoardID), EventPostDeleted, deleted)
+		notifyModeration(db, pub, deletedBy, post.AuthorID, "post", post.ID, fmt.Sprintf("A moderator removed your post %q after it was reported", post.Title))
+	case "comment":
+		comment, err := getCommentById(db, targetId)
+		if err != nil {
+			return err
+		}
+		if comment.Deleted {
+			return gorm.ErrRecordNotFound
+		}
+		if err := removeComment(db, comment, deletedBy, reason); err != nil {
+			return err
+		}
+		db.Model(&model.Post{}).Where(&model.Post{ID: comment.PostID}).Update("comment_count", gorm.Expr("comment_count - 1"))
+		pub.Publish(PostChannel(comment.PostID), EventCommentDeleted, map[string]string{"id": comment.ID, "post_id": comment.PostID})
+		notifyModeration(db, pub, deletedBy, comment.AuthorID, "post", comment.PostID, "A moderator removed your comment after it was reported")
+	default:
+		return errNotRemovable
+	}
+	return nil
+}
+
+func reportTargetName(targetType string) string {
+	if targetType == "user" {
+		return "profile"
+	}
+	return targetType
+}
@@ -0,0 +1,237 @@
+package handler
+
+import (
+	"forum-server/app/auth"
+	"forum-server/app/model"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// testRole creates a role with only the given permissions.
+func testRole(t *testing.T, db *gorm.DB, name string, permissions ...string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	CreateRole(db, testAuditor(db), rec, testRequest(t, "POST", "/api/roles", auth.NewRole{Name: name, Permissions: permissions}, testUser(t, db, "admin")))
+	decodeResponse(t, rec, http.StatusCreated, nil)
+}
+
+func reportPost(t *testing.T, db *gorm.DB, post *model.Post, reporter *model.User, report model.NewReport, status int) *model.Report {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	ReportPost(db, testAuditor(db), rec, testRequest(t, "POST", "/api/posts/"+post.ID+"/report", report, reporter, "postId", post.ID))
+	filed := model.Report{}
+	decodeResponse(t, rec, status, &filed)
+	return &filed
+}
+
+func resolveReports(t *testing.T, db *gorm.DB, moderator *model.User, targetType, targetId string, resolution model.ReportResolution, status int) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	ResolveReports(db, testAuditor(db), &testPublisher{}, rec, testRequest(t, "POST", "/api/reports/"+targetType+"/"+targetId+"/resolve", resolution, moderator, "targetType", targetType, "targetId", targetId))
+	decodeResponse(t, rec, status, nil)
+}
+
+func TestFileReport(t *testing.T) {
+	db := testDB(t)
+	author := testUser(t, db, "user")
+	reporter := testUser(t, db, "user")
+	post := testPost(t, db, testBoard(t, db, "board"), author, "post")
+
+	reportPost(t, db, post, reporter, model.NewReport{Category: "rude"}, http.StatusBadRequest)
+	reportPost(t, db, post, reporter, model.NewReport{Category: "other", Details: "  "}, http.StatusBadRequest)
+	reportPost(t, db, post, reporter, model.NewReport{Category: "spam", Details: strings.Repeat("x", maxReportDetailsLength+1)}, http.StatusBadRequest)
+	reportPost(t, db, post, author, model.NewReport{Category: "spam"}, http.StatusBadRequest)
+	reportPost(t, db, &model.Post{ID: "missing"}, reporter, model.NewReport{Category: "spam"}, http.StatusNotFound)
+
+	filed := reportPost(t, db, post, reporter, model.NewReport{Category: "spam", Details: " ads "}, http.StatusCreated)
+	if filed.TargetAuthorID != author.ID || filed.Details != "ads" || filed.Status != ReportOpen {
+		t.Errorf("filed report = %+v", filed)
+	}
+	if again := reportPost(t, db, post, reporter, model.NewReport{Category: "hate"}, http.StatusOK); again.ID != filed.ID {
+		t.Errorf("reporting twice filed %s, want the open report %s back", again.ID, filed.ID)
+	}
+
+	rec := httptest.NewRecorder()
+	ReportUser(db, testAuditor(db), rec, testRequest(t, "POST", "/api/user/"+reporter.ID+"/report", model.NewReport{Category: "spam"}, reporter, "userId", reporter.ID))
+	decodeResponse(t, rec, http.StatusBadRequest, nil)
+
+	conversation := startConversation(t, db, author, reporter, http.StatusCreated)
+	for _, tt := range []struct {
+		user   *model.User
+		status int
+	}{{author, http.StatusCreated}, {testUser(t, db, "user"), http.StatusNotFound}} {
+		rec := httptest.NewRecorder()
+		ReportConversation(db, testAuditor(db), rec, testRequest(t, "POST", "/api/conversations/"+conversation.ID+"/report", model.NewReport{Category: "harassment"}, tt.user, "conversationId", conversation.ID))
+		decodeResponse(t, rec, tt.status, nil)
+	}
+
+	rec = httptest.NewRecorder()
+	GetMyReports(db, rec, testRequest(t, "GET", "/api/reports/mine", nil, reporter))
+	mine := []model.Report{}
+	decodePage(t, rec, &mine)
+	if len(mine) != 1 || mine[0].ID != filed.ID {
+		t.Errorf("reporter's reports = %+v, want only %s", mine, filed.ID)
+	}
+}
+
+func TestReportAccess(t *testing.T) {
+	db := testDB(t)
+	testRole(t, db, "reviewer", auth.PermReportResolve)
+	author := testUser(t, db, "user")
+	reporter := testUser(t, db, "user")
+	moderator := testUser(t, db, "moderator")
+	reviewer := testUser(t, db, "reviewer")
+	post := testPost(t, db, testBoard(t, db, "board"), author, "post")
+	reportPost(t, db, post, reporter, model.NewReport{Category: "spam"}, http.StatusCreated)
+	conversation := startConversation(t, db, author, reporter, http.StatusCreated)
+	rec := httptest.NewRecorder()
+	ReportConversation(db, testAuditor(db), rec, testRequest(t, "POST", "/api/conversations/"+conversation.ID+"/report", model.NewReport{Category: "harassment"}, reporter, "conversationId", conversation.ID))
+	decodeResponse(t, rec, http.StatusCreated, nil)
+
+	targetReports := func(user *model.User, targetType, targetId string, status int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		GetTargetReports(db, rec, testRequest(t, "GET", "/api/reports/"+targetType+"/"+targetId, nil, user, "targetType", targetType, "targetId", targetId))
+		decodeResponse(t, rec, status, nil)
+	}
+	targetReports(reporter, "post", post.ID, http.StatusUnauthorized)
+	targetReports(reviewer, "post", post.ID, http.StatusOK)
+	targetReports(reviewer, "conversation", conversation.ID, http.StatusUnauthorized)
+	targetReports(moderator, "conversation", conversation.ID, http.StatusOK)
+	targetReports(moderator, "widget", "x", http.StatusUnauthorized)
+
+	queue := func(user *model.User, query string, status int) []model.ReportQueueItem {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		GetReportQueue(db, rec, testRequest(t, "GET", "/api/reports"+query, nil, user))
+		if status != http.StatusOK {
+			decodeResponse(t, rec, status, nil)
+			return nil
+		}
+		items := []model.ReportQueueItem{}
+		decodePage(t, rec, &items)
+		return items
+	}
+	if items := queue(reviewer, "", http.StatusOK); len(items) != 1 || items[0].TargetType != "post" || items[0].ReportCount != 1 {
+		t.Errorf("reviewer queue = %+v, want only the post", items)
+	}
+	if items := queue(moderator, "", http.StatusOK); len(items) != 2 {
+		t.Errorf("moderator queue = %+v, want the post and the conversation", items)
+	}
+	queue(reviewer, "?type=conversation", http.StatusUnauthorized)
+	queue(moderator, "?type=widget", http.StatusBadRequest)
+
+	resolveReports(t, db, reporter, "post", post.ID, model.ReportResolution{Action: "dismiss"}, http.StatusUnauthorized)
+	resolveReports(t, db, reviewer, "conversation", conversation.ID, model.ReportResolution{Action: "dismiss"}, http.StatusUnauthorized)
+	resolveReports(t, db, reviewer, "post", post.ID, model.ReportResolution{Action: "ban"}, http.StatusUnauthorized)
+}
+
+func TestResolveReports(t *testing.T) {
+	db := testDB(t)
+	author := testUser(t, db, "user")
+	reporter := testUser(t, db, "user")
+	second := testUser(t, db, "user")
+	moderator := testUser(t, db, "moderator")
+	post := testPost(t, db, testBoard(t, db, "board"), author, "post")
+	reportPost(t, db, post, reporter, model.NewReport{Category: "spam"}, http.StatusCreated)
+	reportPost(t, db, post, second, model.NewReport{Category: "spam"}, http.StatusCreated)
+
+	resolveReports(t, db, moderator, "post", post.ID, model.ReportResolution{Action: "ignore"}, http.StatusBadRequest)
+	resolveReports(t, db, moderator, "post", post.ID, model.ReportResolution{Action: "delete", Note: "spam"}, http.StatusOK)
+	resolveReports(t, db, moderator, "post", post.ID, model.ReportResolution{Action: "dismiss"}, http.StatusNotFound)
+
+	if live(db, &model.Post{}, post.ID) {
+		t.Error("deleting through the reports left the post up")
+	}
+	reports := []model.Report{}
+	db.Where("target_id = ?", post.ID).Find(&reports)
+	for _, report := range reports {
+		if report.Status != ReportRemoved || report.ResolvedBy != moderator.ID || report.ResolutionNote != "spam" {
+			t.Errorf("report after resolving = %+v", report)
+		}
+	}
+	for _, user := range []*model.User{reporter, second} {
+		if notifications := notificationsOf(t, db, user); len(notifications) != 1 || notifications[0].Type != NotifyReport {
+			t.Errorf("reporter notifications = %+v, want the outcome", notifications)
+		}
+	}
+
+	// warning or banning in a conversation needs the participant to act on
+	conversation := startConversation(t, db, author, reporter, http.StatusCreated)
+	rec := httptest.NewRecorder()
+	ReportConversation(db, testAuditor(db), rec, testRequest(t, "POST", "/api/conversations/"+conversation.ID+"/report", model.NewReport{Category: "harassment"}, reporter, "conversationId", conversation.ID))
+	decodeResponse(t, rec, http.StatusCreated, nil)
+	resolveReports(t, db, moderator, "conversation", conversation.ID, model.ReportResolution{Action: "warn"}, http.StatusBadRequest)
+	resolveReports(t, db, moderator, "conversation", conversation.ID, model.ReportResolution{Action: "warn", UserID: second.ID}, http.StatusBadRequest)
+	resolveReports(t, db, moderator, "conversation", conversation.ID, model.ReportResolution{Action: "delete"}, http.StatusBadRequest)
+	resolveReports(t, db, moderator, "conversation", conversation.ID, model.ReportResolution{Action: "ban", UserID: author.ID}, http.StatusOK)
+	if ban := postingBan(db, author.ID, ""); ban == nil {
+		t.Error("banning through the reports didn't ban the author")
+	}
+
+	// moderators can't ban other moderators through a report either
+	other := testUser(t, db, "moderator")
+	reportPost(t, db, testPost(t, db, testBoard(t, db, "other"), other, "by staff"), reporter, model.NewReport{Category: "spam"}, http.StatusCreated)
+	staffPost := model.Post{}
+	db.Where("author_id = ?", other.ID).First(&staffPost)
+	resolveReports(t, db, moderator, "post", staffPost.ID, model.ReportResolution{Action: "ban"}, http.StatusForbidden)
+}
+
+func TestReportQueueSorts(t *testing.T) {
+	db := testDB(t)
+	author := testUser(t, db, "user")
+	moderator := testUser(t, db, "moderator")
+	board := testBoard(t, db, "board")
+	posts := []*model.Post{}
+	for i := 0; i < 3; i++ {
+		post := testPost(t, db, board, author, "post")
+		posts = append(posts, post)
+		for j := 0; j <= i; j++ {
+			reportPost(t, db, post, testUser(t, db, "user"), model.NewReport{Category: "spam"}, http.StatusCreated)
+		}
+		// space the reports out so the date sorts are stable
+		db.Model(&model.Report{}).Where("target_id = ?", post.ID).Update("create_date", time.Now().Add(time.Duration(i)*time.Hour))
+	}
+
+	for _, tt := range []struct {
+		sort string
+		want []string
+	}{
+		{"most_reported", []string{posts[2].ID, posts[1].ID, posts[0].ID}},
+		{"oldest", []string{posts[0].ID, posts[1].ID, posts[2].ID}},
+		{"newest", []string{posts[2].ID, posts[1].ID, posts[0].ID}},
+	} {
+		t.Run(tt.sort, func(t *testing.T) {
+			got := []string{}
+			query := url.Values{"sort": {tt.sort}, "limit": {"1"}}
+			for pages := 0; pages < len(tt.want)+1; pages++ {
+				rec := httptest.NewRecorder()
+				GetReportQueue(db, rec, testRequest(t, "GET", "/api/reports?"+query.Encode(), nil, moderator))
+				page := struct {
+					Items      []model.ReportQueueItem `json:"items"`
+					NextCursor string                  `json:"next_cursor"`
+				}{}
+				decodeResponse(t, rec, http.StatusOK, &page)
+				for _, item := range page.Items {
+					if item.FirstReportDate.IsZero() || item.LastReportDate.Before(item.FirstReportDate) {
+						t.Errorf("item dates = %v, %v", item.FirstReportDate, item.LastReportDate)
+					}
+					got = append(got, item.ID)
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				query.Set("cursor", page.NextCursor)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("queue = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
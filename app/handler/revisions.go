@@ -0,0 +1,372 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"forum-server/app/auth"
+	"forum-server/app/diff"
+	"forum-server/app/markup"
+	"forum-server/app/model"
+	"forum-server/app/search"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/form3tech-oss/jwt-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	maxReasonLength = 200
+	currentRevision = "current"
+)
+
+var errInvalidDiffMode = errors.New("invalid mode, expected one of line, word")
+
+var revisionSorts = map[string]sortKey{
+	"newest": {column: "number", desc: true, kind: sortInt},
+	"oldest": {column: "number", desc: false, kind: sortInt},
+}
+
+func GetPostRevisions(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	postId := vars["postId"]
+
+	post, err := getPostById(db, postId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "post not found")
+		return
+	}
+	if !canViewRevisions(db, r, post.AuthorID) {
+		RespondError(w, http.StatusForbidden, "you can't view this post's revisions")
+		return
+	}
+
+	p, err := parsePagination(r, revisionSorts, "newest")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	revisions, err := getRevisions(db, "post", postId, p)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "revisions not found")
+		return
+	}
+	RespondJSON(w, http.StatusOK, revisions)
+}
+
+func GetCommentRevisions(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	commentId := vars["commentId"]
+
+	comment, err := getCommentById(db, commentId)
+	if err != nil || comment.Deleted {
+		RespondError(w, http.StatusNotFound, "comment not found")
+		return
+	}
+	if !canViewRevisions(db, r, comment.AuthorID) {
+		RespondError(w, http.StatusForbidden, "you can't view this comment's revisions")
+		return
+	}
+
+	p, err := parsePagination(r, revisionSorts, "newest")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	revisions, err := getRevisions(db, "comment", commentId, p)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "revisions not found")
+		return
+	}
+	RespondJSON(w, http.StatusOK, revisions)
+}
+
+// GetPostRevisionDiff compares the revisions named by the from and to query
+// parameters, either of which may be "current". to defaults to the current
+// version, and mode is "line" (the default) or "word".
+func GetPostRevisionDiff(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	postId := vars["postId"]
+
+	post, err := getPostById(db, postId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "post not found")
+		return
+	}
+	if !canViewRevisions(db, r, post.AuthorID) {
+		RespondError(w, http.StatusForbidden, "you can't view this post's revisions")
+		return
+	}
+
+	current := model.Revision{Title: post.Title, Content: post.Content}
+	result, err := diffRevisions(db, r, "post", post.ID, &current)
+	if err != nil {
+		respondRevisionError(w, err)
+		return
+	}
+	RespondJSON(w, http.StatusOK, result)
+}
+
+func GetCommentRevisionDiff(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	commentId := vars["commentId"]
+
+	comment, err := getCommentById(db, commentId)
+	if err != nil || comment.Deleted {
+		RespondError(w, http.StatusNotFound, "comment not found")
+		return
+	}
+	if !canViewRevisions(db, r, comment.AuthorID) {
+		RespondError(w, http.StatusForbidden, "you can't view this comment's revisions")
+		return
+	}
+
+	current := model.Revision{Content: comment.Content}
+	result, err := diffRevisions(db, r, "comment", comment.ID, &current)
+	if err != nil {
+		respondRevisionError(w, err)
+		return
+	}
+	RespondJSON(w, http.StatusOK, result)
+}
+
+// canViewRevisions reports whether the caller may see earlier versions of
+// content by authorId. Edits can take out things that shouldn't stay
+// public, so only the author and moderators who can restore revisions see
+// them.
+func canViewRevisions(db *gorm.DB, r *http.Request, authorId string) bool {
+	reqId := fmt.Sprintf("%v", r.Context().Value("user").(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+	return reqId == authorId || hasPermission(db, r, auth.PermRevisionRestore)
+}
+
+// RestorePostRevision puts a post back to what it said in an earlier
+// revision. The version it replaces is kept as a revision of its own, so a
+// restore can be undone the same way.
+func RestorePostRevision(db *gorm.DB, pub Publisher, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"]
+
+	vars := mux.Vars(r)
+	postId := vars["postId"]
+
+	post, err := getPostById(db, postId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "post not found")
+		return
+	}
+
+	revision, err := getRevision(db, "post", post.ID, vars["number"])
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "revision not found")
+		return
+	}
+
+	reason := fmt.Sprintf("Restored revision %d", revision.Number)
+	if err := editPost(db, post, fmt.Sprintf("%v", reqId), reason, revision.Title, revision.Content); err != nil {
+		log.Println("ERROR RESTORE REVISION:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	if err := search.Index(db, search.TypePost, post.ID); err != nil {
+		log.Println("ERROR INDEX:", err)
+	}
+	pub.Publish(PostChannel(post.ID), EventPostUpdated, post)
+	pub.Publish(BoardChannel(post.BoardID), EventPostUpdated, post)
+	notifyModeration(db, pub, fmt.Sprintf("%v", reqId), post.AuthorID, "post", post.ID, fmt.Sprintf("A moderator restored an earlier version of your post %q", post.Title))
+	RespondJSON(w, http.StatusOK, post)
+}
+
+func RestoreCommentRevision(db *gorm.DB, pub Publisher, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"]
+
+	vars := mux.Vars(r)
+	commentId := vars["commentId"]
+
+	comment, err := getCommentById(db, commentId)
+	if err != nil || comment.Deleted {
+		RespondError(w, http.StatusNotFound, "comment not found")
+		return
+	}
+
+	revision, err := getRevision(db, "comment", comment.ID, vars["number"])
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "revision not found")
+		return
+	}
+
+	reason := fmt.Sprintf("Restored revision %d", revision.Number)
+	if err := editComment(db, comment, fmt.Sprintf("%v", reqId), reason, revision.Content); err != nil {
+		log.Println("ERROR RESTORE REVISION:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	if err := search.Index(db, search.TypeComment, comment.ID); err != nil {
+		log.Println("ERROR INDEX:", err)
+	}
+	pub.Publish(PostChannel(comment.PostID), EventCommentUpdated, comment)
+	notifyModeration(db, pub, fmt.Sprintf("%v", reqId), comment.AuthorID, "post", comment.PostID, "A moderator restored an earlier version of your comment")
+	RespondJSON(w, http.StatusOK, comment)
+}
+
+// editPost saves a new title and content for post, first recording what it
+// said before as the next revision. Edits that change neither are saved
+// without a revision.
+func editPost(db *gorm.DB, post *model.Post, editorId, reason, title, content string) error {
+	if title == post.Title && content == post.Content {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := saveRevision(tx, "post", post.ID, post.EditCount+1, editorId, reason, post.Title, post.Content); err != nil {
+			return err
+		}
+
+		post.Title = title
+		post.Content = content
+		post.ContentHTML = markup.Render(content)
+		post.Edited = true
+		post.EditCount++
+		post.EditDate = time.Now().UTC().Format(time.RFC3339)
+		return tx.Save(post).Error
+	})
+}
+
+func editComment(db *gorm.DB, comment *model.Comment, editorId, reason, content string) error {
+	if content == comment.Content {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := saveRevision(tx, "comment", comment.ID, comment.EditCount+1, editorId, reason, "", comment.Content); err != nil {
+			return err
+		}
+
+		comment.Content = content
+		comment.ContentHTML = markup.Render(content)
+		comment.Edited = true
+		comment.EditCount++
+		comment.EditDate = time.Now().UTC().Format(time.RFC3339)
+		return tx.Save(comment).Error
+	})
+}
+
+func saveRevision(db *gorm.DB, targetType, targetId string, number int, editorId, reason, title, content string) error {
+	revisionId, err := uuid.NewUUID()
+	if err != nil {
+		return err
+	}
+
+	revision := model.Revision{
+		ID:         revisionId.String(),
+		TargetType: targetType,
+		TargetID:   targetId,
+		Number:     number,
+		EditorID:   editorId,
+		Reason:     reason,
+		Title:      title,
+		Content:    content,
+		CreateDate: time.Now().UTC().Format(time.RFC3339),
+	}
+	return db.Create(&revision).Error
+}
+
+func validReason(reason string) error {
+	if len([]rune(reason)) > maxReasonLength {
+		return fmt.Errorf("reason must be at most %d characters", maxReasonLength)
+	}
+	return nil
+}
+
+func getRevisions(db *gorm.DB, targetType, targetId string, p *pagination) (*Page, error) {
+	revisions := []model.Revision{}
+	return p.find(db.Model(&model.Revision{}).Where(&model.Revision{TargetType: targetType, TargetID: targetId}), &revisions)
+}
+
+func getRevision(db *gorm.DB, targetType, targetId, number string) (*model.Revision, error) {
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	revision := model.Revision{}
+	if err := db.Where(&model.Revision{TargetType: targetType, TargetID: targetId, Number: n}).First(&revision).Error; err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+func deleteRevisions(db *gorm.DB, targetType, targetId string) error {
+	return db.Where("target_type = ? AND target_id = ?", targetType, targetId).Delete(&model.Revision{}).Error
+}
+
+// diffRevisions reads the from, to and mode query parameters and compares
+// the two versions they name. current is the live version of the target.
+func diffRevisions(db *gorm.DB, r *http.Request, targetType, targetId string, current *model.Revision) (*model.RevisionDiff, error) {
+	query := r.URL.Query()
+
+	result := model.RevisionDiff{
+		From: query.Get("from"),
+		To:   query.Get("to"),
+		Mode: query.Get("mode"),
+	}
+	if result.To == "" {
+		result.To = currentRevision
+	}
+	if result.Mode == "" {
+		result.Mode = "line"
+	}
+
+	compare := diff.Lines
+	switch result.Mode {
+	case "line":
+	case "word":
+		compare = diff.Words
+	default:
+		return nil, errInvalidDiffMode
+	}
+
+	from, err := revisionVersion(db, targetType, targetId, result.From, current)
+	if err != nil {
+		return nil, err
+	}
+	to, err := revisionVersion(db, targetType, targetId, result.To, current)
+	if err != nil {
+		return nil, err
+	}
+
+	if targetType == "post" {
+		result.Title = diff.Words(from.Title, to.Title)
+	}
+	result.Content = compare(from.Content, to.Content)
+	return &result, nil
+}
+
+func revisionVersion(db *gorm.DB, targetType, targetId, version string, current *model.Revision) (*model.Revision, error) {
+	if version == currentRevision {
+		return current, nil
+	}
+	return getRevision(db, targetType, targetId, version)
+}
+
+func respondRevisionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errInvalidDiffMode):
+		RespondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		RespondError(w, http.StatusNotFound, "revision not found")
+	default:
+		log.Println("ERROR REVISION DIFF:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+	}
+}
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"encoding/json"
+	"forum-server/app/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func editPostAs(t *testing.T, db *gorm.DB, post *model.Post, user *model.User, edit model.PostEdit, status int) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	UpdatePost(db, &testPublisher{}, rec, testRequest(t, "PUT", "/api/posts/"+post.ID, edit, user, "postId", post.ID))
+	decodeResponse(t, rec, status, nil)
+}
+
+func postRevisions(t *testing.T, db *gorm.DB, post *model.Post, user *model.User, status int) []model.Revision {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	GetPostRevisions(db, rec, testRequest(t, "GET", "/api/posts/"+post.ID+"/revisions", nil, user, "postId", post.ID))
+	revisions := []model.Revision{}
+	if status != http.StatusOK {
+		decodeResponse(t, rec, status, nil)
+		return revisions
+	}
+	decodePage(t, rec, &revisions)
+	return revisions
+}
+
+func TestEditsKeepRevisions(t *testing.T) {
+	db := testDB(t)
+	author := testUser(t, db, "user")
+	post := testPost(t, db, testBoard(t, db, "board"), author, "first title")
+
+	editPostAs(t, db, post, author, model.PostEdit{Title: "first title", Content: post.Content}, http.StatusOK)
+	editPostAs(t, db, post, author, model.PostEdit{Title: "second title", Content: "second", Reason: "typo"}, http.StatusOK)
+	editPostAs(t, db, post, author, model.PostEdit{Title: "third title", Content: "third"}, http.StatusOK)
+	editPostAs(t, db, post, author, model.PostEdit{Title: "x", Content: "x", Reason: strings.Repeat("r", maxReasonLength+1)}, http.StatusBadRequest)
+
+	stored := model.Post{}
+	db.Where("id = ?", post.ID).First(&stored)
+	if !stored.Edited || stored.EditCount != 2 || stored.EditDate == "" || stored.Title != "third title" {
+		t.Errorf("edited post = %+v, want two edits", stored)
+	}
+
+	revisions := postRevisions(t, db, post, author, http.StatusOK)
+	if len(revisions) != 2 {
+		t.Fatalf("%d revisions, want one per edit that changed something", len(revisions))
+	}
+	if first := revisions[1]; first.Number != 1 || first.Title != "first title" || first.Content != post.Content || first.Reason != "typo" || first.EditorID != author.ID {
+		t.Errorf("revision 1 = %+v, want the post as first written", first)
+	}
+	if second := revisions[0]; second.Number != 2 || second.Title != "second title" || second.Content != "second" {
+		t.Errorf("revision 2 = %+v", second)
+	}
+}
+
+func TestRevisionAccess(t *testing.T) {
+	db := testDB(t)
+	author := testUser(t, db, "user")
+	other := testUser(t, db, "user")
+	moderator := testUser(t, db, "moderator")
+	post := testPost(t, db, testBoard(t, db, "board"), author, "title")
+	comment := testComment(t, db, post, author, nil, "first")
+	if err := editComment(db, comment, author.ID, "", "second"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		user   *model.User
+		status int
+	}{
+		{"author", author, http.StatusOK},
+		{"someone else", other, http.StatusForbidden},
+		{"moderator", moderator, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			postRevisions(t, db, post, tt.user, tt.status)
+
+			rec := httptest.NewRecorder()
+			GetPostRevisionDiff(db, rec, testRequest(t, "GET", "/api/posts/"+post.ID+"/revisions/diff?from=current", nil, tt.user, "postId", post.ID))
+			decodeResponse(t, rec, tt.status, nil)
+
+			rec = httptest.NewRecorder()
+			GetCommentRevisions(db, rec, testRequest(t, "GET", "/api/posts/comments/"+comment.ID+"/revisions", nil, tt.user, "commentId", comment.ID))
+			decodeResponse(t, rec, tt.status, nil)
+
+			rec = httptest.NewRecorder()
+			GetCommentRevisionDiff(db, rec, testRequest(t, "GET", "/api/posts/comments/"+comment.ID+"/revisions/diff?from=1", nil, tt.user, "commentId", comment.ID))
+			decodeResponse(t, rec, tt.status, nil)
+		})
+	}
+}
+
+func TestRevisionDiff(t *testing.T) {
+	db := testDB(t)
+	author := testUser(t, db, "user")
+	post := testPost(t, db, testBoard(t, db, "board"), author, "title")
+	if err := editPost(db, post, author.ID, "", "title", "the quick fox"); err != nil {
+		t.Fatal(err)
+	}
+	if err := editPost(db, post, author.ID, "", "new title", "the slow fox"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, query string
+		status      int
+	}{
+		{"words against current", "from=2&mode=word", http.StatusOK},
+		{"between revisions", "from=1&to=2", http.StatusOK},
+		{"unknown mode", "from=1&mode=char", http.StatusBadRequest},
+		{"missing revision", "from=9", http.StatusNotFound},
+		{"not a revision", "from=first", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			GetPostRevisionDiff(db, rec, testRequest(t, "GET", "/api/posts/"+post.ID+"/revisions/diff?"+tt.query, nil, author, "postId", post.ID))
+			decodeResponse(t, rec, tt.status, nil)
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	GetPostRevisionDiff(db, rec, testRequest(t, "GET", "/api/posts/"+post.ID+"/revisions/diff?from=2&mode=word", nil, author, "postId", post.ID))
+	result := model.RevisionDiff{}
+	decodeResponse(t, rec, http.StatusOK, &result)
+	changes := []string{}
+	for _, op := range result.Content {
+		if op.Type != "equal" {
+			changes = append(changes, op.Type+":"+op.Text)
+		}
+	}
+	if got := strings.Join(changes, " "); got != "delete:quick insert:slow" {
+		t.Errorf("word diff changes = %q", got)
+	}
+	if len(result.Title) == 0 || result.From != "2" || result.To != currentRevision {
+		t.Errorf("diff = %+v", result)
+	}
+}
+
+func TestRestoreRevision(t *testing.T) {
+	db := testDB(t)
+	author := testUser(t, db, "user")
+	moderator := testUser(t, db, "moderator")
+	post := testPost(t, db, testBoard(t, db, "board"), author, "original")
+	original := post.Content
+	if err := editPost(db, post, author.ID, "", "vandalised", "gone"); err != nil {
+		t.Fatal(err)
+	}
+
+	restore := func(number string, status int) *model.Post {
+		t.Helper()
+		pub := &testPublisher{}
+		rec := httptest.NewRecorder()
+		RestorePostRevision(db, pub, rec, testRequest(t, "POST", "/api/posts/"+post.ID+"/revisions/"+number+"/restore", nil, moderator, "postId", post.ID, "number", number))
+		restored := model.Post{}
+		decodeResponse(t, rec, status, &restored)
+		return &restored
+	}
+
+	restore("5", http.StatusNotFound)
+	restored := restore("1", http.StatusOK)
+	if restored.Title != "original" || restored.Content != original || restored.EditCount != 2 {
+		t.Errorf("restored post = %+v", restored)
+	}
+
+	// the restore is an edit of its own and can be undone the same way
+	revisions := postRevisions(t, db, post, moderator, http.StatusOK)
+	if len(revisions) != 2 || revisions[0].Title != "vandalised" || revisions[0].Reason != "Restored revision 1" || revisions[0].EditorID != moderator.ID {
+		encoded, _ := json.Marshal(revisions)
+		t.Errorf("revisions after restore = %s", encoded)
+	}
+}
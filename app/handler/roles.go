@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"forum-server/app/auth"
+	"forum-server/app/model"
+	"forum-server/audit"
+	"net/http"
+	"strings"
+
+	"github.com/form3tech-oss/jwt-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+func GetRoles(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	roles := []auth.Role{}
+	if err := db.Preload("Permissions").Order("name").Find(&roles).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, roles)
+}
+
+func GetPermissions(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	perms := []auth.Permission{}
+	if err := db.Order("name").Find(&perms).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, perms)
+}
+
+func CreateRole(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"]
+
+	newRole := auth.NewRole{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&newRole); err != nil {
+		RespondError(w, http.StatusBadRequest, "")
+		return
+	}
+	defer r.Body.Close()
+
+	name := strings.TrimSpace(strings.ToLower(newRole.Name))
+	if name == "" {
+		RespondError(w, http.StatusBadRequest, "role name required")
+		return
+	}
+	if _, err := getRoleByName(db, name); err == nil {
+		RespondError(w, http.StatusConflict, "role already exists")
+		return
+	}
+
+	perms, err := getPermissionsByName(db, newRole.Permissions)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	id, err := uuid.NewUUID()
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	role := auth.Role{
+		ID:          id.String(),
+		Name:        name,
+		Permissions: perms,
+	}
+
+	if err := db.Create(&role).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	auditor.Log(fmt.Sprintf("%v", reqId), "Create Role", "Success", role.Name)
+	RespondJSON(w, http.StatusCreated, role)
+}
+
+func UpdateRole(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"]
+
+	vars := mux.Vars(r)
+	roleId := vars["roleId"]
+
+	role := auth.Role{}
+	if err := db.Where(&auth.Role{ID: roleId}).First(&role).Error; err != nil {
+		RespondError(w, http.StatusNotFound, "role not found")
+		return
+	}
+
+	update := auth.NewRole{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&update); err != nil {
+		RespondError(w, http.StatusBadRequest, "")
+		return
+	}
+	defer r.Body.Close()
+
+	perms, err := getPermissionsByName(db, update.Permissions)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := db.Model(&role).Association("Permissions").Replace(perms); err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	role.Permissions = perms
+
+	auditor.Log(fmt.Sprintf("%v", reqId), "Update Role", "Success", role.Name)
+	RespondJSON(w, http.StatusOK, role)
+}
+
+func AssignRole(db *gorm.DB, auditor *audit.Auditor, pub Publisher, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"]
+
+	vars := mux.Vars(r)
+	userId := vars["userId"]
+
+	assignment := model.RoleAssignment{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&assignment); err != nil {
+		RespondError(w, http.StatusBadRequest, "")
+		return
+	}
+	defer r.Body.Close()
+
+	role, err := getRoleByName(db, assignment.Role)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, "role not found")
+		return
+	}
+
+	user, err := getUserById(db, userId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	if err := db.Model(user).Update("role", role.Name).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	auditor.Log(fmt.Sprintf("%v", reqId), "Assign Role", "Success", user.ID+" "+role.Name)
+	notify(db, pub, notice{
+		UserID:     user.ID,
+		Type:       NotifyRole,
+		ActorID:    fmt.Sprintf("%v", reqId),
+		TargetType: "user",
+		TargetID:   user.ID,
+		Subject:    role.Name,
+	})
+
+	updated, err := publicUser(db, user.ID)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	RespondJSON(w, http.StatusOK, updated)
+}
+
+// RequirePermission only calls next when the role in the request's token
+// grants permission.
+func RequirePermission(db *gorm.DB, permission string, w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if r.Method == http.MethodOptions {
+		next(w, r)
+		return
+	}
+	if !hasPermission(db, r, permission) {
+		RespondError(w, http.StatusUnauthorized, "no access")
+		return
+	}
+	next(w, r)
+}
+
+func hasPermission(db *gorm.DB, r *http.Request, permission string) bool {
+	token, ok := r.Context().Value("user").(*jwt.Token)
+	if !ok {
+		return false
+	}
+	roleName, _ := token.Claims.(jwt.MapClaims)["role"].(string)
+
+	role, err := getRoleByName(db, roleName)
+	if err != nil {
+		return false
+	}
+	return role.HasPermission(permission)
+}
+
+func getRoleByName(db *gorm.DB, name string) (*auth.Role, error) {
+	if name == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	role := auth.Role{}
+	if err := db.Preload("Permissions").Where(&auth.Role{Name: name}).First(&role).Error; err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func getPermissionsByName(db *gorm.DB, names []string) ([]auth.Permission, error) {
+	perms := []auth.Permission{}
+	if len(names) == 0 {
+		return perms, nil
+	}
+	if err := db.Where("name IN ?", names).Find(&perms).Error; err != nil {
+		return nil, err
+	}
+	if len(perms) != len(names) {
+		return nil, fmt.Errorf("unknown permission")
+	}
+	return perms, nil
+}
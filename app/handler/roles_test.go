@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"forum-server/app/auth"
+	"forum-server/app/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequirePermission(t *testing.T) {
+	db := testDB(t)
+	admin := testUser(t, db, "admin")
+	moderator := testUser(t, db, "moderator")
+	user := testUser(t, db, "user")
+
+	tests := []struct {
+		caller     *model.User
+		permission string
+		status     int
+	}{
+		{admin, auth.PermRoleManage, http.StatusOK},
+		{moderator, auth.PermRoleManage, http.StatusUnauthorized},
+		{moderator, auth.PermUserBan, http.StatusOK},
+		{user, auth.PermUserBan, http.StatusUnauthorized},
+		{nil, auth.PermUserBan, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		RequirePermission(db, tt.permission, rec, testRequest(t, "GET", "/api/roles", nil, tt.caller), func(w http.ResponseWriter, r *http.Request) {
+			RespondJSON(w, http.StatusOK, nil)
+		})
+		if rec.Code != tt.status {
+			role := "anonymous"
+			if tt.caller != nil {
+				role = tt.caller.Role
+			}
+			t.Errorf("%s needing %s: status = %d, want %d", role, tt.permission, rec.Code, tt.status)
+		}
+	}
+}
+
+func TestCreateAndUpdateRole(t *testing.T) {
+	db := testDB(t)
+	auditor := testAuditor(db)
+	admin := testUser(t, db, "admin")
+
+	rec := httptest.NewRecorder()
+	CreateRole(db, auditor, rec, testRequest(t, "POST", "/api/roles", auth.NewRole{Name: " Curator ", Permissions: []string{auth.PermTagManage}}, admin))
+	role := auth.Role{}
+	decodeResponse(t, rec, http.StatusCreated, &role)
+	if role.Name != "curator" || !role.HasPermission(auth.PermTagManage) {
+		t.Fatalf("created %+v, want curator with %s", role, auth.PermTagManage)
+	}
+
+	for _, body := range []auth.NewRole{
+		{Name: "curator"},
+		{Name: " "},
+		{Name: "other", Permissions: []string{"no.such.permission"}},
+	} {
+		rec = httptest.NewRecorder()
+		CreateRole(db, auditor, rec, testRequest(t, "POST", "/api/roles", body, admin))
+		if rec.Code == http.StatusCreated {
+			t.Errorf("created role from %+v", body)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	UpdateRole(db, auditor, rec, testRequest(t, "PUT", "/api/roles/"+role.ID, auth.NewRole{Permissions: []string{auth.PermBoardCreate}}, admin, "roleId", role.ID))
+	decodeResponse(t, rec, http.StatusOK, nil)
+	stored, err := getRoleByName(db, "curator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.HasPermission(auth.PermTagManage) || !stored.HasPermission(auth.PermBoardCreate) {
+		t.Errorf("permissions after update = %+v, want only %s", stored.Permissions, auth.PermBoardCreate)
+	}
+
+	rec = httptest.NewRecorder()
+	UpdateRole(db, auditor, rec, testRequest(t, "PUT", "/api/roles/missing", auth.NewRole{}, admin, "roleId", "missing"))
+	decodeResponse(t, rec, http.StatusNotFound, nil)
+}
+
+func TestAssignRole(t *testing.T) {
+	db := testDB(t)
+	auditor := testAuditor(db)
+	admin := testUser(t, db, "admin")
+	user := testUser(t, db, "user")
+
+	rec := httptest.NewRecorder()
+	AssignRole(db, auditor, &testPublisher{}, rec, testRequest(t, "PUT", "/api/user/"+user.ID+"/role", model.RoleAssignment{Role: "no-such-role"}, admin, "userId", user.ID))
+	decodeResponse(t, rec, http.StatusBadRequest, nil)
+
+	rec = httptest.NewRecorder()
+	AssignRole(db, auditor, &testPublisher{}, rec, testRequest(t, "PUT", "/api/user/"+user.ID+"/role", model.RoleAssignment{Role: "moderator"}, admin, "userId", user.ID))
+	updated := model.PublicUser{}
+	decodeResponse(t, rec, http.StatusOK, &updated)
+	if updated.Role != "moderator" {
+		t.Errorf("role = %q, want moderator", updated.Role)
+	}
+
+	// the old token no longer matches the user's role
+	if _, err := CheckSession(db, user.ID, "user", ""); err == nil || err.Status != http.StatusUnauthorized {
+		t.Errorf("CheckSession with the old role = %v, want token outdated", err)
+	}
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"forum-server/app/search"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func Search(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	q := search.Query{Text: strings.TrimSpace(query.Get("q"))}
+	if q.Text == "" {
+		RespondError(w, http.StatusBadRequest, "missing search query")
+		return
+	}
+
+	if types := query.Get("type"); types != "" {
+		for _, t := range strings.Split(types, ",") {
+			if !isSearchType(t) {
+				RespondError(w, http.StatusBadRequest, "invalid type, expected one of "+strings.Join(search.Types, ", "))
+				return
+			}
+			q.Types = append(q.Types, t)
+		}
+	}
+
+	q.BoardID = query.Get("board")
+
+	if username := query.Get("author"); username != "" {
+		user, err := getUserByUsername(db, username)
+		if err != nil {
+			RespondError(w, http.StatusBadRequest, "user not found")
+			return
+		}
+		q.AuthorID = user.ID
+	}
+
+	var err error
+	if q.From, err = parseSearchDate(query.Get("from"), false); err != nil {
+		RespondError(w, http.StatusBadRequest, "invalid from date")
+		return
+	}
+	if q.To, err = parseSearchDate(query.Get("to"), true); err != nil {
+		RespondError(w, http.StatusBadRequest, "invalid to date")
+		return
+	}
+
+	q.Limit, q.Offset, err = parseOffsetPagination(r)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results, total, err := search.Search(db, q)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	RespondJSON(w, http.StatusOK, offsetPage(results, len(results), total, q.Limit, q.Offset))
+}
+
+func isSearchType(t string) bool {
+	for _, known := range search.Types {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSearchDate accepts RFC 3339 timestamps or plain dates. A plain date
+// used as an upper bound covers the whole day.
+func parseSearchDate(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, nil
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"fmt"
+	"forum-server/app/model"
+	"forum-server/app/search"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestSearchQueryParsing(t *testing.T) {
+	db := testDB(t)
+	author := testUser(t, db, "user")
+
+	tests := []struct {
+		name, query string
+		status      int
+	}{
+		{"missing text", "q=+", http.StatusBadRequest},
+		{"unknown type", "q=x&type=post,widget", http.StatusBadRequest},
+		{"unknown author", "q=x&author=nobody", http.StatusBadRequest},
+		{"bad from", "q=x&from=yesterday", http.StatusBadRequest},
+		{"bad to", "q=x&to=2024-13-01", http.StatusBadRequest},
+		{"bad limit", "q=x&limit=0", http.StatusBadRequest},
+		{"bad cursor", "q=x&cursor=%21", http.StatusBadRequest},
+		{"everything", "q=x&type=post,comment&board=b1&author=" + author.Username + "&from=2024-01-01&to=2024-01-02T10:00:00Z&limit=5", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			Search(db, rec, testRequest(t, "GET", "/api/search?"+tt.query, nil, nil))
+			decodeResponse(t, rec, tt.status, nil)
+		})
+	}
+}
+
+func TestSearchDates(t *testing.T) {
+	from, err := parseSearchDate("2024-03-01", false)
+	if err != nil || from.Format("2006-01-02 15:04:05") != "2024-03-01 00:00:00" {
+		t.Errorf("from = %v, %v", from, err)
+	}
+	to, err := parseSearchDate("2024-03-01", true)
+	if err != nil || to.Format("2006-01-02 15:04:05") != "2024-03-01 23:59:59" {
+		t.Errorf("to = %v, %v", to, err)
+	}
+	if exact, err := parseSearchDate("2024-03-01T12:30:00Z", true); err != nil || exact.Hour() != 12 {
+		t.Errorf("timestamp = %v, %v", exact, err)
+	}
+}
+
+func TestSearchAuthorAndCursorPaging(t *testing.T) {
+	db := testDB(t)
+	author := testUser(t, db, "user")
+	other := testUser(t, db, "user")
+	for i := 0; i < 5; i++ {
+		for _, user := range []*model.User{author, other} {
+			post := model.Post{ID: fmt.Sprintf("%s-%d", user.ID, i), AuthorID: user.ID, BoardID: "b1", Title: "cursor", CreateDate: fmt.Sprintf("2024-01-0%dT00:00:00Z", i+1)}
+			if err := db.Create(&post).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	query := url.Values{"q": {"cursor"}, "author": {author.Username}, "limit": {"2"}}
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("next_cursor never ran out")
+		}
+		rec := httptest.NewRecorder()
+		Search(db, rec, testRequest(t, "GET", "/api/search?"+query.Encode(), nil, nil))
+		page := struct {
+			Items      []search.Result `json:"items"`
+			NextCursor string          `json:"next_cursor"`
+			PrevCursor string          `json:"prev_cursor"`
+			Total      int64           `json:"total"`
+		}{}
+		decodeResponse(t, rec, http.StatusOK, &page)
+
+		if page.Total != 5 {
+			t.Errorf("total = %d, want 5", page.Total)
+		}
+		if (pages == 0) != (page.PrevCursor == "") {
+			t.Errorf("page %d prev_cursor = %q", pages, page.PrevCursor)
+		}
+		for _, result := range page.Items {
+			if result.AuthorID != author.ID {
+				t.Errorf("result %s is by %s, want only %s", result.ID, result.AuthorID, author.ID)
+			}
+			if seen[result.ID] {
+				t.Errorf("result %s on more than one page", result.ID)
+			}
+			seen[result.ID] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", page.NextCursor)
+	}
+	if len(seen) != 5 {
+		t.Errorf("paged through %d results, want 5", len(seen))
+	}
+}
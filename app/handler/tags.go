@@ -0,0 +1,526 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forum-server/app/model"
+	"forum-server/audit"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/form3tech-oss/jwt-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxPostTags             = 5
+	maxFilterTags           = 10
+	maxTagLength            = 35
+	maxTagDescriptionLength = 500
+	defaultAutocompleteSize = 10
+	maxAutocompleteSize     = 25
+)
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9+#.-]*$`)
+
+// reservedTagNames would be shadowed by the routes under /api/tags.
+var reservedTagNames = []string{"autocomplete", "posts"}
+
+var (
+	errInvalidTag    = errors.New("invalid tag")
+	errRestrictedTag = errors.New("only staff can use the tag")
+)
+
+var tagSorts = map[string]sortKey{
+	"popular": {column: "post_count", desc: true, kind: sortInt},
+	"name":    {column: "name", desc: false, kind: sortString},
+	"newest":  {column: "create_date", desc: true, kind: sortTime},
+}
+
+// GetTags lists every tag with the number of posts using it, most used
+// first.
+func GetTags(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	p, err := parsePagination(r, tagSorts, "popular")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tags := []model.Tag{}
+	page, err := p.find(db.Model(&model.Tag{}), &tags)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, page)
+}
+
+// GetTag returns a tag along with its synonyms. Looking a tag up by one of
+// its synonyms returns the tag itself.
+func GetTag(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	tag, err := findTag(db, normalizeTag(vars["name"]))
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "tag not found")
+		return
+	}
+	if err := db.Where(&model.TagSynonym{TagID: tag.ID}).Order("name").Find(&tag.Synonyms).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, tag)
+}
+
+// AutocompleteTags suggests tags whose name, or the name of one of their
+// synonyms, starts with the q query parameter.
+func AutocompleteTags(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := defaultAutocompleteSize
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			RespondError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		if n > maxAutocompleteSize {
+			n = maxAutocompleteSize
+		}
+		limit = n
+	}
+
+	tags := []model.Tag{}
+	prefix := normalizeTag(query.Get("q"))
+	if !tagPattern.MatchString(prefix) {
+		RespondJSON(w, http.StatusOK, tags)
+		return
+	}
+
+	err := db.Where("name LIKE ? OR id IN (SELECT tag_id FROM tag_synonyms WHERE name LIKE ?)", prefix+"%", prefix+"%").
+		Order("post_count desc").Order("name").Limit(limit).Find(&tags).Error
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, tags)
+}
+
+// GetTaggedPosts lists posts carrying the comma separated tags query
+// parameter. match is "all" (the default) to require every tag or "any" to
+// require at least one.
+func GetTaggedPosts(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	match := query.Get("match")
+	if match == "" {
+		match = "all"
+	}
+	if match != "all" && match != "any" {
+		RespondError(w, http.StatusBadRequest, "invalid match, expected one of all, any")
+		return
+	}
+
+	names := []string{}
+	for _, name := range strings.Split(query.Get("tags"), ",") {
+		if name = normalizeTag(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		RespondError(w, http.StatusBadRequest, "tags is required")
+		return
+	}
+	if len(names) > maxFilterTags {
+		RespondError(w, http.StatusBadRequest, fmt.Sprintf("at most %d tags can be combined", maxFilterTags))
+		return
+	}
+
+	p, err := parsePagination(r, postSorts, "newest")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tagIds := []string{}
+	for _, name := range names {
+		tag, err := findTag(db, name)
+		if err != nil {
+			if match == "all" {
+				RespondJSON(w, http.StatusOK, &Page{Items: []model.Post{}})
+				return
+			}
+			continue
+		}
+		if !contains(tagIds, tag.ID) {
+			tagIds = append(tagIds, tag.ID)
+		}
+	}
+
+	posts, err := getTaggedPosts(db, tagIds, match == "all", p)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, posts)
+}
+
+// UpdateTag changes a tag's description and whether it is restricted.
+func UpdateTag(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+
+	tag, err := findTag(db, normalizeTag(vars["name"]))
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "tag not found")
+		return
+	}
+
+	update := model.TagUpdate{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&update); err != nil {
+		RespondError(w, http.StatusBadRequest, "")
+		return
+	}
+	defer r.Body.Close()
+
+	if len([]rune(update.Description)) > maxTagDescriptionLength {
+		RespondError(w, http.StatusBadRequest, fmt.Sprintf("description must be at most %d characters", maxTagDescriptionLength))
+		return
+	}
+
+	tag.Description = update.Description
+	tag.Restricted = update.Restricted
+	if err := db.Model(&model.Tag{}).Where("id = ?", tag.ID).Updates(map[string]interface{}{
+		"description": tag.Description,
+		"restricted":  tag.Restricted,
+	}).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	auditor.Log(reqId, "Update Tag", "Success", tag.Name)
+	RespondJSON(w, http.StatusOK, tag)
+}
+
+// AddTagSynonym makes name another name for a tag. If a tag called name
+// already exists it is merged away: its posts and synonyms move over and
+// the tag is deleted.
+func AddTagSynonym(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+
+	tag, err := findTag(db, normalizeTag(vars["name"]))
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "tag not found")
+		return
+	}
+
+	newSynonym := model.NewTagSynonym{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&newSynonym); err != nil {
+		RespondError(w, http.StatusBadRequest, "")
+		return
+	}
+	defer r.Body.Close()
+
+	name := normalizeTag(newSynonym.Name)
+	if err := validTagName(name); err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	existing, err := findTag(db, name)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		existing = nil
+	case err != nil:
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	case existing.ID == tag.ID:
+		RespondError(w, http.StatusConflict, "the name already belongs to this tag")
+		return
+	case existing.Name != name:
+		RespondError(w, http.StatusConflict, fmt.Sprintf("the name is already a synonym of %q", existing.Name))
+		return
+	}
+
+	synonymId, err := uuid.NewUUID()
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	synonym := model.TagSynonym{
+		ID:         synonymId.String(),
+		Name:       name,
+		TagID:      tag.ID,
+		CreateDate: time.Now().UTC(),
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if existing != nil {
+			if err := mergeTag(tx, existing, tag); err != nil {
+				return err
+			}
+		}
+		return tx.Create(&synonym).Error
+	})
+	if err != nil {
+		log.Println("ERROR TAG SYNONYM:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	message := name + " for " + tag.Name
+	if existing != nil {
+		message += ", merged"
+	}
+	auditor.Log(reqId, "Add Tag Synonym", "Success", message)
+	RespondJSON(w, http.StatusOK, synonym)
+}
+
+func RemoveTagSynonym(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+
+	tag, err := findTag(db, normalizeTag(vars["name"]))
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "tag not found")
+		return
+	}
+
+	name := normalizeTag(vars["synonym"])
+	result := db.Where(&model.TagSynonym{Name: name, TagID: tag.ID}).Delete(&model.TagSynonym{})
+	if result.Error != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	if result.RowsAffected == 0 {
+		RespondError(w, http.StatusNotFound, "synonym not found")
+		return
+	}
+	auditor.Log(reqId, "Remove Tag Synonym", "Success", name+" for "+tag.Name)
+	RespondJSON(w, http.StatusNoContent, nil)
+}
+
+// normalizeTag lowercases a tag name and joins its words with dashes.
+func normalizeTag(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), "-")
+}
+
+func validTagName(name string) error {
+	if len(name) > maxTagLength || !tagPattern.MatchString(name) {
+		return fmt.Errorf("%w %q, tags are up to %d letters, digits and + # . -", errInvalidTag, name, maxTagLength)
+	}
+	if contains(reservedTagNames, name) {
+		return fmt.Errorf("%w %q, the name is reserved", errInvalidTag, name)
+	}
+	return nil
+}
+
+// findTag looks a tag up by its name or by one of its synonyms.
+func findTag(db *gorm.DB, name string) (*model.Tag, error) {
+	if name == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	tag := model.Tag{}
+	if err := db.Where("name = ? OR id IN (SELECT tag_id FROM tag_synonyms WHERE name = ?)", name, name).First(&tag).Error; err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+func createTag(db *gorm.DB, name string) (*model.Tag, error) {
+	tagId, err := uuid.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	tag := model.Tag{
+		ID:         tagId.String(),
+		Name:       name,
+		CreateDate: time.Now().UTC(),
+	}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&tag).Error; err != nil {
+		return nil, err
+	}
+	// another post may have created it first
+	return findTag(db, name)
+}
+
+// resolveTags turns the tag names given for a post into tags, following
+// synonyms and creating tags that don't exist yet. Restricted tags are
+// refused unless allowRestricted is set or they are among the ids in
+// current, the tags the post already has.
+func resolveTags(db *gorm.DB, names []string, allowRestricted bool, current []string) ([]model.Tag, error) {
+	if len(names) > maxPostTags {
+		return nil, fmt.Errorf("%w, a post can have at most %d tags", errInvalidTag, maxPostTags)
+	}
+
+	tags := []model.Tag{}
+	ids := []string{}
+	for _, name := range names {
+		name = normalizeTag(name)
+		if err := validTagName(name); err != nil {
+			return nil, err
+		}
+
+		tag, err := findTag(db, name)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			tag, err = createTag(db, name)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if tag.Restricted && !allowRestricted && !contains(current, tag.ID) {
+			return nil, fmt.Errorf("%w %q", errRestrictedTag, tag.Name)
+		}
+		if !contains(ids, tag.ID) {
+			ids = append(ids, tag.ID)
+			tags = append(tags, *tag)
+		}
+	}
+	return tags, nil
+}
+
+// setPostTags replaces the tags on post and refreshes the counts of every
+// tag it gained or lost.
+func setPostTags(db *gorm.DB, post *model.Post, tags []model.Tag) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		ids, err := postTagIds(tx, post.ID)
+		if err != nil {
+			return err
+		}
+
+		association := tx.Model(post).Association("Tags")
+		if len(tags) == 0 {
+			if err := association.Clear(); err != nil {
+				return err
+			}
+			post.Tags = []model.Tag{}
+		} else if err := association.Replace(tags); err != nil {
+			return err
+		}
+
+		for _, tag := range tags {
+			ids = append(ids, tag.ID)
+		}
+		return recountTags(tx, "id IN ?", ids)
+	})
+}
+
+func postTagIds(db *gorm.DB, postId string) ([]string, error) {
+	ids := []string{}
+	err := db.Table("post_tags").Where("post_id = ?", postId).Pluck("tag_id", &ids).Error
+	return ids, err
+}
+
+// recountTags refreshes post_count on the tags matched by query, counting
+// only posts that aren't in the trash.
+func recountTags(db *gorm.DB, query string, args ...interface{}) error {
+	count := gorm.Expr("(SELECT COUNT(*) FROM post_tags JOIN posts ON posts.id = post_tags.post_id WHERE post_tags.tag_id = tags.id AND posts.deleted_at IS NULL)")
+	return db.Model(&model.Tag{}).Where(query, args...).Update("post_count", count).Error
+}
+
+// mergeTag moves the posts and synonyms of from onto into and deletes
+// from.
+func mergeTag(db *gorm.DB, from, into *model.Tag) error {
+	if err := db.Exec("INSERT INTO post_tags (post_id, tag_id) SELECT post_id, ? FROM post_tags WHERE tag_id = ? ON CONFLICT DO NOTHING", into.ID, from.ID).Error; err != nil {
+		return err
+	}
+	if err := db.Exec("DELETE FROM post_tags WHERE tag_id = ?", from.ID).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&model.TagSynonym{}).Where("tag_id = ?", from.ID).Update("tag_id", into.ID).Error; err != nil {
+		return err
+	}
+	if err := db.Delete(from).Error; err != nil {
+		return err
+	}
+	return recountTags(db, "id = ?", into.ID)
+}
+
+// loadTags fills in the tags of posts that were loaded without them.
+func loadTags(db *gorm.DB, posts []model.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(posts))
+	for i := range posts {
+		ids[i] = posts[i].ID
+	}
+
+	tagged := []model.Post{}
+	err := db.Select("id").Preload("Tags", func(db *gorm.DB) *gorm.DB {
+		return db.Order("name")
+	}).Where("id IN ?", ids).Find(&tagged).Error
+	if err != nil {
+		return err
+	}
+
+	tags := map[string][]model.Tag{}
+	for _, post := range tagged {
+		tags[post.ID] = post.Tags
+	}
+	for i := range posts {
+		posts[i].Tags = tags[posts[i].ID]
+		if posts[i].Tags == nil {
+			posts[i].Tags = []model.Tag{}
+		}
+	}
+	return nil
+}
+
+func loadPostTags(db *gorm.DB, post *model.Post) error {
+	return db.Model(post).Order("name").Association("Tags").Find(&post.Tags)
+}
+
+func getTaggedPosts(db *gorm.DB, tagIds []string, all bool, p *pagination) (*Page, error) {
+	q := db.Model(&model.Post{})
+	if all {
+		q = q.Where("id IN (SELECT post_id FROM post_tags WHERE tag_id IN ? GROUP BY post_id HAVING COUNT(*) = ?)", tagIds, len(tagIds))
+	} else {
+		q = q.Where("id IN (SELECT post_id FROM post_tags WHERE tag_id IN ?)", tagIds)
+	}
+
+	posts := []model.Post{}
+	page, err := p.find(q, &posts)
+	if err != nil {
+		return nil, err
+	}
+	return page, loadTags(db, posts)
+}
+
+// respondTagError answers a request whose tags could not be resolved.
+func respondTagError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errInvalidTag):
+		RespondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, errRestrictedTag):
+		RespondError(w, http.StatusForbidden, err.Error())
+	default:
+		log.Println("ERROR TAGS:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+	}
+}
@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"forum-server/app/model"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// tagPost posts to board with tags as user and returns the new post's id.
+func tagPost(t *testing.T, db *gorm.DB, board *model.Board, user *model.User, status int, tags ...string) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	AddPost(db, &testPublisher{}, rec, testRequest(t, "POST", "/api/boards/"+board.ID+"/newPost", model.NewPost{Title: "title", Content: "content", Tags: tags}, user, "boardId", board.ID))
+	created := map[string]string{}
+	decodeResponse(t, rec, status, &created)
+	return created["id"]
+}
+
+func getTag(t *testing.T, db *gorm.DB, name string, status int) *model.Tag {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	GetTag(db, rec, testRequest(t, "GET", "/api/tags/"+name, nil, nil, "name", name))
+	tag := model.Tag{}
+	decodeResponse(t, rec, status, &tag)
+	return &tag
+}
+
+func tagNames(t *testing.T, db *gorm.DB, postId string) []string {
+	t.Helper()
+	names := []string{}
+	db.Model(&model.Tag{}).Where("id IN (SELECT tag_id FROM post_tags WHERE post_id = ?)", postId).Order("name").Pluck("name", &names)
+	return names
+}
+
+func TestPostTags(t *testing.T) {
+	db := testDB(t)
+	user := testUser(t, db, "user")
+	board := testBoard(t, db, "board")
+
+	postId := tagPost(t, db, board, user, http.StatusOK, "Go Lang", "go-lang", "web")
+	if got := tagNames(t, db, postId); !reflect.DeepEqual(got, []string{"go-lang", "web"}) {
+		t.Errorf("tags = %v, want them normalized without duplicates", got)
+	}
+	tagPost(t, db, board, user, http.StatusOK, "web")
+	if tag := getTag(t, db, "web", http.StatusOK); tag.PostCount != 2 {
+		t.Errorf("web post_count = %d, want 2", tag.PostCount)
+	}
+
+	tagPost(t, db, board, user, http.StatusBadRequest, "no spaces!")
+	tagPost(t, db, board, user, http.StatusBadRequest, "posts")
+	tagPost(t, db, board, user, http.StatusBadRequest, "a", "b", "c", "d", "e", "f")
+
+	post := model.Post{}
+	db.Where("id = ?", postId).First(&post)
+	if err := deletePost(db, &post, user.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if tag := getTag(t, db, "web", http.StatusOK); tag.PostCount != 1 {
+		t.Errorf("web post_count after a delete = %d, want 1", tag.PostCount)
+	}
+}
+
+func TestRestrictedTags(t *testing.T) {
+	db := testDB(t)
+	admin := testUser(t, db, "admin")
+	user := testUser(t, db, "user")
+	board := testBoard(t, db, "board")
+	tagPost(t, db, board, user, http.StatusOK, "staff")
+
+	rec := httptest.NewRecorder()
+	UpdateTag(db, testAuditor(db), rec, testRequest(t, "PUT", "/api/tags/staff", model.TagUpdate{Description: "From the staff", Restricted: true}, admin, "name", "staff"))
+	decodeResponse(t, rec, http.StatusOK, nil)
+	if tag := getTag(t, db, "staff", http.StatusOK); !tag.Restricted || tag.Description != "From the staff" {
+		t.Fatalf("updated tag = %+v", tag)
+	}
+
+	tagPost(t, db, board, user, http.StatusForbidden, "staff")
+	postId := tagPost(t, db, board, admin, http.StatusOK, "staff")
+
+	// an edit can keep a restricted tag the post already has
+	post := model.Post{}
+	db.Where("id = ?", postId).First(&post)
+	db.Model(&post).Update("author_id", user.ID)
+	editPostAs(t, db, &post, user, model.PostEdit{Title: "title", Content: "content", Tags: []string{"staff", "news"}}, http.StatusOK)
+	if got := tagNames(t, db, postId); !reflect.DeepEqual(got, []string{"news", "staff"}) {
+		t.Errorf("tags after the edit = %v", got)
+	}
+}
+
+func TestTagSynonyms(t *testing.T) {
+	db := testDB(t)
+	admin := testUser(t, db, "admin")
+	board := testBoard(t, db, "board")
+	tagPost(t, db, board, admin, http.StatusOK, "go")
+	merged := tagPost(t, db, board, admin, http.StatusOK, "golang")
+
+	addSynonym := func(tag, synonym string, status int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		AddTagSynonym(db, testAuditor(db), rec, testRequest(t, "POST", "/api/tags/"+tag+"/synonyms", model.NewTagSynonym{Name: synonym}, admin, "name", tag))
+		decodeResponse(t, rec, status, nil)
+	}
+	removeSynonym := func(tag, synonym string, status int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		RemoveTagSynonym(db, testAuditor(db), rec, testRequest(t, "DELETE", "/api/tags/"+tag+"/synonyms/"+synonym, nil, admin, "name", tag, "synonym", synonym))
+		decodeResponse(t, rec, status, nil)
+	}
+
+	addSynonym("missing", "x", http.StatusNotFound)
+	addSynonym("go", "go", http.StatusConflict)
+	addSynonym("go", "not valid!", http.StatusBadRequest)
+	addSynonym("go", "golang", http.StatusOK)
+
+	getTag(t, db, "golang", http.StatusOK)
+	if tag := getTag(t, db, "go", http.StatusOK); tag.PostCount != 2 {
+		t.Errorf("go post_count = %d, want the merged tag's post too", tag.PostCount)
+	}
+	if got := tagNames(t, db, merged); !reflect.DeepEqual(got, []string{"go"}) {
+		t.Errorf("merged post tags = %v, want [go]", got)
+	}
+	if got := tagNames(t, db, tagPost(t, db, board, admin, http.StatusOK, "GoLang")); !reflect.DeepEqual(got, []string{"go"}) {
+		t.Errorf("post tagged with a synonym got %v, want [go]", got)
+	}
+
+	tagPost(t, db, board, admin, http.StatusOK, "rust")
+	addSynonym("rust", "golang", http.StatusConflict)
+
+	removeSynonym("go", "golang", http.StatusNoContent)
+	removeSynonym("go", "golang", http.StatusNotFound)
+	getTag(t, db, "golang", http.StatusNotFound)
+}
+
+func TestTaggedPosts(t *testing.T) {
+	db := testDB(t)
+	user := testUser(t, db, "user")
+	board := testBoard(t, db, "board")
+	both := tagPost(t, db, board, user, http.StatusOK, "a", "b")
+	onlyA := tagPost(t, db, board, user, http.StatusOK, "a")
+
+	tagged := func(query string, status int) []string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		GetTaggedPosts(db, rec, testRequest(t, "GET", "/api/tags/posts?"+query, nil, nil))
+		if status != http.StatusOK {
+			decodeResponse(t, rec, status, nil)
+			return nil
+		}
+		posts := []model.Post{}
+		decodePage(t, rec, &posts)
+		ids := postIDs(posts)
+		sort.Strings(ids)
+		return ids
+	}
+
+	want := []string{both, onlyA}
+	sort.Strings(want)
+	if got := tagged(url.Values{"tags": {"a"}}.Encode(), http.StatusOK); !reflect.DeepEqual(got, want) {
+		t.Errorf("tagged a = %v, want %v", got, want)
+	}
+	if got := tagged(url.Values{"tags": {"a,b"}}.Encode(), http.StatusOK); !reflect.DeepEqual(got, []string{both}) {
+		t.Errorf("tagged a and b = %v, want %v", got, []string{both})
+	}
+	if got := tagged(url.Values{"tags": {"b,missing"}, "match": {"any"}}.Encode(), http.StatusOK); !reflect.DeepEqual(got, []string{both}) {
+		t.Errorf("tagged b or missing = %v, want %v", got, []string{both})
+	}
+	if got := tagged(url.Values{"tags": {"a,missing"}}.Encode(), http.StatusOK); len(got) != 0 {
+		t.Errorf("tagged a and missing = %v, want none", got)
+	}
+	tagged(url.Values{"tags": {" , "}}.Encode(), http.StatusBadRequest)
+	tagged(url.Values{"tags": {"a"}, "match": {"some"}}.Encode(), http.StatusBadRequest)
+}
@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"forum-server/app/config"
+	"forum-server/app/markup"
+	"forum-server/app/model"
+	"forum-server/app/search"
+	"forum-server/app/storage"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultMaxCommentDepth = 8
+	deletedCommentContent  = "[deleted]"
+)
+
+func GetCommentTree(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	postId := vars["postId"]
+
+	if _, err := getPostById(db, postId); err != nil {
+		RespondError(w, http.StatusNotFound, "post not found")
+		return
+	}
+
+	comments := []model.Comment{}
+	if err := db.Unscoped().Where(&model.Comment{PostID: postId}).Order("create_date asc").Order("id asc").Find(&comments).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	tree := buildCommentTree(visibleComments(comments))
+
+	switch r.URL.Query().Get("format") {
+	case "", "tree":
+		RespondJSON(w, http.StatusOK, tree)
+	case "flat":
+		RespondJSON(w, http.StatusOK, flattenCommentTree(tree, []model.Comment{}))
+	default:
+		RespondError(w, http.StatusBadRequest, "invalid format, expected tree or flat")
+	}
+}
+
+// maxCommentDepth is the deepest a reply may be nested, read from
+// COMMENT_MAX_DEPTH. Top level comments have depth 0.
+func maxCommentDepth() int {
+	if depth := config.Int("COMMENT_MAX_DEPTH", defaultMaxCommentDepth); depth >= 0 {
+		return depth
+	}
+	return defaultMaxCommentDepth
+}
+
+// buildCommentTree nests comments under their parents. comments must be in
+// the order siblings should appear. Comments whose parent is missing are
+// treated as top level.
+func buildCommentTree(comments []model.Comment) []*model.CommentNode {
+	nodes := map[string]*model.CommentNode{}
+	for _, c := range comments {
+		nodes[c.ID] = &model.CommentNode{Comment: c, Children: []*model.CommentNode{}}
+	}
+
+	roots := []*model.CommentNode{}
+	for _, c := range comments {
+		node := nodes[c.ID]
+		if parent, ok := nodes[c.ParentID]; ok && c.ParentID != "" {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
+
+// flattenCommentTree lists the tree depth first, so every comment follows
+// its parent and its depth says how far to indent it.
+func flattenCommentTree(nodes []*model.CommentNode, flat []model.Comment) []model.Comment {
+	for _, node := range nodes {
+		flat = append(flat, node.Comment)
+		flat = flattenCommentTree(node.Children, flat)
+	}
+	return flat
+}
+
+// removeComment moves comment to the trash. Its replies stay where they are
+// and the tree shows a tombstone in its place.
+func removeComment(db *gorm.DB, comment *model.Comment, deletedBy, reason string) error {
+	return db.Model(&model.Comment{}).Where("id = ?", comment.ID).Updates(trashColumns(deletedBy, reason)).Error
+}
+
+// visibleComments drops deleted comments from a post's comments, loaded
+// with the trash included, and turns those that still have replies showing
+// into tombstones so the replies stay attached.
+func visibleComments(comments []model.Comment) []model.Comment {
+	ids := map[string]bool{}
+	children := map[string][]int{}
+	for i, c := range comments {
+		ids[c.ID] = true
+		children[c.ParentID] = append(children[c.ParentID], i)
+	}
+
+	shown := make([]bool, len(comments))
+	var walk func(i int) bool
+	walk = func(i int) bool {
+		c := comments[i]
+		shown[i] = !c.DeletedAt.Valid && !c.Deleted
+		for _, child := range children[c.ID] {
+			if walk(child) {
+				shown[i] = true
+			}
+		}
+		return shown[i]
+	}
+	for i, c := range comments {
+		if c.ParentID == "" || !ids[c.ParentID] {
+			walk(i)
+		}
+	}
+
+	visible := []model.Comment{}
+	for i, c := range comments {
+		if !shown[i] {
+			continue
+		}
+		if c.DeletedAt.Valid || c.Deleted {
+			c.AuthorID = ""
+			c.Content = deletedCommentContent
+			c.ContentHTML = markup.Render(deletedCommentContent)
+			c.Deleted = true
+			c.SoftDelete = model.SoftDelete{}
+		}
+		visible = append(visible, c)
+	}
+	return visible
+}
+
+// purgeComment removes comment for good along with its attachments and
+// revisions. A comment that still has replies, even ones in the trash, is
+// left as a tombstone so they stay attached. Tombstones left without replies
+// are removed along the way.
+func purgeComment(db *gorm.DB, store storage.BlobStore, comment *model.Comment) error {
+	var replies int64
+	if err := db.Unscoped().Model(&model.Comment{}).Where("parent_id = ?", comment.ID).Count(&replies).Error; err != nil {
+		return err
+	}
+
+	if _, err := deleteAttachments(db, store, "comment_id = ?", comment.ID); err != nil {
+		return err
+	}
+	if err := deleteRevisions(db, "comment", comment.ID); err != nil {
+		return err
+	}
+	if err := deleteVotes(db, "comment", []string{comment.ID}); err != nil {
+		return err
+	}
+
+	if replies > 0 {
+		if err := db.Unscoped().Model(&model.Comment{}).Where("id = ?", comment.ID).Updates(map[string]interface{}{
+			"author_id":     "",
+			"content":       deletedCommentContent,
+			"content_html":  markup.Render(deletedCommentContent),
+			"deleted":       true,
+			"deleted_at":    nil,
+			"deleted_by":    "",
+			"delete_reason": "",
+		}).Error; err != nil {
+			return err
+		}
+		return search.Index(db, search.TypeComment, comment.ID)
+	}
+
+	if err := db.Unscoped().Delete(comment).Error; err != nil {
+		return err
+	}
+
+	if comment.ParentID == "" {
+		return nil
+	}
+	parent, err := getCommentById(db, comment.ParentID)
+	if err != nil || !parent.Deleted {
+		return nil
+	}
+	return purgeComment(db, store, parent)
+}
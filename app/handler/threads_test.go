@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"forum-server/app/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAddCommentReplies(t *testing.T) {
+	t.Setenv("COMMENT_MAX_DEPTH", "1")
+	db := testDB(t)
+	user := testUser(t, db, "user")
+	board := testBoard(t, db, "threads")
+	post := testPost(t, db, board, user, "post")
+	other := testPost(t, db, board, user, "other")
+	root := testComment(t, db, post, user, nil, "root")
+
+	add := func(body model.NewComment, status int) model.Comment {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		AddComment(db, &testPublisher{}, rec, testRequest(t, "POST", "/api/post/addComment", body, user))
+		comment := model.Comment{}
+		if status == http.StatusOK {
+			decodeResponse(t, rec, status, &comment)
+		} else {
+			decodeResponse(t, rec, status, nil)
+		}
+		return comment
+	}
+
+	reply := add(model.NewComment{PostID: post.ID, ParentID: root.ID, Content: "reply"}, http.StatusOK)
+	if reply.ParentID != root.ID || reply.Depth != 1 {
+		t.Errorf("reply parent, depth = %q, %d, want %q, 1", reply.ParentID, reply.Depth, root.ID)
+	}
+	add(model.NewComment{PostID: post.ID, ParentID: reply.ID, Content: "too deep"}, http.StatusBadRequest)
+	add(model.NewComment{PostID: other.ID, ParentID: root.ID, Content: "wrong post"}, http.StatusBadRequest)
+	add(model.NewComment{PostID: post.ID, ParentID: "missing", Content: "no parent"}, http.StatusBadRequest)
+	add(model.NewComment{PostID: "missing", Content: "no post"}, http.StatusNotFound)
+}
+
+func TestCommentTreeKeepsRepliesToDeletedComments(t *testing.T) {
+	db := testDB(t)
+	user := testUser(t, db, "user")
+	board := testBoard(t, db, "tombstones")
+	post := testPost(t, db, board, user, "post")
+	removed := testComment(t, db, post, user, nil, "removed")
+	reply := testComment(t, db, post, user, removed, "reply")
+	gone := testComment(t, db, post, user, nil, "gone")
+	for _, c := range []*model.Comment{removed, gone} {
+		if err := removeComment(db, c, user.ID, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	GetCommentTree(db, rec, testRequest(t, "GET", "/api/posts/"+post.ID+"/comments/tree", nil, nil, "postId", post.ID))
+	tree := []*model.CommentNode{}
+	decodeResponse(t, rec, http.StatusOK, &tree)
+	if len(tree) != 1 {
+		t.Fatalf("got %d top level comments, want only the tombstone", len(tree))
+	}
+	tombstone := tree[0]
+	if tombstone.ID != removed.ID || tombstone.Content != deletedCommentContent || tombstone.AuthorID != "" {
+		t.Errorf("tombstone = %+v", tombstone.Comment)
+	}
+	if len(tombstone.Children) != 1 || tombstone.Children[0].ID != reply.ID || tombstone.Children[0].Content != "reply" {
+		t.Errorf("tombstone lost its reply: %+v", tombstone.Children)
+	}
+
+	rec = httptest.NewRecorder()
+	GetCommentTree(db, rec, testRequest(t, "GET", "/api/posts/"+post.ID+"/comments/tree?format=flat", nil, nil, "postId", post.ID))
+	flat := []model.Comment{}
+	decodeResponse(t, rec, http.StatusOK, &flat)
+	if len(flat) != 2 || flat[0].ID != removed.ID || flat[1].ID != reply.ID {
+		t.Errorf("flat = %+v, want the tombstone then its reply", flat)
+	}
+
+	rec = httptest.NewRecorder()
+	GetCommentTree(db, rec, testRequest(t, "GET", "/api/posts/"+post.ID+"/comments/tree?format=list", nil, nil, "postId", post.ID))
+	decodeResponse(t, rec, http.StatusBadRequest, nil)
+}
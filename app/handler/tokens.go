@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"forum-server/app/auth"
+	"forum-server/app/model"
+	"forum-server/audit"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/form3tech-oss/jwt-go"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func RefreshToken(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	req := model.RefreshRequest{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		RespondError(w, http.StatusBadRequest, "an unknown error has occurred")
+		return
+	}
+	defer r.Body.Close()
+
+	stored := model.RefreshToken{}
+	if err := db.Where(&model.RefreshToken{TokenHash: auth.HashToken(req.RefreshToken)}).First(&stored).Error; err != nil {
+		RespondError(w, http.StatusUnauthorized, "invalid refresh token")
+		return
+	}
+
+	if stored.Revoked {
+		// A rotated token being presented again means it was copied; kill the
+		// whole family so neither party can keep using it.
+		revokeTokenFamily(db, stored.FamilyID)
+		auditor.Log(stored.UserID, "Refresh Token Reuse", "Error", stored.FamilyID)
+		RespondError(w, http.StatusUnauthorized, "invalid refresh token")
+		return
+	}
+
+	if time.Now().UTC().After(stored.ExpiresAt) {
+		RespondError(w, http.StatusUnauthorized, "refresh token expired")
+		return
+	}
+
+	user, err := getUserById(db, stored.UserID)
+	if err != nil || !user.Active || siteBan(db, user.ID) != nil {
+		revokeTokenFamily(db, stored.FamilyID)
+		RespondError(w, http.StatusUnauthorized, "invalid refresh token")
+		return
+	}
+
+	result := db.Model(&model.RefreshToken{}).Where("id = ? AND revoked = ?", stored.ID, false).Update("revoked", true)
+	if result.Error != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	if result.RowsAffected == 0 {
+		revokeTokenFamily(db, stored.FamilyID)
+		auditor.Log(stored.UserID, "Refresh Token Reuse", "Error", stored.FamilyID)
+		RespondError(w, http.StatusUnauthorized, "invalid refresh token")
+		return
+	}
+
+	token, refreshToken, err := issueTokens(db, user, stored.FamilyID)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	RespondJSON(w, http.StatusOK, model.TokenResponse{Token: token, RefreshToken: refreshToken})
+}
+
+func Logout(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	claims := userCtx.(*jwt.Token).Claims.(jwt.MapClaims)
+	reqId := fmt.Sprintf("%v", claims["id"])
+
+	sid, _ := claims["sid"].(string)
+	if sid == "" {
+		RespondError(w, http.StatusBadRequest, "token has no session")
+		return
+	}
+
+	if err := revokeTokenFamily(db, sid); err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	auditor.Log(reqId, "Logout", "Success", sid)
+	RespondJSON(w, http.StatusNoContent, nil)
+}
+
+// ValidateSession runs after the jwt middleware and rejects tokens whose
+// owner no longer exists, is inactive or banned, or whose session has been
+// revoked. Users whose role needs two-factor authentication are held to
+// the two-factor routes until they set it up.
+func ValidateSession(db *gorm.DB, w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	token, ok := r.Context().Value("user").(*jwt.Token)
+	if !ok {
+		next(w, r)
+		return
+	}
+	claims := token.Claims.(jwt.MapClaims)
+
+	role, _ := claims["role"].(string)
+	sid, _ := claims["sid"].(string)
+	if _, err := CheckSession(db, fmt.Sprintf("%v", claims["id"]), role, sid); err != nil {
+		RespondError(w, err.Status, err.Message)
+		return
+	}
+	if err := CheckTwoFactor(db, fmt.Sprintf("%v", claims["id"]), role, r.URL.Path); err != nil {
+		RespondError(w, err.Status, err.Message)
+		return
+	}
+
+	next(w, r)
+}
+
+type SessionError struct {
+	Status  int
+	Message string
+}
+
+func (e *SessionError) Error() string {
+	return e.Message
+}
+
+// CheckSession returns the user a token was issued to, or the reason the
+// token may no longer be used.
+func CheckSession(db *gorm.DB, userId, role, sid string) (*model.User, *SessionError) {
+	user, err := getUserById(db, userId)
+	if err != nil {
+		return nil, &SessionError{http.StatusUnauthorized, "user not found"}
+	}
+	if !user.Active {
+		return nil, &SessionError{http.StatusForbidden, "account disabled"}
+	}
+	if ban := siteBan(db, user.ID); ban != nil {
+		return nil, &SessionError{http.StatusForbidden, banMessage(ban)}
+	}
+	// a role change invalidates outstanding access tokens so the new
+	// permissions apply right away; clients pick them up on refresh
+	if role != user.Role {
+		return nil, &SessionError{http.StatusUnauthorized, "token outdated"}
+	}
+
+	if sid != "" {
+		var count int64
+		if err := db.Model(&model.RefreshToken{}).Where("family_id = ? AND revoked = ?", sid, false).Count(&count).Error; err != nil {
+			return nil, &SessionError{http.StatusInternalServerError, "an unknown error has occurred"}
+		}
+		if count == 0 {
+			return nil, &SessionError{http.StatusUnauthorized, "session revoked"}
+		}
+	}
+
+	return user, nil
+}
+
+// optionalUserID returns who is calling a route that doesn't require signing
+// in, or "" for anonymous callers and tokens that are invalid or revoked.
+func optionalUserID(db *gorm.DB, r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	claims, err := auth.ParseToken(strings.TrimPrefix(header, "Bearer "))
+	if err != nil {
+		return ""
+	}
+	if _, sessionErr := CheckSession(db, claims.ID, claims.Role, claims.SessionID); sessionErr != nil {
+		return ""
+	}
+	return claims.ID
+}
+
+// issueTokens signs a new access token and stores a new refresh token in the
+// given family. An empty familyId starts a new family, i.e. a new session.
+func issueTokens(db *gorm.DB, user *model.User, familyId string) (string, string, error) {
+	if familyId == "" {
+		id, err := uuid.NewUUID()
+		if err != nil {
+			return "", "", err
+		}
+		familyId = id.String()
+	}
+
+	refreshToken, hash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now().UTC()
+	stored := model.RefreshToken{
+		ID:         id.String(),
+		UserID:     user.ID,
+		FamilyID:   familyId,
+		TokenHash:  hash,
+		Revoked:    false,
+		ExpiresAt:  now.Add(auth.RefreshTokenLifetime),
+		CreateDate: now,
+	}
+	if err := db.Save(&stored).Error; err != nil {
+		return "", "", err
+	}
+
+	token, err := auth.GenerateToken(user, familyId)
+	if err != nil {
+		return "", "", err
+	}
+	return token, refreshToken, nil
+}
+
+func revokeTokenFamily(db *gorm.DB, familyId string) error {
+	return db.Model(&model.RefreshToken{}).Where(&model.RefreshToken{FamilyID: familyId}).Update("revoked", true).Error
+}
+
+func revokeUserTokens(db *gorm.DB, userId string) error {
+	return db.Model(&model.RefreshToken{}).Where(&model.RefreshToken{UserID: userId}).Update("revoked", true).Error
+}
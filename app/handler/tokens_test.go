@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"context"
+	"forum-server/app/auth"
+	"forum-server/app/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/form3tech-oss/jwt-go"
+	"gorm.io/gorm"
+)
+
+// withSession adds the session id sid to the caller's claims on r.
+func withSession(r *http.Request, sid string) *http.Request {
+	claims := r.Context().Value("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	withSid := jwt.MapClaims{"sid": sid}
+	for k, v := range claims {
+		withSid[k] = v
+	}
+	return r.WithContext(context.WithValue(r.Context(), "user", &jwt.Token{Claims: withSid}))
+}
+
+// sessionOf returns the refresh token family that refreshToken belongs to.
+func sessionOf(t *testing.T, db *gorm.DB, refreshToken string) string {
+	t.Helper()
+	stored := model.RefreshToken{}
+	if err := db.Where("token_hash = ?", auth.HashToken(refreshToken)).First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	return stored.FamilyID
+}
+
+func refresh(t *testing.T, db *gorm.DB, refreshToken string, status int) model.TokenResponse {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	RefreshToken(db, testAuditor(db), rec, testRequest(t, "POST", "/api/token/refresh", model.RefreshRequest{RefreshToken: refreshToken}, nil))
+	tokens := model.TokenResponse{}
+	if status == http.StatusOK {
+		decodeResponse(t, rec, status, &tokens)
+	} else {
+		decodeResponse(t, rec, status, nil)
+	}
+	return tokens
+}
+
+func TestRefreshTokenRotates(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test secret")
+	db := testDB(t)
+	user := testUser(t, db, "user")
+
+	_, first, err := issueTokens(db, user, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := refresh(t, db, first, http.StatusOK)
+	if second.Token == "" || second.RefreshToken == "" || second.RefreshToken == first {
+		t.Fatalf("refresh = %+v, want a new pair", second)
+	}
+	if sessionOf(t, db, second.RefreshToken) != sessionOf(t, db, first) {
+		t.Error("rotated token left its session")
+	}
+
+	// presenting the rotated token again revokes the whole session
+	refresh(t, db, first, http.StatusUnauthorized)
+	refresh(t, db, second.RefreshToken, http.StatusUnauthorized)
+	refresh(t, db, "not a token", http.StatusUnauthorized)
+}
+
+func TestRefreshTokenRefusesDisabledUser(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test secret")
+	db := testDB(t)
+	user := testUser(t, db, "user")
+	_, refreshToken, err := issueTokens(db, user, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(user).Update("active", false).Error; err != nil {
+		t.Fatal(err)
+	}
+	refresh(t, db, refreshToken, http.StatusUnauthorized)
+}
+
+func TestValidateSession(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test secret")
+	db := testDB(t)
+	user := testUser(t, db, "user")
+	_, refreshToken, err := issueTokens(db, user, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sid := sessionOf(t, db, refreshToken)
+
+	validate := func(r *http.Request, status int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		ValidateSession(db, rec, r, func(w http.ResponseWriter, r *http.Request) {
+			RespondJSON(w, http.StatusOK, nil)
+		})
+		decodeResponse(t, rec, status, nil)
+	}
+
+	validate(withSession(testRequest(t, "GET", "/api/auth", nil, user), sid), http.StatusOK)
+
+	outdated := *user
+	outdated.Role = "moderator"
+	validate(withSession(testRequest(t, "GET", "/api/auth", nil, &outdated), sid), http.StatusUnauthorized)
+
+	rec := httptest.NewRecorder()
+	Logout(db, testAuditor(db), rec, withSession(testRequest(t, "POST", "/api/logout", nil, user), sid))
+	decodeResponse(t, rec, http.StatusNoContent, nil)
+	validate(withSession(testRequest(t, "GET", "/api/auth", nil, user), sid), http.StatusUnauthorized)
+	refresh(t, db, refreshToken, http.StatusUnauthorized)
+
+	if err := db.Model(user).Update("active", false).Error; err != nil {
+		t.Fatal(err)
+	}
+	validate(testRequest(t, "GET", "/api/auth", nil, user), http.StatusForbidden)
+}
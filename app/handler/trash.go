@@ -0,0 +1,342 @@
+package handler
+
+import (
+	"errors"
+	"forum-server/app/config"
+	"forum-server/app/model"
+	"forum-server/app/storage"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const defaultTrashRetentionDays = 30
+
+var trashSorts = map[string]sortKey{
+	"newest": {column: "deleted_at", desc: true, kind: sortTime},
+	"oldest": {column: "deleted_at", desc: false, kind: sortTime},
+}
+
+var (
+	errBoardTrashed = errors.New("the board is in the trash, restore it first")
+	errPostTrashed  = errors.New("the post is in the trash, restore it first")
+)
+
+// TrashRetention is how long deleted rows stay restorable before the purge
+// removes them, read from TRASH_RETENTION_DAYS.
+func TrashRetention() time.Duration {
+	days := config.PositiveInt("TRASH_RETENTION_DAYS", defaultTrashRetentionDays)
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// GetTrash lists deleted posts, comments, boards or users, most recently
+// deleted first.
+func GetTrash(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	kind := vars["type"]
+
+	p, err := parsePagination(r, trashSorts, "newest")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var page *Page
+	switch kind {
+	case "posts":
+		posts := []model.Post{}
+		page, err = p.find(trashed(db, &model.Post{}), &posts)
+	case "comments":
+		comments := []model.Comment{}
+		page, err = p.find(trashed(db, &model.Comment{}), &comments)
+	case "boards":
+		boards := []model.Board{}
+		page, err = p.find(trashed(db, &model.Board{}), &boards)
+	case "users":
+		users := []model.User{}
+		page, err = p.find(trashed(db, &model.User{}), &users)
+		for i := range users {
+			users[i].Password = ""
+		}
+	default:
+		RespondError(w, http.StatusNotFound, "trash not found")
+		return
+	}
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, page)
+}
+
+// RestoreTrash takes a row back out of the trash. Posts and boards bring
+// back the comments and posts that were deleted along with them.
+func RestoreTrash(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	kind := vars["type"]
+	id := vars["id"]
+
+	var err error
+	switch kind {
+	case "posts":
+		post := model.Post{}
+		if err = findTrashed(db, id, &post); err == nil {
+			err = restorePost(db, &post)
+		}
+	case "comments":
+		comment := model.Comment{}
+		if err = findTrashed(db, id, &comment); err == nil {
+			err = restoreComment(db, &comment)
+		}
+	case "boards":
+		board := model.Board{}
+		if err = findTrashed(db, id, &board); err == nil {
+			err = restoreBoard(db, &board)
+		}
+	case "users":
+		user := model.User{}
+		if err = findTrashed(db, id, &user); err == nil {
+			err = restoreRows(db.Unscoped().Model(&model.User{}).Where("id = ?", id))
+		}
+	default:
+		err = gorm.ErrRecordNotFound
+	}
+
+	switch {
+	case err == nil:
+		RespondJSON(w, http.StatusNoContent, nil)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		RespondError(w, http.StatusNotFound, "not found in trash")
+	case errors.Is(err, errBoardTrashed), errors.Is(err, errPostTrashed):
+		RespondError(w, http.StatusConflict, err.Error())
+	default:
+		log.Println("ERROR RESTORE:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+	}
+}
+
+func trashed(db *gorm.DB, value interface{}) *gorm.DB {
+	return db.Unscoped().Model(value).Where("deleted_at IS NOT NULL")
+}
+
+func findTrashed(db *gorm.DB, id string, dest interface{}) error {
+	if id == "" {
+		return gorm.ErrRecordNotFound
+	}
+	return trashed(db, dest).Where("id = ?", id).First(dest).Error
+}
+
+// trashColumns moves rows to the trash. Everything deleted in one go shares
+// the same deleted_at, which is how a restore finds what was taken along.
+func trashColumns(deletedBy, reason string) map[string]interface{} {
+	return map[string]interface{}{
+		"deleted_at":    time.Now().UTC().Truncate(time.Microsecond),
+		"deleted_by":    deletedBy,
+		"delete_reason": reason,
+	}
+}
+
+func restoreRows(q *gorm.DB) error {
+	return q.Updates(map[string]interface{}{
+		"deleted_at":    nil,
+		"deleted_by":    "",
+		"delete_reason": "",
+	}).Error
+}
+
+// deletePost moves post and its comments to the trash.
+func deletePost(db *gorm.DB, post *model.Post, deletedBy, reason string) error {
+	columns := trashColumns(deletedBy, reason)
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.Post{}).Where("id = ?", post.ID).Updates(columns).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model.Comment{}).Where("post_id = ?", post.ID).Updates(columns).Error; err != nil {
+			return err
+		}
+		return recountTags(tx, "id IN (SELECT tag_id FROM post_tags WHERE post_id = ?)", post.ID)
+	})
+}
+
+// deleteBoard moves board, its posts and their comments to the trash.
+func deleteBoard(db *gorm.DB, board *model.Board, deletedBy, reason string) error {
+	columns := trashColumns(deletedBy, reason)
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.Board{}).Where("id = ?", board.ID).Updates(columns).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model.Comment{}).Where("post_id IN (SELECT id FROM posts WHERE board_id = ? AND deleted_at IS NULL)", board.ID).Updates(columns).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model.Post{}).Where("board_id = ?", board.ID).Updates(columns).Error; err != nil {
+			return err
+		}
+		return recountTags(tx, "id IN (SELECT tag_id FROM post_tags WHERE post_id IN (SELECT id FROM posts WHERE board_id = ?))", board.ID)
+	})
+}
+
+func restorePost(db *gorm.DB, post *model.Post) error {
+	if _, err := getBoardByID(db, post.BoardID); err != nil {
+		return errBoardTrashed
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := restoreRows(tx.Unscoped().Model(&model.Post{}).Where("id = ?", post.ID)); err != nil {
+			return err
+		}
+		if err := restoreRows(tx.Unscoped().Model(&model.Comment{}).Where("post_id = ? AND deleted_at = ?", post.ID, post.DeletedAt.Time)); err != nil {
+			return err
+		}
+		return recountTags(tx, "id IN (SELECT tag_id FROM post_tags WHERE post_id = ?)", post.ID)
+	})
+}
+
+// restoreComment brings back a comment deleted on its own, which was taken
+// off its post's comment count at the time.
+func restoreComment(db *gorm.DB, comment *model.Comment) error {
+	if _, err := getPostById(db, comment.PostID); err != nil {
+		return errPostTrashed
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := restoreRows(tx.Unscoped().Model(&model.Comment{}).Where("id = ?", comment.ID)); err != nil {
+			return err
+		}
+		return tx.Model(&model.Post{}).Where("id = ?", comment.PostID).Update("comment_count", gorm.Expr("comment_count + 1")).Error
+	})
+}
+
+func restoreBoard(db *gorm.DB, board *model.Board) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := restoreRows(tx.Unscoped().Model(&model.Board{}).Where("id = ?", board.ID)); err != nil {
+			return err
+		}
+		if err := restoreRows(tx.Unscoped().Model(&model.Comment{}).Where("post_id IN (SELECT id FROM posts WHERE board_id = ? AND deleted_at = ?) AND deleted_at = ?", board.ID, board.DeletedAt.Time, board.DeletedAt.Time)); err != nil {
+			return err
+		}
+		if err := restoreRows(tx.Unscoped().Model(&model.Post{}).Where("board_id = ? AND deleted_at = ?", board.ID, board.DeletedAt.Time)); err != nil {
+			return err
+		}
+		return recountTags(tx, "id IN (SELECT tag_id FROM post_tags WHERE post_id IN (SELECT id FROM posts WHERE board_id = ?))", board.ID)
+	})
+}
+
+// PurgeTrash removes everything that has been in the trash for longer than
+// retention, along with the attachments, revisions and avatars that belong
+// to it. It returns how many rows were purged.
+func PurgeTrash(db *gorm.DB, store storage.BlobStore, retention time.Duration) (int, error) {
+	cutoff := time.Now().Add(-retention)
+	purged := 0
+
+	boards := []model.Board{}
+	if err := trashed(db, &model.Board{}).Where("deleted_at < ?", cutoff).Find(&boards).Error; err != nil {
+		return purged, err
+	}
+	for i := range boards {
+		if err := purgeBoard(db, store, &boards[i]); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	posts := []model.Post{}
+	if err := trashed(db, &model.Post{}).Where("deleted_at < ?", cutoff).Find(&posts).Error; err != nil {
+		return purged, err
+	}
+	for i := range posts {
+		if err := purgePost(db, store, &posts[i]); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	comments := []model.Comment{}
+	if err := trashed(db, &model.Comment{}).Where("deleted_at < ?", cutoff).Find(&comments).Error; err != nil {
+		return purged, err
+	}
+	for i := range comments {
+		if err := purgeComment(db, store, &comments[i]); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	users := []model.User{}
+	if err := trashed(db, &model.User{}).Where("deleted_at < ?", cutoff).Find(&users).Error; err != nil {
+		return purged, err
+	}
+	for i := range users {
+		if err := purgeUser(db, store, &users[i]); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+func purgeBoard(db *gorm.DB, store storage.BlobStore, board *model.Board) error {
+	posts := []model.Post{}
+	if err := db.Unscoped().Where("board_id = ?", board.ID).Find(&posts).Error; err != nil {
+		return err
+	}
+	for i := range posts {
+		if err := purgePost(db, store, &posts[i]); err != nil {
+			return err
+		}
+	}
+	return db.Unscoped().Delete(board).Error
+}
+
+func purgePost(db *gorm.DB, store storage.BlobStore, post *model.Post) error {
+	if _, err := deleteAttachments(db, store, "post_id = ?", post.ID); err != nil {
+		return err
+	}
+	commentIds := []string{}
+	if err := db.Unscoped().Model(&model.Comment{}).Where("post_id = ?", post.ID).Pluck("id", &commentIds).Error; err != nil {
+		return err
+	}
+	if err := deleteVotes(db, "comment", commentIds); err != nil {
+		return err
+	}
+	if err := deleteVotes(db, "post", []string{post.ID}); err != nil {
+		return err
+	}
+	if err := db.Where("target_type = ? AND target_id IN (SELECT id FROM comments WHERE post_id = ?)", "comment", post.ID).Delete(&model.Revision{}).Error; err != nil {
+		return err
+	}
+	if err := db.Unscoped().Where("post_id = ?", post.ID).Delete(&model.Comment{}).Error; err != nil {
+		return err
+	}
+	if err := deleteRevisions(db, "post", post.ID); err != nil {
+		return err
+	}
+	if err := db.Exec("DELETE FROM post_tags WHERE post_id = ?", post.ID).Error; err != nil {
+		return err
+	}
+	return db.Unscoped().Delete(post).Error
+}
+
+func purgeUser(db *gorm.DB, store storage.BlobStore, user *model.User) error {
+	if err := db.Where(&model.RefreshToken{UserID: user.ID}).Delete(&model.RefreshToken{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("user_id = ?", user.ID).Delete(&model.LoginAttempt{}).Error; err != nil {
+		return err
+	}
+	if err := deleteTwoFactor(db, user.ID); err != nil {
+		return err
+	}
+	if err := db.Unscoped().Delete(user).Error; err != nil {
+		return err
+	}
+	deleteObjects(store, avatarKeys(store, user.AvatarURL))
+	return nil
+}
+
+// trashReason reads the optional reason query parameter of a delete.
+func trashReason(r *http.Request) (string, error) {
+	reason := r.URL.Query().Get("reason")
+	return reason, validReason(reason)
+}
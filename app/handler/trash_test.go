@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"context"
+	"forum-server/app/model"
+	"forum-server/app/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func trashList(t *testing.T, db *gorm.DB, kind string, items interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	GetTrash(db, rec, testRequest(t, "GET", "/api/trash/"+kind, nil, nil, "type", kind))
+	decodePage(t, rec, items)
+}
+
+func restoreTrash(t *testing.T, db *gorm.DB, kind, id string, status int) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	RestoreTrash(db, rec, testRequest(t, "POST", "/api/trash/"+kind+"/"+id+"/restore", nil, nil, "type", kind, "id", id))
+	decodeResponse(t, rec, status, nil)
+}
+
+// live reports whether the row with id is out of the trash.
+func live(db *gorm.DB, value interface{}, id string) bool {
+	var count int64
+	db.Model(value).Where("id = ?", id).Count(&count)
+	return count == 1
+}
+
+func TestDeleteOwnership(t *testing.T) {
+	db := testDB(t)
+	author := testUser(t, db, "user")
+	other := testUser(t, db, "user")
+	moderator := testUser(t, db, "moderator")
+	board := testBoard(t, db, "board")
+
+	deletePostAs := func(post *model.Post, user *model.User, query string, status int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		DeletePost(db, &testPublisher{}, rec, testRequest(t, "DELETE", "/api/posts/"+post.ID+query, nil, user, "postId", post.ID))
+		decodeResponse(t, rec, status, nil)
+	}
+	deleteCommentAs := func(comment *model.Comment, user *model.User, status int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		DeleteComment(db, &testPublisher{}, rec, testRequest(t, "DELETE", "/api/posts/comments/"+comment.ID, nil, user, "commentId", comment.ID))
+		decodeResponse(t, rec, status, nil)
+	}
+
+	own := testPost(t, db, board, author, "own")
+	moderated := testPost(t, db, board, author, "moderated")
+	deletePostAs(own, other, "", http.StatusUnauthorized)
+	deletePostAs(own, author, "?reason="+strings.Repeat("r", maxReasonLength+1), http.StatusBadRequest)
+	deletePostAs(own, author, "?reason=duplicate", http.StatusNoContent)
+	deletePostAs(moderated, moderator, "", http.StatusNoContent)
+	deletePostAs(own, author, "", http.StatusNotFound)
+
+	post := testPost(t, db, board, author, "post")
+	comment := testComment(t, db, post, author, nil, "comment")
+	deleteCommentAs(comment, other, http.StatusUnauthorized)
+	deleteCommentAs(comment, moderator, http.StatusNoContent)
+	deleteCommentAs(comment, author, http.StatusNotFound)
+
+	posts := []model.Post{}
+	trashList(t, db, "posts", &posts)
+	if len(posts) != 2 {
+		t.Fatalf("%d posts in the trash, want 2", len(posts))
+	}
+	for _, p := range posts {
+		if p.ID == own.ID && (p.DeletedBy != author.ID || p.DeleteReason != "duplicate") {
+			t.Errorf("trashed post = %+v, want who deleted it and why", p)
+		}
+	}
+}
+
+func TestRestoreTrash(t *testing.T) {
+	db := testDB(t)
+	author := testUser(t, db, "user")
+	board := testBoard(t, db, "board")
+	post := testPost(t, db, board, author, "post")
+	kept := testComment(t, db, post, author, nil, "deleted with the post")
+	alone := testComment(t, db, post, author, nil, "deleted on its own")
+
+	if err := removeComment(db, alone, author.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if err := deletePost(db, post, author.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	restoreTrash(t, db, "comments", kept.ID, http.StatusConflict)
+	restoreTrash(t, db, "posts", post.ID, http.StatusNoContent)
+	if !live(db, &model.Post{}, post.ID) || !live(db, &model.Comment{}, kept.ID) {
+		t.Error("restoring the post left it or its comment in the trash")
+	}
+	if live(db, &model.Comment{}, alone.ID) {
+		t.Error("restoring the post brought back a comment deleted before it")
+	}
+	restoreTrash(t, db, "comments", alone.ID, http.StatusNoContent)
+	restoreTrash(t, db, "comments", alone.ID, http.StatusNotFound)
+
+	if err := deleteBoard(db, board, author.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	restoreTrash(t, db, "posts", post.ID, http.StatusConflict)
+	restoreTrash(t, db, "boards", board.ID, http.StatusNoContent)
+	if !live(db, &model.Post{}, post.ID) || !live(db, &model.Comment{}, alone.ID) {
+		t.Error("restoring the board left its posts or comments in the trash")
+	}
+
+	restoreTrash(t, db, "widgets", board.ID, http.StatusNotFound)
+	rec := httptest.NewRecorder()
+	GetTrash(db, rec, testRequest(t, "GET", "/api/trash/widgets", nil, nil, "type", "widgets"))
+	decodeResponse(t, rec, http.StatusNotFound, nil)
+}
+
+func TestTrashedUsersHidePasswords(t *testing.T) {
+	db := testDB(t)
+	user := testUser(t, db, "user")
+	if err := db.Delete(user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	users := []model.User{}
+	trashList(t, db, "users", &users)
+	if len(users) != 1 || users[0].ID != user.ID || users[0].Password != "" {
+		t.Errorf("trashed users = %+v, want %s without a password", users, user.ID)
+	}
+	restoreTrash(t, db, "users", user.ID, http.StatusNoContent)
+	if !live(db, &model.User{}, user.ID) {
+		t.Error("user still in the trash")
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	db := testDB(t)
+	store := storage.NewMemoryStore("/api/media")
+	author := testUser(t, db, "user")
+	board := testBoard(t, db, "board")
+	old := testPost(t, db, board, author, "old")
+	recent := testPost(t, db, board, author, "recent")
+	comment := testComment(t, db, old, author, nil, "comment")
+	if err := editPost(db, old, author.ID, "", "old", "edited"); err != nil {
+		t.Fatal(err)
+	}
+	storage.PutBytes(context.Background(), store, "attachments/a/file.txt", []byte("x"), "text/plain")
+	if err := db.Create(&model.Attachment{ID: "a", UploaderID: author.ID, PostID: old.ID, Key: "attachments/a/file.txt", CreateDate: time.Now().UTC()}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	deletePost(db, old, author.ID, "")
+	deletePost(db, recent, author.ID, "")
+	db.Unscoped().Model(&model.Post{}).Where("id = ?", old.ID).Update("deleted_at", time.Now().Add(-48*time.Hour))
+	db.Unscoped().Model(&model.Comment{}).Where("id = ?", comment.ID).Update("deleted_at", time.Now().Add(-48*time.Hour))
+
+	purged, err := PurgeTrash(db, store, 24*time.Hour)
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeTrash = %d, %v, want 1", purged, err)
+	}
+
+	counts := map[string]int64{}
+	for name, value := range map[string]interface{}{"posts": &model.Post{}, "comments": &model.Comment{}, "revisions": &model.Revision{}, "attachments": &model.Attachment{}} {
+		var count int64
+		db.Unscoped().Model(value).Count(&count)
+		counts[name] = count
+	}
+	if counts["posts"] != 1 || counts["comments"] != 0 || counts["revisions"] != 0 || counts["attachments"] != 0 {
+		t.Errorf("rows left after the purge = %v, want only the recent post", counts)
+	}
+	if keys := store.Keys(); len(keys) != 0 {
+		t.Errorf("purge left files behind: %v", keys)
+	}
+	if findTrashed(db, recent.ID, &model.Post{}) != nil {
+		t.Error("purge removed a post deleted within the retention")
+	}
+}
+
+func TestTrashRetention(t *testing.T) {
+	t.Setenv("TRASH_RETENTION_DAYS", "7")
+	if got := TrashRetention(); got != 7*24*time.Hour {
+		t.Errorf("TrashRetention = %v, want 7 days", got)
+	}
+	t.Setenv("TRASH_RETENTION_DAYS", "-1")
+	if got := TrashRetention(); got != defaultTrashRetentionDays*24*time.Hour {
+		t.Errorf("TrashRetention with a bad value = %v, want the default", got)
+	}
+}
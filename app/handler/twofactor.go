@@ -0,0 +1,526 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forum-server/app/auth"
+	"forum-server/app/config"
+	"forum-server/app/mail"
+	"forum-server/app/model"
+	"forum-server/app/ratelimit"
+	"forum-server/audit"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/form3tech-oss/jwt-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	recoveryCodeCount      = 10
+	loginChallengeLifetime = 5 * time.Minute
+	loginChallengeAttempts = 5
+	defaultTwoFactorIssuer = "Forum"
+	defaultTwoFactorRoles  = "admin,moderator"
+	twoFactorRoutePrefix   = "/api/2fa"
+	invalidCodeMessage     = "invalid code"
+)
+
+// twoFactorOpenPaths are the routes a user who must set up two-factor
+// authentication can still use before they have.
+var twoFactorOpenPaths = []string{"/api/auth", "/api/logout"}
+
+// GetTwoFactor reports whether the caller has two-factor authentication on
+// and how many recovery codes they have left.
+func GetTwoFactor(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	user, err := getUserById(db, reqId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	status := model.TwoFactorStatus{Required: twoFactorRequired(user.Role)}
+	if tf := enabledTwoFactor(db, user.ID); tf != nil {
+		status.Enabled = true
+		status.EnableDate = tf.EnableDate
+		db.Model(&model.RecoveryCode{}).Where("user_id = ? AND used_date IS NULL", user.ID).Count(&status.RecoveryCodesLeft)
+	}
+	RespondJSON(w, http.StatusOK, status)
+}
+
+// SetupTwoFactor starts setting up two-factor authentication with a new
+// secret, which is stored encrypted. It doesn't take effect until EnableTwoFactor confirms the user's
+// authenticator produces matching codes.
+func SetupTwoFactor(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	user, err := getUserById(db, reqId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if enabledTwoFactor(db, user.ID) != nil {
+		RespondError(w, http.StatusConflict, "two-factor authentication is already on")
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	sealed, err := auth.SealTOTPSecret(secret, user.ID)
+	if err != nil {
+		log.Println("ERROR TWO FACTOR SETUP:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	tf := model.TwoFactor{
+		UserID:     user.ID,
+		Secret:     sealed,
+		CreateDate: time.Now().UTC(),
+	}
+	if err := db.Save(&tf).Error; err != nil {
+		log.Println("ERROR TWO FACTOR SETUP:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	issuer := config.String("TWO_FACTOR_ISSUER", defaultTwoFactorIssuer)
+	RespondJSON(w, http.StatusOK, model.TwoFactorSetup{
+		Secret: secret,
+		URI:    auth.TOTPURI(issuer, user.Username, secret),
+	})
+}
+
+// EnableTwoFactor turns two-factor authentication on once the caller
+// proves their authenticator works, and returns their recovery codes. This
+// is the only time the codes are shown.
+func EnableTwoFactor(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	req := model.TwoFactorCode{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		RespondError(w, http.StatusBadRequest, "an unknown error has occurred")
+		return
+	}
+	defer r.Body.Close()
+
+	tf := model.TwoFactor{}
+	if err := db.Where("user_id = ?", reqId).First(&tf).Error; err != nil {
+		RespondError(w, http.StatusBadRequest, "two-factor authentication hasn't been set up")
+		return
+	}
+	if tf.Enabled {
+		RespondError(w, http.StatusConflict, "two-factor authentication is already on")
+		return
+	}
+	secret, err := auth.OpenTOTPSecret(tf.Secret, tf.UserID)
+	if err != nil {
+		log.Println("ERROR TWO FACTOR ENABLE:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	period, ok := auth.VerifyTOTP(secret, req.Code, time.Now(), tf.LastPeriod)
+	if !ok {
+		RespondError(w, http.StatusBadRequest, invalidCodeMessage)
+		return
+	}
+
+	var codes []string
+	now := time.Now().UTC()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.TwoFactor{}).Where("user_id = ?", reqId).Updates(map[string]interface{}{
+			"enabled":     true,
+			"last_period": period,
+			"enable_date": now,
+		}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, reqId)
+		return err
+	})
+	if err != nil {
+		log.Println("ERROR TWO FACTOR ENABLE:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	auditor.Log(reqId, "Enable Two Factor", "Success", "")
+	RespondJSON(w, http.StatusOK, model.RecoveryCodes{Codes: codes})
+}
+
+// DisableTwoFactor turns two-factor authentication off. It needs the
+// caller's password and a code, and isn't allowed for roles that require
+// two-factor authentication. The caller is signed out everywhere.
+func DisableTwoFactor(db *gorm.DB, auditor *audit.Auditor, mailer mail.Mailer, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	req := model.TwoFactorDisable{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		RespondError(w, http.StatusBadRequest, "an unknown error has occurred")
+		return
+	}
+	defer r.Body.Close()
+
+	user, err := getUserById(db, reqId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if twoFactorRequired(user.Role) {
+		RespondError(w, http.StatusForbidden, "two-factor authentication is required for your role")
+		return
+	}
+	if enabledTwoFactor(db, user.ID) == nil {
+		RespondError(w, http.StatusBadRequest, "two-factor authentication is off")
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		RespondError(w, http.StatusUnauthorized, "invalid password")
+		return
+	}
+	if ok, err := checkSecondFactor(db, auditor, user.ID, req.Code, true); err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	} else if !ok {
+		RespondError(w, http.StatusUnauthorized, invalidCodeMessage)
+		return
+	}
+
+	if err := turnTwoFactorOff(db, mailer, user); err != nil {
+		log.Println("ERROR TWO FACTOR DISABLE:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	auditor.Log(reqId, "Disable Two Factor", "Success", "")
+	RespondJSON(w, http.StatusOK, nil)
+}
+
+// RegenerateRecoveryCodes replaces the caller's recovery codes, used or
+// not, with a new set. It needs a code from their authenticator.
+func RegenerateRecoveryCodes(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	req := model.TwoFactorCode{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		RespondError(w, http.StatusBadRequest, "an unknown error has occurred")
+		return
+	}
+	defer r.Body.Close()
+
+	if enabledTwoFactor(db, reqId) == nil {
+		RespondError(w, http.StatusBadRequest, "two-factor authentication is off")
+		return
+	}
+	if ok, err := checkSecondFactor(db, auditor, reqId, req.Code, false); err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	} else if !ok {
+		RespondError(w, http.StatusUnauthorized, invalidCodeMessage)
+		return
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, reqId)
+		return err
+	})
+	if err != nil {
+		log.Println("ERROR RECOVERY CODES:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	auditor.Log(reqId, "Regenerate Recovery Codes", "Success", "")
+	RespondJSON(w, http.StatusOK, model.RecoveryCodes{Codes: codes})
+}
+
+// ResetTwoFactor turns off another user's two-factor authentication, for
+// users who have lost both their authenticator and their recovery codes.
+// Users whose role requires it will have to set it up again.
+func ResetTwoFactor(db *gorm.DB, auditor *audit.Auditor, mailer mail.Mailer, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	userId := vars["userId"]
+
+	user, err := getUserById(db, userId)
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err := db.Where("user_id = ?", user.ID).First(&model.TwoFactor{}).Error; err != nil {
+		RespondError(w, http.StatusNotFound, "two-factor authentication is off")
+		return
+	}
+
+	if err := turnTwoFactorOff(db, mailer, user); err != nil {
+		log.Println("ERROR TWO FACTOR RESET:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	auditor.Log(reqId, "Reset Two Factor", "Success", user.ID)
+	RespondJSON(w, http.StatusOK, nil)
+}
+
+// VerifyTwoFactorLogin is the second step of signing in for users with
+// two-factor authentication. It trades the challenge token from UserLogin
+// and a TOTP or recovery code for the real tokens. Wrong codes count as
+// failed logins.
+func VerifyTwoFactorLogin(db *gorm.DB, auditor *audit.Auditor, mailer mail.Mailer, w http.ResponseWriter, r *http.Request) {
+	req := model.TwoFactorLogin{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		RespondError(w, http.StatusBadRequest, "an unknown error has occurred")
+		return
+	}
+	defer r.Body.Close()
+
+	limits := readLoginLimits()
+	now := time.Now().UTC()
+	if wait := loginWait(db, limits, "", ratelimit.ClientIP(r), now); wait > 0 {
+		respondLoginWait(w, wait)
+		return
+	}
+
+	challenge := model.LoginChallenge{}
+	err := db.Where("token_hash = ? AND expires_at > ? AND attempts < ?", auth.HashToken(req.ChallengeToken), now, loginChallengeAttempts).First(&challenge).Error
+	if req.ChallengeToken == "" || err != nil {
+		RespondError(w, http.StatusUnauthorized, "invalid or expired challenge, sign in again")
+		return
+	}
+	user, err := getUserById(db, challenge.UserID)
+	if err != nil {
+		RespondError(w, http.StatusUnauthorized, "invalid or expired challenge, sign in again")
+		return
+	}
+	if wait := loginWait(db, limits, user.ID, ratelimit.ClientIP(r), now); wait > 0 {
+		respondLoginWait(w, wait)
+		return
+	}
+
+	ok, err := checkSecondFactor(db, auditor, user.ID, req.Code, true)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	if !ok {
+		db.Model(&model.LoginChallenge{}).Where("id = ?", challenge.ID).Update("attempts", gorm.Expr("attempts + 1"))
+		loginFailed(db, auditor, mailer, limits, user, r)
+		RespondError(w, http.StatusUnauthorized, invalidCodeMessage)
+		return
+	}
+	db.Delete(&model.LoginChallenge{}, "id = ?", challenge.ID)
+
+	if !user.Active {
+		RespondError(w, http.StatusForbidden, "account disabled")
+		return
+	}
+	if ban := siteBan(db, user.ID); ban != nil {
+		respondBanned(w, ban)
+		return
+	}
+
+	completeLogin(db, auditor, user, w, r)
+}
+
+// twoFactorRequired reports whether users with role must have two-factor
+// authentication, per the comma separated TWO_FACTOR_REQUIRED_ROLES. Set it
+// to "none" to require it of no one.
+func twoFactorRequired(role string) bool {
+	return contains(config.List("TWO_FACTOR_REQUIRED_ROLES", defaultTwoFactorRoles), role)
+}
+
+// turnTwoFactorOff removes user's two-factor settings and signs them out
+// everywhere, then lets them know by email. Whoever turned it off could be
+// holding a stolen session, so none are left standing.
+func turnTwoFactorOff(db *gorm.DB, mailer mail.Mailer, user *model.User) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := deleteTwoFactor(tx, user.ID); err != nil {
+			return err
+		}
+		return revokeUserTokens(tx, user.ID)
+	})
+	if err != nil {
+		return err
+	}
+	sendMail(mailer, mail.TemplateTwoFactorOff, user.Email, mail.Data{Username: user.Username, Email: user.Email})
+	return nil
+}
+
+// CheckTwoFactor turns away a request to path from a user whose role needs
+// two-factor authentication but who hasn't set it up yet.
+func CheckTwoFactor(db *gorm.DB, userId, role, path string) *SessionError {
+	if twoFactorPending(db, userId, role, path) {
+		return &SessionError{http.StatusForbidden, "two-factor authentication is required for your role, set it up first"}
+	}
+	return nil
+}
+
+// twoFactorPending reports whether a request to path must be turned away
+// because the caller's role needs two-factor authentication and they
+// haven't set it up yet.
+func twoFactorPending(db *gorm.DB, userId, role, path string) bool {
+	if !twoFactorRequired(role) || strings.HasPrefix(path, twoFactorRoutePrefix) || contains(twoFactorOpenPaths, path) {
+		return false
+	}
+	return enabledTwoFactor(db, userId) == nil
+}
+
+// enabledTwoFactor returns userId's two-factor settings, or nil if they
+// don't have it turned on.
+func enabledTwoFactor(db *gorm.DB, userId string) *model.TwoFactor {
+	tf := model.TwoFactor{}
+	err := db.Where("user_id = ? AND enabled = ?", userId, true).First(&tf).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Println("ERROR TWO FACTOR LOOKUP:", err)
+		}
+		return nil
+	}
+	return &tf
+}
+
+// checkSecondFactor checks code against userId's authenticator and, if
+// allowRecovery, their unused recovery codes. A code that checks out is
+// used up.
+func checkSecondFactor(db *gorm.DB, auditor *audit.Auditor, userId, code string, allowRecovery bool) (bool, error) {
+	tf := enabledTwoFactor(db, userId)
+	if tf == nil || strings.TrimSpace(code) == "" {
+		return false, nil
+	}
+
+	secret, err := auth.OpenTOTPSecret(tf.Secret, tf.UserID)
+	if err != nil {
+		log.Println("ERROR TWO FACTOR CHECK:", err)
+		return false, err
+	}
+	if period, ok := auth.VerifyTOTP(secret, code, time.Now(), tf.LastPeriod); ok {
+		// only move forward, so two requests racing with the same code
+		// can't both succeed
+		result := db.Model(&model.TwoFactor{}).Where("user_id = ? AND last_period < ?", userId, period).Update("last_period", period)
+		if result.Error != nil {
+			log.Println("ERROR TWO FACTOR CHECK:", result.Error)
+			return false, result.Error
+		}
+		return result.RowsAffected == 1, nil
+	}
+	if !allowRecovery {
+		return false, nil
+	}
+
+	result := db.Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_date IS NULL", userId, auth.HashRecoveryCode(code)).
+		Update("used_date", time.Now().UTC())
+	if result.Error != nil {
+		log.Println("ERROR TWO FACTOR CHECK:", result.Error)
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	auditor.Log(userId, "Use Recovery Code", "Success", "")
+	return true, nil
+}
+
+// replaceRecoveryCodes stores a new set of recovery codes for userId in
+// place of any they had and returns them.
+func replaceRecoveryCodes(tx *gorm.DB, userId string) ([]string, error) {
+	codes, hashes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Where("user_id = ?", userId).Delete(&model.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	stored := make([]model.RecoveryCode, len(hashes))
+	for i, hash := range hashes {
+		id, err := uuid.NewUUID()
+		if err != nil {
+			return nil, err
+		}
+		stored[i] = model.RecoveryCode{ID: id.String(), UserID: userId, CodeHash: hash, CreateDate: now}
+	}
+	if err := tx.Create(&stored).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func deleteTwoFactor(tx *gorm.DB, userId string) error {
+	if err := tx.Where("user_id = ?", userId).Delete(&model.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userId).Delete(&model.LoginChallenge{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("user_id = ?", userId).Delete(&model.TwoFactor{}).Error
+}
+
+// issueLoginChallenge returns a token that stands in for userId's password
+// until they give their second factor. Their earlier challenges, and any
+// expired ones, are dropped.
+func issueLoginChallenge(db *gorm.DB, userId string) (string, time.Time, error) {
+	token, hash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now().UTC()
+	challenge := model.LoginChallenge{
+		ID:         id.String(),
+		UserID:     userId,
+		TokenHash:  hash,
+		ExpiresAt:  now.Add(loginChallengeLifetime),
+		CreateDate: now,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? OR expires_at < ?", userId, now).Delete(&model.LoginChallenge{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&challenge).Error
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, challenge.ExpiresAt, nil
+}
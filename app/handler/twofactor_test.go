@@ -0,0 +1,286 @@
+package handler
+
+import (
+	"forum-server/app/auth"
+	"forum-server/app/mail"
+	"forum-server/app/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// currentCode is the TOTP code for secret right now.
+func currentCode(t *testing.T, secret string) string {
+	t.Helper()
+	code, err := auth.TOTPCode(secret, time.Now().Unix()/int64(auth.TOTPPeriod.Seconds()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestTwoFactorSecretStoredSealed(t *testing.T) {
+	t.Setenv("TWO_FACTOR_KEY", "test key")
+	db := testDB(t)
+	auditor := testAuditor(db)
+	user := testUser(t, db, "user")
+
+	rec := httptest.NewRecorder()
+	SetupTwoFactor(db, rec, testRequest(t, "POST", "/api/2fa/setup", nil, user))
+	setup := model.TwoFactorSetup{}
+	decodeResponse(t, rec, http.StatusOK, &setup)
+
+	stored := model.TwoFactor{}
+	if err := db.Where("user_id = ?", user.ID).First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Secret == setup.Secret {
+		t.Fatal("secret stored in plain text")
+	}
+
+	rec = httptest.NewRecorder()
+	EnableTwoFactor(db, auditor, rec, testRequest(t, "POST", "/api/2fa/enable", model.TwoFactorCode{Code: currentCode(t, setup.Secret)}, user))
+	codes := model.RecoveryCodes{}
+	decodeResponse(t, rec, http.StatusOK, &codes)
+	if len(codes.Codes) != recoveryCodeCount {
+		t.Errorf("got %d recovery codes, want %d", len(codes.Codes), recoveryCodeCount)
+	}
+	if enabledTwoFactor(db, user.ID) == nil {
+		t.Error("two-factor not enabled")
+	}
+}
+
+func TestTwoFactorSetupNeedsKey(t *testing.T) {
+	t.Setenv("TWO_FACTOR_KEY", "")
+	db := testDB(t)
+	user := testUser(t, db, "user")
+
+	rec := httptest.NewRecorder()
+	SetupTwoFactor(db, rec, testRequest(t, "POST", "/api/2fa/setup", nil, user))
+	decodeResponse(t, rec, http.StatusInternalServerError, nil)
+	if err := db.Where("user_id = ?", user.ID).First(&model.TwoFactor{}).Error; err == nil {
+		t.Error("two-factor settings stored without a key")
+	}
+}
+
+// enableTwoFactor turns two-factor on for user and returns their secret
+// and recovery codes.
+func enableTwoFactor(t *testing.T, db *gorm.DB, user *model.User) (string, []string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	SetupTwoFactor(db, rec, testRequest(t, "POST", "/api/2fa/setup", nil, user))
+	setup := model.TwoFactorSetup{}
+	decodeResponse(t, rec, http.StatusOK, &setup)
+
+	rec = httptest.NewRecorder()
+	EnableTwoFactor(db, testAuditor(db), rec, testRequest(t, "POST", "/api/2fa/enable", model.TwoFactorCode{Code: currentCode(t, setup.Secret)}, user))
+	codes := model.RecoveryCodes{}
+	decodeResponse(t, rec, http.StatusOK, &codes)
+	return setup.Secret, codes.Codes
+}
+
+// lastCode is the TOTP code user last signed in or turned two-factor on
+// with.
+func lastCode(t *testing.T, db *gorm.DB, user *model.User, secret string) string {
+	t.Helper()
+	tf := model.TwoFactor{}
+	if err := db.Where("user_id = ?", user.ID).First(&tf).Error; err != nil {
+		t.Fatal(err)
+	}
+	code, err := auth.TOTPCode(secret, tf.LastPeriod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// forgetLastCode lets the last TOTP code be used again, as if it hadn't
+// been.
+func forgetLastCode(db *gorm.DB, user *model.User) {
+	db.Model(&model.TwoFactor{}).Where("user_id = ?", user.ID).Update("last_period", gorm.Expr("last_period - 1"))
+}
+
+func challengeLogin(t *testing.T, db *gorm.DB, user *model.User) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	UserLogin(db, testAuditor(db), &mail.MemoryMailer{}, rec, testRequest(t, "POST", "/api/login", model.LoginCredentials{Email: user.Email, Password: testPassword}, nil))
+	challenge := model.TwoFactorChallenge{}
+	decodeResponse(t, rec, http.StatusOK, &challenge)
+	if !challenge.TwoFactorRequired || challenge.ChallengeToken == "" {
+		t.Fatalf("login = %+v, want a two-factor challenge", challenge)
+	}
+	return challenge.ChallengeToken
+}
+
+func verifyLogin(t *testing.T, db *gorm.DB, challenge, code string, status int) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	VerifyTwoFactorLogin(db, testAuditor(db), &mail.MemoryMailer{}, rec, testRequest(t, "POST", "/api/login/2fa", model.TwoFactorLogin{ChallengeToken: challenge, Code: code}, nil))
+	if status != http.StatusOK {
+		decodeResponse(t, rec, status, nil)
+		return
+	}
+	tokens := model.LoginResponse{}
+	decodeResponse(t, rec, status, &tokens)
+	if tokens.Token == "" {
+		t.Errorf("verified login = %+v, want tokens", tokens)
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	t.Setenv("TWO_FACTOR_KEY", "test key")
+	t.Setenv("JWT_SECRET", "test secret")
+	t.Setenv("LOGIN_DELAY_AFTER", "100")
+	db := testDB(t)
+	user := testUser(t, db, "user")
+	secret, codes := enableTwoFactor(t, db, user)
+
+	// a code can't be used twice, even the one that turned two-factor on
+	code := lastCode(t, db, user, secret)
+	challenge := challengeLogin(t, db, user)
+	verifyLogin(t, db, challenge, code, http.StatusUnauthorized)
+	forgetLastCode(db, user)
+	verifyLogin(t, db, challenge, code, http.StatusOK)
+	verifyLogin(t, db, challenge, codes[0], http.StatusUnauthorized)
+	verifyLogin(t, db, challengeLogin(t, db, user), code, http.StatusUnauthorized)
+
+	verifyLogin(t, db, challengeLogin(t, db, user), codes[0], http.StatusOK)
+	verifyLogin(t, db, challengeLogin(t, db, user), codes[0], http.StatusUnauthorized)
+	verifyLogin(t, db, "", codes[1], http.StatusUnauthorized)
+
+	// a newer challenge replaces the older one
+	old := challengeLogin(t, db, user)
+	challengeLogin(t, db, user)
+	verifyLogin(t, db, old, codes[1], http.StatusUnauthorized)
+
+	// challenges only take so many wrong codes
+	challenge = challengeLogin(t, db, user)
+	for i := 0; i < loginChallengeAttempts; i++ {
+		verifyLogin(t, db, challenge, "000000", http.StatusUnauthorized)
+	}
+	verifyLogin(t, db, challenge, codes[1], http.StatusUnauthorized)
+
+	rec := httptest.NewRecorder()
+	GetTwoFactor(db, rec, testRequest(t, "GET", "/api/2fa", nil, user))
+	status := model.TwoFactorStatus{}
+	decodeResponse(t, rec, http.StatusOK, &status)
+	if !status.Enabled || status.RecoveryCodesLeft != recoveryCodeCount-1 {
+		t.Errorf("status = %+v, want on with one recovery code used", status)
+	}
+}
+
+func TestDisableTwoFactor(t *testing.T) {
+	t.Setenv("TWO_FACTOR_KEY", "test key")
+	t.Setenv("JWT_SECRET", "test secret")
+	db := testDB(t)
+	user := testUser(t, db, "user")
+	moderator := testUser(t, db, "moderator")
+
+	disable := func(caller *model.User, body model.TwoFactorDisable, status int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		DisableTwoFactor(db, testAuditor(db), &mail.MemoryMailer{}, rec, testRequest(t, "POST", "/api/2fa/disable", body, caller))
+		decodeResponse(t, rec, status, nil)
+	}
+	disable(user, model.TwoFactorDisable{Password: testPassword, Code: "000000"}, http.StatusBadRequest)
+
+	_, codes := enableTwoFactor(t, db, user)
+	enableTwoFactor(t, db, moderator)
+	disable(moderator, model.TwoFactorDisable{Password: testPassword, Code: "000000"}, http.StatusForbidden)
+	disable(user, model.TwoFactorDisable{Password: "wrong", Code: codes[0]}, http.StatusUnauthorized)
+	disable(user, model.TwoFactorDisable{Password: testPassword, Code: "000000"}, http.StatusUnauthorized)
+
+	_, refreshToken, err := issueTokens(db, user, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	disable(user, model.TwoFactorDisable{Password: testPassword, Code: codes[0]}, http.StatusOK)
+	if enabledTwoFactor(db, user.ID) != nil {
+		t.Error("two-factor still on after disabling it")
+	}
+	var left int64
+	db.Model(&model.RecoveryCode{}).Where("user_id = ?", user.ID).Count(&left)
+	if left != 0 {
+		t.Errorf("%d recovery codes left after disabling two-factor", left)
+	}
+	refresh(t, db, refreshToken, http.StatusUnauthorized)
+}
+
+func TestResetTwoFactor(t *testing.T) {
+	t.Setenv("TWO_FACTOR_KEY", "test key")
+	db := testDB(t)
+	admin := testUser(t, db, "admin")
+	moderator := testUser(t, db, "moderator")
+	user := testUser(t, db, "user")
+
+	for _, tt := range []struct {
+		caller *model.User
+		status int
+	}{{user, http.StatusUnauthorized}, {moderator, http.StatusUnauthorized}, {admin, http.StatusOK}} {
+		rec := httptest.NewRecorder()
+		RequirePermission(db, auth.PermTwoFactorReset, rec, testRequest(t, "DELETE", "/api/user/"+user.ID+"/2fa", nil, tt.caller), func(w http.ResponseWriter, r *http.Request) {
+			RespondJSON(w, http.StatusOK, nil)
+		})
+		if rec.Code != tt.status {
+			t.Errorf("%s resetting two-factor: status = %d, want %d", tt.caller.Role, rec.Code, tt.status)
+		}
+	}
+
+	reset := func(target *model.User, status int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		ResetTwoFactor(db, testAuditor(db), &mail.MemoryMailer{}, rec, testRequest(t, "DELETE", "/api/user/"+target.ID+"/2fa", nil, admin, "userId", target.ID))
+		decodeResponse(t, rec, status, nil)
+	}
+	reset(&model.User{ID: "missing"}, http.StatusNotFound)
+	reset(user, http.StatusNotFound)
+
+	// moderators need two-factor, so after a reset they must set it up again
+	enableTwoFactor(t, db, moderator)
+	reset(moderator, http.StatusOK)
+	if enabledTwoFactor(db, moderator.ID) != nil {
+		t.Error("two-factor still on after a reset")
+	}
+	if err := CheckTwoFactor(db, moderator.ID, moderator.Role, "/api/posts"); err == nil {
+		t.Error("moderator without two-factor wasn't turned away after a reset")
+	}
+}
+
+func TestCheckTwoFactor(t *testing.T) {
+	t.Setenv("TWO_FACTOR_KEY", "test key")
+	db := testDB(t)
+	moderator := testUser(t, db, "moderator")
+	user := testUser(t, db, "user")
+
+	tests := []struct {
+		user    *model.User
+		path    string
+		allowed bool
+	}{
+		{user, "/api/posts", true},
+		{moderator, "/api/posts", false},
+		{moderator, "/ws", false},
+		{moderator, "/api/2fa/setup", true},
+		{moderator, "/api/logout", true},
+	}
+	for _, tt := range tests {
+		err := CheckTwoFactor(db, tt.user.ID, tt.user.Role, tt.path)
+		if (err == nil) != tt.allowed || (err != nil && err.Status != http.StatusForbidden) {
+			t.Errorf("%s to %s: %v, want allowed %v", tt.user.Role, tt.path, err, tt.allowed)
+		}
+	}
+
+	enableTwoFactor(t, db, moderator)
+	if err := CheckTwoFactor(db, moderator.ID, moderator.Role, "/api/posts"); err != nil {
+		t.Errorf("moderator with two-factor turned away: %v", err)
+	}
+
+	t.Setenv("TWO_FACTOR_REQUIRED_ROLES", "none")
+	other := testUser(t, db, "moderator")
+	if err := CheckTwoFactor(db, other.ID, other.Role, "/api/posts"); err != nil {
+		t.Errorf("two-factor required with TWO_FACTOR_REQUIRED_ROLES=none: %v", err)
+	}
+}
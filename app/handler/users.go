@@ -2,13 +2,19 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"forum-server/app/auth"
+	"forum-server/app/mail"
 	"forum-server/app/model"
+	"forum-server/app/ratelimit"
+	"forum-server/app/search"
+	"forum-server/app/storage"
 	"forum-server/audit"
 	"log"
 	"net/http"
-	"os"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,25 +25,29 @@ import (
 	"github.com/gorilla/mux"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
-
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
 )
 
-// TODO: implement pagination
 func GetUsers(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
-	var IDs []string
-	db.Model(&model.User{}).Pluck("id", &IDs)
+	p, err := parsePagination(r, userSorts, "newest")
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	users := []model.User{}
+	page, err := p.find(db.Model(&model.User{}), &users)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
 
-	var users []model.PublicUser
-	for _, id := range IDs {
-		user, _ := publicUser(db, id)
-		users = append(users, *user)
+	public := []model.PublicUser{}
+	for i := range users {
+		public = append(public, toPublicUser(&users[i]))
 	}
+	page.Items = public
 
-	RespondJSON(w, http.StatusOK, users)
+	RespondJSON(w, http.StatusOK, page)
 }
 
 func GetPublicUser(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
@@ -80,14 +90,8 @@ func GetUserById(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *
 
 	reqId := userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"]
 
-	userRole, err := getUserRoleById(db, fmt.Sprintf("%v", reqId))
-	if err != nil {
-		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
-		return
-	}
-
 	if reqId != id {
-		if userRole != "admin" {
+		if !hasPermission(db, r, auth.PermUserViewAny) {
 			retUser, err := publicUser(db, id)
 			if err != nil {
 				RespondError(w, http.StatusNotFound, "user not found")
@@ -128,17 +132,44 @@ func UpdateUser(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *h
 		return
 	}
 
+	// everything else has its own route: roles go through AssignRole, bans
+	// through BanUser, avatars through UploadAvatar, email and password
+	// changes through their confirmation flows, and reputation comes from
+	// votes
+	update := model.UserUpdate{}
 	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&user); err != nil {
-		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+	if err := decoder.Decode(&update); err != nil {
+		RespondError(w, http.StatusBadRequest, "an unknown error has occurred")
 		return
 	}
 	defer r.Body.Close()
 
+	if update.Username != nil && *update.Username != user.Username {
+		username := strings.TrimSpace(*update.Username)
+		if username == "" {
+			RespondError(w, http.StatusBadRequest, "username is required")
+			return
+		}
+		if other, err := claimingUser(db, "username = ? AND id <> ?", username, user.ID); err == nil {
+			RespondError(w, http.StatusConflict, claimedMessage(other, "username"))
+			return
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+			return
+		}
+		user.Username = username
+	}
+	if update.Bio != nil {
+		user.Bio = *update.Bio
+	}
+
 	if err := db.Save(&user).Error; err != nil {
 		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
 		return
 	}
+	if err := search.Index(db, search.TypeUser, user.ID); err != nil {
+		log.Println("ERROR INDEX:", err)
+	}
 	RespondJSON(w, http.StatusOK, user)
 }
 
@@ -161,14 +192,21 @@ func DeleteUser(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *h
 		RespondError(w, http.StatusNotFound, "user not found")
 		return
 	}
-	if err := db.Delete(&user).Error; err != nil {
+	reason, err := trashReason(r)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := db.Model(&model.User{}).Where("id = ?", user.ID).Updates(trashColumns(user.ID, reason)).Error; err != nil {
 		RespondError(w, http.StatusInternalServerError, "")
 		return
 	}
+	db.Where(&model.RefreshToken{UserID: user.ID}).Delete(&model.RefreshToken{})
 	RespondJSON(w, http.StatusNoContent, nil)
 }
 
-func UserLogin(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+func UserLogin(db *gorm.DB, auditor *audit.Auditor, mailer mail.Mailer, w http.ResponseWriter, r *http.Request) {
 	creds := model.LoginCredentials{}
 
 	decoder := json.NewDecoder(r.Body)
@@ -178,28 +216,67 @@ func UserLogin(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *ht
 		return
 	}
 
-	log.Println(creds.Email, creds.Password)
+	limits := readLoginLimits()
+	now := time.Now().UTC()
+	if wait := loginWait(db, limits, "", ratelimit.ClientIP(r), now); wait > 0 {
+		respondLoginWait(w, wait)
+		return
+	}
 
 	user, err := getUserByEmail(db, creds.Email)
 	if err != nil {
-		log.Println("ERROR GET USER:", err)
+		loginFailed(db, auditor, mailer, limits, nil, r)
 		RespondError(w, http.StatusUnauthorized, "invalid username or password")
 		return
 	}
 
-	log.Println(user.Username, user.Email, user.Password)
+	if wait := loginWait(db, limits, user.ID, ratelimit.ClientIP(r), now); wait > 0 {
+		respondLoginWait(w, wait)
+		return
+	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password)); err != nil {
+		loginFailed(db, auditor, mailer, limits, user, r)
 		RespondError(w, http.StatusUnauthorized, "invalid username or password")
 		return
 	}
 
-	token, err := auth.GenerateToken(user)
+	if !user.Active {
+		RespondError(w, http.StatusForbidden, "account disabled")
+		return
+	}
+	if ban := siteBan(db, user.ID); ban != nil {
+		respondBanned(w, ban)
+		return
+	}
+
+	if enabledTwoFactor(db, user.ID) != nil {
+		token, expiresAt, err := issueLoginChallenge(db, user.ID)
+		if err != nil {
+			log.Println("ERROR LOGIN CHALLENGE:", err)
+			RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+			return
+		}
+		RespondJSON(w, http.StatusOK, model.TwoFactorChallenge{
+			TwoFactorRequired: true,
+			ChallengeToken:    token,
+			ExpiresAt:         expiresAt,
+		})
+		return
+	}
+
+	completeLogin(db, auditor, user, w, r)
+}
+
+// completeLogin signs user in once every factor has checked out.
+func completeLogin(db *gorm.DB, auditor *audit.Auditor, user *model.User, w http.ResponseWriter, r *http.Request) {
+	token, refreshToken, err := issueTokens(db, user, "")
 	if err != nil {
 		log.Println("ERROR GENERATE:", err)
 		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
 		return
 	}
+	recordLogin(db, auditor, user.ID, r, true)
 
 	pub, err := publicUser(db, user.ID)
 	if err != nil {
@@ -209,10 +286,14 @@ func UserLogin(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *ht
 	}
 
 	resp := model.LoginResponse{
-		ID:         user.ID,
-		Username:   user.Username,
-		PublicUser: *pub,
-		Token:      token,
+		ID:            user.ID,
+		Username:      user.Username,
+		PublicUser:    *pub,
+		EmailVerified: user.EmailVerified,
+		Token:         token,
+		RefreshToken:  refreshToken,
+
+		TwoFactorSetupRequired: twoFactorRequired(user.Role) && enabledTwoFactor(db, user.ID) == nil,
 	}
 
 	RespondJSON(w, http.StatusOK, resp)
@@ -220,7 +301,7 @@ func UserLogin(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *ht
 
 // TODO: log errors
 
-func UserRegister(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r *http.Request) {
+func UserRegister(db *gorm.DB, auditor *audit.Auditor, mailer mail.Mailer, w http.ResponseWriter, r *http.Request) {
 	creds := model.RegisterCredentials{}
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&creds); err != nil {
@@ -229,6 +310,29 @@ func UserRegister(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r
 	}
 	defer r.Body.Close()
 
+	email, err := normalizeEmail(creds.Email)
+	if err != nil {
+		RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	username := strings.TrimSpace(creds.Username)
+	if username == "" {
+		RespondError(w, http.StatusBadRequest, "username is required")
+		return
+	}
+	for _, claim := range []struct{ what, query, value string }{
+		{"email", "LOWER(email) = ?", email},
+		{"username", "username = ?", username},
+	} {
+		if other, err := claimingUser(db, claim.query, claim.value); err == nil {
+			RespondError(w, http.StatusConflict, claimedMessage(other, claim.what))
+			return
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+			return
+		}
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(creds.Password), 8)
 	if err != nil {
 		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
@@ -242,63 +346,38 @@ func UserRegister(db *gorm.DB, auditor *audit.Auditor, w http.ResponseWriter, r
 	}
 
 	user := model.User{
-		ID:         id.String(),
-		Username:   creds.Username,
-		Email:      creds.Email,
-		Password:   string(hashedPassword),
-		Bio:        "",
-		Reputation: 0,
-		AvatarURL:  "", // will add default later
-		Role:       "user",
-		Active:     true,
-		CreateDate: time.Now().UTC().Format(time.RFC3339),
+		ID:            id.String(),
+		Username:      username,
+		Email:         email,
+		Password:      string(hashedPassword),
+		Bio:           "",
+		Reputation:    0,
+		AvatarURL:     "", // will add default later
+		Role:          "user",
+		Active:        true,
+		EmailVerified: false,
+		CreateDate:    time.Now().UTC().Format(time.RFC3339),
 	}
 
 	if err := db.Save(&user).Error; err != nil {
 		RespondError(w, http.StatusInternalServerError, "")
 		return
 	}
+	if err := search.Index(db, search.TypeUser, user.ID); err != nil {
+		log.Println("ERROR INDEX:", err)
+	}
+	if err := sendVerification(db, mailer, &user); err != nil {
+		log.Println("ERROR VERIFICATION:", err)
+	}
 
 	// TODO: Decide what to return. Also, automatically login after register, or redirect to login page?
 
 	RespondJSON(w, http.StatusOK, "")
 }
 
-func BanUser(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	userCtx := ctx.Value("user")
-
-	reqId := userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"]
-	userRole, err := getUserRoleById(db, fmt.Sprintf("%v", reqId))
-	if err != nil {
-		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
-		return
-	}
-
-	if userRole != "admin" && userRole != "moderator" {
-		RespondError(w, http.StatusUnauthorized, "no access")
-		return
-	}
-
-	vars := mux.Vars(r)
-	userId := vars["userId"]
-
-	user, err := getUserById(db, userId)
-	if err != nil {
-		RespondError(w, http.StatusNotFound, "user not found")
-		return
-	}
-
-	user.Active = false
-
-	if err := db.Save(&user).Error; err != nil {
-		RespondError(w, http.StatusInternalServerError, "")
-		return
-	}
-	RespondJSON(w, http.StatusOK, user)
-}
+const maxAvatarSize = 5 << 20
 
-func UploadAvatar(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+func UploadAvatar(db *gorm.DB, store storage.BlobStore, w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	userCtx := ctx.Value("user")
 
@@ -308,80 +387,85 @@ func UploadAvatar(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	reqId := userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"]
 
 	if reqId != id {
-		log.Println("REQID")
 		RespondError(w, http.StatusUnauthorized, "no access")
 		return
 	}
 
 	user, err := getUserById(db, id)
 	if err != nil {
-		log.Println("GET USER")
 		RespondError(w, http.StatusNotFound, "user not found")
 		return
 	}
 
-	r.ParseMultipartForm(10 << 20)
-
-	file, handler, err := r.FormFile("file")
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarSize+(1<<20))
+	file, _, err := r.FormFile("file")
 	if err != nil {
-		fmt.Println("Error Retrieving the File")
-		fmt.Println(err)
+		RespondError(w, http.StatusBadRequest, "a file is required")
 		return
 	}
-
 	defer file.Close()
 
-	fmt.Printf("Uploaded File: %+v\n", handler.Filename)
-	fmt.Printf("File Size: %+v\n", handler.Size)
-	fmt.Printf("MIME Header: %+v\n", handler.Header)
-
-	//fileBytes, err := ioutil.ReadAll(file)
-
-	key := os.Getenv("SPACES_KEY")
-	secret := os.Getenv("SPACES_SECRET")
-
-	s3Config := &aws.Config{
-		Credentials: credentials.NewStaticCredentials(key, secret, ""),
-		Endpoint:    aws.String("sfo3.digitaloceanspaces.com"),
-		Region:      aws.String("us-east-1"),
+	data, err := storage.ReadLimited(file, maxAvatarSize)
+	if err == storage.ErrTooLarge {
+		RespondError(w, http.StatusRequestEntityTooLarge, "avatar must be at most 5MB")
+		return
 	}
-
-	newSession, err := session.NewSession(s3Config)
 	if err != nil {
-		log.Println("CONNECT TO SPACES")
-		RespondError(w, http.StatusInternalServerError, "an unknown error has occured")
+		RespondError(w, http.StatusBadRequest, "invalid file")
 		return
 	}
-	s3Client := s3.New(newSession)
 
-	files := strings.Split(handler.Filename, ".")
-	ext := files[len(files)-1]
+	// trust the content, not the file name or the type the client sent
+	if !storage.Allowed(storage.DetectContentType(data), storage.ImageTypes) {
+		RespondError(w, http.StatusUnsupportedMediaType, "avatar must be a jpeg, png, gif or webp image")
+		return
+	}
 
-	object := s3.PutObjectInput{
-		Bucket: aws.String("kerrmetric"),
-		Key:    aws.String(user.ID + "." + ext),
-		Body:   file,
-		ACL:    aws.String("public-read"),
-		Metadata: map[string]*string{
-			"x-amz-meta-my-key": aws.String(user.ID),
-		},
+	img, err := storage.DecodeImage(data)
+	if err == storage.ErrImageTooLarge {
+		RespondError(w, http.StatusBadRequest, "avatar dimensions are too large")
+		return
 	}
-	_, err = s3Client.PutObject(&object)
 	if err != nil {
-		log.Println("PUT OBJECT")
-		fmt.Println(err.Error())
+		RespondError(w, http.StatusBadRequest, "invalid image")
+		return
 	}
 
-	url := "https://kerrmetric.sfo3.digitaloceanspaces.com/" + user.ID + "." + ext
+	dir := "avatars/" + user.ID + "/" + uuid.New().String() + "/"
+	keys := []string{}
+	url := ""
+	for _, size := range storage.AvatarSizes {
+		encoded, contentType, err := storage.EncodeImage(storage.Square(img, size))
+		if err != nil {
+			log.Println("ERROR ENCODE AVATAR:", err)
+			RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+			return
+		}
+		key := dir + strconv.Itoa(size) + storage.Extension(contentType)
+		if err := storage.PutBytes(r.Context(), store, key, encoded, contentType); err != nil {
+			log.Println("ERROR STORE AVATAR:", err)
+			deleteObjects(store, keys)
+			RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+			return
+		}
+		keys = append(keys, key)
+		if url == "" {
+			url = store.URL(key)
+		}
+	}
 
+	old := user.AvatarURL
 	user.AvatarURL = url
 
 	if err := db.Save(&user).Error; err != nil {
+		deleteObjects(store, keys)
 		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
 		return
 	}
+	deleteObjects(store, avatarKeys(store, old))
 
-	token, err := auth.GenerateToken(user)
+	sid, _ := userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["sid"].(string)
+	token, err := auth.GenerateToken(user, sid)
 	if err != nil {
 		log.Println("ERROR GENERATE:", err)
 		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
@@ -396,28 +480,55 @@ func UploadAvatar(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	}
 
 	resp := model.LoginResponse{
-		ID:         user.ID,
-		Username:   user.Username,
-		PublicUser: *pub,
-		Token:      token,
+		ID:            user.ID,
+		Username:      user.Username,
+		PublicUser:    *pub,
+		Token:         token,
+		EmailVerified: user.EmailVerified,
 	}
 
 	RespondJSON(w, http.StatusOK, resp)
 }
 
+// avatarKeys lists every stored size of the avatar at url. Avatars hosted
+// outside the store, such as the old hardcoded bucket, are left alone.
+func avatarKeys(store storage.BlobStore, url string) []string {
+	key, ok := storage.KeyFromURL(store, url)
+	if !ok || !strings.HasPrefix(key, "avatars/") {
+		return nil
+	}
+	dir, ext := path.Dir(key), path.Ext(key)
+	keys := []string{}
+	for _, size := range storage.AvatarSizes {
+		keys = append(keys, dir+"/"+strconv.Itoa(size)+ext)
+	}
+	return keys
+}
+
 func CheckRole(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	userCtx := ctx.Value("user")
 
 	reqId := userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"]
 
-	userRole, err := getUserRoleById(db, fmt.Sprintf("%v", reqId))
+	user, err := getUserById(db, fmt.Sprintf("%v", reqId))
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	role, err := getRoleByName(db, user.Role)
 	if err != nil {
 		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
 		return
 	}
 
-	RespondJSON(w, http.StatusOK, map[string]string{"role": userRole})
+	permissions := []string{}
+	for _, p := range role.Permissions {
+		permissions = append(permissions, p.Name)
+	}
+
+	RespondJSON(w, http.StatusOK, map[string]interface{}{"role": role.Name, "permissions": permissions})
 }
 
 func publicUser(db *gorm.DB, userId string) (*model.PublicUser, error) {
@@ -426,7 +537,12 @@ func publicUser(db *gorm.DB, userId string) (*model.PublicUser, error) {
 		return nil, err
 	}
 
-	public := model.PublicUser{
+	public := toPublicUser(&private)
+	return &public, nil
+}
+
+func toPublicUser(private *model.User) model.PublicUser {
+	return model.PublicUser{
 		ID:         private.ID,
 		Username:   private.Username,
 		Bio:        private.Bio,
@@ -435,8 +551,6 @@ func publicUser(db *gorm.DB, userId string) (*model.PublicUser, error) {
 		Role:       private.Role,
 		CreateDate: private.CreateDate,
 	}
-
-	return &public, nil
 }
 
 func getUserById(db *gorm.DB, userId string) (*model.User, error) {
@@ -455,19 +569,35 @@ func getUserByUsername(db *gorm.DB, username string) (*model.User, error) {
 	return &user, nil
 }
 
-func getUserByEmail(db *gorm.DB, email string) (*model.User, error) {
+// getUserByEmail ignores case, matching how normalizeEmail stores
+// addresses. Accounts from before addresses were lowercased still match.
+// claimingUser returns the user, trashed or not, matching query. Trashed
+// users keep their email and username until they are purged, so neither
+// can be taken by anyone else before then.
+func claimingUser(db *gorm.DB, query string, args ...interface{}) (*model.User, error) {
 	user := model.User{}
-	if err := db.Where(&model.User{Email: email}).First(&user).Error; err != nil {
-		//if err := db.Find(&user).Where(&model.User{Email: email}).Error; err != nil {
+	if err := db.Unscoped().Where(query, args...).First(&user).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func getUserRoleById(db *gorm.DB, userId string) (string, error) {
-	user, err := getUserById(db, userId)
-	if err != nil {
-		return "", err
+// claimedMessage explains that what, such as "email", belongs to user.
+func claimedMessage(user *model.User, what string) string {
+	if user.DeletedAt.Valid {
+		return what + " belongs to a deleted account"
 	}
-	return user.Role, nil
+	return what + " already in use"
+}
+
+func getUserByEmail(db *gorm.DB, email string) (*model.User, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	user := model.User{}
+	if err := db.Where("LOWER(email) = ?", email).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
 }
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"forum-server/app/mail"
+	"forum-server/app/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUpdateUserOnlyChangesProfile(t *testing.T) {
+	db := testDB(t)
+	user := testUser(t, db, "user")
+
+	body := map[string]interface{}{
+		"id":             "someone-else",
+		"username":       "renamed",
+		"bio":            "hello",
+		"reputation":     99999,
+		"role":           "admin",
+		"active":         false,
+		"email":          "new@example.com",
+		"email_verified": false,
+		"avatar_url":     "https://evil.example/a.png",
+		"deleted_at":     "2024-01-01T00:00:00Z",
+	}
+	rec := httptest.NewRecorder()
+	UpdateUser(db, testAuditor(db), rec, testRequest(t, "PUT", "/api/user/"+user.ID, body, user, "userId", user.ID))
+	decodeResponse(t, rec, http.StatusOK, nil)
+
+	got := model.User{}
+	if err := db.Where("id = ?", user.ID).First(&got).Error; err != nil {
+		t.Fatalf("user %s gone after the update: %v", user.ID, err)
+	}
+	if got.Username != "renamed" || got.Bio != "hello" {
+		t.Errorf("username, bio = %q, %q, want them updated", got.Username, got.Bio)
+	}
+	if got.Reputation != 0 || got.Role != "user" || !got.Active || got.Email != user.Email || !got.EmailVerified || got.AvatarURL != "" {
+		t.Errorf("update changed fields it shouldn't: %+v", got)
+	}
+	var count int64
+	db.Unscoped().Model(&model.User{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d users after the update, want 1", count)
+	}
+}
+
+func TestUpdateUserKeepsOmittedFields(t *testing.T) {
+	db := testDB(t)
+	user := testUser(t, db, "user")
+	db.Model(user).Update("bio", "kept")
+
+	rec := httptest.NewRecorder()
+	UpdateUser(db, testAuditor(db), rec, testRequest(t, "PUT", "/api/user/"+user.ID, map[string]string{"username": "only-this"}, user, "userId", user.ID))
+	decodeResponse(t, rec, http.StatusOK, nil)
+
+	got := model.User{}
+	db.Where("id = ?", user.ID).First(&got)
+	if got.Username != "only-this" || got.Bio != "kept" {
+		t.Errorf("username, bio = %q, %q, want only-this, kept", got.Username, got.Bio)
+	}
+}
+
+func TestUpdateUserRefusals(t *testing.T) {
+	db := testDB(t)
+	user := testUser(t, db, "user")
+	other := testUser(t, db, "user")
+	admin := testUser(t, db, "admin")
+
+	tests := []struct {
+		name   string
+		caller *model.User
+		body   interface{}
+		status int
+	}{
+		{"someone else", other, map[string]string{"bio": "x"}, http.StatusUnauthorized},
+		{"admin", admin, map[string]string{"bio": "x"}, http.StatusUnauthorized},
+		{"taken username", user, map[string]string{"username": other.Username}, http.StatusConflict},
+		{"blank username", user, map[string]string{"username": "  "}, http.StatusBadRequest},
+		{"not json", user, "bio", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			UpdateUser(db, testAuditor(db), rec, testRequest(t, "PUT", "/api/user/"+user.ID, tt.body, tt.caller, "userId", user.ID))
+			decodeResponse(t, rec, tt.status, nil)
+		})
+	}
+}
+
+func TestRegisterRefusesClaimedEmailAndUsername(t *testing.T) {
+	db := testDB(t)
+	active := testUser(t, db, "user")
+	trashed := testUser(t, db, "user")
+	if err := db.Delete(trashed).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		email    string
+		status   int
+		message  string
+	}{
+		{"active email", "fresh-1", active.Email, http.StatusConflict, "email already in use"},
+		{"active email in capitals", "fresh-2", strings.ToUpper(active.Email), http.StatusConflict, "email already in use"},
+		{"trashed email", "fresh-3", trashed.Email, http.StatusConflict, "email belongs to a deleted account"},
+		{"active username", active.Username, "fresh-4@example.com", http.StatusConflict, "username already in use"},
+		{"trashed username", trashed.Username, "fresh-5@example.com", http.StatusConflict, "username belongs to a deleted account"},
+		{"blank username", " ", "fresh-6@example.com", http.StatusBadRequest, "username is required"},
+		{"free", "fresh-7", "fresh-7@example.com", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := model.RegisterCredentials{Username: tt.username, Email: tt.email, Password: testPassword}
+			rec := httptest.NewRecorder()
+			UserRegister(db, testAuditor(db), &mail.MemoryMailer{}, rec, testRequest(t, "POST", "/api/register", body, nil))
+			if tt.message == "" {
+				decodeResponse(t, rec, tt.status, nil)
+				return
+			}
+			got := map[string]string{}
+			decodeResponse(t, rec, tt.status, &got)
+			if got["error"] != tt.message {
+				t.Errorf("error = %q, want %q", got["error"], tt.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,459 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forum-server/app/config"
+	"forum-server/app/model"
+	"forum-server/app/reputation"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/form3tech-oss/jwt-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+var defaultReactions = []string{"👍", "👎", "❤️", "😂", "🎉", "😮", "😢"}
+
+// voteTarget is the post or comment a vote or reaction is for.
+type voteTarget struct {
+	kind     string
+	id       string
+	authorId string
+	postId   string
+	boardId  string
+	archived bool
+}
+
+func (t *voteTarget) model() interface{} {
+	if t.kind == "post" {
+		return &model.Post{}
+	}
+	return &model.Comment{}
+}
+
+func postTarget(db *gorm.DB, postId string) (*voteTarget, error) {
+	post, err := getPostById(db, postId)
+	if err != nil {
+		return nil, err
+	}
+	return &voteTarget{kind: "post", id: post.ID, authorId: post.AuthorID, postId: post.ID, boardId: post.BoardID, archived: post.Archived}, nil
+}
+
+func commentTarget(db *gorm.DB, commentId string) (*voteTarget, error) {
+	comment, err := getCommentById(db, commentId)
+	if err != nil {
+		return nil, err
+	}
+	if comment.Deleted {
+		return nil, gorm.ErrRecordNotFound
+	}
+	post, err := getPostById(db, comment.PostID)
+	if err != nil {
+		return nil, err
+	}
+	return &voteTarget{kind: "comment", id: comment.ID, authorId: comment.AuthorID, postId: post.ID, boardId: post.BoardID, archived: post.Archived}, nil
+}
+
+func VotePost(db *gorm.DB, pub Publisher, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	target, err := postTarget(db, vars["postId"])
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "post not found")
+		return
+	}
+	castVote(db, pub, target, w, r)
+}
+
+func VoteComment(db *gorm.DB, pub Publisher, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	target, err := commentTarget(db, vars["commentId"])
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "comment not found")
+		return
+	}
+	castVote(db, pub, target, w, r)
+}
+
+// castVote sets the caller's vote on target to 1, -1, or 0 to take it back.
+// Each user has at most one vote per target, so voting again replaces it.
+func castVote(db *gorm.DB, pub Publisher, target *voteTarget, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vote := model.VoteRequest{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&vote); err != nil {
+		RespondError(w, http.StatusBadRequest, "an unknown error has occurred")
+		return
+	}
+	defer r.Body.Close()
+
+	if vote.Value < -1 || vote.Value > 1 {
+		RespondError(w, http.StatusBadRequest, "vote must be 1, -1 or 0")
+		return
+	}
+	if target.authorId == reqId {
+		RespondError(w, http.StatusBadRequest, "cannot vote on your own "+target.kind)
+		return
+	}
+	if target.archived {
+		RespondError(w, http.StatusForbidden, "post is archived")
+		return
+	}
+	if ban := postingBan(db, reqId, target.boardId); ban != nil {
+		respondBanned(w, ban)
+		return
+	}
+	if vote.Value < 0 && !hasReputation(db, reqId, reputation.AbilityVoteDown) {
+		RespondError(w, http.StatusForbidden, fmt.Sprintf("voting down needs %d reputation", reputation.Thresholds[reputation.AbilityVoteDown]))
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		existing := model.Vote{}
+		err := tx.Where(&model.Vote{UserID: reqId, TargetType: target.kind, TargetID: target.id}).First(&existing).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		found := err == nil
+
+		old := existing.Value
+		if old == vote.Value {
+			return nil
+		}
+
+		switch {
+		case vote.Value == 0:
+			err = tx.Delete(&existing).Error
+		case found:
+			err = tx.Model(&existing).Updates(map[string]interface{}{"value": vote.Value, "create_date": time.Now().UTC()}).Error
+		default:
+			voteId, uuidErr := uuid.NewUUID()
+			if uuidErr != nil {
+				return uuidErr
+			}
+			err = tx.Create(&model.Vote{
+				ID:             voteId.String(),
+				UserID:         reqId,
+				TargetType:     target.kind,
+				TargetID:       target.id,
+				TargetAuthorID: target.authorId,
+				Value:          vote.Value,
+				CreateDate:     time.Now().UTC(),
+			}).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(target.model()).Where("id = ?", target.id).Updates(map[string]interface{}{
+			"upvotes":   gorm.Expr("upvotes + ?", upvote(vote.Value)-upvote(old)),
+			"downvotes": gorm.Expr("downvotes + ?", downvote(vote.Value)-downvote(old)),
+			"score":     gorm.Expr("score + ?", vote.Value-old),
+		}).Error
+	})
+	if err != nil {
+		log.Println("ERROR VOTE:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	if target.authorId != "" {
+		if _, err := reputation.Recalculate(db, target.authorId); err != nil {
+			log.Println("ERROR REPUTATION:", err)
+		}
+	}
+
+	result := model.VoteResult{Vote: vote.Value}
+	if err := db.Model(target.model()).Select("upvotes, downvotes, score").Where("id = ?", target.id).Scan(&result).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	pub.Publish(PostChannel(target.postId), EventVoteUpdated, map[string]interface{}{
+		"target_type": target.kind,
+		"target_id":   target.id,
+		"upvotes":     result.Upvotes,
+		"downvotes":   result.Downvotes,
+		"score":       result.Score,
+	})
+	RespondJSON(w, http.StatusOK, result)
+}
+
+func upvote(value int) int {
+	if value > 0 {
+		return 1
+	}
+	return 0
+}
+
+func downvote(value int) int {
+	if value < 0 {
+		return 1
+	}
+	return 0
+}
+
+// hasReputation reports whether a user's reputation unlocks ability.
+func hasReputation(db *gorm.DB, userId, ability string) bool {
+	user, err := getUserById(db, userId)
+	if err != nil {
+		return false
+	}
+	return reputation.Allows(user.Reputation, ability)
+}
+
+// GetReputationRules lists what votes are worth and the reputation each
+// ability needs.
+func GetReputationRules(w http.ResponseWriter, r *http.Request) {
+	RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"weights":    reputation.LoadWeights(),
+		"thresholds": reputation.Thresholds,
+	})
+}
+
+// allowedReactions is the set of emoji users can react with, read as a
+// comma separated list from REACTIONS.
+func allowedReactions() []string {
+	reactions := config.List("REACTIONS", "")
+	if len(reactions) == 0 {
+		return defaultReactions
+	}
+	return reactions
+}
+
+func GetAllowedReactions(w http.ResponseWriter, r *http.Request) {
+	RespondJSON(w, http.StatusOK, allowedReactions())
+}
+
+func GetPostReactions(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	target, err := postTarget(db, vars["postId"])
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "post not found")
+		return
+	}
+	respondReactions(db, target, optionalUserID(db, r), w)
+}
+
+func GetCommentReactions(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	target, err := commentTarget(db, vars["commentId"])
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "comment not found")
+		return
+	}
+	respondReactions(db, target, optionalUserID(db, r), w)
+}
+
+func AddPostReaction(db *gorm.DB, pub Publisher, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	target, err := postTarget(db, vars["postId"])
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "post not found")
+		return
+	}
+	addReaction(db, pub, target, w, r)
+}
+
+func AddCommentReaction(db *gorm.DB, pub Publisher, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	target, err := commentTarget(db, vars["commentId"])
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "comment not found")
+		return
+	}
+	addReaction(db, pub, target, w, r)
+}
+
+func RemovePostReaction(db *gorm.DB, pub Publisher, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	target, err := postTarget(db, vars["postId"])
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "post not found")
+		return
+	}
+	removeReaction(db, pub, target, w, r)
+}
+
+func RemoveCommentReaction(db *gorm.DB, pub Publisher, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	target, err := commentTarget(db, vars["commentId"])
+	if err != nil {
+		RespondError(w, http.StatusNotFound, "comment not found")
+		return
+	}
+	removeReaction(db, pub, target, w, r)
+}
+
+func addReaction(db *gorm.DB, pub Publisher, target *voteTarget, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	reaction := model.ReactionRequest{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&reaction); err != nil {
+		RespondError(w, http.StatusBadRequest, "an unknown error has occurred")
+		return
+	}
+	defer r.Body.Close()
+
+	if !contains(allowedReactions(), reaction.Emoji) {
+		RespondError(w, http.StatusBadRequest, "reaction not allowed")
+		return
+	}
+	if target.archived {
+		RespondError(w, http.StatusForbidden, "post is archived")
+		return
+	}
+	if ban := postingBan(db, reqId, target.boardId); ban != nil {
+		respondBanned(w, ban)
+		return
+	}
+
+	existing := model.Reaction{UserID: reqId, TargetType: target.kind, TargetID: target.id, Emoji: reaction.Emoji}
+	err := db.Where(&existing).First(&model.Reaction{}).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		reactionId, uuidErr := uuid.NewUUID()
+		if uuidErr != nil {
+			RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+			return
+		}
+		existing.ID = reactionId.String()
+		existing.CreateDate = time.Now().UTC()
+		err = db.Create(&existing).Error
+	}
+	if err != nil {
+		log.Println("ERROR REACTION:", err)
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	publishReactions(db, pub, target)
+	respondReactions(db, target, reqId, w)
+}
+
+func removeReaction(db *gorm.DB, pub Publisher, target *voteTarget, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userCtx := ctx.Value("user")
+
+	reqId := fmt.Sprintf("%v", userCtx.(*jwt.Token).Claims.(jwt.MapClaims)["id"])
+
+	vars := mux.Vars(r)
+	emoji := vars["emoji"]
+
+	if err := db.Where(&model.Reaction{UserID: reqId, TargetType: target.kind, TargetID: target.id, Emoji: emoji}).Delete(&model.Reaction{}).Error; err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+
+	publishReactions(db, pub, target)
+	respondReactions(db, target, reqId, w)
+}
+
+func publishReactions(db *gorm.DB, pub Publisher, target *voteTarget) {
+	counts, err := reactionCounts(db, target, "")
+	if err != nil {
+		log.Println("ERROR REACTIONS:", err)
+		return
+	}
+	pub.Publish(PostChannel(target.postId), EventReactionUpdated, map[string]interface{}{
+		"target_type": target.kind,
+		"target_id":   target.id,
+		"reactions":   counts,
+	})
+}
+
+func respondReactions(db *gorm.DB, target *voteTarget, userId string, w http.ResponseWriter) {
+	counts, err := reactionCounts(db, target, userId)
+	if err != nil {
+		RespondError(w, http.StatusInternalServerError, "an unknown error has occurred")
+		return
+	}
+	RespondJSON(w, http.StatusOK, counts)
+}
+
+// reactionCounts totals the reactions on target in the order of the allowed
+// set, followed by any left from before the set was changed. Reacted marks
+// the ones userId left.
+func reactionCounts(db *gorm.DB, target *voteTarget, userId string) ([]model.ReactionCount, error) {
+	rows := []model.ReactionCount{}
+	if err := db.Model(&model.Reaction{}).Select("emoji, count(*) AS count").
+		Where("target_type = ? AND target_id = ?", target.kind, target.id).
+		Group("emoji").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	mine := []string{}
+	if userId != "" {
+		if err := db.Model(&model.Reaction{}).Where("user_id = ? AND target_type = ? AND target_id = ?", userId, target.kind, target.id).
+			Pluck("emoji", &mine).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	byEmoji := map[string]model.ReactionCount{}
+	for _, row := range rows {
+		row.Reacted = contains(mine, row.Emoji)
+		byEmoji[row.Emoji] = row
+	}
+
+	counts := []model.ReactionCount{}
+	for _, emoji := range allowedReactions() {
+		if row, ok := byEmoji[emoji]; ok {
+			counts = append(counts, row)
+			delete(byEmoji, emoji)
+		}
+	}
+	for _, row := range rows {
+		if _, ok := byEmoji[row.Emoji]; ok {
+			counts = append(counts, byEmoji[row.Emoji])
+		}
+	}
+	return counts, nil
+}
+
+// deleteVotes removes the votes and reactions on the given targets and
+// recomputes the reputation of everyone who had received those votes.
+func deleteVotes(db *gorm.DB, targetType string, targetIds []string) error {
+	if len(targetIds) == 0 {
+		return nil
+	}
+
+	authors := []string{}
+	if err := db.Model(&model.Vote{}).Distinct("target_author_id").
+		Where("target_type = ? AND target_id IN ?", targetType, targetIds).
+		Pluck("target_author_id", &authors).Error; err != nil {
+		return err
+	}
+	if err := db.Where("target_type = ? AND target_id IN ?", targetType, targetIds).Delete(&model.Vote{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("target_type = ? AND target_id IN ?", targetType, targetIds).Delete(&model.Reaction{}).Error; err != nil {
+		return err
+	}
+
+	for _, author := range authors {
+		if _, err := reputation.Recalculate(db, author); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"forum-server/app/model"
+	"forum-server/app/reputation"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func votePost(t *testing.T, db *gorm.DB, post *model.Post, voter *model.User, value, status int) model.VoteResult {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	VotePost(db, &testPublisher{}, rec, testRequest(t, "PUT", "/api/posts/"+post.ID+"/vote", model.VoteRequest{Value: value}, voter, "postId", post.ID))
+	result := model.VoteResult{}
+	decodeResponse(t, rec, status, &result)
+	return result
+}
+
+func reputationOf(db *gorm.DB, user *model.User) int {
+	got := model.User{}
+	db.Where("id = ?", user.ID).First(&got)
+	return got.Reputation
+}
+
+func TestVotes(t *testing.T) {
+	db := testDB(t)
+	author := testUser(t, db, "user")
+	voter := testUser(t, db, "user")
+	trusted := testUser(t, db, "user")
+	db.Model(trusted).Update("reputation", reputation.Thresholds[reputation.AbilityVoteDown])
+	post := testPost(t, db, testBoard(t, db, "board"), author, "post")
+	weights := reputation.LoadWeights()
+
+	votePost(t, db, post, author, 1, http.StatusBadRequest)
+	votePost(t, db, post, voter, 2, http.StatusBadRequest)
+	votePost(t, db, post, voter, -1, http.StatusForbidden)
+
+	if got := votePost(t, db, post, voter, 1, http.StatusOK); got != (model.VoteResult{Score: 1, Upvotes: 1, Vote: 1}) {
+		t.Errorf("upvote = %+v", got)
+	}
+	if got := votePost(t, db, post, voter, 1, http.StatusOK); got.Score != 1 {
+		t.Errorf("voting the same way twice counted twice: %+v", got)
+	}
+	if got := reputationOf(db, author); got != weights.PostUpvote {
+		t.Errorf("author reputation = %d, want %d", got, weights.PostUpvote)
+	}
+
+	if got := votePost(t, db, post, trusted, -1, http.StatusOK); got != (model.VoteResult{Score: 0, Upvotes: 1, Downvotes: 1, Vote: -1}) {
+		t.Errorf("downvote = %+v", got)
+	}
+	if got := votePost(t, db, post, voter, 0, http.StatusOK); got != (model.VoteResult{Score: -1, Downvotes: 1}) {
+		t.Errorf("retracted vote = %+v", got)
+	}
+	if got := reputationOf(db, author); got != 0 {
+		t.Errorf("author reputation after losing the upvote = %d, want 0", got)
+	}
+
+	db.Model(post).Update("archived", true)
+	votePost(t, db, post, voter, 1, http.StatusForbidden)
+}
+
+func TestVoteOnComment(t *testing.T) {
+	db := testDB(t)
+	author := testUser(t, db, "user")
+	voter := testUser(t, db, "user")
+	post := testPost(t, db, testBoard(t, db, "board"), voter, "post")
+	comment := testComment(t, db, post, author, nil, "comment")
+
+	vote := func(commentId string, status int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		VoteComment(db, &testPublisher{}, rec, testRequest(t, "PUT", "/api/posts/comments/"+commentId+"/vote", model.VoteRequest{Value: 1}, voter, "commentId", commentId))
+		decodeResponse(t, rec, status, nil)
+	}
+	vote("missing", http.StatusNotFound)
+	vote(comment.ID, http.StatusOK)
+	if got := reputationOf(db, author); got != reputation.LoadWeights().CommentUpvote {
+		t.Errorf("comment author reputation = %d", got)
+	}
+}
+
+func TestReactions(t *testing.T) {
+	t.Setenv("REACTIONS", "👍,🎉")
+	db := testDB(t)
+	author := testUser(t, db, "user")
+	other := testUser(t, db, "user")
+	post := testPost(t, db, testBoard(t, db, "board"), author, "post")
+
+	react := func(user *model.User, emoji string, status int) []model.ReactionCount {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		AddPostReaction(db, &testPublisher{}, rec, testRequest(t, "POST", "/api/posts/"+post.ID+"/reactions", model.ReactionRequest{Emoji: emoji}, user, "postId", post.ID))
+		if status != http.StatusOK {
+			decodeResponse(t, rec, status, nil)
+			return nil
+		}
+		counts := []model.ReactionCount{}
+		decodeResponse(t, rec, status, &counts)
+		return counts
+	}
+	unreact := func(user *model.User, emoji string) []model.ReactionCount {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		RemovePostReaction(db, &testPublisher{}, rec, testRequest(t, "DELETE", "/api/posts/"+post.ID+"/reactions/"+emoji, nil, user, "postId", post.ID, "emoji", emoji))
+		counts := []model.ReactionCount{}
+		decodeResponse(t, rec, http.StatusOK, &counts)
+		return counts
+	}
+
+	react(author, "❤️", http.StatusBadRequest)
+	react(author, "🎉", http.StatusOK)
+	react(author, "🎉", http.StatusOK)
+	got := react(other, "👍", http.StatusOK)
+	want := []model.ReactionCount{{Emoji: "👍", Count: 1, Reacted: true}, {Emoji: "🎉", Count: 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("reactions = %+v, want %+v", got, want)
+	}
+
+	// removing only ever takes away the caller's own reaction
+	if got := unreact(other, "🎉"); len(got) != 2 {
+		t.Errorf("someone else removed the author's reaction: %+v", got)
+	}
+	if got := unreact(author, "🎉"); !reflect.DeepEqual(got, []model.ReactionCount{{Emoji: "👍", Count: 1}}) {
+		t.Errorf("reactions after removing = %+v", got)
+	}
+
+	db.Model(post).Update("archived", true)
+	react(other, "🎉", http.StatusForbidden)
+}
+
+func TestReputationUnlocksEditing(t *testing.T) {
+	db := testDB(t)
+	author := testUser(t, db, "user")
+	editor := testUser(t, db, "user")
+	post := testPost(t, db, testBoard(t, db, "board"), author, "post")
+
+	edit := model.PostEdit{Title: "post", Content: "tidied up"}
+	editPostAs(t, db, post, editor, edit, http.StatusUnauthorized)
+	db.Model(editor).Update("reputation", reputation.Thresholds[reputation.AbilityEditOthers])
+	editPostAs(t, db, post, editor, edit, http.StatusOK)
+}
@@ -0,0 +1,345 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"forum-server/app/auth"
+	"forum-server/app/handler"
+	"forum-server/app/ratelimit"
+	"forum-server/audit"
+
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+)
+
+const (
+	writeWait        = 10 * time.Second
+	pongWait         = 60 * time.Second
+	pingPeriod       = (pongWait * 9) / 10
+	maxInboundSize   = 4096
+	maxSubscriptions = 100
+	sendBufferSize   = 64
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// origins are already open to everyone through the CORS handler
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// Hub fans realtime events out to websocket clients subscribed to channels
+// and hosts the global chat room.
+type Hub struct {
+	DB      *gorm.DB
+	Auditor *audit.Auditor
+	// Limiter throttles chat messages per user. Chat isn't limited when it
+	// is nil.
+	Limiter *ratelimit.Limiter
+
+	mu       sync.RWMutex
+	channels map[string]map[*client]bool
+}
+
+type client struct {
+	hub       *Hub
+	conn      *websocket.Conn
+	send      chan []byte
+	closed    bool
+	userID    string
+	role      string
+	sessionID string
+	expiresAt int64
+	channels  map[string]bool
+}
+
+type inboundMessage struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+	Content string `json:"content"`
+}
+
+type outboundMessage struct {
+	Type    string      `json:"type"`
+	Channel string      `json:"channel,omitempty"`
+	Event   string      `json:"event,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+func NewHub(db *gorm.DB, auditor *audit.Auditor, limiter *ratelimit.Limiter) *Hub {
+	return &Hub{
+		DB:       db,
+		Auditor:  auditor,
+		Limiter:  limiter,
+		channels: map[string]map[*client]bool{},
+	}
+}
+
+// Publish sends an event to every client subscribed to channel. Clients that
+// can't keep up are disconnected rather than allowed to block the sender.
+func (h *Hub) Publish(channel, event string, data interface{}) {
+	message, err := json.Marshal(outboundMessage{Type: "event", Channel: channel, Event: event, Data: data})
+	if err != nil {
+		log.Println("ERROR PUBLISH:", err)
+		return
+	}
+
+	slow := []*client{}
+	h.mu.RLock()
+	for c := range h.channels[channel] {
+		select {
+		case c.send <- message:
+		default:
+			slow = append(slow, c)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, c := range slow {
+		h.unregister(c)
+	}
+}
+
+// ServeWS upgrades an authenticated request to a websocket. Browsers can't
+// set headers on websocket requests, so the token may also be passed in the
+// token query parameter.
+func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
+	tokenString := r.URL.Query().Get("token")
+	if header := r.Header.Get("Authorization"); tokenString == "" && strings.HasPrefix(header, "Bearer ") {
+		tokenString = strings.TrimPrefix(header, "Bearer ")
+	}
+
+	claims, err := auth.ParseToken(tokenString)
+	if err != nil {
+		handler.RespondError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+	if _, sessionErr := handler.CheckSession(h.DB, claims.ID, claims.Role, claims.SessionID); sessionErr != nil {
+		handler.RespondError(w, sessionErr.Status, sessionErr.Message)
+		return
+	}
+	if sessionErr := handler.CheckTwoFactor(h.DB, claims.ID, claims.Role, r.URL.Path); sessionErr != nil {
+		handler.RespondError(w, sessionErr.Status, sessionErr.Message)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("ERROR UPGRADE:", err)
+		return
+	}
+
+	c := &client{
+		hub:       h,
+		conn:      conn,
+		send:      make(chan []byte, sendBufferSize),
+		userID:    claims.ID,
+		role:      claims.Role,
+		sessionID: claims.SessionID,
+		expiresAt: claims.ExpiresAt,
+		channels:  map[string]bool{},
+	}
+
+	go c.writePump()
+	c.readPump()
+}
+
+func (h *Hub) subscribe(c *client, channel string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c.closed {
+		return
+	}
+	if h.channels[channel] == nil {
+		h.channels[channel] = map[*client]bool{}
+	}
+	h.channels[channel][c] = true
+	c.channels[channel] = true
+}
+
+func (h *Hub) unsubscribe(c *client, channel string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeFromChannel(c, channel)
+}
+
+func (h *Hub) unregister(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c.closed {
+		return
+	}
+	for channel := range c.channels {
+		h.removeFromChannel(c, channel)
+	}
+	c.closed = true
+	close(c.send)
+}
+
+func (h *Hub) removeFromChannel(c *client, channel string) {
+	delete(c.channels, channel)
+	delete(h.channels[channel], c)
+	if len(h.channels[channel]) == 0 {
+		delete(h.channels, channel)
+	}
+}
+
+// canSubscribe reports whether the client may listen on channel. User
+// channels are private to their user.
+func (c *client) canSubscribe(channel string) bool {
+	if channel == handler.ChatChannel {
+		return true
+	}
+	parts := strings.SplitN(channel, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return false
+	}
+	switch parts[0] {
+	case "board", "post":
+		return true
+	case "user":
+		return parts[1] == c.userID
+	}
+	return false
+}
+
+func (c *client) readPump() {
+	defer func() {
+		c.hub.unregister(c)
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(maxInboundSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		msg := inboundMessage{}
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Println("ERROR WEBSOCKET:", err)
+			}
+			return
+		}
+		c.handle(msg)
+	}
+}
+
+func (c *client) handle(msg inboundMessage) {
+	switch msg.Type {
+	case "subscribe":
+		if !c.canSubscribe(msg.Channel) {
+			c.reply(outboundMessage{Type: "error", Channel: msg.Channel, Error: "no access"})
+			return
+		}
+		c.hub.mu.RLock()
+		count := len(c.channels)
+		c.hub.mu.RUnlock()
+		if count >= maxSubscriptions {
+			c.reply(outboundMessage{Type: "error", Channel: msg.Channel, Error: "too many subscriptions"})
+			return
+		}
+		c.hub.subscribe(c, msg.Channel)
+		c.reply(outboundMessage{Type: "subscribed", Channel: msg.Channel})
+
+		if msg.Channel == handler.ChatChannel {
+			messages, err := handler.ChatBackfill(c.hub.DB)
+			if err != nil {
+				c.reply(outboundMessage{Type: "error", Channel: msg.Channel, Error: "an unknown error has occurred"})
+				return
+			}
+			c.reply(outboundMessage{Type: "backfill", Channel: msg.Channel, Data: messages})
+		}
+	case "unsubscribe":
+		c.hub.unsubscribe(c, msg.Channel)
+		c.reply(outboundMessage{Type: "unsubscribed", Channel: msg.Channel})
+	case "chat":
+		if c.hub.Limiter != nil {
+			if res := c.hub.Limiter.Allow(ratelimit.PolicyChat, c.userID, c.role); !res.Allowed {
+				c.reply(outboundMessage{Type: "error", Channel: handler.ChatChannel, Error: fmt.Sprintf("too many messages, try again in %d seconds", res.RetryAfterSeconds())})
+				return
+			}
+		}
+		message, err := handler.SaveChatMessage(c.hub.DB, c.userID, msg.Content)
+		if err != nil {
+			switch err {
+			case handler.ErrChatMuted, handler.ErrChatEmpty, handler.ErrChatMessageTooLong, handler.ErrChatUnverified, handler.ErrChatBanned:
+				c.reply(outboundMessage{Type: "error", Channel: handler.ChatChannel, Error: err.Error()})
+			default:
+				c.reply(outboundMessage{Type: "error", Channel: handler.ChatChannel, Error: "an unknown error has occurred"})
+			}
+			return
+		}
+		c.hub.Publish(handler.ChatChannel, handler.EventChatMessage, message)
+	default:
+		c.reply(outboundMessage{Type: "error", Error: "unknown message type"})
+	}
+}
+
+// reply queues a message for this client only.
+func (c *client) reply(msg outboundMessage) {
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	c.hub.mu.RLock()
+	defer c.hub.mu.RUnlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.send <- encoded:
+	default:
+	}
+}
+
+// writePump delivers queued messages and pings the client. On every ping the
+// session is checked again so expired, revoked or banned sessions, and
+// clients whose role now needs two-factor they haven't set up, are dropped.
+func (c *client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if time.Now().Unix() > c.expiresAt {
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "token expired"))
+				return
+			}
+			if _, err := handler.CheckSession(c.hub.DB, c.userID, c.role, c.sessionID); err != nil {
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Message))
+				return
+			}
+			if err := handler.CheckTwoFactor(c.hub.DB, c.userID, c.role, "/ws"); err != nil {
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Message))
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
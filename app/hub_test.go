@@ -0,0 +1,78 @@
+package app
+
+import (
+	"encoding/json"
+	"forum-server/app/handler"
+	"forum-server/app/model"
+	"forum-server/app/ratelimit"
+	"forum-server/db"
+	"testing"
+	"time"
+)
+
+func TestChatIsRateLimited(t *testing.T) {
+	store, err := db.OpenEmbedded("file:hub_chat?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if sqlDB, err := store.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}()
+	user := model.User{ID: "chatter", Username: "chatter", Email: "chatter@example.com", Role: "user", Active: true, EmailVerified: true}
+	if err := store.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	limiter := &ratelimit.Limiter{
+		Store:    ratelimit.NewMemoryStore(),
+		Policies: map[string]ratelimit.Limit{ratelimit.PolicyChat: {Requests: 2, Period: time.Minute}},
+	}
+	hub := NewHub(store, nil, limiter)
+	c := &client{hub: hub, send: make(chan []byte, sendBufferSize), userID: user.ID, role: user.Role, channels: map[string]bool{}}
+
+	for i := 0; i < 3; i++ {
+		c.handle(inboundMessage{Type: "chat", Content: "hello"})
+	}
+
+	var saved int64
+	store.Model(&model.ChatMessage{}).Count(&saved)
+	if saved != 2 {
+		t.Errorf("%d messages saved, want 2", saved)
+	}
+	select {
+	case raw := <-c.send:
+		reply := outboundMessage{}
+		if err := json.Unmarshal(raw, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.Type != "error" || reply.Channel != handler.ChatChannel || reply.Error == "" {
+			t.Errorf("reply = %+v, want a chat error", reply)
+		}
+	default:
+		t.Error("refused message got no reply")
+	}
+}
+
+func TestCanSubscribe(t *testing.T) {
+	c := &client{userID: "me"}
+	tests := []struct {
+		channel string
+		want    bool
+	}{
+		{handler.ChatChannel, true},
+		{handler.BoardChannel("b1"), true},
+		{handler.PostChannel("p1"), true},
+		{handler.UserChannel("me"), true},
+		{handler.UserChannel("someone-else"), false},
+		{"user:", false},
+		{"board", false},
+		{"admin:everything", false},
+	}
+	for _, tt := range tests {
+		if got := c.canSubscribe(tt.channel); got != tt.want {
+			t.Errorf("canSubscribe(%q) = %v, want %v", tt.channel, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package mail
+
+import (
+	"forum-server/app/config"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers a rendered message.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// New returns the mailer selected by MAIL_DRIVER: "smtp", "memory" or, by
+// default, "file", which writes messages to MAIL_DIR instead of sending them.
+func New() Mailer {
+	from := config.String("MAIL_FROM", "no-reply@localhost")
+
+	switch config.String("MAIL_DRIVER", "file") {
+	case "smtp":
+		return &SMTPMailer{
+			Host:     config.String("SMTP_HOST", ""),
+			Port:     config.Int("SMTP_PORT", 587),
+			Username: config.String("SMTP_USERNAME", ""),
+			Password: config.String("SMTP_PASSWORD", ""),
+			From:     from,
+		}
+	case "memory":
+		return &MemoryMailer{}
+	}
+
+	return &FileMailer{Dir: config.String("MAIL_DIR", "mail"), From: from}
+}
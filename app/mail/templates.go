@@ -0,0 +1,138 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+const (
+	TemplateVerifyEmail   = "verify_email"
+	TemplateResetPassword = "reset_password"
+	TemplateConfirmEmail  = "confirm_email"
+	TemplateEmailChanged  = "email_changed"
+	TemplateAccountLocked = "account_locked"
+	TemplateTwoFactorOff  = "two_factor_off"
+)
+
+// Data is what every template is rendered with.
+type Data struct {
+	Username string
+	Email    string
+	Link     string
+}
+
+type template struct {
+	subject string
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+var templates = map[string]template{
+	TemplateVerifyEmail: {
+		subject: "Verify your email address",
+		text: texttemplate.Must(texttemplate.New("").Parse(`Hi {{.Username}},
+
+Please confirm that {{.Email}} is your email address by opening the link below.
+
+{{.Link}}
+
+If you didn't create an account you can ignore this email.
+`)),
+		html: htmltemplate.Must(htmltemplate.New("").Parse(`<p>Hi {{.Username}},</p>
+<p>Please confirm that {{.Email}} is your email address.</p>
+<p><a href="{{.Link}}">Verify email address</a></p>
+<p>If you didn't create an account you can ignore this email.</p>
+`)),
+	},
+	TemplateResetPassword: {
+		subject: "Reset your password",
+		text: texttemplate.Must(texttemplate.New("").Parse(`Hi {{.Username}},
+
+Someone asked to reset the password for your account. Open the link below to choose a new one. It expires in an hour and can only be used once.
+
+{{.Link}}
+
+If it wasn't you, you can ignore this email and your password won't change.
+`)),
+		html: htmltemplate.Must(htmltemplate.New("").Parse(`<p>Hi {{.Username}},</p>
+<p>Someone asked to reset the password for your account. The link below expires in an hour and can only be used once.</p>
+<p><a href="{{.Link}}">Reset password</a></p>
+<p>If it wasn't you, you can ignore this email and your password won't change.</p>
+`)),
+	},
+	TemplateConfirmEmail: {
+		subject: "Confirm your new email address",
+		text: texttemplate.Must(texttemplate.New("").Parse(`Hi {{.Username}},
+
+Open the link below to start using {{.Email}} for your account.
+
+{{.Link}}
+`)),
+		html: htmltemplate.Must(htmltemplate.New("").Parse(`<p>Hi {{.Username}},</p>
+<p>Confirm that you want to use {{.Email}} for your account.</p>
+<p><a href="{{.Link}}">Confirm email address</a></p>
+`)),
+	},
+	TemplateEmailChanged: {
+		subject: "Your email address is being changed",
+		text: texttemplate.Must(texttemplate.New("").Parse(`Hi {{.Username}},
+
+Someone asked to change the email address on your account to {{.Email}}. If this wasn't you, reset your password straight away.
+`)),
+		html: htmltemplate.Must(htmltemplate.New("").Parse(`<p>Hi {{.Username}},</p>
+<p>Someone asked to change the email address on your account to {{.Email}}. If this wasn't you, reset your password straight away.</p>
+`)),
+	},
+	TemplateAccountLocked: {
+		subject: "Your account has been locked",
+		text: texttemplate.Must(texttemplate.New("").Parse(`Hi {{.Username}},
+
+There have been too many failed attempts to sign in to your account, so it has been locked for a while. You'll be able to sign in again once the lock wears off.
+
+If this wasn't you, someone may be trying to guess your password. You can choose a new one from the link below. It expires in an hour.
+
+{{.Link}}
+`)),
+		html: htmltemplate.Must(htmltemplate.New("").Parse(`<p>Hi {{.Username}},</p>
+<p>There have been too many failed attempts to sign in to your account, so it has been locked for a while. You'll be able to sign in again once the lock wears off.</p>
+<p>If this wasn't you, someone may be trying to guess your password. The link below expires in an hour.</p>
+<p><a href="{{.Link}}">Choose a new password</a></p>
+`)),
+	},
+	TemplateTwoFactorOff: {
+		subject: "Two-factor authentication has been turned off",
+		text: texttemplate.Must(texttemplate.New("").Parse(`Hi {{.Username}},
+
+Two-factor authentication has been turned off for your account, and you have been signed out everywhere. If this wasn't you or a moderator you asked, reset your password straight away.
+`)),
+		html: htmltemplate.Must(htmltemplate.New("").Parse(`<p>Hi {{.Username}},</p>
+<p>Two-factor authentication has been turned off for your account, and you have been signed out everywhere. If this wasn't you or a moderator you asked, reset your password straight away.</p>
+`)),
+	},
+}
+
+// Render builds the message for the named template.
+func Render(name, to string, data Data) (Message, error) {
+	t, ok := templates[name]
+	if !ok {
+		return Message{}, fmt.Errorf("unknown mail template %q", name)
+	}
+
+	text := bytes.Buffer{}
+	if err := t.text.Execute(&text, data); err != nil {
+		return Message{}, err
+	}
+	html := bytes.Buffer{}
+	if err := t.html.Execute(&html, data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		To:      to,
+		Subject: t.subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
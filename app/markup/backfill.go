@@ -0,0 +1,86 @@
+package markup
+
+import (
+	"errors"
+	"fmt"
+	"forum-server/app/model"
+	"forum-server/audit"
+	"forum-server/db"
+
+	"gorm.io/gorm"
+)
+
+const (
+	renderUsage    = "usage: forum-server render [--all]"
+	backfillBatch  = 200
+	renderedFormat = "rendered %d posts and %d comments"
+)
+
+// RunRenderCommand handles the render subcommand, given the arguments that
+// follow it. It fills in content_html for rows that don't have it yet, or
+// re-renders every row with --all, e.g. after changing MARKUP_MATH.
+func RunRenderCommand(auditor *audit.Auditor, args []string) error {
+	all := false
+	for _, arg := range args {
+		if arg != "--all" {
+			return errors.New(renderUsage)
+		}
+		all = true
+	}
+
+	conn := db.Open(auditor)
+	posts, err := backfillPosts(conn, all)
+	if err != nil {
+		auditor.Log("", "Render Content", "Error", err.Error())
+		return err
+	}
+	comments, err := backfillComments(conn, all)
+	if err != nil {
+		auditor.Log("", "Render Content", "Error", err.Error())
+		return err
+	}
+
+	auditor.Log("", "Render Content", "Success", fmt.Sprintf(renderedFormat, posts, comments))
+	fmt.Printf(renderedFormat+"\n", posts, comments)
+	return nil
+}
+
+func backfillPosts(conn *gorm.DB, all bool) (int, error) {
+	query := conn.Model(&model.Post{})
+	if !all {
+		query = query.Where("content_html = ? OR content_html IS NULL", "")
+	}
+
+	count := 0
+	posts := []model.Post{}
+	err := query.FindInBatches(&posts, backfillBatch, func(tx *gorm.DB, batch int) error {
+		for _, post := range posts {
+			if err := conn.Model(&model.Post{}).Where("id = ?", post.ID).UpdateColumn("content_html", Render(post.Content)).Error; err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	}).Error
+	return count, err
+}
+
+func backfillComments(conn *gorm.DB, all bool) (int, error) {
+	query := conn.Model(&model.Comment{})
+	if !all {
+		query = query.Where("content_html = ? OR content_html IS NULL", "")
+	}
+
+	count := 0
+	comments := []model.Comment{}
+	err := query.FindInBatches(&comments, backfillBatch, func(tx *gorm.DB, batch int) error {
+		for _, comment := range comments {
+			if err := conn.Model(&model.Comment{}).Where("id = ?", comment.ID).UpdateColumn("content_html", Render(comment.Content)).Error; err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	}).Error
+	return count, err
+}
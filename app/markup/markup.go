@@ -0,0 +1,111 @@
+package markup
+
+import (
+	"bytes"
+	"forum-server/app/config"
+	"html"
+	"log"
+	"regexp"
+	"sync"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+)
+
+var (
+	markdownOnce sync.Once
+	markdown     goldmark.Markdown
+)
+
+var policy = newPolicy()
+
+// converter renders CommonMark with the GitHub extensions for tables,
+// strikethrough, task lists and bare links, plus TeX math. Raw HTML in the
+// source is left out rather than passed through. It is built on first use
+// so MARKUP_MATH is read after the environment has been loaded.
+func converter() goldmark.Markdown {
+	markdownOnce.Do(func() {
+		mode := MathTeX
+		if config.String("MARKUP_MATH", MathTeX) == MathML {
+			mode = MathML
+		}
+		markdown = goldmark.New(
+			goldmark.WithExtensions(
+				extension.Table,
+				extension.Strikethrough,
+				extension.TaskList,
+				extension.Linkify,
+				&mathExtension{mode: mode},
+			),
+			goldmark.WithRendererOptions(
+				gmhtml.WithXHTML(),
+			),
+		)
+	})
+	return markdown
+}
+
+// newPolicy allows the elements markdown and math produce and nothing else.
+// Fenced code keeps its language-* class so clients can highlight it, and
+// links are marked nofollow and opened in a new tab.
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements(
+		"p", "br", "hr", "blockquote",
+		"h1", "h2", "h3", "h4", "h5", "h6",
+		"ul", "ol", "li",
+		"strong", "em", "del", "code", "pre",
+		"table", "thead", "tbody", "tr", "th", "td",
+	)
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowAttrs("align").Matching(regexp.MustCompile(`^(left|center|right)$`)).OnElements("th", "td")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#.-]+$`)).OnElements("code")
+
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^(|checked|disabled)$`)).OnElements("input")
+
+	p.AllowElements("span", "div")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^math math-(inline|display)$`)).OnElements("span", "div")
+	p.AllowNoAttrs().OnElements(
+		"math", "semantics", "annotation", "mrow", "mi", "mn", "mo", "mtext", "mspace",
+		"msub", "msup", "msubsup", "munder", "mover", "munderover", "mfrac", "msqrt", "mroot",
+		"mtable", "mtr", "mtd",
+	)
+	p.AllowAttrs("display").Matching(regexp.MustCompile(`^block$`)).OnElements("math")
+	p.AllowAttrs("encoding").Matching(regexp.MustCompile(`^application/x-tex$`)).OnElements("annotation")
+	p.AllowAttrs("mathvariant").Matching(regexp.MustCompile(`^normal$`)).OnElements("mi")
+	p.AllowAttrs("fence", "stretchy", "largeop", "movablelimits").Matching(regexp.MustCompile(`^true$`)).OnElements("mo")
+	p.AllowAttrs("accent").Matching(regexp.MustCompile(`^true$`)).OnElements("mover")
+	p.AllowAttrs("accentunder").Matching(regexp.MustCompile(`^true$`)).OnElements("munder")
+	p.AllowAttrs("linethickness").Matching(regexp.MustCompile(`^0$`)).OnElements("mfrac")
+	p.AllowAttrs("width").Matching(regexp.MustCompile(`^-?\d+(\.\d+)?em$`)).OnElements("mspace")
+	p.AllowAttrs("columnalign").Matching(regexp.MustCompile(`^(left|center|right)( (left|center|right))*$`)).OnElements("mtable")
+
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowAttrs("src", "alt", "title").OnElements("img")
+	p.AllowAttrs("title").OnElements("a")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.AllowRelativeURLs(true)
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}
+
+// Render turns markdown source into HTML that is safe to show as is. Source
+// that can't be rendered is shown as escaped text instead.
+func Render(source string) string {
+	var buf bytes.Buffer
+	if err := converter().Convert([]byte(source), &buf); err != nil {
+		log.Println("ERROR RENDER:", err)
+		return "<p>" + html.EscapeString(source) + "</p>"
+	}
+	return Sanitize(buf.String())
+}
+
+// Sanitize strips everything but the markup Render produces from html.
+func Sanitize(markup string) string {
+	return policy.Sanitize(markup)
+}
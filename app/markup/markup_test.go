@@ -0,0 +1,87 @@
+package markup
+
+import (
+	"forum-server/app/model"
+	"forum-server/db"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		want    []string
+		notWant []string
+	}{
+		{"emphasis", "**bold** and ~~gone~~", []string{"<strong>bold</strong>", "<del>gone</del>"}, nil},
+		{"table", "| a | b |\n|:-|-:|\n| 1 | 2 |", []string{"<table>", `<th align="left">a</th>`, `<td align="right">2</td>`}, nil},
+		{"fenced code", "```go\nfmt.Println(\"<b>\")\n```", []string{`<code class="language-go">`, "&lt;b&gt;"}, []string{"<b>"}},
+		{"code language with markup", "```\" onclick=\"x\n1\n```", nil, []string{"onclick"}},
+		{"bare link", "see https://example.com/a", []string{`href="https://example.com/a"`, `rel="nofollow noopener"`, `target="_blank"`}, nil},
+		{"raw html", "<script>alert(1)</script><b onmouseover=x>hi</b>", nil, []string{"<script", "onmouseover", "alert(1)"}},
+		{"javascript link", "[x](javascript:alert(1))", nil, []string{"javascript:"}},
+		{"image", "![cat](https://example.com/cat.png)", []string{`<img src="https://example.com/cat.png" alt="cat"`}, nil},
+		{"task list", "- [x] done", []string{`type="checkbox"`, "checked"}, nil},
+		{"math", "$x^2$", []string{`<span class="math math-inline">x^2</span>`}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Render(tt.source)
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("Render(%q) = %q, want it to contain %q", tt.source, got, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("Render(%q) = %q, want no %q", tt.source, got, notWant)
+				}
+			}
+		})
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	conn, err := db.OpenEmbedded("file:TestBackfill?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}()
+
+	conn.Create(&model.Post{ID: "missing", Content: "*new*"})
+	conn.Create(&model.Post{ID: "rendered", Content: "*old*", ContentHTML: "<p>stale</p>"})
+	conn.Create(&model.Comment{ID: "comment", PostID: "missing", Content: "**hi**"})
+
+	posts, err := backfillPosts(conn, false)
+	if err != nil || posts != 1 {
+		t.Fatalf("backfillPosts = %d, %v, want 1", posts, err)
+	}
+	comments, err := backfillComments(conn, false)
+	if err != nil || comments != 1 {
+		t.Fatalf("backfillComments = %d, %v, want 1", comments, err)
+	}
+
+	html := func(id string) string {
+		post := model.Post{}
+		conn.Where("id = ?", id).First(&post)
+		return post.ContentHTML
+	}
+	if got := html("missing"); got != "<p><em>new</em></p>\n" {
+		t.Errorf("backfilled content_html = %q", got)
+	}
+	if got := html("rendered"); got != "<p>stale</p>" {
+		t.Errorf("already rendered content_html = %q, want it left alone", got)
+	}
+
+	if posts, err := backfillPosts(conn, true); err != nil || posts != 2 {
+		t.Fatalf("backfillPosts --all = %d, %v, want 2", posts, err)
+	}
+	if got := html("rendered"); got != "<p><em>old</em></p>\n" {
+		t.Errorf("re-rendered content_html = %q", got)
+	}
+}
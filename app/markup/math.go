@@ -0,0 +1,287 @@
+package markup
+
+import (
+	"bytes"
+	"html"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Math output modes, chosen with MARKUP_MATH.
+const (
+	// MathTeX keeps the TeX source in math-inline and math-display elements
+	// for clients to typeset, e.g. with KaTeX or MathJax.
+	MathTeX = "tex"
+	// MathML converts math to MathML on the server, which browsers show
+	// without any JavaScript.
+	MathML = "mathml"
+)
+
+var (
+	kindMath      = ast.NewNodeKind("Math")
+	kindMathBlock = ast.NewNodeKind("MathBlock")
+)
+
+// Math is $...$ or $$...$$ inside a paragraph.
+type Math struct {
+	ast.BaseInline
+	TeX     string
+	Display bool
+}
+
+func (n *Math) Kind() ast.NodeKind {
+	return kindMath
+}
+
+func (n *Math) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"TeX": n.TeX}, nil)
+}
+
+// MathBlock is display math on lines of its own, between $$ lines or as a
+// single $$...$$ line.
+type MathBlock struct {
+	ast.BaseBlock
+	TeX    string
+	closed bool
+}
+
+func (n *MathBlock) Kind() ast.NodeKind {
+	return kindMathBlock
+}
+
+func (n *MathBlock) IsRaw() bool {
+	return true
+}
+
+func (n *MathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"TeX": n.TeX}, nil)
+}
+
+// mathExtension reads math before markdown gets to it, so underscores,
+// asterisks and backslashes in TeX aren't taken for emphasis or escapes.
+type mathExtension struct {
+	mode string
+}
+
+func (e *mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(&mathBlockParser{}, 150)),
+		parser.WithInlineParsers(util.Prioritized(&mathInlineParser{}, 150)),
+	)
+	m.Renderer().AddOptions(
+		renderer.WithNodeRenderers(util.Prioritized(&mathRenderer{mode: e.mode}, 150)),
+	)
+}
+
+type mathInlineParser struct{}
+
+func (p *mathInlineParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+// Parse follows pandoc for inline math: the opening $ can't be followed by
+// a space and the closing $ can't follow a space or come before a digit, so
+// prices like $5 and $10 stay text. Math can't span lines, and anything
+// that isn't valid TeX is left as text.
+func (p *mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+	display := len(line) > 1 && line[1] == '$'
+	open := 1
+	if display {
+		open = 2
+	}
+	if len(line) <= open || util.IsSpace(line[open]) && !display {
+		return nil
+	}
+
+	// positions in m are relative to where the line was first scanned
+	m := scanMathLine(pc, line, segment)
+	offset := segment.Start - m.start
+	if display {
+		i := m.after(m.dollars, offset+open) - offset
+		if i <= open || i+1 >= len(line) || line[i+1] != '$' || !validMath(line[open:i]) {
+			return nil
+		}
+		block.Advance(i + 2)
+		return &Math{TeX: string(line[open:i]), Display: true}
+	}
+	i := m.after(m.closes, offset+open) - offset
+	if i < open || !validMath(line[open:i]) {
+		return nil
+	}
+	block.Advance(i + 1)
+	return &Math{TeX: string(line[open:i])}
+}
+
+var mathLineKey = parser.NewContextKey()
+
+// mathLine is where the unescaped $ signs are on one line, so that each $
+// the inline parser is triggered by doesn't rescan the rest of the line.
+type mathLine struct {
+	start, stop int
+	// dollars holds every unescaped $ and closes those that can end inline
+	// math.
+	dollars []int
+	closes  []int
+}
+
+// scanMathLine returns the scan of the line segment is on, scanning it from
+// segment on if it hasn't been already.
+func scanMathLine(pc parser.Context, line []byte, segment text.Segment) *mathLine {
+	if m, ok := pc.Get(mathLineKey).(*mathLine); ok && segment.Padding == 0 && m.stop == segment.Stop && m.start <= segment.Start {
+		return m
+	}
+
+	m := &mathLine{start: segment.Start, stop: segment.Stop}
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '$':
+			m.dollars = append(m.dollars, i)
+			if i > 0 && !util.IsSpace(line[i-1]) && !(i+1 < len(line) && line[i+1] >= '0' && line[i+1] <= '9') {
+				m.closes = append(m.closes, i)
+			}
+		}
+	}
+	if segment.Padding == 0 {
+		pc.Set(mathLineKey, m)
+	}
+	return m
+}
+
+// after returns the first position in positions that is at least from, or
+// -1 if there is none.
+func (m *mathLine) after(positions []int, from int) int {
+	i := sort.SearchInts(positions, from)
+	if i == len(positions) {
+		return -1
+	}
+	return positions[i]
+}
+
+// validMath checks tex, turning away anything too long without parsing it.
+func validMath(tex []byte) bool {
+	return len(tex) <= maxTeXLength*utf8.UTFMax && ValidateTeX(string(tex)) == nil
+}
+
+type mathBlockParser struct{}
+
+func (b *mathBlockParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (b *mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, _ := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], []byte("$$")) {
+		return nil, parser.NoChildren
+	}
+
+	rest := util.TrimRightSpace(line[pos+2:])
+	if len(rest) == 0 {
+		return &MathBlock{}, parser.NoChildren
+	}
+	if len(rest) > 2 && bytes.HasSuffix(rest, []byte("$$")) && bytes.Count(rest, []byte("$$")) == 1 {
+		return &MathBlock{TeX: string(rest[:len(rest)-2]), closed: true}, parser.NoChildren
+	}
+	// $$ followed by more on the line is left to the inline parser
+	return nil, parser.NoChildren
+}
+
+func (b *mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	n := node.(*MathBlock)
+	if n.closed {
+		return parser.Close
+	}
+
+	line, segment := reader.PeekLine()
+	newline := 0
+	if len(line) > 0 && line[len(line)-1] == '\n' {
+		newline = 1
+	}
+	reader.Advance(segment.Len() - newline)
+
+	trimmed := util.TrimRightSpace(line)
+	if bytes.HasSuffix(trimmed, []byte("$$")) {
+		n.TeX += string(trimmed[:len(trimmed)-2])
+		n.closed = true
+		return parser.Close
+	}
+	n.TeX += string(line)
+	return parser.Continue | parser.NoChildren
+}
+
+func (b *mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (b *mathBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (b *mathBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+type mathRenderer struct {
+	mode string
+}
+
+func (r *mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMath, r.renderMath)
+	reg.Register(kindMathBlock, r.renderMathBlock)
+}
+
+func (r *mathRenderer) renderMath(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		n := node.(*Math)
+		r.write(w, n.TeX, n.Display, false)
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *mathRenderer) renderMathBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		r.write(w, strings.TrimSpace(node.(*MathBlock).TeX), true, true)
+		w.WriteByte('\n')
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+// write emits math in the configured mode. Math blocks that fail validation
+// are shown as they were written.
+func (r *mathRenderer) write(w util.BufWriter, tex string, display, block bool) {
+	if r.mode == MathML {
+		if mathml, err := TeXToMathML(tex, display); err == nil {
+			w.WriteString(mathml)
+			return
+		}
+	} else if ValidateTeX(tex) == nil {
+		tag, class := "span", "math math-inline"
+		if block {
+			tag = "div"
+		}
+		if display {
+			class = "math math-display"
+		}
+		w.WriteString("<" + tag + ` class="` + class + `">` + html.EscapeString(tex) + "</" + tag + ">")
+		return
+	}
+
+	delim := "$"
+	if display {
+		delim = "$$"
+	}
+	source := html.EscapeString(delim + tex + delim)
+	if block {
+		source = "<p>" + source + "</p>"
+	}
+	w.WriteString(source)
+}
@@ -0,0 +1,124 @@
+package markup
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuin/goldmark"
+)
+
+func renderMath(t *testing.T, mode, source string) string {
+	t.Helper()
+	md := goldmark.New(goldmark.WithExtensions(&mathExtension{mode: mode}))
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(source), &buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestMathDelimiters(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"inline", "$x^2$", `<p><span class="math math-inline">x^2</span></p>` + "\n"},
+		{"inline display", "a $$x$$ b", `<p>a <span class="math math-display">x</span> b</p>` + "\n"},
+		{"two inline", "$a$ and $b$", `<p><span class="math math-inline">a</span> and <span class="math math-inline">b</span></p>` + "\n"},
+		{"block", "$$\nx + y\n$$", `<div class="math math-display">x + y</div>` + "\n"},
+		{"single line block", "$$x + y$$", `<div class="math math-display">x + y</div>` + "\n"},
+		{"prices", "$5 and $10", "<p>$5 and $10</p>\n"},
+		{"price then math", "costs $5 or $x$ here", `<p>costs $5 or <span class="math math-inline">x</span> here</p>` + "\n"},
+		{"space after open", "$ x$", "<p>$ x$</p>\n"},
+		{"space before close", "$x $", "<p>$x $</p>\n"},
+		{"escaped dollar", `\$x$`, "<p>$x$</p>\n"},
+		{"empty display", "a $$$$ b", "<p>a $$$$ b</p>\n"},
+		{"unclosed", "$x", "<p>$x</p>\n"},
+		{"across lines", "$x\ny$", "<p>$x\ny$</p>\n"},
+		{"markdown inside math", "$a_1 * b_2 * c$", `<p><span class="math math-inline">a_1 * b_2 * c</span></p>` + "\n"},
+		{"invalid tex", `$\href{x}{y}$`, `<p>$\href{x}{y}$</p>` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderMath(t, MathTeX, tt.source); got != tt.want {
+				t.Errorf("render(%q) = %q, want %q", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMathEnvironments(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"pmatrix", "$$\n\\begin{pmatrix}a&b\\\\c&d\\end{pmatrix}\n$$", `<mo fence="true" stretchy="true">(</mo><mtable>`},
+		{"cases", "$$\n\\begin{cases}1&x>0\\\\0&x\\le0\\end{cases}\n$$", `<mtable columnalign="left left">`},
+		{"inline pmatrix", `$\begin{pmatrix}a\end{pmatrix}$`, `<math><semantics><mrow><mo fence="true" stretchy="true">(</mo>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := renderMath(t, MathML, tt.source)
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("render(%q) = %q, want it to contain %q", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMathMLEscapes(t *testing.T) {
+	got := renderMath(t, MathML, `$\text{<img src=x onerror=alert(1)>}$`)
+	if strings.Contains(got, "<img") {
+		t.Errorf("render = %q, want markup escaped", got)
+	}
+}
+
+func TestMathInvalidShownAsWritten(t *testing.T) {
+	got := renderMath(t, MathML, "$$\n\\def\\x{<b>}\n$$")
+	want := "<p>$$\\def\\x{&lt;b&gt;}$$</p>\n"
+	if got != want {
+		t.Errorf("render = %q, want %q", got, want)
+	}
+}
+
+func TestMathManyDollars(t *testing.T) {
+	// every $ could open math, so a naive parser rescans the rest of the
+	// line for each one
+	line := strings.Repeat("$5 $a ", 40000)
+	done := make(chan string, 1)
+	go func() { done <- renderMath(t, MathTeX, line) }()
+	select {
+	case got := <-done:
+		if strings.Contains(got, "math-inline") {
+			t.Errorf("prices were taken for math")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("render took too long")
+	}
+}
+
+// TestMathSurvivesSanitizer makes sure the policy allows everything math
+// rendering produces, so Render doesn't strip it back out.
+func TestMathSurvivesSanitizer(t *testing.T) {
+	sources := []string{
+		`$x^2_i + \frac{a}{b}$`,
+		`$$\sqrt[3]{x} \sum_{n=0}^{\infty} \hat{a} \underline{b} \vec{v}$$`,
+		"$$\n\\begin{pmatrix} a & b \\\\ c & d \\end{pmatrix}\n$$",
+		`$\text{a b} \quad \binom{n}{k} \mathrm{d}x \mathbf{v} \left( y \right)$`,
+	}
+	for _, mode := range []string{MathTeX, MathML} {
+		for _, source := range sources {
+			rendered := renderMath(t, mode, source)
+			if mode == MathML && !strings.Contains(rendered, "<math") {
+				t.Errorf("%q rendered without MathML: %q", source, rendered)
+			}
+			if sanitized := Sanitize(rendered); sanitized != rendered {
+				t.Errorf("%s: Sanitize(%q)\n = %q", mode, rendered, sanitized)
+			}
+		}
+	}
+}
@@ -0,0 +1,759 @@
+package markup
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxTeXLength = 2000
+	maxTeXDepth  = 32
+)
+
+var errTeXEnd = errors.New("unexpected end of math")
+
+type texSymbol struct {
+	tag  string
+	text string
+	// limits is set for operators that take their scripts above and below
+	// in display math, such as \sum and \lim
+	limits bool
+}
+
+// texSymbols, texFunctions, texCommands and texEnvironments are the TeX this
+// server understands. Anything else is rejected, so math can't smuggle in
+// the commands some typesetters offer for links, HTML or macros.
+var texSymbols = map[string]texSymbol{
+	"alpha": {"mi", "α", false}, "beta": {"mi", "β", false}, "gamma": {"mi", "γ", false},
+	"delta": {"mi", "δ", false}, "epsilon": {"mi", "ϵ", false}, "varepsilon": {"mi", "ε", false},
+	"zeta": {"mi", "ζ", false}, "eta": {"mi", "η", false}, "theta": {"mi", "θ", false},
+	"vartheta": {"mi", "ϑ", false}, "iota": {"mi", "ι", false}, "kappa": {"mi", "κ", false},
+	"lambda": {"mi", "λ", false}, "mu": {"mi", "μ", false}, "nu": {"mi", "ν", false},
+	"xi": {"mi", "ξ", false}, "pi": {"mi", "π", false}, "varpi": {"mi", "ϖ", false},
+	"rho": {"mi", "ρ", false}, "varrho": {"mi", "ϱ", false}, "sigma": {"mi", "σ", false},
+	"varsigma": {"mi", "ς", false}, "tau": {"mi", "τ", false}, "upsilon": {"mi", "υ", false},
+	"phi": {"mi", "ϕ", false}, "varphi": {"mi", "φ", false}, "chi": {"mi", "χ", false},
+	"psi": {"mi", "ψ", false}, "omega": {"mi", "ω", false},
+
+	"Gamma": {"mi-normal", "Γ", false}, "Delta": {"mi-normal", "Δ", false}, "Theta": {"mi-normal", "Θ", false},
+	"Lambda": {"mi-normal", "Λ", false}, "Xi": {"mi-normal", "Ξ", false}, "Pi": {"mi-normal", "Π", false},
+	"Sigma": {"mi-normal", "Σ", false}, "Upsilon": {"mi-normal", "Υ", false}, "Phi": {"mi-normal", "Φ", false},
+	"Psi": {"mi-normal", "Ψ", false}, "Omega": {"mi-normal", "Ω", false},
+
+	"infty": {"mi", "∞", false}, "partial": {"mi", "∂", false}, "nabla": {"mi", "∇", false},
+	"hbar": {"mi", "ℏ", false}, "ell": {"mi", "ℓ", false}, "emptyset": {"mi", "∅", false},
+	"varnothing": {"mi", "∅", false}, "aleph": {"mi", "ℵ", false}, "Re": {"mi", "ℜ", false},
+	"Im": {"mi", "ℑ", false}, "angle": {"mi", "∠", false}, "top": {"mi", "⊤", false},
+	"bot": {"mi", "⊥", false}, "prime": {"mi", "′", false},
+
+	"pm": {"mo", "±", false}, "mp": {"mo", "∓", false}, "times": {"mo", "×", false},
+	"div": {"mo", "÷", false}, "cdot": {"mo", "⋅", false}, "ast": {"mo", "∗", false},
+	"star": {"mo", "⋆", false}, "circ": {"mo", "∘", false}, "bullet": {"mo", "∙", false},
+	"otimes": {"mo", "⊗", false}, "oplus": {"mo", "⊕", false}, "odot": {"mo", "⊙", false},
+	"dagger": {"mo", "†", false}, "setminus": {"mo", "∖", false}, "cup": {"mo", "∪", false},
+	"cap": {"mo", "∩", false}, "wedge": {"mo", "∧", false}, "land": {"mo", "∧", false},
+	"vee": {"mo", "∨", false}, "lor": {"mo", "∨", false}, "neg": {"mo", "¬", false},
+	"lnot": {"mo", "¬", false},
+
+	"leq": {"mo", "≤", false}, "le": {"mo", "≤", false}, "geq": {"mo", "≥", false},
+	"ge": {"mo", "≥", false}, "neq": {"mo", "≠", false}, "ne": {"mo", "≠", false},
+	"approx": {"mo", "≈", false}, "equiv": {"mo", "≡", false}, "sim": {"mo", "∼", false},
+	"simeq": {"mo", "≃", false}, "cong": {"mo", "≅", false}, "propto": {"mo", "∝", false},
+	"ll": {"mo", "≪", false}, "gg": {"mo", "≫", false}, "lesssim": {"mo", "≲", false},
+	"gtrsim": {"mo", "≳", false}, "in": {"mo", "∈", false}, "notin": {"mo", "∉", false},
+	"ni": {"mo", "∋", false}, "subset": {"mo", "⊂", false}, "supset": {"mo", "⊃", false},
+	"subseteq": {"mo", "⊆", false}, "supseteq": {"mo", "⊇", false}, "perp": {"mo", "⊥", false},
+	"parallel": {"mo", "∥", false}, "mid": {"mo", "∣", false}, "forall": {"mo", "∀", false},
+	"exists": {"mo", "∃", false}, "colon": {"mo", ":", false},
+
+	"to": {"mo", "→", false}, "rightarrow": {"mo", "→", false}, "leftarrow": {"mo", "←", false},
+	"gets": {"mo", "←", false}, "leftrightarrow": {"mo", "↔", false}, "Rightarrow": {"mo", "⇒", false},
+	"Leftarrow": {"mo", "⇐", false}, "Leftrightarrow": {"mo", "⇔", false}, "implies": {"mo", "⟹", false},
+	"iff": {"mo", "⟺", false}, "mapsto": {"mo", "↦", false}, "uparrow": {"mo", "↑", false},
+	"downarrow": {"mo", "↓", false}, "rightleftharpoons": {"mo", "⇌", false},
+
+	"ldots": {"mo", "…", false}, "dots": {"mo", "…", false}, "cdots": {"mo", "⋯", false},
+	"vdots": {"mo", "⋮", false}, "ddots": {"mo", "⋱", false},
+
+	"langle": {"mo", "⟨", false}, "rangle": {"mo", "⟩", false}, "lfloor": {"mo", "⌊", false},
+	"rfloor": {"mo", "⌋", false}, "lceil": {"mo", "⌈", false}, "rceil": {"mo", "⌉", false},
+	"vert": {"mo", "|", false}, "Vert": {"mo", "‖", false}, "lbrace": {"mo", "{", false},
+	"rbrace": {"mo", "}", false}, "{": {"mo", "{", false}, "}": {"mo", "}", false},
+	"|": {"mo", "‖", false}, "%": {"mo", "%", false}, "#": {"mo", "#", false},
+	"&": {"mo", "&", false}, "_": {"mo", "_", false}, "$": {"mo", "$", false},
+
+	"sum": {"mo", "∑", true}, "prod": {"mo", "∏", true}, "coprod": {"mo", "∐", true},
+	"bigcup": {"mo", "⋃", true}, "bigcap": {"mo", "⋂", true}, "bigoplus": {"mo", "⨁", true},
+	"bigotimes": {"mo", "⨂", true}, "int": {"mo", "∫", false}, "iint": {"mo", "∬", false},
+	"iiint": {"mo", "∭", false}, "oint": {"mo", "∮", false},
+}
+
+// texFunctions are typeset upright, like \sin. The value says whether they
+// take limits in display math.
+var texFunctions = map[string]bool{
+	"sin": false, "cos": false, "tan": false, "cot": false, "sec": false, "csc": false,
+	"arcsin": false, "arccos": false, "arctan": false, "sinh": false, "cosh": false,
+	"tanh": false, "coth": false, "log": false, "ln": false, "lg": false, "exp": false,
+	"det": true, "dim": false, "ker": false, "deg": false, "gcd": true, "arg": false,
+	"hom": false, "Pr": true, "lim": true, "limsup": true, "liminf": true, "max": true,
+	"min": true, "sup": true, "inf": true,
+}
+
+var texSpaces = map[string]string{
+	",": "0.167em", ":": "0.222em", ">": "0.222em", ";": "0.278em", "!": "-0.167em",
+	" ": "0.333em", "quad": "1em", "qquad": "2em",
+}
+
+var texAccents = map[string]string{
+	"hat": "^", "widehat": "^", "bar": "¯", "overline": "¯", "vec": "→", "dot": "˙",
+	"ddot": "¨", "tilde": "~", "widetilde": "~",
+}
+
+// texVariants are the font commands. Their letters are mapped onto the
+// Unicode mathematical alphabets, which is how MathML Core styles them.
+var texVariants = map[string]string{
+	"mathrm": "normal", "mathbf": "bold", "mathit": "italic", "boldsymbol": "bold-italic",
+	"mathcal": "script", "mathbb": "double-struck", "mathfrak": "fraktur",
+	"mathsf": "sans-serif", "mathtt": "monospace",
+}
+
+// texCommands take arguments or change how what follows is read.
+var texCommands = map[string]bool{
+	"frac": true, "dfrac": true, "tfrac": true, "cfrac": true, "binom": true, "sqrt": true,
+	"text": true, "textrm": true, "textit": true, "textbf": true, "operatorname": true,
+	"underline": true, "left": true, "right": true, "begin": true, "end": true,
+	"displaystyle": true, "textstyle": true, "scriptstyle": true, "limits": true, "nolimits": true,
+	"big": true, "Big": true, "bigg": true, "Bigg": true, "bigl": true, "bigr": true,
+	"Bigl": true, "Bigr": true, "biggl": true, "biggr": true, "Biggl": true, "Biggr": true,
+}
+
+type texEnvironment struct {
+	open, close string
+	align       string
+}
+
+var texEnvironments = map[string]texEnvironment{
+	"matrix":   {"", "", ""},
+	"pmatrix":  {"(", ")", ""},
+	"bmatrix":  {"[", "]", ""},
+	"Bmatrix":  {"{", "}", ""},
+	"vmatrix":  {"|", "|", ""},
+	"Vmatrix":  {"‖", "‖", ""},
+	"cases":    {"{", "", "left left"},
+	"aligned":  {"", "", "right left"},
+	"align":    {"", "", "right left"},
+	"align*":   {"", "", "right left"},
+	"gathered": {"", "", ""},
+	"split":    {"", "", "right left"},
+}
+
+// ValidateTeX reports whether tex only uses the commands this server
+// understands and is well formed.
+func ValidateTeX(tex string) error {
+	_, err := texToMathML(tex, false)
+	return err
+}
+
+// TeXToMathML converts tex to a MathML element, keeping the source as an
+// annotation for clients that would rather typeset it themselves.
+func TeXToMathML(tex string, display bool) (string, error) {
+	body, err := texToMathML(tex, display)
+	if err != nil {
+		return "", err
+	}
+	open := "<math>"
+	if display {
+		open = `<math display="block">`
+	}
+	return open + "<semantics>" + body + `<annotation encoding="application/x-tex">` + html.EscapeString(tex) + "</annotation></semantics></math>", nil
+}
+
+func texToMathML(tex string, display bool) (string, error) {
+	if utf8.RuneCountInString(tex) > maxTeXLength {
+		return "", fmt.Errorf("math is longer than %d characters", maxTeXLength)
+	}
+	p := texParser{src: []rune(tex), display: display}
+	items, stop, err := p.parseList(nil)
+	if err != nil {
+		return "", err
+	}
+	if stop != "" {
+		return "", fmt.Errorf("unexpected %s", stop)
+	}
+	return mrow(items), nil
+}
+
+type texParser struct {
+	src     []rune
+	pos     int
+	depth   int
+	display bool
+	variant string
+}
+
+func (p *texParser) peek() rune {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *texParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+// peekToken names the next token where it could end a list: "}", "]", "&",
+// a command such as "\right", or "" at the end of the source.
+func (p *texParser) peekToken() string {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return ""
+	}
+	if p.src[p.pos] != '\\' {
+		return string(p.src[p.pos])
+	}
+	save := p.pos
+	name := p.readCommand()
+	p.pos = save
+	return `\` + name
+}
+
+// readCommand reads the command at a backslash, returning its name.
+func (p *texParser) readCommand() string {
+	p.pos++
+	start := p.pos
+	for p.pos < len(p.src) && isASCIILetter(p.src[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start && p.pos < len(p.src) {
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+// parseList reads atoms until one of stops or the end of the source, which
+// it leaves unread and returns.
+func (p *texParser) parseList(stops map[string]bool) ([]string, string, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxTeXDepth {
+		return nil, "", errors.New("math is nested too deeply")
+	}
+
+	items := []string{}
+	for {
+		token := p.peekToken()
+		if token == "" || stops[token] {
+			return items, token, nil
+		}
+		switch token {
+		case "}", `\right`, `\end`, "&", `\\`:
+			if token == `\\` || token == "&" {
+				// line breaks and alignment outside an environment are
+				// ignored, as the typesetters do
+				p.readToken(token)
+				continue
+			}
+			return nil, "", fmt.Errorf("unexpected %s", token)
+		}
+
+		item, err := p.parseScripted()
+		if err != nil {
+			return nil, "", err
+		}
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+}
+
+func (p *texParser) readToken(token string) {
+	p.skipSpace()
+	if strings.HasPrefix(token, `\`) {
+		p.readCommand()
+		return
+	}
+	p.pos++
+}
+
+// parseScripted reads an atom with any sub and superscripts on it.
+func (p *texParser) parseScripted() (string, error) {
+	base, limits, err := p.parseAtom()
+	if err != nil {
+		return "", err
+	}
+
+	sub, sup := "", ""
+	for {
+		token := p.peekToken()
+		if token == `\limits` || token == `\nolimits` {
+			p.readToken(token)
+			limits = token == `\limits`
+			continue
+		}
+		if token != "^" && token != "_" {
+			break
+		}
+		p.pos++
+		arg, err := p.parseArgument()
+		if err != nil {
+			return "", err
+		}
+		if token == "^" {
+			if sup != "" {
+				return "", errors.New("double superscript")
+			}
+			sup = arg
+		} else {
+			if sub != "" {
+				return "", errors.New("double subscript")
+			}
+			sub = arg
+		}
+	}
+
+	if sub == "" && sup == "" {
+		return base, nil
+	}
+	if base == "" {
+		base = "<mrow></mrow>"
+	}
+	under := limits && p.display
+	switch {
+	case sub != "" && sup != "" && under:
+		return "<munderover>" + base + sub + sup + "</munderover>", nil
+	case sub != "" && sup != "":
+		return "<msubsup>" + base + sub + sup + "</msubsup>", nil
+	case sub != "" && under:
+		return "<munder>" + base + sub + "</munder>", nil
+	case sub != "":
+		return "<msub>" + base + sub + "</msub>", nil
+	case under:
+		return "<mover>" + base + sup + "</mover>", nil
+	}
+	return "<msup>" + base + sup + "</msup>", nil
+}
+
+// parseArgument reads a command argument or script: a group, a command or a
+// single character.
+func (p *texParser) parseArgument() (string, error) {
+	p.skipSpace()
+	switch c := p.peek(); {
+	case c == 0:
+		return "", errTeXEnd
+	case c == '{' || c == '\\':
+		item, _, err := p.parseAtom()
+		if item == "" && err == nil {
+			item = "<mrow></mrow>"
+		}
+		return item, err
+	case c == '}' || c == '^' || c == '_' || c == '&':
+		return "", fmt.Errorf("unexpected %c", c)
+	}
+	c := p.src[p.pos]
+	p.pos++
+	return p.character(c)
+}
+
+func (p *texParser) parseGroup() (string, error) {
+	p.skipSpace()
+	if p.peek() != '{' {
+		if p.peek() == 0 {
+			return "", errTeXEnd
+		}
+		return "", errors.New("expected {")
+	}
+	p.pos++
+	items, stop, err := p.parseList(map[string]bool{"}": true})
+	if err != nil {
+		return "", err
+	}
+	if stop != "}" {
+		return "", errors.New("missing }")
+	}
+	p.pos++
+	return mrow(items), nil
+}
+
+// readText reads a braced argument as plain text.
+func (p *texParser) readText() (string, error) {
+	p.skipSpace()
+	if p.peek() != '{' {
+		return "", errors.New("expected {")
+	}
+	p.pos++
+	start := p.pos
+	for depth := 1; p.pos < len(p.src); p.pos++ {
+		switch p.src[p.pos] {
+		case '\\':
+			p.pos++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				text := string(p.src[start:p.pos])
+				p.pos++
+				return text, nil
+			}
+		}
+	}
+	return "", errors.New("missing }")
+}
+
+func (p *texParser) parseAtom() (string, bool, error) {
+	p.skipSpace()
+	c := p.peek()
+	switch {
+	case c == 0:
+		return "", false, errTeXEnd
+	case c == '{':
+		item, err := p.parseGroup()
+		return item, false, err
+	case c == '\\':
+		return p.parseCommand()
+	case c == '^' || c == '_':
+		// scripts with nothing to attach to, like {}^{14}C
+		return "", false, nil
+	case c == '%' || c == '#' || c == '$':
+		return "", false, fmt.Errorf("unsupported character %c", c)
+	case c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos < len(p.src) && (isDigit(p.src[p.pos]) || p.src[p.pos] == '.' && p.pos+1 < len(p.src) && isDigit(p.src[p.pos+1])) {
+			p.pos++
+		}
+		if p.variant != "" && p.variant != "normal" {
+			return "<mn>" + html.EscapeString(mathAlphabet(string(p.src[start:p.pos]), p.variant)) + "</mn>", false, nil
+		}
+		return "<mn>" + string(p.src[start:p.pos]) + "</mn>", false, nil
+	}
+	p.pos++
+	item, err := p.character(c)
+	return item, false, err
+}
+
+func (p *texParser) character(c rune) (string, error) {
+	switch {
+	case c == '~':
+		return `<mspace width="0.333em"></mspace>`, nil
+	case c == '\'':
+		return "<mo>′</mo>", nil
+	case c == '%' || c == '#' || c == '$':
+		return "", fmt.Errorf("unsupported character %c", c)
+	case isDigit(c):
+		return "<mn>" + html.EscapeString(mathAlphabet(string(c), p.variant)) + "</mn>", nil
+	case unicode.IsLetter(c):
+		if p.variant == "" {
+			return "<mi>" + string(c) + "</mi>", nil
+		}
+		return `<mi mathvariant="normal">` + html.EscapeString(mathAlphabet(string(c), p.variant)) + "</mi>", nil
+	}
+	return "<mo>" + html.EscapeString(string(c)) + "</mo>", nil
+}
+
+func (p *texParser) parseCommand() (string, bool, error) {
+	name := p.readCommand()
+
+	if symbol, ok := texSymbols[name]; ok {
+		text := html.EscapeString(symbol.text)
+		switch symbol.tag {
+		case "mi-normal":
+			return `<mi mathvariant="normal">` + text + "</mi>", false, nil
+		case "mi":
+			return "<mi>" + text + "</mi>", false, nil
+		}
+		if symbol.limits {
+			return `<mo largeop="true" movablelimits="true">` + text + "</mo>", true, nil
+		}
+		return "<mo>" + text + "</mo>", false, nil
+	}
+	if limits, ok := texFunctions[name]; ok {
+		text := name
+		switch name {
+		case "limsup":
+			text = "lim sup"
+		case "liminf":
+			text = "lim inf"
+		}
+		return "<mi>" + text + "</mi>", limits, nil
+	}
+	if width, ok := texSpaces[name]; ok {
+		return `<mspace width="` + width + `"></mspace>`, false, nil
+	}
+	if accent, ok := texAccents[name]; ok {
+		arg, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		return `<mover accent="true">` + arg + "<mo>" + html.EscapeString(accent) + "</mo></mover>", false, nil
+	}
+	if variant, ok := texVariants[name]; ok {
+		outer := p.variant
+		p.variant = variant
+		arg, err := p.parseArgument()
+		p.variant = outer
+		return arg, false, err
+	}
+	if !texCommands[name] {
+		return "", false, fmt.Errorf(`unsupported command \%s`, name)
+	}
+
+	switch name {
+	case "frac", "dfrac", "tfrac", "cfrac", "binom":
+		num, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		den, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		if name == "binom" {
+			return `<mrow><mo>(</mo><mfrac linethickness="0">` + num + den + "</mfrac><mo>)</mo></mrow>", false, nil
+		}
+		return "<mfrac>" + num + den + "</mfrac>", false, nil
+
+	case "sqrt":
+		index := ""
+		p.skipSpace()
+		if p.peek() == '[' {
+			p.pos++
+			items, stop, err := p.parseList(map[string]bool{"]": true})
+			if err != nil {
+				return "", false, err
+			}
+			if stop != "]" {
+				return "", false, errors.New("missing ]")
+			}
+			p.pos++
+			index = mrow(items)
+		}
+		arg, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		if index != "" {
+			return "<mroot>" + arg + index + "</mroot>", false, nil
+		}
+		return "<msqrt>" + arg + "</msqrt>", false, nil
+
+	case "text", "textrm", "textit", "textbf":
+		text, err := p.readText()
+		if err != nil {
+			return "", false, err
+		}
+		return "<mtext>" + html.EscapeString(text) + "</mtext>", false, nil
+
+	case "operatorname":
+		text, err := p.readText()
+		if err != nil {
+			return "", false, err
+		}
+		for _, c := range text {
+			if !isASCIILetter(c) {
+				return "", false, errors.New(`\operatorname only takes letters`)
+			}
+		}
+		return `<mi mathvariant="normal">` + text + "</mi>", false, nil
+
+	case "underline":
+		arg, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		return `<munder accentunder="true">` + arg + "<mo>_</mo></munder>", false, nil
+
+	case "left":
+		open, err := p.readDelimiter()
+		if err != nil {
+			return "", false, err
+		}
+		items, stop, err := p.parseList(map[string]bool{`\right`: true})
+		if err != nil {
+			return "", false, err
+		}
+		if stop != `\right` {
+			return "", false, errors.New(`\left without \right`)
+		}
+		p.readToken(stop)
+		close, err := p.readDelimiter()
+		if err != nil {
+			return "", false, err
+		}
+		return "<mrow>" + fence(open) + strings.Join(items, "") + fence(close) + "</mrow>", false, nil
+
+	case "begin":
+		item, err := p.parseEnvironment()
+		return item, false, err
+
+	case "big", "Big", "bigg", "Bigg", "bigl", "bigr", "Bigl", "Bigr", "biggl", "biggr", "Biggl", "Biggr":
+		delim, err := p.readDelimiter()
+		if err != nil {
+			return "", false, err
+		}
+		return fence(delim), false, nil
+	}
+
+	// \displaystyle and friends only change sizes, which is left to the
+	// client, and \limits on its own has nothing to apply to
+	return "", false, nil
+}
+
+// readDelimiter reads what follows \left, \right or \big. "." means none.
+func (p *texParser) readDelimiter() (string, error) {
+	p.skipSpace()
+	c := p.peek()
+	switch {
+	case c == 0:
+		return "", errTeXEnd
+	case c == '.':
+		p.pos++
+		return "", nil
+	case c == '\\':
+		name := p.readCommand()
+		if symbol, ok := texSymbols[name]; ok && symbol.tag == "mo" && !symbol.limits {
+			return symbol.text, nil
+		}
+		return "", fmt.Errorf(`\%s is not a delimiter`, name)
+	case strings.ContainsRune("()[]|/<>", c):
+		p.pos++
+		switch c {
+		case '<':
+			return "⟨", nil
+		case '>':
+			return "⟩", nil
+		}
+		return string(c), nil
+	}
+	return "", fmt.Errorf("%c is not a delimiter", c)
+}
+
+func (p *texParser) parseEnvironment() (string, error) {
+	name, err := p.readText()
+	if err != nil {
+		return "", err
+	}
+	env, ok := texEnvironments[name]
+	if !ok {
+		return "", fmt.Errorf("unsupported environment %s", name)
+	}
+
+	stops := map[string]bool{"&": true, `\\`: true, `\end`: true}
+	rows := []string{}
+	cells := []string{}
+	for {
+		items, stop, err := p.parseList(stops)
+		if err != nil {
+			return "", err
+		}
+		cells = append(cells, "<mtd>"+mrow(items)+"</mtd>")
+		if stop == "" {
+			return "", fmt.Errorf(`\begin{%s} without \end`, name)
+		}
+		p.readToken(stop)
+		if stop == "&" {
+			continue
+		}
+		// a trailing \\ before \end doesn't start another row
+		if !(stop == `\end` && len(cells) == 1 && len(items) == 0 && len(rows) > 0) {
+			rows = append(rows, "<mtr>"+strings.Join(cells, "")+"</mtr>")
+		}
+		cells = []string{}
+		if stop == `\end` {
+			break
+		}
+	}
+
+	end, err := p.readText()
+	if err != nil {
+		return "", err
+	}
+	if end != name {
+		return "", fmt.Errorf(`\begin{%s} ended by \end{%s}`, name, end)
+	}
+
+	table := "<mtable>"
+	if env.align != "" {
+		table = `<mtable columnalign="` + env.align + `">`
+	}
+	table += strings.Join(rows, "") + "</mtable>"
+	if env.open == "" && env.close == "" {
+		return table, nil
+	}
+	return "<mrow>" + fence(env.open) + table + fence(env.close) + "</mrow>", nil
+}
+
+func fence(delim string) string {
+	if delim == "" {
+		return ""
+	}
+	return `<mo fence="true" stretchy="true">` + html.EscapeString(delim) + "</mo>"
+}
+
+func mrow(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return "<mrow>" + strings.Join(items, "") + "</mrow>"
+}
+
+func isDigit(c rune) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isASCIILetter(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// mathAlphabets give the first capital, small letter and digit of each
+// Unicode mathematical alphabet; zero where the alphabet has none.
+var mathAlphabets = map[string][3]rune{
+	"bold":          {0x1D400, 0x1D41A, 0x1D7CE},
+	"italic":        {0x1D434, 0x1D44E, 0},
+	"bold-italic":   {0x1D468, 0x1D482, 0x1D7CE},
+	"script":        {0x1D49C, 0x1D4B6, 0},
+	"double-struck": {0x1D538, 0x1D552, 0x1D7D8},
+	"fraktur":       {0x1D504, 0x1D51E, 0},
+	"sans-serif":    {0x1D5A0, 0x1D5BA, 0x1D7E2},
+	"monospace":     {0x1D670, 0x1D68A, 0x1D7F6},
+}
+
+// mathAlphabetHoles are the letters that were already in Unicode before the
+// mathematical alphabets, which left gaps where they would have gone.
+var mathAlphabetHoles = map[string]map[rune]rune{
+	"italic":        {'h': 'ℎ'},
+	"script":        {'B': 'ℬ', 'E': 'ℰ', 'F': 'ℱ', 'H': 'ℋ', 'I': 'ℐ', 'L': 'ℒ', 'M': 'ℳ', 'R': 'ℛ', 'e': 'ℯ', 'g': 'ℊ', 'o': 'ℴ'},
+	"double-struck": {'C': 'ℂ', 'H': 'ℍ', 'N': 'ℕ', 'P': 'ℙ', 'Q': 'ℚ', 'R': 'ℝ', 'Z': 'ℤ'},
+	"fraktur":       {'C': 'ℭ', 'H': 'ℌ', 'I': 'ℑ', 'R': 'ℜ', 'Z': 'ℨ'},
+}
+
+func mathAlphabet(s, variant string) string {
+	bases, ok := mathAlphabets[variant]
+	if !ok {
+		return s
+	}
+	var b strings.Builder
+	for _, c := range s {
+		if hole, ok := mathAlphabetHoles[variant][c]; ok {
+			b.WriteRune(hole)
+			continue
+		}
+		switch {
+		case c >= 'A' && c <= 'Z':
+			b.WriteRune(bases[0] + c - 'A')
+		case c >= 'a' && c <= 'z':
+			b.WriteRune(bases[1] + c - 'a')
+		case c >= '0' && c <= '9' && bases[2] != 0:
+			b.WriteRune(bases[2] + c - '0')
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,144 @@
+package markup
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTeXToMathMLRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		tex  string
+	}{
+		{"href", `\href{javascript:alert(1)}{x}`},
+		{"def", `\def\x{1}\x`},
+		{"unknown macro", `\foo`},
+		{"unknown environment", `\begin{evil}x\end{evil}`},
+		{"mismatched environment", `\begin{pmatrix}x\end{bmatrix}`},
+		{"unclosed group", `{x`},
+		{"stray close", `x}`},
+		{"comment", `x % y`},
+		{"dollar", `x $ y`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if out, err := TeXToMathML(tt.tex, false); err == nil {
+				t.Errorf("TeXToMathML(%q) = %q, want error", tt.tex, out)
+			}
+		})
+	}
+}
+
+func TestTeXToMathMLEscapesText(t *testing.T) {
+	out, err := TeXToMathML(`\text{<script>alert("x")</script> & more}`, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "<script") {
+		t.Errorf("output contains raw markup: %s", out)
+	}
+	want := "<mtext>&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; &amp; more</mtext>"
+	if !strings.Contains(out, want) {
+		t.Errorf("output = %s, want it to contain %s", out, want)
+	}
+}
+
+func TestTeXToMathMLEscapesOperators(t *testing.T) {
+	out, err := TeXToMathML(`a<b`, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "<mo>&lt;</mo>") {
+		t.Errorf("output = %s, want < escaped", out)
+	}
+	if !strings.Contains(out, `<annotation encoding="application/x-tex">a&lt;b</annotation>`) {
+		t.Errorf("output = %s, want the source escaped in the annotation", out)
+	}
+}
+
+func TestTeXToMathMLDepthLimit(t *testing.T) {
+	nested := func(depth int) string {
+		return strings.Repeat("{", depth) + "x" + strings.Repeat("}", depth)
+	}
+	if err := ValidateTeX(nested(maxTeXDepth - 1)); err != nil {
+		t.Errorf("depth %d: %v", maxTeXDepth-1, err)
+	}
+	if err := ValidateTeX(nested(maxTeXDepth + 1)); err == nil {
+		t.Errorf("depth %d: want error", maxTeXDepth+1)
+	}
+	if err := ValidateTeX(strings.Repeat(`\sqrt{`, maxTeXDepth+1) + "x" + strings.Repeat("}", maxTeXDepth+1)); err == nil {
+		t.Errorf("nested \\sqrt: want error")
+	}
+}
+
+func TestTeXToMathMLLengthLimit(t *testing.T) {
+	if err := ValidateTeX(strings.Repeat("x", maxTeXLength)); err != nil {
+		t.Errorf("%d characters: %v", maxTeXLength, err)
+	}
+	if err := ValidateTeX(strings.Repeat("x", maxTeXLength+1)); err == nil {
+		t.Errorf("%d characters: want error", maxTeXLength+1)
+	}
+	// the limit is on characters, not bytes
+	if err := ValidateTeX(strings.Repeat("α", maxTeXLength)); err != nil {
+		t.Errorf("%d multibyte characters: %v", maxTeXLength, err)
+	}
+}
+
+func TestTeXToMathMLEnvironments(t *testing.T) {
+	tests := []struct {
+		name string
+		tex  string
+		want []string
+	}{
+		{
+			"pmatrix",
+			`\begin{pmatrix}a&b\\c&d\end{pmatrix}`,
+			[]string{
+				`<mo fence="true" stretchy="true">(</mo><mtable>`,
+				"<mtr><mtd><mi>a</mi></mtd><mtd><mi>b</mi></mtd></mtr><mtr><mtd><mi>c</mi></mtd><mtd><mi>d</mi></mtd></mtr>",
+				`</mtable><mo fence="true" stretchy="true">)</mo>`,
+			},
+		},
+		{
+			"cases",
+			`\begin{cases}1&x>0\\0&\text{otherwise}\end{cases}`,
+			[]string{
+				`<mo fence="true" stretchy="true">{</mo><mtable columnalign="left left">`,
+				"<mo>&gt;</mo>",
+				"<mtext>otherwise</mtext>",
+			},
+		},
+		{
+			"trailing row break",
+			`\begin{matrix}a\\b\\\end{matrix}`,
+			[]string{"<mtable><mtr><mtd><mi>a</mi></mtd></mtr><mtr><mtd><mi>b</mi></mtd></mtr></mtable>"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := TeXToMathML(tt.tex, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(out, `<math display="block">`) {
+				t.Errorf("output = %s, want a display math element", out)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("output = %s, want it to contain %s", out, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTeXToMathMLKeepsSource(t *testing.T) {
+	out, err := TeXToMathML(`x^2`, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<math><semantics><msup><mi>x</mi><mn>2</mn></msup><annotation encoding="application/x-tex">x^2</annotation></semantics></math>`
+	if out != want {
+		t.Errorf("output = %s, want %s", out, want)
+	}
+}
@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// Attachment is a file uploaded to be shown with a post or comment. Uploads
+// start out unlinked and are attached when the post or comment that uses
+// them is saved; CommentID is empty for attachments on the post itself.
+type Attachment struct {
+	ID           string    `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	UploaderID   string    `gorm:"index" json:"uploader_id"`
+	PostID       string    `gorm:"index" json:"post_id"`
+	CommentID    string    `gorm:"index" json:"comment_id"`
+	Filename     string    `json:"filename"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width,omitempty"`
+	Height       int       `json:"height,omitempty"`
+	Key          string    `json:"-"`
+	ThumbnailKey string    `json:"-"`
+	CreateDate   time.Time `gorm:"index" json:"create_date"`
+	URL          string    `gorm:"-" json:"url"`
+	ThumbnailURL string    `gorm:"-" json:"thumbnail_url,omitempty"`
+}
+
+type AttachmentUsage struct {
+	Used        int64 `json:"used"`
+	Quota       int64 `json:"quota"`
+	MaxFileSize int64 `json:"max_file_size"`
+}
@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// Ban keeps a user out of the site, out of posting anywhere, or out of
+// posting in one board. A ban without ExpiresAt is permanent. Lifted bans
+// are kept as history.
+type Ban struct {
+	ID         string     `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	UserID     string     `gorm:"index" json:"user_id"`
+	IssuedBy   string     `json:"issued_by"`
+	Reason     string     `json:"reason"`
+	Scope      string     `json:"scope"`
+	BoardID    string     `json:"board_id,omitempty"`
+	StartDate  time.Time  `json:"start_date"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LiftedBy   string     `json:"lifted_by,omitempty"`
+	LiftReason string     `json:"lift_reason,omitempty"`
+	LiftDate   *time.Time `json:"lift_date,omitempty"`
+}
+
+// NewBan issues a ban. A DurationHours of 0 makes it permanent.
+type NewBan struct {
+	Scope         string `json:"scope"`
+	BoardID       string `json:"board_id"`
+	Reason        string `json:"reason"`
+	DurationHours int    `json:"duration_hours"`
+}
@@ -7,6 +7,7 @@ type Board struct {
 	Name        string    `gorm:"UNIQUE" json:"name"`
 	Description string    `json:"description"`
 	CreateDate  time.Time `json:"create_date"`
+	SoftDelete
 }
 
 type NewBoard struct {
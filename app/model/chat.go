@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+type ChatMessage struct {
+	ID         string `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	AuthorID   string `gorm:"index" json:"author_id"`
+	Content    string `json:"content"`
+	Deleted    bool   `json:"deleted"`
+	DeletedBy  string `json:"-"`
+	CreateDate string `gorm:"index" json:"create_date"`
+}
+
+type ChatMute struct {
+	UserID     string    `gorm:"UNIQUE;PRIMARY_KEY" json:"user_id"`
+	MutedBy    string    `json:"muted_by"`
+	Reason     string    `json:"reason"`
+	Until      time.Time `json:"until"`
+	CreateDate time.Time `json:"create_date"`
+}
+
+type NewChatMute struct {
+	UserID  string `json:"user_id"`
+	Minutes int    `json:"minutes"`
+	Reason  string `json:"reason"`
+}
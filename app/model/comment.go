@@ -1,15 +1,39 @@
 package model
 
 type Comment struct {
-	ID         string `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
-	AuthorID   string `json:"author_id"`
-	PostID     string `json:"post_id"`
-	ParentID   string `json:"parent_id"`
-	Content    string `json:"content"`
-	CreateDate string `json:"create_date"`
+	ID          string `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	AuthorID    string `json:"author_id"`
+	PostID      string `json:"post_id"`
+	ParentID    string `gorm:"index" json:"parent_id"`
+	Depth       int    `json:"depth"`
+	Content     string `json:"content"`
+	ContentHTML string `json:"content_html"`
+	Deleted     bool   `json:"deleted"`
+	CreateDate  string `json:"create_date"`
+	Edited      bool   `json:"edited"`
+	EditCount   int    `json:"edit_count"`
+	EditDate    string `json:"edit_date,omitempty"`
+	RedirectID  string `json:"redirect_id,omitempty"`
+	Upvotes     int    `json:"upvotes"`
+	Downvotes   int    `json:"downvotes"`
+	Score       int    `json:"score"`
+	SoftDelete
 }
 
 type NewComment struct {
-	PostID  string `json:"post_id"`
-	Content string `json:"content"`
+	PostID        string   `json:"post_id"`
+	ParentID      string   `json:"parent_id"`
+	Content       string   `json:"content"`
+	AttachmentIDs []string `json:"attachment_ids"`
+}
+
+type CommentEdit struct {
+	Content       string   `json:"content"`
+	AttachmentIDs []string `json:"attachment_ids"`
+	Reason        string   `json:"reason"`
+}
+
+type CommentNode struct {
+	Comment
+	Children []*CommentNode `json:"children"`
 }
@@ -0,0 +1,52 @@
+package model
+
+import "time"
+
+type Conversation struct {
+	ID            string                    `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	CreatorID     string                    `json:"creator_id"`
+	Subject       string                    `json:"subject"`
+	LastMessageAt string                    `gorm:"index" json:"last_message_at"`
+	CreateDate    time.Time                 `json:"create_date"`
+	Participants  []ConversationParticipant `gorm:"foreignKey:ConversationID" json:"participants"`
+	Unread        int64                     `gorm:"-" json:"unread"`
+}
+
+// ConversationParticipant links a user to a conversation. LastReadAt is the
+// create date of the newest message the user has read and doubles as their
+// read receipt. LeftDate is set once the user leaves.
+type ConversationParticipant struct {
+	ConversationID string     `gorm:"PRIMARY_KEY" json:"conversation_id"`
+	UserID         string     `gorm:"PRIMARY_KEY;index" json:"user_id"`
+	LastReadAt     string     `json:"last_read_at"`
+	LeftDate       *time.Time `json:"left_date,omitempty"`
+	JoinDate       time.Time  `json:"join_date"`
+}
+
+type Message struct {
+	ID             string `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	ConversationID string `gorm:"index" json:"conversation_id"`
+	AuthorID       string `json:"author_id"`
+	Content        string `json:"content"`
+	CreateDate     string `gorm:"index" json:"create_date"`
+}
+
+type Block struct {
+	UserID     string    `gorm:"PRIMARY_KEY" json:"user_id"`
+	BlockedID  string    `gorm:"PRIMARY_KEY" json:"blocked_id"`
+	CreateDate time.Time `json:"create_date"`
+}
+
+type NewConversation struct {
+	Participants []string `json:"participants"`
+	Subject      string   `json:"subject"`
+	Content      string   `json:"content"`
+}
+
+type NewMessage struct {
+	Content string `json:"content"`
+}
+
+type UnreadCount struct {
+	Unread int64 `json:"unread"`
+}
@@ -0,0 +1,35 @@
+package model
+
+import "time"
+
+// EmailToken is a single use token sent by email to verify an address,
+// reset a password or confirm an email change. Email holds the new address
+// for email changes.
+type EmailToken struct {
+	ID         string     `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	UserID     string     `gorm:"index" json:"user_id"`
+	Purpose    string     `json:"purpose"`
+	TokenHash  string     `gorm:"UNIQUE" json:"-"`
+	Email      string     `json:"email"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	UsedAt     *time.Time `json:"used_at"`
+	CreateDate time.Time  `json:"create_date"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type EmailTokenRequest struct {
+	Token string `json:"token"`
+}
+
+type EmailChangeRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// LoginAttempt records one try at signing in. Failed attempts for unknown
+// emails have no UserID but still count against the IP they came from.
+type LoginAttempt struct {
+	ID         string    `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	UserID     string    `gorm:"index" json:"-"`
+	IP         string    `gorm:"index" json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Success    bool      `json:"success"`
+	NewClient  bool      `json:"new_client"`
+	CreateDate time.Time `json:"create_date"`
+}
@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+// Notification tells a user something happened. Unread notifications with
+// the same GroupKey are folded into one, counting how many times it happened.
+type Notification struct {
+	ID         string `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	UserID     string `gorm:"index" json:"user_id"`
+	Type       string `json:"type"`
+	ActorID    string `json:"actor_id"`
+	TargetType string `json:"target_type"`
+	TargetID   string `json:"target_id"`
+	GroupKey   string `gorm:"index" json:"-"`
+	Count      int    `json:"count"`
+	Message    string `json:"message"`
+	Read       bool   `json:"read"`
+	UpdateDate string `gorm:"index" json:"update_date"`
+}
+
+type NotificationPreference struct {
+	UserID  string `gorm:"PRIMARY_KEY" json:"user_id"`
+	Type    string `gorm:"PRIMARY_KEY" json:"type"`
+	Enabled bool   `json:"enabled"`
+}
+
+type BoardSubscription struct {
+	UserID     string    `gorm:"PRIMARY_KEY" json:"user_id"`
+	BoardID    string    `gorm:"PRIMARY_KEY;index" json:"board_id"`
+	CreateDate time.Time `json:"create_date"`
+}
@@ -1,15 +1,58 @@
 package model
 
 type Post struct {
-	ID         string `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
-	AuthorID   string `json:"author_id"`
-	BoardID    string `json:"board_id"`
-	Title      string `json:"title"`
-	Content    string `json:"content"`
-	CreateDate string `json:"create_date"`
+	ID           string `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	AuthorID     string `json:"author_id"`
+	BoardID      string `json:"board_id"`
+	Title        string `json:"title"`
+	Content      string `json:"content"`
+	ContentHTML  string `json:"content_html"`
+	CreateDate   string `json:"create_date"`
+	CommentCount int    `json:"comment_count"`
+	LastActivity string `json:"last_activity"`
+	Edited       bool   `json:"edited"`
+	EditCount    int    `json:"edit_count"`
+	EditDate     string `json:"edit_date,omitempty"`
+	Pinned       bool   `json:"pinned"`
+	Announced    bool   `json:"announced"`
+	Locked       bool   `json:"locked"`
+	Archived     bool   `json:"archived"`
+	RedirectID   string `json:"redirect_id,omitempty"`
+	Upvotes      int    `json:"upvotes"`
+	Downvotes    int    `json:"downvotes"`
+	Score        int    `json:"score"`
+	Tags         []Tag  `gorm:"many2many:post_tags" json:"tags"`
+	SoftDelete
 }
 
 type NewPost struct {
-	Title   string `json:"title"`
-	Content string `json:"content"`
+	Title         string   `json:"title"`
+	Content       string   `json:"content"`
+	AttachmentIDs []string `json:"attachment_ids"`
+	Tags          []string `json:"tags"`
+}
+
+// PostEdit leaves the tags as they are when Tags is omitted.
+type PostEdit struct {
+	Title         string   `json:"title"`
+	Content       string   `json:"content"`
+	AttachmentIDs []string `json:"attachment_ids"`
+	Tags          []string `json:"tags"`
+	Reason        string   `json:"reason"`
+}
+
+type PostMove struct {
+	BoardID string `json:"board_id"`
+}
+
+type PostMerge struct {
+	TargetID string `json:"target_id"`
+}
+
+// PostSplit moves comments into a new post. Replies to the chosen comments
+// go with them. BoardID defaults to the board of the original post.
+type PostSplit struct {
+	Title      string   `json:"title"`
+	BoardID    string   `json:"board_id"`
+	CommentIDs []string `json:"comment_ids"`
 }
@@ -0,0 +1,45 @@
+package model
+
+import "time"
+
+// Report flags a post, comment, user or conversation for moderators. A
+// reporter can only have one open report on each target. Resolving a target
+// closes every open report on it with the same Status.
+type Report struct {
+	ID             string     `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	ReporterID     string     `gorm:"index" json:"reporter_id"`
+	TargetType     string     `gorm:"index:idx_reports_target" json:"target_type"`
+	TargetID       string     `gorm:"index:idx_reports_target" json:"target_id"`
+	TargetAuthorID string     `json:"target_author_id,omitempty"`
+	Category       string     `json:"category"`
+	Details        string     `json:"details"`
+	Status         string     `gorm:"index" json:"status"`
+	ResolvedBy     string     `json:"resolved_by,omitempty"`
+	ResolutionNote string     `json:"resolution_note,omitempty"`
+	ResolveDate    *time.Time `json:"resolve_date,omitempty"`
+	CreateDate     time.Time  `json:"create_date"`
+}
+
+type NewReport struct {
+	Category string `json:"category"`
+	Details  string `json:"details"`
+}
+
+// ReportQueueItem groups the open reports on one target. ID is the id of
+// the target.
+type ReportQueueItem struct {
+	ID              string    `json:"id"`
+	TargetType      string    `json:"target_type"`
+	TargetAuthorID  string    `json:"target_author_id,omitempty"`
+	ReportCount     int       `json:"report_count"`
+	FirstReportDate time.Time `json:"first_report_date"`
+	LastReportDate  time.Time `json:"last_report_date"`
+}
+
+// ReportResolution closes the open reports on a target. UserID picks the
+// participant to warn or ban when the target is a conversation.
+type ReportResolution struct {
+	Action string `json:"action"`
+	Note   string `json:"note"`
+	UserID string `json:"user_id"`
+}
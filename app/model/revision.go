@@ -0,0 +1,28 @@
+package model
+
+import "forum-server/app/diff"
+
+// Revision keeps what a post or comment said before one of its edits.
+// Number counts the edits to a target from 1, so revision 1 is the text as
+// first written.
+type Revision struct {
+	ID         string `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	TargetType string `gorm:"uniqueIndex:idx_revisions_target" json:"target_type"`
+	TargetID   string `gorm:"uniqueIndex:idx_revisions_target" json:"target_id"`
+	Number     int    `gorm:"uniqueIndex:idx_revisions_target" json:"number"`
+	EditorID   string `json:"editor_id"`
+	Reason     string `json:"reason"`
+	Title      string `json:"title,omitempty"`
+	Content    string `json:"content"`
+	CreateDate string `json:"create_date"`
+}
+
+// RevisionDiff compares two versions of a post or comment. From and To are
+// revision numbers, or "current" for the text as it is now.
+type RevisionDiff struct {
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Mode    string    `json:"mode"`
+	Title   []diff.Op `json:"title,omitempty"`
+	Content []diff.Op `json:"content"`
+}
@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+// Tag labels posts across boards. PostCount only counts posts that aren't
+// in the trash. Restricted tags can only be applied by staff.
+type Tag struct {
+	ID          string       `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	Name        string       `gorm:"UNIQUE" json:"name"`
+	Description string       `json:"description"`
+	Restricted  bool         `json:"restricted"`
+	PostCount   int          `json:"post_count"`
+	CreateDate  time.Time    `json:"create_date"`
+	Synonyms    []TagSynonym `json:"synonyms,omitempty"`
+}
+
+// TagSynonym is another name for a tag. Posts tagged with it get the tag
+// it points to instead.
+type TagSynonym struct {
+	ID         string    `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	Name       string    `gorm:"UNIQUE" json:"name"`
+	TagID      string    `gorm:"index" json:"tag_id"`
+	CreateDate time.Time `json:"create_date"`
+}
+
+type TagUpdate struct {
+	Description string `json:"description"`
+	Restricted  bool   `json:"restricted"`
+}
+
+type NewTagSynonym struct {
+	Name string `json:"name"`
+}
@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+type RefreshToken struct {
+	ID         string    `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	UserID     string    `gorm:"index" json:"user_id"`
+	FamilyID   string    `gorm:"index" json:"family_id"`
+	TokenHash  string    `gorm:"UNIQUE" json:"-"`
+	Revoked    bool      `json:"revoked"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	CreateDate time.Time `json:"create_date"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
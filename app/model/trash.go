@@ -0,0 +1,12 @@
+package model
+
+import "gorm.io/gorm"
+
+// SoftDelete is embedded in models that go to the trash instead of being
+// removed. gorm leaves rows with DeletedAt set out of every query that isn't
+// Unscoped, and they are purged for good once the retention period is over.
+type SoftDelete struct {
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	DeletedBy    string         `json:"deleted_by,omitempty"`
+	DeleteReason string         `json:"delete_reason,omitempty"`
+}
@@ -0,0 +1,74 @@
+package model
+
+import "time"
+
+// TwoFactor holds a user's TOTP secret. It is created when they start
+// setting up two-factor authentication and only takes effect once Enabled.
+type TwoFactor struct {
+	UserID     string     `gorm:"UNIQUE;PRIMARY_KEY" json:"-"`
+	Secret     string     `json:"-"`
+	Enabled    bool       `json:"enabled"`
+	LastPeriod int64      `json:"-"`
+	CreateDate time.Time  `json:"create_date"`
+	EnableDate *time.Time `json:"enable_date,omitempty"`
+}
+
+// RecoveryCode stands in for a TOTP code once, for users who have lost
+// their authenticator. Only the hash is kept.
+type RecoveryCode struct {
+	ID         string     `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	UserID     string     `gorm:"index" json:"user_id"`
+	CodeHash   string     `gorm:"UNIQUE" json:"-"`
+	UsedDate   *time.Time `json:"used_date,omitempty"`
+	CreateDate time.Time  `json:"create_date"`
+}
+
+// LoginChallenge is handed out when a password checks out but a second
+// factor is still needed. Only the hash of its token is kept.
+type LoginChallenge struct {
+	ID         string    `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	UserID     string    `gorm:"index" json:"user_id"`
+	TokenHash  string    `gorm:"UNIQUE" json:"-"`
+	Attempts   int       `json:"attempts"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	CreateDate time.Time `json:"create_date"`
+}
+
+type TwoFactorSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TwoFactorStatus struct {
+	Enabled           bool       `json:"enabled"`
+	Required          bool       `json:"required"`
+	EnableDate        *time.Time `json:"enable_date,omitempty"`
+	RecoveryCodesLeft int64      `json:"recovery_codes_left"`
+}
+
+// TwoFactorCode is a TOTP code or, where allowed, a recovery code.
+type TwoFactorCode struct {
+	Code string `json:"code"`
+}
+
+type TwoFactorDisable struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// TwoFactorChallenge is what UserLogin responds with instead of tokens when
+// the user has two-factor authentication on.
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool      `json:"two_factor_required"`
+	ChallengeToken    string    `json:"challenge_token"`
+	ExpiresAt         time.Time `json:"expires_at"`
+}
+
+type TwoFactorLogin struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
@@ -1,16 +1,18 @@
 package model
 
 type User struct {
-	ID         string `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
-	Username   string `gorm:"UNIQUE" json:"username"`
-	Email      string `gorm:"UNIQUE" json:"email"`
-	Password   string `json:"password"`
-	Bio        string `json:"bio"`
-	Reputation int    `json:"reputation"`
-	AvatarURL  string `json:"avatar_url"`
-	Role       string `json:"role"`
-	Active     bool   `json:"active"`
-	CreateDate string `json:"create_date"`
+	ID            string `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	Username      string `gorm:"UNIQUE" json:"username"`
+	Email         string `gorm:"UNIQUE" json:"email"`
+	Password      string `json:"password"`
+	Bio           string `json:"bio"`
+	Reputation    int    `json:"reputation"`
+	AvatarURL     string `json:"avatar_url"`
+	Role          string `json:"role"`
+	Active        bool   `json:"active"`
+	EmailVerified bool   `json:"email_verified"`
+	CreateDate    string `json:"create_date"`
+	SoftDelete
 }
 
 type PublicUser struct {
@@ -23,12 +25,23 @@ type PublicUser struct {
 	CreateDate string `json:"create_date"`
 }
 
+// UserUpdate is what users can change on their own profile. Fields left
+// out are kept as they are.
+type UserUpdate struct {
+	Username *string `json:"username"`
+	Bio      *string `json:"bio"`
+}
+
 type RegisterCredentials struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
+type RoleAssignment struct {
+	Role string `json:"role"`
+}
+
 // TODO: user either email or username, not just one
 type LoginCredentials struct {
 	Email    string `json:"email"`
@@ -36,8 +49,14 @@ type LoginCredentials struct {
 }
 
 type LoginResponse struct {
-	ID         string     `json:"id"`
-	Username   string     `json:"username"`
-	PublicUser PublicUser `json:"public_user"`
-	Token      string     `json:"token"`
+	ID            string     `json:"id"`
+	Username      string     `json:"username"`
+	PublicUser    PublicUser `json:"public_user"`
+	EmailVerified bool       `json:"email_verified"`
+	Token         string     `json:"token"`
+	RefreshToken  string     `json:"refresh_token,omitempty"`
+	// TwoFactorSetupRequired is set when the user's role needs two-factor
+	// authentication and they haven't set it up yet. Until they do, only
+	// the two-factor routes accept their token.
+	TwoFactorSetupRequired bool `json:"two_factor_setup_required,omitempty"`
 }
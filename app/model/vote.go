@@ -0,0 +1,48 @@
+package model
+
+import "time"
+
+// Vote is one user's up (1) or down (-1) vote on a post or comment.
+// TargetAuthorID is copied from the target so reputation can be summed
+// without joining both tables.
+type Vote struct {
+	ID             string    `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	UserID         string    `gorm:"uniqueIndex:idx_votes_user_target" json:"user_id"`
+	TargetType     string    `gorm:"uniqueIndex:idx_votes_user_target" json:"target_type"`
+	TargetID       string    `gorm:"uniqueIndex:idx_votes_user_target" json:"target_id"`
+	TargetAuthorID string    `gorm:"index" json:"target_author_id"`
+	Value          int       `json:"value"`
+	CreateDate     time.Time `json:"create_date"`
+}
+
+// Reaction is an emoji a user left on a post or comment. A user can leave
+// several different ones on the same target.
+type Reaction struct {
+	ID         string    `gorm:"UNIQUE;PRIMARY_KEY" json:"id"`
+	UserID     string    `gorm:"uniqueIndex:idx_reactions_user_target" json:"user_id"`
+	TargetType string    `gorm:"uniqueIndex:idx_reactions_user_target;index:idx_reactions_target" json:"target_type"`
+	TargetID   string    `gorm:"uniqueIndex:idx_reactions_user_target;index:idx_reactions_target" json:"target_id"`
+	Emoji      string    `gorm:"uniqueIndex:idx_reactions_user_target" json:"emoji"`
+	CreateDate time.Time `json:"create_date"`
+}
+
+type VoteRequest struct {
+	Value int `json:"value"`
+}
+
+type VoteResult struct {
+	Score     int `json:"score"`
+	Upvotes   int `json:"upvotes"`
+	Downvotes int `json:"downvotes"`
+	Vote      int `json:"vote"`
+}
+
+type ReactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+type ReactionCount struct {
+	Emoji   string `json:"emoji"`
+	Count   int64  `json:"count"`
+	Reacted bool   `json:"reacted"`
+}
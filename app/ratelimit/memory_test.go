@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// one token a second, up to three
+var testLimit = Limit{Requests: 3, Period: 3 * time.Second}
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func take(t *testing.T, s *MemoryStore, key string, now time.Time) Result {
+	t.Helper()
+	res, err := s.Take(key, testLimit, now)
+	if err != nil {
+		t.Fatalf("Take(%q): %v", key, err)
+	}
+	return res
+}
+
+func TestTakeBurst(t *testing.T) {
+	s := NewMemoryStore()
+	for want := 2; want >= 0; want-- {
+		res := take(t, s, "a", start)
+		if !res.Allowed || res.Remaining != want || res.Limit != 3 {
+			t.Fatalf("Take = %+v, want allowed with %d remaining", res, want)
+		}
+	}
+
+	res := take(t, s, "a", start)
+	if res.Allowed || !res.Tripped {
+		t.Fatalf("Take past the burst = %+v, want refused and tripped", res)
+	}
+	if res.RetryAfter != time.Second || res.Reset != 3*time.Second {
+		t.Errorf("RetryAfter, Reset = %v, %v, want 1s, 3s", res.RetryAfter, res.Reset)
+	}
+	if res := take(t, s, "a", start); res.Allowed || res.Tripped {
+		t.Errorf("second refusal = %+v, want refused without tripping again", res)
+	}
+	if res := take(t, s, "b", start); !res.Allowed {
+		t.Errorf("Take on another key = %+v, want allowed", res)
+	}
+}
+
+func TestTakeRefill(t *testing.T) {
+	s := NewMemoryStore()
+	for i := 0; i < 4; i++ {
+		take(t, s, "a", start)
+	}
+
+	if res := take(t, s, "a", start.Add(500*time.Millisecond)); res.Allowed || res.RetryAfter != 500*time.Millisecond {
+		t.Errorf("Take half way to a token = %+v, want refused for 500ms", res)
+	}
+	res := take(t, s, "a", start.Add(time.Second))
+	if !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("Take after a second = %+v, want allowed with 0 remaining", res)
+	}
+	if res := take(t, s, "a", start.Add(time.Second)); !res.Tripped {
+		t.Errorf("refusal after an allowed request = %+v, want tripped", res)
+	}
+
+	// a bucket left long enough holds no more than its limit
+	res = take(t, s, "a", start.Add(time.Hour))
+	if !res.Allowed || res.Remaining != 2 {
+		t.Errorf("Take after an hour = %+v, want allowed with 2 remaining", res)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	s := NewMemoryStore()
+	take(t, s, "idle", start)
+	for i := 0; i < 3; i++ {
+		take(t, s, "busy", start.Add(time.Minute))
+	}
+
+	// the next call sweeps, a second after "busy" was drained
+	s.calls = sweepEvery - 1
+	take(t, s, "new", start.Add(time.Minute+time.Second))
+
+	if _, ok := s.buckets["idle"]; ok {
+		t.Error("sweep kept a full bucket")
+	}
+	if _, ok := s.buckets["busy"]; !ok {
+		t.Error("sweep dropped a bucket that was still refilling")
+	}
+	if _, ok := s.buckets["new"]; !ok {
+		t.Error("bucket taken from during the sweep is missing")
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	tests := []struct {
+		method, path, want string
+	}{
+		{"OPTIONS", "/api/login", ""},
+		{"GET", "/api/posts", PolicyRead},
+		{"HEAD", "/api/posts", PolicyRead},
+		{"GET", "/api/email/verify", PolicyRead},
+		{"POST", "/api/login", PolicyAuth},
+		{"POST", "/api/login/2fa", PolicyAuth},
+		{"POST", "/api/register", PolicyAuth},
+		{"POST", "/api/password/forgot", PolicyAuth},
+		{"POST", "/api/token/refresh", PolicyRefresh},
+		{"POST", "/api/posts", PolicyWrite},
+		{"DELETE", "/api/posts/1", PolicyWrite},
+		{"POST", "/api/login/other", PolicyWrite},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := policyFor(r); got != tt.want {
+			t.Errorf("policyFor(%s %s) = %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
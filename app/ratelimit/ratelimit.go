@@ -0,0 +1,243 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"forum-server/app/auth"
+	"forum-server/app/config"
+	"forum-server/audit"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy names, from strictest to most relaxed.
+const (
+	PolicyAuth    = "auth"
+	PolicyRefresh = "refresh"
+	PolicyWrite   = "write"
+	PolicyRead    = "read"
+	// PolicyChat limits chat messages, which arrive over a websocket rather
+	// than as requests.
+	PolicyChat = "chat"
+)
+
+// refreshPath is limited on its own. Clients refresh on a timer, so sharing
+// the auth bucket would let a busy IP lock its users out of signing in.
+const refreshPath = "/api/token/refresh"
+
+// authPaths are the unauthenticated endpoints worth guessing at. They are
+// always limited by IP.
+var authPaths = []string{
+	"/api/login",
+	"/api/login/2fa",
+	"/api/register",
+	"/api/password/forgot",
+	"/api/password/reset",
+	"/api/email/verify",
+	"/api/email/confirm",
+}
+
+// Limit allows Requests per Period, refilling steadily so a client that
+// has used up its burst gets another request every Period/Requests.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until a refused client may try again.
+	RetryAfter time.Duration
+	// Tripped is set on the first refusal since the last allowed request,
+	// so a client hammering away is only reported once.
+	Tripped bool
+}
+
+// Store keeps token buckets. MemoryStore is enough for a single server;
+// instances behind a load balancer need a shared Store so they agree on
+// what each client has used.
+type Store interface {
+	Take(key string, limit Limit, now time.Time) (Result, error)
+}
+
+// Limiter is negroni middleware that throttles each client by policy. A
+// client is the signed in user when the request has a valid access token
+// and the remote IP otherwise.
+type Limiter struct {
+	Store    Store
+	Auditor  *audit.Auditor
+	Policies map[string]Limit
+	// Exempt roles are never limited, except on the auth and refresh
+	// policies.
+	Exempt []string
+}
+
+// New returns a Limiter backed by a MemoryStore, reading its limits from
+// the RATE_LIMIT_* variables. Each is requests per minute; 0 turns the
+// policy off.
+func New(auditor *audit.Auditor) *Limiter {
+	return &Limiter{
+		Store:   NewMemoryStore(),
+		Auditor: auditor,
+		Policies: map[string]Limit{
+			PolicyAuth:    {Requests: config.Int("RATE_LIMIT_AUTH", 10), Period: time.Minute},
+			PolicyRefresh: {Requests: config.Int("RATE_LIMIT_REFRESH", 30), Period: time.Minute},
+			PolicyWrite:   {Requests: config.Int("RATE_LIMIT_WRITE", 60), Period: time.Minute},
+			PolicyRead:    {Requests: config.Int("RATE_LIMIT_READ", 300), Period: time.Minute},
+			PolicyChat:    {Requests: config.Int("RATE_LIMIT_CHAT", 20), Period: time.Minute},
+		},
+		Exempt: config.List("RATE_LIMIT_EXEMPT_ROLES", "admin"),
+	}
+}
+
+func (l *Limiter) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	policy := policyFor(r)
+	limit, ok := l.Policies[policy]
+	if policy == "" || !ok || limit.Requests <= 0 || limit.Period <= 0 {
+		next(w, r)
+		return
+	}
+
+	userId := ""
+	key := policy + ":ip:" + ClientIP(r)
+	if policy != PolicyAuth && policy != PolicyRefresh {
+		if claims := bearerClaims(r); claims != nil {
+			if l.exempt(claims.Role) {
+				next(w, r)
+				return
+			}
+			userId = claims.ID
+			key = policy + ":user:" + claims.ID
+		}
+	}
+
+	res, err := l.Store.Take(key, limit, time.Now())
+	if err != nil {
+		// Don't take the site down with the limiter's store.
+		log.Println("ERROR RATE LIMIT:", err)
+		next(w, r)
+		return
+	}
+
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, int(limit.Period.Seconds())))
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	if res.Allowed {
+		next(w, r)
+		return
+	}
+
+	if res.Tripped && l.Auditor != nil {
+		l.Auditor.Log(userId, "Rate Limit", "Error", key+" "+r.Method+" "+r.URL.Path)
+	}
+
+	retryAfter := ceilSeconds(res.RetryAfter)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("too many requests, try again in %d seconds", retryAfter)})
+}
+
+// Allow takes a token for userId under policy, for traffic that doesn't
+// pass through the middleware such as websocket messages. Exempt roles and
+// policies that are off are always allowed.
+func (l *Limiter) Allow(policy, userId, role string) Result {
+	limit, ok := l.Policies[policy]
+	if !ok || limit.Requests <= 0 || limit.Period <= 0 || l.exempt(role) {
+		return Result{Allowed: true}
+	}
+
+	key := policy + ":user:" + userId
+	res, err := l.Store.Take(key, limit, time.Now())
+	if err != nil {
+		log.Println("ERROR RATE LIMIT:", err)
+		return Result{Allowed: true}
+	}
+	if !res.Allowed && res.Tripped && l.Auditor != nil {
+		l.Auditor.Log(userId, "Rate Limit", "Error", key)
+	}
+	return res
+}
+
+// RetryAfterSeconds is RetryAfter rounded up to whole seconds.
+func (r Result) RetryAfterSeconds() int {
+	return ceilSeconds(r.RetryAfter)
+}
+
+func (l *Limiter) exempt(role string) bool {
+	for _, r := range l.Exempt {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// policyFor picks the policy for r, or "" for requests that aren't limited
+// such as CORS preflights.
+func policyFor(r *http.Request) string {
+	switch r.Method {
+	case http.MethodOptions:
+		return ""
+	case http.MethodGet, http.MethodHead:
+		return PolicyRead
+	}
+	if r.URL.Path == refreshPath {
+		return PolicyRefresh
+	}
+	for _, p := range authPaths {
+		if r.URL.Path == p {
+			return PolicyAuth
+		}
+	}
+	return PolicyWrite
+}
+
+// bearerClaims returns the claims of the request's access token, or nil if
+// it has none or it doesn't verify. Revoked sessions still count as their
+// user here; they are turned away later by the session check.
+func bearerClaims(r *http.Request) *auth.Claims {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil
+	}
+	claims, err := auth.ParseToken(strings.TrimPrefix(header, "Bearer "))
+	if err != nil {
+		return nil
+	}
+	return claims
+}
+
+// ClientIP returns the address r came from. X-Forwarded-For is only
+// trusted when TRUST_PROXY is "true", since clients can set it themselves.
+func ClientIP(r *http.Request) string {
+	if config.Bool("TRUST_PROXY") {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,36 @@
+package ratelimit
+
+import "testing"
+
+func TestAllow(t *testing.T) {
+	l := &Limiter{
+		Store:    NewMemoryStore(),
+		Policies: map[string]Limit{PolicyChat: testLimit, PolicyWrite: {}},
+		Exempt:   []string{"admin"},
+	}
+
+	for i := 0; i < testLimit.Requests; i++ {
+		if res := l.Allow(PolicyChat, "a", "user"); !res.Allowed {
+			t.Fatalf("message %d refused: %+v", i+1, res)
+		}
+	}
+	res := l.Allow(PolicyChat, "a", "user")
+	if res.Allowed || res.RetryAfterSeconds() < 1 {
+		t.Fatalf("message past the burst = %+v, want refused with a wait", res)
+	}
+
+	if res := l.Allow(PolicyChat, "b", "user"); !res.Allowed {
+		t.Error("another user shares the bucket")
+	}
+	for i := 0; i <= testLimit.Requests; i++ {
+		if res := l.Allow(PolicyChat, "c", "admin"); !res.Allowed {
+			t.Fatal("exempt role refused")
+		}
+	}
+	if res := l.Allow(PolicyWrite, "a", "user"); !res.Allowed {
+		t.Error("policy that is off refused")
+	}
+	if res := l.Allow("unknown", "a", "user"); !res.Allowed {
+		t.Error("unknown policy refused")
+	}
+}
@@ -0,0 +1,71 @@
+package reputation
+
+import (
+	"errors"
+	"fmt"
+	"forum-server/app/model"
+	"forum-server/audit"
+	"forum-server/db"
+
+	"gorm.io/gorm"
+)
+
+const (
+	reputationUsage    = "usage: forum-server reputation"
+	recalculateBatch   = 200
+	recalculatedFormat = "recalculated reputation for %d users"
+	recountedFormat    = "recounted votes on %d posts and %d comments"
+)
+
+// RunCommand handles the reputation subcommand, given the arguments that
+// follow it. It recounts the vote totals on every post and comment and
+// recomputes every user's reputation, e.g. after changing the weights.
+func RunCommand(auditor *audit.Auditor, args []string) error {
+	if len(args) > 0 {
+		return errors.New(reputationUsage)
+	}
+
+	conn := db.Open(auditor)
+	posts, err := recountVotes(conn, &model.Post{}, "posts", "post")
+	if err != nil {
+		auditor.Log("", "Recalculate Reputation", "Error", err.Error())
+		return err
+	}
+	comments, err := recountVotes(conn, &model.Comment{}, "comments", "comment")
+	if err != nil {
+		auditor.Log("", "Recalculate Reputation", "Error", err.Error())
+		return err
+	}
+	fmt.Printf(recountedFormat+"\n", posts, comments)
+
+	count := 0
+	users := []model.User{}
+	err = conn.Unscoped().Select("id").FindInBatches(&users, recalculateBatch, func(tx *gorm.DB, batch int) error {
+		for _, user := range users {
+			if _, err := Recalculate(conn, user.ID); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	}).Error
+	if err != nil {
+		auditor.Log("", "Recalculate Reputation", "Error", err.Error())
+		return err
+	}
+
+	auditor.Log("", "Recalculate Reputation", "Success", fmt.Sprintf(recalculatedFormat, count))
+	fmt.Printf(recalculatedFormat+"\n", count)
+	return nil
+}
+
+// recountVotes sets upvotes, downvotes and score on every row of table from
+// the votes cast on it.
+func recountVotes(conn *gorm.DB, value interface{}, table, targetType string) (int64, error) {
+	result := conn.Session(&gorm.Session{AllowGlobalUpdate: true}).Unscoped().Model(value).Updates(map[string]interface{}{
+		"upvotes":   gorm.Expr("(SELECT count(*) FROM votes v WHERE v.target_type = ? AND v.target_id = "+table+".id AND v.value > 0)", targetType),
+		"downvotes": gorm.Expr("(SELECT count(*) FROM votes v WHERE v.target_type = ? AND v.target_id = "+table+".id AND v.value < 0)", targetType),
+		"score":     gorm.Expr("(SELECT coalesce(sum(v.value), 0) FROM votes v WHERE v.target_type = ? AND v.target_id = "+table+".id)", targetType),
+	})
+	return result.RowsAffected, result.Error
+}
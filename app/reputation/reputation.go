@@ -0,0 +1,101 @@
+package reputation
+
+import (
+	"forum-server/app/config"
+	"forum-server/app/model"
+
+	"gorm.io/gorm"
+)
+
+// Abilities unlocked by reputation rather than granted by a role.
+const (
+	AbilityVoteDown   = "vote.down"
+	AbilityEditOthers = "post.edit.others"
+)
+
+// Thresholds is the reputation each ability needs.
+var Thresholds = map[string]int{
+	AbilityVoteDown:   125,
+	AbilityEditOthers: 2000,
+}
+
+// Allows reports whether reputation is enough for ability.
+func Allows(reputation int, ability string) bool {
+	threshold, ok := Thresholds[ability]
+	return ok && reputation >= threshold
+}
+
+// Weights are the reputation a user gains or loses for each vote their
+// posts and comments receive. Gains are capped per day; losses are not.
+type Weights struct {
+	PostUpvote      int `json:"post_upvote"`
+	PostDownvote    int `json:"post_downvote"`
+	CommentUpvote   int `json:"comment_upvote"`
+	CommentDownvote int `json:"comment_downvote"`
+	DailyCap        int `json:"daily_cap"`
+}
+
+// LoadWeights builds the weights from REPUTATION_POST_UPVOTE and the other
+// REPUTATION_* settings. Downvote weights are expected to be negative.
+func LoadWeights() Weights {
+	return Weights{
+		PostUpvote:      config.Int("REPUTATION_POST_UPVOTE", 10),
+		PostDownvote:    config.Int("REPUTATION_POST_DOWNVOTE", -2),
+		CommentUpvote:   config.Int("REPUTATION_COMMENT_UPVOTE", 5),
+		CommentDownvote: config.Int("REPUTATION_COMMENT_DOWNVOTE", -1),
+		DailyCap:        config.Int("REPUTATION_DAILY_CAP", 200),
+	}
+}
+
+func (w Weights) weight(targetType string, value int) int {
+	switch {
+	case targetType == "post" && value > 0:
+		return w.PostUpvote
+	case targetType == "post" && value < 0:
+		return w.PostDownvote
+	case value > 0:
+		return w.CommentUpvote
+	case value < 0:
+		return w.CommentDownvote
+	}
+	return 0
+}
+
+// Compute sums reputation from votes received, capping what can be gained
+// on each UTC day. It never goes below zero.
+func (w Weights) Compute(votes []model.Vote) int {
+	gained := map[string]int{}
+	lost := 0
+	for _, v := range votes {
+		n := w.weight(v.TargetType, v.Value)
+		if n < 0 {
+			lost += n
+			continue
+		}
+		gained[v.CreateDate.UTC().Format("2006-01-02")] += n
+	}
+
+	total := lost
+	for _, n := range gained {
+		if w.DailyCap > 0 && n > w.DailyCap {
+			n = w.DailyCap
+		}
+		total += n
+	}
+	if total < 0 {
+		return 0
+	}
+	return total
+}
+
+// Recalculate recomputes one user's reputation from the votes on their
+// posts and comments and saves it.
+func Recalculate(db *gorm.DB, userId string) (int, error) {
+	votes := []model.Vote{}
+	if err := db.Select("target_type, value, create_date").Where("target_author_id = ?", userId).Find(&votes).Error; err != nil {
+		return 0, err
+	}
+	reputation := LoadWeights().Compute(votes)
+	err := db.Model(&model.User{}).Where("id = ?", userId).Update("reputation", reputation).Error
+	return reputation, err
+}
@@ -0,0 +1,51 @@
+package reputation
+
+import (
+	"forum-server/app/model"
+	"testing"
+	"time"
+)
+
+func TestCompute(t *testing.T) {
+	w := Weights{PostUpvote: 10, PostDownvote: -2, CommentUpvote: 5, CommentDownvote: -1, DailyCap: 25}
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	vote := func(targetType string, value int, at time.Time) model.Vote {
+		return model.Vote{TargetType: targetType, Value: value, CreateDate: at}
+	}
+
+	tests := []struct {
+		name  string
+		votes []model.Vote
+		want  int
+	}{
+		{"nothing", nil, 0},
+		{"mixed", []model.Vote{vote("post", 1, day), vote("comment", 1, day), vote("post", -1, day), vote("comment", -1, day)}, 12},
+		{"capped per day", []model.Vote{vote("post", 1, day), vote("post", 1, day), vote("post", 1, day)}, 25},
+		{"cap resets the next day", []model.Vote{vote("post", 1, day), vote("post", 1, day), vote("post", 1, day), vote("post", 1, day.Add(24*time.Hour))}, 35},
+		{"losses aren't capped", []model.Vote{vote("post", 1, day), vote("post", 1, day), vote("post", 1, day), vote("post", -1, day)}, 23},
+		{"never negative", []model.Vote{vote("post", -1, day)}, 0},
+	}
+	for _, tt := range tests {
+		if got := w.Compute(tt.votes); got != tt.want {
+			t.Errorf("%s: Compute = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAllows(t *testing.T) {
+	if Allows(Thresholds[AbilityVoteDown]-1, AbilityVoteDown) || !Allows(Thresholds[AbilityVoteDown], AbilityVoteDown) {
+		t.Errorf("vote down threshold isn't %d", Thresholds[AbilityVoteDown])
+	}
+	if Allows(1<<30, "no.such.ability") {
+		t.Error("unknown abilities allowed")
+	}
+}
+
+func TestLoadWeights(t *testing.T) {
+	t.Setenv("REPUTATION_POST_UPVOTE", "15")
+	t.Setenv("REPUTATION_DAILY_CAP", "0")
+	w := LoadWeights()
+	if w.PostUpvote != 15 || w.DailyCap != 0 || w.CommentUpvote != 5 {
+		t.Errorf("LoadWeights = %+v", w)
+	}
+}
@@ -0,0 +1,214 @@
+package search
+
+import (
+	"forum-server/app/model"
+	"html"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const snippetRadius = 80
+
+// searchLike is a substring search for databases without full text
+// support. It matches the whole query text case-insensitively and ranks
+// title matches above body matches.
+//
+// Each type is sorted and cut off in the database at the end of the
+// requested page; those are the only rows that can make it onto the page
+// once the types are merged.
+func searchLike(db *gorm.DB, q Query) ([]Result, int64, error) {
+	text := strings.ToLower(strings.TrimSpace(q.Text))
+	pattern := "%" + escapeLike(text) + "%"
+	like := func(column string) string {
+		return "LOWER(" + column + ") LIKE ? ESCAPE '\\'"
+	}
+	// order sorts rows the same way results are merged below: title
+	// matches first, then newest first.
+	order := func(title, date, id string) clause.OrderBy {
+		return clause.OrderBy{Expression: clause.Expr{
+			SQL:  "CASE WHEN " + like(title) + " THEN 1 ELSE 0 END DESC, " + date + " DESC, " + id,
+			Vars: []interface{}{pattern},
+		}}
+	}
+	limit := q.Offset + q.Limit
+
+	results := []Result{}
+	var total int64
+
+	if q.wants(TypePost) {
+		rows := []Result{}
+		query := q.likeFilters(db.Table("posts p").
+			Where("("+like("p.title")+" OR "+like("p.content")+")", pattern, pattern).
+			Where("p.deleted_at IS NULL"), "p.board_id", "p.author_id", "p.create_date").Session(&gorm.Session{})
+		var count int64
+		if err := query.Count(&count).Error; err != nil {
+			return nil, 0, err
+		}
+		total += count
+		err := query.Select("p.id, p.title, p.content AS snippet, p.board_id, p.author_id, p.create_date").
+			Clauses(order("p.title", "p.create_date", "p.id")).Limit(limit).Scan(&rows).Error
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, row := range rows {
+			row.Type = TypePost
+			results = append(results, scoreLike(row, text))
+		}
+	}
+
+	if q.wants(TypeComment) {
+		rows := []Result{}
+		query := q.likeFilters(db.Table("comments c").
+			Joins("JOIN posts p ON p.id = c.post_id").
+			Where(like("c.content"), pattern).
+			Where("c.deleted = ? AND c.deleted_at IS NULL AND p.deleted_at IS NULL", false), "p.board_id", "c.author_id", "c.create_date").Session(&gorm.Session{})
+		var count int64
+		if err := query.Count(&count).Error; err != nil {
+			return nil, 0, err
+		}
+		total += count
+		err := query.Select("c.id, p.title, c.content AS snippet, p.board_id, c.post_id, c.author_id, c.create_date").
+			Clauses(order("p.title", "c.create_date", "c.id")).Limit(limit).Scan(&rows).Error
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, row := range rows {
+			row.Type = TypeComment
+			results = append(results, scoreLike(row, text))
+		}
+	}
+
+	if q.wants(TypeBoard) {
+		boards := []model.Board{}
+		query := db.Model(&model.Board{}).Where("("+like("name")+" OR "+like("description")+")", pattern, pattern)
+		if q.BoardID != "" {
+			query = query.Where("id = ?", q.BoardID)
+		}
+		if !q.From.IsZero() {
+			query = query.Where("create_date >= ?", q.From.UTC())
+		}
+		if !q.To.IsZero() {
+			query = query.Where("create_date <= ?", q.To.UTC())
+		}
+		query = query.Session(&gorm.Session{})
+		var count int64
+		if err := query.Count(&count).Error; err != nil {
+			return nil, 0, err
+		}
+		total += count
+		if err := query.Clauses(order("name", "create_date", "id")).Limit(limit).Find(&boards).Error; err != nil {
+			return nil, 0, err
+		}
+		for _, b := range boards {
+			results = append(results, scoreLike(Result{
+				Type:       TypeBoard,
+				ID:         b.ID,
+				Title:      b.Name,
+				Snippet:    b.Description,
+				BoardID:    b.ID,
+				CreateDate: formatDate(b.CreateDate),
+			}, text))
+		}
+	}
+
+	if q.wants(TypeUser) {
+		users := []model.User{}
+		query := q.likeFilters(db.Model(&model.User{}).
+			Where("("+like("username")+" OR "+like("bio")+")", pattern, pattern).
+			Where("active = ?", true), "", "", "create_date").Session(&gorm.Session{})
+		var count int64
+		if err := query.Count(&count).Error; err != nil {
+			return nil, 0, err
+		}
+		total += count
+		if err := query.Clauses(order("username", "create_date", "id")).Limit(limit).Find(&users).Error; err != nil {
+			return nil, 0, err
+		}
+		for _, u := range users {
+			results = append(results, scoreLike(Result{
+				Type:       TypeUser,
+				ID:         u.ID,
+				Title:      u.Username,
+				Snippet:    u.Bio,
+				AuthorID:   u.ID,
+				CreateDate: u.CreateDate,
+			}, text))
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		if results[i].CreateDate != results[j].CreateDate {
+			return results[i].CreateDate > results[j].CreateDate
+		}
+		return results[i].ID < results[j].ID
+	})
+
+	if q.Offset >= len(results) {
+		return []Result{}, total, nil
+	}
+	results = results[q.Offset:]
+	if len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+	return results, total, nil
+}
+
+func (q *Query) likeFilters(query *gorm.DB, boardColumn, authorColumn, dateColumn string) *gorm.DB {
+	where, args := q.filters(boardColumn, authorColumn, dateColumn, false)
+	if where == "" {
+		return query
+	}
+	return query.Where(strings.TrimPrefix(where, " AND "), args...)
+}
+
+// scoreLike ranks a result and replaces its snippet with an escaped excerpt
+// around the first match.
+func scoreLike(result Result, text string) Result {
+	result.Rank = 0.5
+	if strings.Contains(strings.ToLower(result.Title), text) {
+		result.Rank = 1
+	}
+	result.Snippet = excerpt(result.Snippet, text)
+	return result
+}
+
+func excerpt(content, text string) string {
+	lower := strings.ToLower(content)
+	i := strings.Index(lower, text)
+	if i < 0 || text == "" || len(lower) != len(content) {
+		end := len(content)
+		if end > 2*snippetRadius {
+			end = 2 * snippetRadius
+		}
+		for end < len(content) && !utf8.RuneStart(content[end]) {
+			end++
+		}
+		return html.EscapeString(content[:end])
+	}
+
+	start := i - snippetRadius
+	if start < 0 {
+		start = 0
+	}
+	for start > 0 && !utf8.RuneStart(content[start]) {
+		start--
+	}
+	end := i + len(text) + snippetRadius
+	if end > len(content) {
+		end = len(content)
+	}
+	for end < len(content) && !utf8.RuneStart(content[end]) {
+		end++
+	}
+
+	return html.EscapeString(content[start:i]) +
+		"<mark>" + html.EscapeString(content[i:i+len(text)]) + "</mark>" +
+		html.EscapeString(content[i+len(text):end])
+}
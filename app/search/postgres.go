@@ -0,0 +1,121 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const headlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=30, MinWords=10"
+
+// searchPostgres ranks matches from every requested table in one UNION so
+// ordering and paging are global rather than per type.
+func searchPostgres(db *gorm.DB, q Query) ([]Result, int64, error) {
+	parts := []string{}
+	args := []interface{}{}
+
+	if q.wants(TypePost) {
+		where, whereArgs := q.filters("p.board_id", "p.author_id", "p.create_date", false)
+		parts = append(parts, `SELECT 'post' AS type, p.id, p.title, `+headline("p.content")+` AS snippet,
+			p.board_id, '' AS post_id, p.author_id, p.create_date, ts_rank(p.search_vector, s.query) AS rank
+			FROM posts p, search s WHERE p.search_vector @@ s.query AND p.deleted_at IS NULL`+where)
+		args = append(args, whereArgs...)
+	}
+	if q.wants(TypeComment) {
+		where, whereArgs := q.filters("p.board_id", "c.author_id", "c.create_date", false)
+		parts = append(parts, `SELECT 'comment' AS type, c.id, p.title, `+headline("c.content")+` AS snippet,
+			p.board_id, c.post_id, c.author_id, c.create_date, ts_rank(c.search_vector, s.query) AS rank
+			FROM comments c JOIN posts p ON p.id = c.post_id, search s
+			WHERE c.search_vector @@ s.query AND c.deleted = false
+			AND c.deleted_at IS NULL AND p.deleted_at IS NULL`+where)
+		args = append(args, whereArgs...)
+	}
+	if q.wants(TypeBoard) {
+		where, whereArgs := q.filters("b.id", "", "b.create_date", true)
+		parts = append(parts, `SELECT 'board' AS type, b.id, b.name AS title, `+headline("b.description")+` AS snippet,
+			b.id AS board_id, '' AS post_id, '' AS author_id,
+			to_char(b.create_date AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"') AS create_date,
+			ts_rank(b.search_vector, s.query) AS rank
+			FROM boards b, search s WHERE b.search_vector @@ s.query AND b.deleted_at IS NULL`+where)
+		args = append(args, whereArgs...)
+	}
+	if q.wants(TypeUser) {
+		where, whereArgs := q.filters("", "", "u.create_date", false)
+		parts = append(parts, `SELECT 'user' AS type, u.id, u.username AS title, `+headline("u.bio")+` AS snippet,
+			'' AS board_id, '' AS post_id, u.id AS author_id, u.create_date, ts_rank(u.search_vector, s.query) AS rank
+			FROM users u, search s WHERE u.search_vector @@ s.query AND u.active = true AND u.deleted_at IS NULL`+where)
+		args = append(args, whereArgs...)
+	}
+
+	results := []Result{}
+	if len(parts) == 0 {
+		return results, 0, nil
+	}
+
+	with := "WITH search AS (SELECT websearch_to_tsquery('english', ?) AS query) "
+	union := strings.Join(parts, " UNION ALL ")
+	args = append([]interface{}{q.Text}, args...)
+
+	var total int64
+	if err := db.Raw(with+"SELECT count(*) FROM ("+union+") results", args...).Scan(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, q.Limit, q.Offset)
+	if err := db.Raw(with+"SELECT * FROM ("+union+") results ORDER BY rank DESC, create_date DESC, id LIMIT ? OFFSET ?", args...).Scan(&results).Error; err != nil {
+		return nil, 0, err
+	}
+	return results, total, nil
+}
+
+// headline highlights matches in column. The text is HTML escaped first so
+// the only markup in a snippet is the <mark> tags.
+func headline(column string) string {
+	return fmt.Sprintf("ts_headline('english', %s, s.query, '%s')", escapeHTML(column), headlineOptions)
+}
+
+// escapeHTML is SQL that escapes the HTML special characters in column,
+// ampersands first so the other escapes aren't escaped again.
+func escapeHTML(column string) string {
+	return fmt.Sprintf("replace(replace(replace(coalesce(%s, ''), '&', '&amp;'), '<', '&lt;'), '>', '&gt;')", column)
+}
+
+// filters builds the AND clauses for the query's board, author and date
+// filters. An empty column name means the filter doesn't apply.
+func (q *Query) filters(boardColumn, authorColumn, dateColumn string, dateIsTime bool) (string, []interface{}) {
+	clauses := []string{}
+	args := []interface{}{}
+
+	if q.BoardID != "" && boardColumn != "" {
+		clauses = append(clauses, boardColumn+" = ?")
+		args = append(args, q.BoardID)
+	}
+	if q.AuthorID != "" && authorColumn != "" {
+		clauses = append(clauses, authorColumn+" = ?")
+		args = append(args, q.AuthorID)
+	}
+	if !q.From.IsZero() {
+		clauses = append(clauses, dateColumn+" >= ?")
+		args = append(args, dateArg(q.From, dateIsTime))
+	}
+	if !q.To.IsZero() {
+		clauses = append(clauses, dateColumn+" <= ?")
+		args = append(args, dateArg(q.To, dateIsTime))
+	}
+
+	if len(clauses) == 0 {
+		return "", args
+	}
+	return " AND " + strings.Join(clauses, " AND "), args
+}
+
+// dateArg matches the column type: boards store a timestamp while posts,
+// comments and users store RFC 3339 strings.
+func dateArg(t time.Time, dateIsTime bool) interface{} {
+	if dateIsTime {
+		return t.UTC()
+	}
+	return formatDate(t)
+}
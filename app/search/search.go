@@ -0,0 +1,117 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	TypePost    = "post"
+	TypeComment = "comment"
+	TypeBoard   = "board"
+	TypeUser    = "user"
+)
+
+var Types = []string{TypePost, TypeComment, TypeBoard, TypeUser}
+
+// tables maps each searchable type to its table and the expression its
+// search_vector column is built from. The columns are created and first
+// filled in by the 0006_search migration, which must use the same
+// expressions.
+var tables = map[string]struct {
+	table  string
+	vector string
+}{
+	TypePost: {"posts", `setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+		setweight(to_tsvector('english', coalesce(content, '')), 'B')`},
+	TypeComment: {"comments", `to_tsvector('english', coalesce(content, ''))`},
+	TypeBoard: {"boards", `setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+		setweight(to_tsvector('english', coalesce(description, '')), 'B')`},
+	TypeUser: {"users", `setweight(to_tsvector('simple', coalesce(username, '')), 'A') ||
+		setweight(to_tsvector('english', coalesce(bio, '')), 'B')`},
+}
+
+type Query struct {
+	Text     string
+	Types    []string
+	BoardID  string
+	AuthorID string
+	From     time.Time
+	To       time.Time
+	Offset   int
+	Limit    int
+}
+
+type Result struct {
+	Type       string  `json:"type"`
+	ID         string  `json:"id"`
+	Title      string  `json:"title"`
+	Snippet    string  `json:"snippet"`
+	BoardID    string  `json:"board_id,omitempty"`
+	PostID     string  `json:"post_id,omitempty"`
+	AuthorID   string  `json:"author_id,omitempty"`
+	CreateDate string  `json:"create_date"`
+	Rank       float64 `json:"rank"`
+}
+
+func isPostgres(db *gorm.DB) bool {
+	return db.Dialector.Name() == "postgres"
+}
+
+// Index rebuilds the search vector of one row after it was created or
+// edited.
+func Index(db *gorm.DB, kind, id string) error {
+	if !isPostgres(db) {
+		return nil
+	}
+	t, ok := tables[kind]
+	if !ok {
+		return fmt.Errorf("unknown search type %q", kind)
+	}
+	return db.Exec(fmt.Sprintf("UPDATE %s SET search_vector = %s WHERE id = ?", t.table, t.vector), id).Error
+}
+
+// Search returns one page of results ranked by relevance, along with the
+// total number of matches.
+func Search(db *gorm.DB, q Query) ([]Result, int64, error) {
+	if len(q.Types) == 0 {
+		q.Types = Types
+	}
+	if isPostgres(db) {
+		return searchPostgres(db, q)
+	}
+	return searchLike(db, q)
+}
+
+// wants reports whether kind should be searched given the query's type list
+// and filters. Boards and users have no author to filter on and users belong
+// to no board.
+func (q *Query) wants(kind string) bool {
+	found := false
+	for _, t := range q.Types {
+		if t == kind {
+			found = true
+		}
+	}
+	if !found {
+		return false
+	}
+	switch kind {
+	case TypeBoard:
+		return q.AuthorID == ""
+	case TypeUser:
+		return q.AuthorID == "" && q.BoardID == ""
+	}
+	return true
+}
+
+func formatDate(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
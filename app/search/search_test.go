@@ -0,0 +1,196 @@
+package search
+
+import (
+	"fmt"
+	"forum-server/app/model"
+	forumdb "forum-server/db"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := forumdb.OpenEmbedded("file:" + name + "?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func create(t *testing.T, db *gorm.DB, rows ...interface{}) {
+	t.Helper()
+	for _, row := range rows {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func date(day int) string {
+	return formatDate(time.Date(2024, 1, day, 0, 0, 0, 0, time.UTC))
+}
+
+func ids(results []Result) []string {
+	out := []string{}
+	for _, r := range results {
+		out = append(out, r.Type+":"+r.ID)
+	}
+	return out
+}
+
+func TestSearchFallsBackToLike(t *testing.T) {
+	db := openTestDB(t)
+	if isPostgres(db) {
+		t.Fatal("embedded store reports itself as postgres")
+	}
+	create(t, db,
+		&model.Board{ID: "b1", Name: "Gardening", Description: "All about tomatoes", CreateDate: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		&model.User{ID: "u1", Username: "tomatofan", Email: "u1@example.com", Bio: "grows things", Active: true, CreateDate: date(2)},
+		&model.User{ID: "u2", Username: "inactive", Email: "u2@example.com", Bio: "tomato", Active: false, CreateDate: date(2)},
+		&model.Post{ID: "p1", AuthorID: "u1", BoardID: "b1", Title: "Tomato blight", Content: "help", CreateDate: date(3)},
+		&model.Post{ID: "p2", AuthorID: "u1", BoardID: "b1", Title: "Watering", Content: "my tomato plants wilt", CreateDate: date(5)},
+		&model.Post{ID: "p3", AuthorID: "u1", BoardID: "b1", Title: "Unrelated", Content: "potatoes", CreateDate: date(6)},
+		&model.Comment{ID: "c1", AuthorID: "u1", PostID: "p3", Content: "Try TOMATO feed", CreateDate: date(4)},
+		&model.Comment{ID: "c2", AuthorID: "u1", PostID: "p3", Content: "tomato, removed", Deleted: true, CreateDate: date(7)},
+	)
+	if err := Index(db, TypePost, "p1"); err != nil {
+		t.Errorf("Index on the embedded store: %v", err)
+	}
+
+	results, total, err := Search(db, Query{Text: "Tomato", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// title matches first, newest first within a rank. A comment's title is
+	// its post's.
+	want := []string{"post:p1", "user:u1", "post:p2", "comment:c1", "board:b1"}
+	if got := ids(results); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("results = %v, want %v", got, want)
+	}
+	if total != int64(len(want)) {
+		t.Errorf("total = %d, want %d", total, len(want))
+	}
+	if results[0].Rank <= results[len(results)-1].Rank {
+		t.Errorf("title match ranked %v, body match %v", results[0].Rank, results[len(results)-1].Rank)
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	db := openTestDB(t)
+	create(t, db,
+		&model.Post{ID: "p1", AuthorID: "u1", BoardID: "b1", Title: "news one", CreateDate: date(1)},
+		&model.Post{ID: "p2", AuthorID: "u2", BoardID: "b1", Title: "news two", CreateDate: date(10)},
+		&model.Post{ID: "p3", AuthorID: "u1", BoardID: "b2", Title: "news three", CreateDate: date(20)},
+		&model.Comment{ID: "c1", AuthorID: "u2", PostID: "p3", Content: "news reply", CreateDate: date(21)},
+	)
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"type", Query{Types: []string{TypeComment}}, []string{"comment:c1"}},
+		{"board", Query{BoardID: "b1"}, []string{"post:p2", "post:p1"}},
+		{"author", Query{AuthorID: "u2"}, []string{"comment:c1", "post:p2"}},
+		{"from", Query{From: time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)}, []string{"comment:c1", "post:p3", "post:p2"}},
+		{"to", Query{To: time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)}, []string{"post:p2", "post:p1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := tt.query
+			q.Text, q.Limit = "news", 10
+			results, _, err := Search(db, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(results); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("results = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchPaging(t *testing.T) {
+	db := openTestDB(t)
+	for i := 1; i <= 7; i++ {
+		create(t, db,
+			&model.Post{ID: fmt.Sprintf("p%d", i), Title: "paged", CreateDate: date(i)},
+			&model.Comment{ID: fmt.Sprintf("c%d", i), PostID: "p1", Content: "paged", CreateDate: date(i)},
+		)
+	}
+
+	all, total, err := Search(db, Query{Text: "paged", Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 14 || len(all) != 14 {
+		t.Fatalf("got %d results of %d, want 14", len(all), total)
+	}
+
+	paged := []Result{}
+	for offset := 0; offset < 14; offset += 4 {
+		page, pageTotal, err := Search(db, Query{Text: "paged", Offset: offset, Limit: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pageTotal != total {
+			t.Errorf("total at offset %d = %d, want %d", offset, pageTotal, total)
+		}
+		paged = append(paged, page...)
+	}
+	if fmt.Sprint(ids(paged)) != fmt.Sprint(ids(all)) {
+		t.Errorf("pages joined = %v, want %v", ids(paged), ids(all))
+	}
+
+	page, _, err := Search(db, Query{Text: "paged", Offset: 20, Limit: 4})
+	if err != nil || len(page) != 0 {
+		t.Errorf("page past the end = %v, %v, want none", page, err)
+	}
+}
+
+func TestLikeSnippet(t *testing.T) {
+	content := strings.Repeat("x", 200) + " <b>Tomato</b> & " + strings.Repeat("y", 200)
+	snippet := excerpt(content, "tomato")
+	if !strings.Contains(snippet, "&lt;b&gt;<mark>Tomato</mark>&lt;/b&gt; &amp; ") {
+		t.Errorf("snippet %q doesn't escape around the match", snippet)
+	}
+	if len(snippet) > 2*snippetRadius+100 {
+		t.Errorf("snippet is %d bytes, want it cut around the match", len(snippet))
+	}
+	if got := excerpt("<i>no match</i>", "tomato"); got != "&lt;i&gt;no match&lt;/i&gt;" {
+		t.Errorf("snippet without a match = %q", got)
+	}
+}
+
+// TestHeadlineEscaping runs the escaping that ts_headline is given, which
+// sticks to functions SQLite has too.
+func TestHeadlineEscaping(t *testing.T) {
+	db := openTestDB(t)
+	tests := map[string]string{
+		`<script>alert("x")</script>`: `&lt;script&gt;alert("x")&lt;/script&gt;`,
+		"a && b":                      "a &amp;&amp; b",
+		"&lt; stays literal":          "&amp;lt; stays literal",
+		"<mark>":                      "&lt;mark&gt;",
+	}
+	for in, want := range tests {
+		var got string
+		if err := db.Raw("SELECT "+escapeHTML("?"), in).Scan(&got).Error; err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("escaping %q = %q, want %q", in, got, want)
+		}
+	}
+	if !strings.Contains(headline("p.content"), escapeHTML("p.content")) {
+		t.Error("headline doesn't escape its column")
+	}
+}
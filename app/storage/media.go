@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+)
+
+var (
+	ErrTooLarge       = errors.New("file is too large")
+	ErrTypeNotAllowed = errors.New("file type is not allowed")
+)
+
+var ImageTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
+// ReadLimited reads all of body, failing with ErrTooLarge past limit bytes.
+func ReadLimited(body io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
+
+// DetectContentType sniffs the type of data from its content, ignoring
+// whatever name or type the client claimed.
+func DetectContentType(data []byte) string {
+	contentType := http.DetectContentType(data)
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	return contentType
+}
+
+// Allowed reports whether contentType is one of allowed.
+func Allowed(contentType string, allowed []string) bool {
+	for _, t := range allowed {
+		if t == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// Extension returns the file extension used when storing contentType.
+func Extension(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return ".jpg"
+	case "text/plain":
+		return ".txt"
+	}
+	if exts, err := mime.ExtensionsByType(contentType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// PutBytes stores data under key.
+func PutBytes(ctx context.Context, store BlobStore, key string, data []byte, contentType string) error {
+	return store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), contentType)
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3Store keeps objects in an S3 compatible bucket, such as DigitalOcean
+// Spaces. Public objects are readable by anyone and served from PublicURL,
+// which defaults to the bucket's virtual host on Endpoint.
+type S3Store struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Key       string
+	Secret    string
+	PublicURL string
+
+	once   sync.Once
+	client *s3.S3
+	err    error
+}
+
+func (s *S3Store) connect() (*s3.S3, error) {
+	s.once.Do(func() {
+		config := &aws.Config{
+			Credentials: credentials.NewStaticCredentials(s.Key, s.Secret, ""),
+			Region:      aws.String(s.Region),
+		}
+		if s.Endpoint != "" {
+			config.Endpoint = aws.String(s.Endpoint)
+		}
+		sess, err := session.NewSession(config)
+		if err != nil {
+			s.err = err
+			return
+		}
+		s.client = s3.New(sess)
+	})
+	return s.client, s.err
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+
+	seeker, ok := body.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		seeker = bytes.NewReader(data)
+	}
+
+	acl := s3.ObjectCannedACLPrivate
+	if Public(key) {
+		acl = s3.ObjectCannedACLPublicRead
+	}
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(key),
+		Body:        seeker,
+		ContentType: aws.String(contentType),
+		ACL:         aws.String(acl),
+	}
+	if size >= 0 {
+		input.ContentLength = aws.Int64(size)
+	}
+	_, err = client.PutObjectWithContext(ctx, input)
+	return err
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	client, err := s.connect()
+	if err != nil {
+		return nil, "", err
+	}
+
+	out, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, "", ErrNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return out.Body, aws.StringValue(out.ContentType), nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	_, err = client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (s *S3Store) URL(key string) string {
+	base := s.PublicURL
+	if base == "" {
+		base = s.bucketURL()
+	}
+	return joinURL(base, key)
+}
+
+// bucketURL is the bucket's virtual host on Endpoint, which may be given
+// with or without a scheme. Without an Endpoint it is the AWS host for
+// Region.
+func (s *S3Store) bucketURL() string {
+	scheme, host := "https", s.Endpoint
+	if host == "" {
+		host = "s3." + s.Region + ".amazonaws.com"
+	}
+	if strings.Contains(host, "://") {
+		if u, err := url.Parse(host); err == nil && u.Host != "" {
+			scheme, host = u.Scheme, u.Host
+		}
+	}
+	return scheme + "://" + s.Bucket + "." + strings.TrimSuffix(host, "/")
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"forum-server/app/config"
+	"io"
+	"strings"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// BlobStore keeps uploaded files. Keys are slash separated paths such as
+// "avatars/<user>/<id>/256.jpg".
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, string, error)
+	Delete(ctx context.Context, key string) error
+	// URL is where clients can fetch the object from, if it is Public.
+	URL(key string) string
+}
+
+// New returns the store selected by STORAGE_DRIVER: "s3", "memory" or, by
+// default, "local".
+func New() BlobStore {
+	switch config.String("STORAGE_DRIVER", "local") {
+	case "s3":
+		return &S3Store{
+			Endpoint:  config.String("S3_ENDPOINT", ""),
+			Region:    config.String("S3_REGION", "us-east-1"),
+			Bucket:    config.String("S3_BUCKET", ""),
+			Key:       config.String("S3_KEY", ""),
+			Secret:    config.String("S3_SECRET", ""),
+			PublicURL: config.String("S3_PUBLIC_URL", ""),
+		}
+	case "memory":
+		return NewMemoryStore(config.String("STORAGE_PUBLIC_URL", "/api/media"))
+	}
+	return &LocalStore{
+		Dir:       config.String("STORAGE_DIR", "uploads"),
+		PublicURL: config.String("STORAGE_PUBLIC_URL", "/api/media"),
+	}
+}
+
+// Public reports whether the object at key may be served to anyone with its
+// URL. Everything else, such as attachments, is private and only handed out
+// by handlers that check access first.
+func Public(key string) bool {
+	return strings.HasPrefix(key, "avatars/")
+}
+
+// KeyFromURL reverses URL for objects in store, returning false for URLs
+// that point elsewhere.
+func KeyFromURL(store BlobStore, url string) (string, bool) {
+	prefix := store.URL("")
+	if url == "" || !strings.HasPrefix(url, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(url, prefix), true
+}
+
+// validKey rejects keys that could escape the store's root.
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func joinURL(base, key string) string {
+	return strings.TrimRight(base, "/") + "/" + key
+}
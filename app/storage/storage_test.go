@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestValidKey(t *testing.T) {
+	for key, want := range map[string]bool{
+		"avatars/u1/a/256.jpg": true,
+		"":                     false,
+		"/etc/passwd":          false,
+		"avatars/../secret":    false,
+		"avatars//256.jpg":     false,
+		"avatars/./256.jpg":    false,
+		`avatars\256.jpg`:      false,
+	} {
+		if got := validKey(key); got != want {
+			t.Errorf("validKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestKeyFromURL(t *testing.T) {
+	store := NewMemoryStore("/api/media/")
+	url := store.URL("avatars/u1/a/256.jpg")
+	if url != "/api/media/avatars/u1/a/256.jpg" {
+		t.Errorf("URL = %q", url)
+	}
+	if key, ok := KeyFromURL(store, url); !ok || key != "avatars/u1/a/256.jpg" {
+		t.Errorf("KeyFromURL(%q) = %q, %v", url, key, ok)
+	}
+	if _, ok := KeyFromURL(store, "https://elsewhere.example/a.jpg"); ok {
+		t.Error("KeyFromURL accepted a URL outside the store")
+	}
+	if !Public("avatars/u1/a/256.jpg") || Public("attachments/u1/file.pdf") {
+		t.Error("only avatars are public")
+	}
+}
+
+func TestS3URL(t *testing.T) {
+	tests := []struct {
+		name  string
+		store *S3Store
+		want  string
+	}{
+		{"aws", &S3Store{Bucket: "b", Region: "eu-west-1"}, "https://b.s3.eu-west-1.amazonaws.com/k"},
+		{"endpoint", &S3Store{Bucket: "b", Endpoint: "nyc3.digitaloceanspaces.com"}, "https://b.nyc3.digitaloceanspaces.com/k"},
+		{"endpoint with scheme", &S3Store{Bucket: "b", Endpoint: "http://localhost:9000/"}, "http://b.localhost:9000/k"},
+		{"public url", &S3Store{Bucket: "b", PublicURL: "https://cdn.example/"}, "https://cdn.example/k"},
+	}
+	for _, tt := range tests {
+		if got := tt.store.URL("k"); got != tt.want {
+			t.Errorf("%s: URL = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestStores checks the stores without outside dependencies behave the same
+// way.
+func TestStores(t *testing.T) {
+	stores := map[string]BlobStore{
+		"memory": NewMemoryStore("/media"),
+		"local":  &LocalStore{Dir: t.TempDir(), PublicURL: "/media"},
+	}
+	ctx := context.Background()
+	for name, store := range stores {
+		if err := PutBytes(ctx, store, "avatars/u1/a.png", []byte("data"), "image/png"); err != nil {
+			t.Fatalf("%s: Put: %v", name, err)
+		}
+		body, contentType, err := store.Get(ctx, "avatars/u1/a.png")
+		if err != nil {
+			t.Fatalf("%s: Get: %v", name, err)
+		}
+		data, _ := io.ReadAll(body)
+		body.Close()
+		if string(data) != "data" || contentType != "image/png" {
+			t.Errorf("%s: Get = %q, %q", name, data, contentType)
+		}
+
+		if err := PutBytes(ctx, store, "../escape.png", []byte("data"), "image/png"); err == nil {
+			t.Errorf("%s: stored a key outside the root", name)
+		}
+		if err := store.Delete(ctx, "avatars/u1/a.png"); err != nil {
+			t.Errorf("%s: Delete: %v", name, err)
+		}
+		if _, _, err := store.Get(ctx, "avatars/u1/a.png"); err != ErrNotFound {
+			t.Errorf("%s: Get after Delete = %v, want ErrNotFound", name, err)
+		}
+	}
+}
+
+func TestReadLimited(t *testing.T) {
+	if _, err := ReadLimited(strings.NewReader("12345"), 4); err != ErrTooLarge {
+		t.Errorf("over the limit: err = %v, want ErrTooLarge", err)
+	}
+	if data, err := ReadLimited(strings.NewReader("1234"), 4); err != nil || string(data) != "1234" {
+		t.Errorf("at the limit = %q, %v", data, err)
+	}
+}
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.NRGBA{R: 200, A: 255})
+		}
+	}
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImages(t *testing.T) {
+	data := testPNG(t, 300, 200)
+	if contentType := DetectContentType(data); !Allowed(contentType, ImageTypes) {
+		t.Fatalf("DetectContentType = %q", contentType)
+	}
+	if Allowed(DetectContentType([]byte("<html>")), ImageTypes) {
+		t.Error("html allowed as an image")
+	}
+
+	img, err := DecodeImage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := Square(img, 64).Bounds(); b.Dx() != 64 || b.Dy() != 64 {
+		t.Errorf("Square = %v, want 64x64", b)
+	}
+	if b := Fit(img, 150).Bounds(); b.Dx() != 150 || b.Dy() != 100 {
+		t.Errorf("Fit = %v, want 150x100", b)
+	}
+	if Fit(img, 1000) != img {
+		t.Error("Fit scaled up a small image")
+	}
+	if _, contentType, err := EncodeImage(img); err != nil || contentType != "image/jpeg" {
+		t.Errorf("EncodeImage of an opaque image = %q, %v, want image/jpeg", contentType, err)
+	}
+
+	if _, err := DecodeImage(testPNG(t, 1, maxImageDimension+1)); err != ErrImageTooLarge {
+		t.Errorf("DecodeImage of a huge image = %v, want ErrImageTooLarge", err)
+	}
+}
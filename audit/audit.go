@@ -1,13 +1,13 @@
 package audit
 
 import (
+	"forum-server/db/driver"
 	"log"
 	"os"
 	"time"
 
 	"github.com/google/uuid"
 	_ "github.com/joho/godotenv/autoload"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
@@ -25,8 +25,12 @@ type Audit struct {
 }
 
 func (a *Auditor) Init() {
-	dsn := os.Getenv("DSN")
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	dialector, err := driver.Open()
+	if err != nil {
+		log.Println("Could not connect to database:", err)
+		os.Exit(1)
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{})
 	if err != nil {
 		log.Println("Could not connect to database")
 		os.Exit(1)
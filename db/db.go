@@ -1,31 +1,59 @@
 package db
 
 import (
-	"forum-server/app/model"
 	"forum-server/audit"
+	"forum-server/db/driver"
 	"log"
 	"os"
 
 	_ "github.com/joho/godotenv/autoload"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
+// Init connects to the database, applies any pending migrations and seeds
+// the default roles. It exits if the schema can't be brought up to date.
 func Init(auditor *audit.Auditor) *gorm.DB {
-	dsn := os.Getenv("DSN")
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db := Open(auditor)
+
+	if driver.Name() == driver.SQLite {
+		if err := createEmbedded(db); err != nil {
+			auditor.Log("", "Migrate Database", "Error", err.Error())
+			os.Exit(1)
+		}
+		if err := seedRoles(db); err != nil {
+			auditor.Log("", "Seed Roles", "Error", err.Error())
+		}
+		return db
+	}
+
+	migrator, err := NewMigrator(db, auditor)
+	if err != nil {
+		auditor.Log("", "Migrate Database", "Error", err.Error())
+		os.Exit(1)
+	}
+	if err := migrator.Up(); err != nil {
+		log.Println("Could not migrate database:", err)
+		os.Exit(1)
+	}
+
+	if err := seedRoles(db); err != nil {
+		auditor.Log("", "Seed Roles", "Error", err.Error())
+	}
+	return db
+}
+
+func Open(auditor *audit.Auditor) *gorm.DB {
+	dialector, err := driver.Open()
+	if err != nil {
+		log.Println("Could not connect to database:", err)
+		os.Exit(1)
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{})
 	if err != nil {
 		log.Println("Could not connect to database")
 		auditor.Log("", "Connect To Database", "Error", err.Error())
 		os.Exit(1)
 	}
 	auditor.Log("", "Connect To Database", "Success", "")
-	mdb := migrate(db)
-	auditor.Log("", "Migrate Database", "Success", "")
-	return mdb
-}
-
-func migrate(db *gorm.DB) *gorm.DB {
-	db.AutoMigrate(&model.User{}, &model.Post{}, &model.Comment{}, &model.Board{}, &audit.Audit{})
 	return db
 }
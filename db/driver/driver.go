@@ -0,0 +1,42 @@
+// Package driver picks the database driver from DB_DRIVER. It is kept apart
+// from db so the auditor, which db depends on, can open its connection the
+// same way.
+package driver
+
+import (
+	"fmt"
+	"forum-server/app/config"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const (
+	Postgres = "postgres"
+	// SQLite is an embedded store for tests and trying the server out. It
+	// has no full text search, so search falls back to LIKE matching, and
+	// its schema is built from the models rather than the migrations.
+	SQLite = "sqlite"
+)
+
+// Name is the driver DB_DRIVER selects, Postgres by default.
+func Name() string {
+	return config.String("DB_DRIVER", Postgres)
+}
+
+// Open returns the dialector for the selected driver and DSN. For SQLite
+// the DSN is a file name.
+func Open() (gorm.Dialector, error) {
+	return Dialector(Name(), config.String("DSN", ""))
+}
+
+func Dialector(name, dsn string) (gorm.Dialector, error) {
+	switch name {
+	case Postgres:
+		return postgres.Open(dsn), nil
+	case SQLite:
+		return sqlite.Open(dsn), nil
+	}
+	return nil, fmt.Errorf("unknown DB_DRIVER %q, expected %s or %s", name, Postgres, SQLite)
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"forum-server/app/auth"
+	"forum-server/app/model"
+	"forum-server/audit"
+	"forum-server/db/driver"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// embeddedModels are the tables of the embedded SQLite store. Postgres gets
+// its schema from the migrations instead, so a table added there needs its
+// model listed here too.
+var embeddedModels = []interface{}{
+	&model.User{},
+	&model.Board{},
+	&model.Post{},
+	&model.Comment{},
+	&model.RefreshToken{},
+	&auth.Role{},
+	&auth.Permission{},
+	&model.ChatMessage{},
+	&model.ChatMute{},
+	&model.Conversation{},
+	&model.ConversationParticipant{},
+	&model.Message{},
+	&model.Block{},
+	&model.Notification{},
+	&model.NotificationPreference{},
+	&model.BoardSubscription{},
+	&model.EmailToken{},
+	&model.Attachment{},
+	&model.Revision{},
+	&model.Vote{},
+	&model.Reaction{},
+	&model.Tag{},
+	&model.TagSynonym{},
+	&model.Report{},
+	&model.Ban{},
+	&model.LoginAttempt{},
+	&model.TwoFactor{},
+	&model.RecoveryCode{},
+	&model.LoginChallenge{},
+	&audit.Audit{},
+	&roleGrant{},
+}
+
+// createEmbedded creates any missing tables of the embedded store.
+func createEmbedded(db *gorm.DB) error {
+	return db.AutoMigrate(embeddedModels...)
+}
+
+// OpenEmbedded opens the SQLite database at dsn with its tables created and
+// the default roles seeded. Tests use it with an in-memory dsn such as
+// "file:name?mode=memory&cache=shared".
+func OpenEmbedded(dsn string) (*gorm.DB, error) {
+	dialector, err := driver.Dialector(driver.SQLite, dsn)
+	if err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, err
+	}
+	if err := createEmbedded(db); err != nil {
+		return nil, err
+	}
+	if err := seedRoles(db); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
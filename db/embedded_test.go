@@ -0,0 +1,53 @@
+package db
+
+import (
+	"forum-server/app/auth"
+	"io/fs"
+	"regexp"
+	"testing"
+)
+
+var createTable = regexp.MustCompile(`CREATE TABLE IF NOT EXISTS (\w+)`)
+
+// retiredTables are created by a migration but no longer used.
+var retiredTables = map[string]bool{"conversation_reports": true}
+
+// TestEmbeddedTables keeps embeddedModels in step with the migrations.
+func TestEmbeddedTables(t *testing.T) {
+	db, err := OpenEmbedded("file:embedded_tables?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scripts, err := fs.Glob(migrationFiles, "migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, script := range scripts {
+		sql, err := fs.ReadFile(migrationFiles, script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, match := range createTable.FindAllStringSubmatch(string(sql), -1) {
+			if table := match[1]; !retiredTables[table] && !db.Migrator().HasTable(table) {
+				t.Errorf("%s creates %s, which the embedded store doesn't have", script, table)
+			}
+		}
+	}
+}
+
+func TestEmbeddedRoles(t *testing.T) {
+	db, err := OpenEmbedded("file:embedded_roles?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, perms := range auth.DefaultRoles {
+		role := auth.Role{}
+		if err := db.Preload("Permissions").Where(&auth.Role{Name: name}).First(&role).Error; err != nil {
+			t.Fatalf("role %s: %v", name, err)
+		}
+		if len(role.Permissions) != len(perms) {
+			t.Errorf("role %s has %d permissions, want %d", name, len(role.Permissions), len(perms))
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	files := fstest.MapFS{
+		"migrations/0002_second.up.sql":   {Data: []byte("up 2")},
+		"migrations/0002_second.down.sql": {Data: []byte("down 2")},
+		"migrations/0001_first.up.sql":    {Data: []byte("up 1")},
+		"migrations/0001_first.down.sql":  {Data: []byte("down 1")},
+	}
+	migrations, err := loadMigrations(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].String() != "0001_first" || migrations[1].String() != "0002_second" {
+		t.Fatalf("migrations = %v, want 0001_first then 0002_second", migrations)
+	}
+	if migrations[1].Up != "up 2" || migrations[1].Down != "down 2" {
+		t.Errorf("0002 = %+v", migrations[1])
+	}
+}
+
+func TestLoadMigrationsRefusals(t *testing.T) {
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		want  string
+	}{
+		{"stray file", fstest.MapFS{
+			"migrations/notes.txt": {},
+		}, "unexpected migration file"},
+		{"missing down", fstest.MapFS{
+			"migrations/0001_first.up.sql": {Data: []byte("up")},
+		}, "missing its up or down"},
+		{"two names", fstest.MapFS{
+			"migrations/0001_first.up.sql":   {Data: []byte("up")},
+			"migrations/0001_other.down.sql": {Data: []byte("down")},
+		}, "two names"},
+	}
+	for _, tt := range tests {
+		if _, err := loadMigrations(tt.files); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// TestEmbeddedMigrations checks the shipped migrations load and are
+// numbered without gaps.
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %s is out of sequence, want version %d", m, i+1)
+		}
+	}
+}
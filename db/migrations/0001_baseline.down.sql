@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS audits;
+DROP TABLE IF EXISTS comments;
+DROP TABLE IF EXISTS posts;
+DROP TABLE IF EXISTS boards;
+DROP TABLE IF EXISTS users;
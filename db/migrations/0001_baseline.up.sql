@@ -0,0 +1,49 @@
+-- The schema as it was created by AutoMigrate before versioned migrations.
+-- IF NOT EXISTS lets databases created that way adopt the migrations.
+
+CREATE TABLE IF NOT EXISTS users (
+	id text PRIMARY KEY,
+	username text UNIQUE,
+	email text UNIQUE,
+	password text,
+	bio text,
+	reputation bigint,
+	avatar_url text,
+	role text,
+	active boolean,
+	create_date text
+);
+
+CREATE TABLE IF NOT EXISTS boards (
+	id text PRIMARY KEY,
+	name text UNIQUE,
+	description text,
+	create_date timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS posts (
+	id text PRIMARY KEY,
+	author_id text,
+	board_id text,
+	title text,
+	content text,
+	create_date text
+);
+
+CREATE TABLE IF NOT EXISTS comments (
+	id text PRIMARY KEY,
+	author_id text,
+	post_id text,
+	parent_id text,
+	content text,
+	create_date text
+);
+
+CREATE TABLE IF NOT EXISTS audits (
+	id text PRIMARY KEY,
+	user_id text,
+	date_time timestamptz,
+	action text,
+	error_string text,
+	message text
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	id text PRIMARY KEY,
+	user_id text,
+	family_id text,
+	token_hash text UNIQUE,
+	revoked boolean,
+	expires_at timestamptz,
+	create_date timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
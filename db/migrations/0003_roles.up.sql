@@ -0,0 +1,19 @@
+-- Roles and permissions themselves are seeded on startup.
+
+CREATE TABLE IF NOT EXISTS roles (
+	id text PRIMARY KEY,
+	name text UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+	id text PRIMARY KEY,
+	name text UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+	role_id text,
+	permission_id text,
+	PRIMARY KEY (role_id, permission_id),
+	CONSTRAINT fk_role_permissions_role FOREIGN KEY (role_id) REFERENCES roles (id),
+	CONSTRAINT fk_role_permissions_permission FOREIGN KEY (permission_id) REFERENCES permissions (id)
+);
@@ -0,0 +1,7 @@
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS comment_count bigint;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS last_activity text;
+
+UPDATE posts SET
+	comment_count = (SELECT count(*) FROM comments WHERE comments.post_id = posts.id),
+	last_activity = COALESCE((SELECT max(create_date) FROM comments WHERE comments.post_id = posts.id), posts.create_date)
+WHERE last_activity IS NULL OR last_activity = '';
@@ -0,0 +1,14 @@
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS depth bigint;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS deleted boolean;
+
+CREATE INDEX IF NOT EXISTS idx_comments_parent_id ON comments (parent_id);
+
+WITH RECURSIVE tree AS (
+	SELECT id, 0 AS depth FROM comments WHERE parent_id IS NULL OR parent_id = ''
+	UNION ALL
+	SELECT comments.id, tree.depth + 1 FROM comments JOIN tree ON comments.parent_id = tree.id
+)
+UPDATE comments SET depth = tree.depth FROM tree
+WHERE comments.id = tree.id AND comments.depth IS NULL;
+
+UPDATE comments SET deleted = false WHERE deleted IS NULL;
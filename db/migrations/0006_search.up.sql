@@ -0,0 +1,29 @@
+-- The vectors must match the expressions search.Index rebuilds rows with.
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS search_vector tsvector;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS search_vector tsvector;
+ALTER TABLE boards ADD COLUMN IF NOT EXISTS search_vector tsvector;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS search_vector tsvector;
+
+CREATE INDEX IF NOT EXISTS idx_posts_search_vector ON posts USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_comments_search_vector ON comments USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_boards_search_vector ON boards USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_users_search_vector ON users USING GIN (search_vector);
+
+UPDATE posts SET search_vector =
+	setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+	setweight(to_tsvector('english', coalesce(content, '')), 'B')
+WHERE search_vector IS NULL;
+
+UPDATE comments SET search_vector = to_tsvector('english', coalesce(content, ''))
+WHERE search_vector IS NULL;
+
+UPDATE boards SET search_vector =
+	setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+	setweight(to_tsvector('english', coalesce(description, '')), 'B')
+WHERE search_vector IS NULL;
+
+UPDATE users SET search_vector =
+	setweight(to_tsvector('simple', coalesce(username, '')), 'A') ||
+	setweight(to_tsvector('english', coalesce(bio, '')), 'B')
+WHERE search_vector IS NULL;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS chat_messages (
+	id text PRIMARY KEY,
+	author_id text,
+	content text,
+	deleted boolean,
+	deleted_by text,
+	create_date text
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_messages_author_id ON chat_messages (author_id);
+CREATE INDEX IF NOT EXISTS idx_chat_messages_create_date ON chat_messages (create_date);
+
+CREATE TABLE IF NOT EXISTS chat_mutes (
+	user_id text PRIMARY KEY,
+	muted_by text,
+	reason text,
+	until timestamptz,
+	create_date timestamptz
+);
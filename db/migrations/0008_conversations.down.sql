@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS conversation_reports;
+DROP TABLE IF EXISTS blocks;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS conversation_participants;
+DROP TABLE IF EXISTS conversations;
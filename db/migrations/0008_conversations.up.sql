@@ -0,0 +1,49 @@
+CREATE TABLE IF NOT EXISTS conversations (
+	id text PRIMARY KEY,
+	creator_id text,
+	subject text,
+	last_message_at text,
+	create_date timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversations_last_message_at ON conversations (last_message_at);
+
+CREATE TABLE IF NOT EXISTS conversation_participants (
+	conversation_id text,
+	user_id text,
+	last_read_at text,
+	left_date timestamptz,
+	join_date timestamptz,
+	PRIMARY KEY (conversation_id, user_id),
+	CONSTRAINT fk_conversations_participants FOREIGN KEY (conversation_id) REFERENCES conversations (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversation_participants_user_id ON conversation_participants (user_id);
+
+CREATE TABLE IF NOT EXISTS messages (
+	id text PRIMARY KEY,
+	conversation_id text,
+	author_id text,
+	content text,
+	create_date text
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_id ON messages (conversation_id);
+CREATE INDEX IF NOT EXISTS idx_messages_create_date ON messages (create_date);
+
+CREATE TABLE IF NOT EXISTS blocks (
+	user_id text,
+	blocked_id text,
+	create_date timestamptz,
+	PRIMARY KEY (user_id, blocked_id)
+);
+
+CREATE TABLE IF NOT EXISTS conversation_reports (
+	id text PRIMARY KEY,
+	conversation_id text,
+	reporter_id text,
+	reason text,
+	create_date timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversation_reports_conversation_id ON conversation_reports (conversation_id);
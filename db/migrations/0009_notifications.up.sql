@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS notifications (
+	id text PRIMARY KEY,
+	user_id text,
+	type text,
+	actor_id text,
+	target_type text,
+	target_id text,
+	group_key text,
+	count bigint,
+	message text,
+	read boolean,
+	update_date text
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications (user_id);
+CREATE INDEX IF NOT EXISTS idx_notifications_group_key ON notifications (group_key);
+CREATE INDEX IF NOT EXISTS idx_notifications_update_date ON notifications (update_date);
+
+CREATE TABLE IF NOT EXISTS notification_preferences (
+	user_id text,
+	type text,
+	enabled boolean,
+	PRIMARY KEY (user_id, type)
+);
+
+CREATE TABLE IF NOT EXISTS board_subscriptions (
+	user_id text,
+	board_id text,
+	create_date timestamptz,
+	PRIMARY KEY (user_id, board_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_board_subscriptions_board_id ON board_subscriptions (board_id);
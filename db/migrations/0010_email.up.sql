@@ -0,0 +1,16 @@
+-- Accounts created before verification existed count as verified.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified boolean DEFAULT true;
+ALTER TABLE users ALTER COLUMN email_verified SET DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS email_tokens (
+	id text PRIMARY KEY,
+	user_id text,
+	purpose text,
+	token_hash text UNIQUE,
+	email text,
+	expires_at timestamptz,
+	used_at timestamptz,
+	create_date timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_tokens_user_id ON email_tokens (user_id);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS attachments (
+	id text PRIMARY KEY,
+	uploader_id text,
+	post_id text DEFAULT '',
+	comment_id text DEFAULT '',
+	filename text,
+	content_type text,
+	size bigint,
+	width bigint,
+	height bigint,
+	key text,
+	thumbnail_key text,
+	create_date timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_attachments_uploader_id ON attachments (uploader_id);
+CREATE INDEX IF NOT EXISTS idx_attachments_post_id ON attachments (post_id);
+CREATE INDEX IF NOT EXISTS idx_attachments_comment_id ON attachments (comment_id);
+CREATE INDEX IF NOT EXISTS idx_attachments_create_date ON attachments (create_date);
@@ -0,0 +1,4 @@
+-- Filled in by the handlers on save; run "forum-server render" to backfill
+-- rows written before this migration.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS content_html text DEFAULT '';
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS content_html text DEFAULT '';
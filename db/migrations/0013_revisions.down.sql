@@ -0,0 +1,7 @@
+ALTER TABLE comments DROP COLUMN IF EXISTS edit_date;
+ALTER TABLE comments DROP COLUMN IF EXISTS edit_count;
+ALTER TABLE comments DROP COLUMN IF EXISTS edited;
+ALTER TABLE posts DROP COLUMN IF EXISTS edit_date;
+ALTER TABLE posts DROP COLUMN IF EXISTS edit_count;
+ALTER TABLE posts DROP COLUMN IF EXISTS edited;
+DROP TABLE IF EXISTS revisions;
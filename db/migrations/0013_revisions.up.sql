@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS revisions (
+	id text PRIMARY KEY,
+	target_type text,
+	target_id text,
+	number bigint,
+	editor_id text,
+	reason text,
+	title text,
+	content text,
+	create_date text
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_revisions_target ON revisions (target_type, target_id, number);
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS edited boolean DEFAULT false;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS edit_count bigint DEFAULT 0;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS edit_date text DEFAULT '';
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS edited boolean DEFAULT false;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS edit_count bigint DEFAULT 0;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS edit_date text DEFAULT '';
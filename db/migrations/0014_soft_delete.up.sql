@@ -0,0 +1,19 @@
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS deleted_by text DEFAULT '';
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS delete_reason text DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_posts_deleted_at ON posts (deleted_at);
+
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS deleted_by text DEFAULT '';
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS delete_reason text DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_comments_deleted_at ON comments (deleted_at);
+
+ALTER TABLE boards ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+ALTER TABLE boards ADD COLUMN IF NOT EXISTS deleted_by text DEFAULT '';
+ALTER TABLE boards ADD COLUMN IF NOT EXISTS delete_reason text DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_boards_deleted_at ON boards (deleted_at);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_by text DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS delete_reason text DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
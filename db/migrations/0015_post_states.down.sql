@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_posts_announced;
+DROP INDEX IF EXISTS idx_posts_board_id_pinned;
+ALTER TABLE posts DROP COLUMN IF EXISTS archived;
+ALTER TABLE posts DROP COLUMN IF EXISTS locked;
+ALTER TABLE posts DROP COLUMN IF EXISTS announced;
+ALTER TABLE posts DROP COLUMN IF EXISTS pinned;
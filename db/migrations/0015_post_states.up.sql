@@ -0,0 +1,7 @@
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS pinned boolean NOT NULL DEFAULT false;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS announced boolean NOT NULL DEFAULT false;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS locked boolean NOT NULL DEFAULT false;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS archived boolean NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS idx_posts_board_id_pinned ON posts (board_id, pinned);
+CREATE INDEX IF NOT EXISTS idx_posts_announced ON posts (announced) WHERE announced;
@@ -0,0 +1,3 @@
+-- Set on the stubs left behind when threads are moved, merged or split.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS redirect_id text DEFAULT '';
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS redirect_id text DEFAULT '';
@@ -0,0 +1,8 @@
+ALTER TABLE comments DROP COLUMN IF EXISTS score;
+ALTER TABLE comments DROP COLUMN IF EXISTS downvotes;
+ALTER TABLE comments DROP COLUMN IF EXISTS upvotes;
+ALTER TABLE posts DROP COLUMN IF EXISTS score;
+ALTER TABLE posts DROP COLUMN IF EXISTS downvotes;
+ALTER TABLE posts DROP COLUMN IF EXISTS upvotes;
+DROP TABLE IF EXISTS reactions;
+DROP TABLE IF EXISTS votes;
@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS votes (
+	id text PRIMARY KEY,
+	user_id text,
+	target_type text,
+	target_id text,
+	target_author_id text,
+	value bigint,
+	create_date timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_votes_user_target ON votes (user_id, target_type, target_id);
+CREATE INDEX IF NOT EXISTS idx_votes_target_author_id ON votes (target_author_id);
+
+CREATE TABLE IF NOT EXISTS reactions (
+	id text PRIMARY KEY,
+	user_id text,
+	target_type text,
+	target_id text,
+	emoji text,
+	create_date timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reactions_user_target ON reactions (user_id, target_type, target_id, emoji);
+CREATE INDEX IF NOT EXISTS idx_reactions_target ON reactions (target_type, target_id);
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS upvotes bigint DEFAULT 0;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS downvotes bigint DEFAULT 0;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS score bigint DEFAULT 0;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS upvotes bigint DEFAULT 0;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS downvotes bigint DEFAULT 0;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS score bigint DEFAULT 0;
+
+-- Nothing ever wrote reputation before votes existed.
+UPDATE users SET reputation = 0 WHERE reputation IS NULL;
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS tags (
+	id text PRIMARY KEY,
+	name text UNIQUE,
+	description text DEFAULT '',
+	restricted boolean NOT NULL DEFAULT false,
+	post_count bigint DEFAULT 0,
+	create_date timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_tags_post_count ON tags (post_count);
+
+CREATE TABLE IF NOT EXISTS tag_synonyms (
+	id text PRIMARY KEY,
+	name text UNIQUE,
+	tag_id text,
+	create_date timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_tag_synonyms_tag_id ON tag_synonyms (tag_id);
+
+CREATE TABLE IF NOT EXISTS post_tags (
+	post_id text,
+	tag_id text,
+	PRIMARY KEY (post_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_tags_tag_id ON post_tags (tag_id);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS conversation_reports (
+	id text PRIMARY KEY,
+	conversation_id text,
+	reporter_id text,
+	reason text,
+	create_date timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversation_reports_conversation_id ON conversation_reports (conversation_id);
+
+INSERT INTO conversation_reports (id, conversation_id, reporter_id, reason, create_date)
+SELECT id, target_id, reporter_id, details, create_date FROM reports WHERE target_type = 'conversation'
+ON CONFLICT DO NOTHING;
+
+DROP TABLE IF EXISTS reports;
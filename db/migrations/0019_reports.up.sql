@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS reports (
+	id text PRIMARY KEY,
+	reporter_id text,
+	target_type text,
+	target_id text,
+	target_author_id text DEFAULT '',
+	category text,
+	details text DEFAULT '',
+	status text,
+	resolved_by text DEFAULT '',
+	resolution_note text DEFAULT '',
+	resolve_date timestamptz,
+	create_date timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_reports_reporter_id ON reports (reporter_id);
+CREATE INDEX IF NOT EXISTS idx_reports_target ON reports (target_type, target_id);
+CREATE INDEX IF NOT EXISTS idx_reports_status ON reports (status);
+-- one open report per reporter and target
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reports_open ON reports (reporter_id, target_type, target_id) WHERE status = 'open';
+
+INSERT INTO reports (id, reporter_id, target_type, target_id, category, details, status, create_date)
+SELECT id, reporter_id, 'conversation', conversation_id, 'other', reason, 'open', create_date FROM conversation_reports
+ON CONFLICT DO NOTHING;
+
+DROP TABLE IF EXISTS conversation_reports;
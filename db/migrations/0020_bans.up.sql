@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS bans (
+	id text PRIMARY KEY,
+	user_id text,
+	issued_by text,
+	reason text,
+	scope text,
+	board_id text DEFAULT '',
+	start_date timestamptz,
+	expires_at timestamptz,
+	lifted_by text DEFAULT '',
+	lift_reason text DEFAULT '',
+	lift_date timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_bans_user_id ON bans (user_id);
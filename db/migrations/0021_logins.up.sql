@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+	id text PRIMARY KEY,
+	user_id text DEFAULT '',
+	ip text,
+	user_agent text DEFAULT '',
+	success boolean DEFAULT false,
+	new_client boolean DEFAULT false,
+	create_date timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_user_id ON login_attempts (user_id, create_date);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts (ip, create_date);
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS two_factors (
+	user_id text PRIMARY KEY,
+	secret text,
+	enabled boolean DEFAULT false,
+	last_period bigint DEFAULT 0,
+	create_date timestamptz,
+	enable_date timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	id text PRIMARY KEY,
+	user_id text,
+	code_hash text UNIQUE,
+	used_date timestamptz,
+	create_date timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes (user_id);
+
+CREATE TABLE IF NOT EXISTS login_challenges (
+	id text PRIMARY KEY,
+	user_id text,
+	token_hash text UNIQUE,
+	attempts integer DEFAULT 0,
+	expires_at timestamptz,
+	create_date timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_challenges_user_id ON login_challenges (user_id);
@@ -0,0 +1,7 @@
+-- Lowercase stored addresses to match normalizeEmail, leaving any that
+-- would collide with another account for an admin to sort out.
+UPDATE users SET email = LOWER(email)
+WHERE email <> LOWER(email)
+AND NOT EXISTS (SELECT 1 FROM users other WHERE other.id <> users.id AND LOWER(other.email) = LOWER(users.email));
+
+CREATE INDEX IF NOT EXISTS idx_users_email_lower ON users (LOWER(email));
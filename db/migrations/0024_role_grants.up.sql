@@ -0,0 +1,7 @@
+-- Default permissions seeding has given to default roles. A permission
+-- listed here isn't given again, so an admin can take it away for good.
+CREATE TABLE IF NOT EXISTS role_grants (
+	role_name text,
+	permission_name text,
+	PRIMARY KEY (role_name, permission_name)
+);
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"forum-server/app/auth"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// roleGrant records that seeding gave a default role one of its default
+// permissions.
+type roleGrant struct {
+	RoleName       string `gorm:"primaryKey"`
+	PermissionName string `gorm:"primaryKey"`
+}
+
+// seedRoles makes sure every known permission and default role exists, and
+// reconciles the default roles with DefaultRoles. The admin role always has
+// every permission. Other default roles are given each of their defaults
+// once, including ones added to DefaultRoles after the role was created,
+// so a permission an admin has since taken away stays away.
+func seedRoles(db *gorm.DB) error {
+	perms := map[string]auth.Permission{}
+	for _, name := range auth.Permissions {
+		perm := auth.Permission{}
+		if err := db.Where(&auth.Permission{Name: name}).Attrs(auth.Permission{ID: uuid.NewString()}).FirstOrCreate(&perm).Error; err != nil {
+			return err
+		}
+		perms[name] = perm
+	}
+
+	for name, permNames := range auth.DefaultRoles {
+		role := auth.Role{}
+		if err := db.Where(&auth.Role{Name: name}).Attrs(auth.Role{ID: uuid.NewString()}).FirstOrCreate(&role).Error; err != nil {
+			return err
+		}
+
+		if name == "admin" {
+			var rolePerms []auth.Permission
+			for _, p := range permNames {
+				rolePerms = append(rolePerms, perms[p])
+			}
+			if err := db.Model(&role).Association("Permissions").Replace(rolePerms); err != nil {
+				return err
+			}
+			continue
+		}
+
+		granted := []string{}
+		if err := db.Model(&roleGrant{}).Where("role_name = ?", name).Pluck("permission_name", &granted).Error; err != nil {
+			return err
+		}
+		for _, p := range permNames {
+			if contains(granted, p) {
+				continue
+			}
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Model(&role).Association("Permissions").Append([]auth.Permission{perms[p]}); err != nil {
+					return err
+				}
+				return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&roleGrant{RoleName: name, PermissionName: p}).Error
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"forum-server/app/auth"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func rolePermissions(t *testing.T, db *gorm.DB, name string) map[string]bool {
+	t.Helper()
+	role := auth.Role{}
+	if err := db.Preload("Permissions").Where(&auth.Role{Name: name}).First(&role).Error; err != nil {
+		t.Fatalf("role %s: %v", name, err)
+	}
+	perms := map[string]bool{}
+	for _, p := range role.Permissions {
+		perms[p.Name] = true
+	}
+	return perms
+}
+
+func removePermission(t *testing.T, db *gorm.DB, roleName, permName string) {
+	t.Helper()
+	role := auth.Role{}
+	perm := auth.Permission{}
+	if err := db.Where(&auth.Role{Name: roleName}).First(&role).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Where(&auth.Permission{Name: permName}).First(&perm).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&role).Association("Permissions").Delete(&perm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSeedRolesBackfillsDefaults covers a database whose moderator role was
+// seeded before some of its defaults existed.
+func TestSeedRolesBackfillsDefaults(t *testing.T) {
+	db, err := OpenEmbedded("file:seed_backfill?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := []string{auth.PermUserBan, auth.PermRevisionRestore, auth.PermTrashManage, auth.PermPostModerate, auth.PermReportResolve}
+	for _, p := range added {
+		removePermission(t, db, "moderator", p)
+	}
+	if err := db.Where("permission_name IN ?", added).Delete(&roleGrant{}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := seedRoles(db); err != nil {
+		t.Fatal(err)
+	}
+	perms := rolePermissions(t, db, "moderator")
+	for _, p := range auth.DefaultRoles["moderator"] {
+		if !perms[p] {
+			t.Errorf("moderator is missing default permission %s", p)
+		}
+	}
+}
+
+func TestSeedRolesKeepsRemovals(t *testing.T) {
+	db, err := OpenEmbedded("file:seed_removals?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	removePermission(t, db, "moderator", auth.PermUserBan)
+	removePermission(t, db, "admin", auth.PermRoleManage)
+
+	if err := seedRoles(db); err != nil {
+		t.Fatal(err)
+	}
+	if rolePermissions(t, db, "moderator")[auth.PermUserBan] {
+		t.Error("seeding gave back a permission an admin took away from moderator")
+	}
+	if len(rolePermissions(t, db, "admin")) != len(auth.Permissions) {
+		t.Error("admin doesn't have every permission after seeding")
+	}
+}
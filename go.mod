@@ -4,22 +4,28 @@ go 1.17
 
 require (
 	github.com/auth0/go-jwt-middleware v1.0.1
+	github.com/aws/aws-sdk-go v1.42.35
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/form3tech-oss/jwt-go v3.2.2+incompatible
 	github.com/google/uuid v1.3.0
+	github.com/gorilla/handlers v1.5.1
 	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.4.2
 	github.com/joho/godotenv v1.4.0
-	github.com/rs/cors v1.8.0
+	github.com/microcosm-cc/bluemonday v1.0.27
 	github.com/urfave/negroni v1.0.0
-	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
+	github.com/yuin/goldmark v1.4.13
+	golang.org/x/crypto v0.24.0
+	golang.org/x/image v0.12.0
 	gorm.io/driver/postgres v1.2.2
+	gorm.io/driver/sqlite v1.2.6
 	gorm.io/gorm v1.22.3
 )
 
 require (
-	github.com/aws/aws-sdk-go v1.42.35 // indirect
+	github.com/aymerick/douceur v0.2.0 // indirect
 	github.com/felixge/httpsnoop v1.0.1 // indirect
-	github.com/gorilla/handlers v1.5.1 // indirect
+	github.com/gorilla/css v1.0.1 // indirect
 	github.com/jackc/chunkreader/v2 v2.0.1 // indirect
 	github.com/jackc/pgconn v1.10.0 // indirect
 	github.com/jackc/pgio v1.0.0 // indirect
@@ -28,11 +34,10 @@ require (
 	github.com/jackc/pgservicefile v0.0.0-20200714003250-2b9c44734f2b // indirect
 	github.com/jackc/pgtype v1.8.1 // indirect
 	github.com/jackc/pgx/v4 v4.13.0 // indirect
-	github.com/jinzhu/gorm v1.9.16 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.3 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
-	github.com/lib/pq v1.10.2 // indirect
-	github.com/pkg/errors v0.9.1 // indirect
-	golang.org/x/text v0.3.7 // indirect
+	github.com/mattn/go-sqlite3 v1.14.9 // indirect
+	golang.org/x/net v0.26.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
 )
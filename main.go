@@ -2,13 +2,38 @@ package main
 
 import (
 	"forum-server/app"
+	"forum-server/app/markup"
+	"forum-server/app/reputation"
 	"forum-server/audit"
+	"forum-server/db"
+	"log"
+	"os"
 )
 
 func main() {
 	auditor := audit.Auditor{}
 	auditor.Init()
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := db.RunMigrateCommand(&auditor, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		if err := markup.RunRenderCommand(&auditor, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "reputation" {
+		if err := reputation.RunCommand(&auditor, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	app := app.App{}
 	app.Init(&auditor)
 	app.Run(":2814")